@@ -0,0 +1,114 @@
+package tstorage
+
+import (
+	"fmt"
+)
+
+// Appender buffers rows and ingests them all at once on Commit, which saves the overhead of ingesting
+// row by row, such as writing to WAL. Obtain one with Storage.Appender.
+//
+// An Appender isn't goroutine safe; use one for each goroutine.
+type Appender[T any] interface {
+	// Append buffers a row, and gives back the reference to its series.
+	// Give the reference to AppendRef for the following rows of the same series, which skips identifying the series.
+	// Rows with invalid metric names or labels, or new series beyond the limits, are refused immediately.
+	Append(metric string, labels []Label, timestamp int64, value float64) (SeriesRef, error)
+	// AppendRef buffers a row for the series the given reference points to.
+	// ErrUnknownSeriesRef is given back if the series is no longer held in memory; use Append instead.
+	AppendRef(ref SeriesRef, timestamp int64, value float64) error
+	// Commit ingests all buffered rows, and gives back the outcome for each row in the order buffered.
+	// The Appender can be reused after that.
+	Commit() (InsertResult, error)
+	// Rollback discards all buffered rows. Series created by Append are forgotten unless anyone else
+	// has got them, so that they don't count against the series limits.
+	Rollback()
+}
+
+type appender[T any] struct {
+	storage *storage[T]
+	rows    []Row[T]
+	// The series for each of rows.
+	series []*indexedSeries
+	// Series acquired until committed or rolled back, keyed by the marshaled metric name and reference.
+	acquired    map[string]*indexedSeries
+	acquiredRef map[SeriesRef]*indexedSeries
+}
+
+func (a *appender[T]) Append(metric string, labels []Label, timestamp int64, value float64) (SeriesRef, error) {
+	if err := a.storage.validation.validateSeries(metric, labels); err != nil {
+		return 0, err
+	}
+	name := marshalMetricName(metric, labels)
+	series, ok := a.acquired[name]
+	if !ok {
+		var err error
+		series, err = a.storage.seriesIndex.acquire(name, metric, labels)
+		if err != nil {
+			return 0, err
+		}
+		a.acquire(series)
+	}
+	a.append(series, timestamp, value)
+	return series.ref, nil
+}
+
+func (a *appender[T]) AppendRef(ref SeriesRef, timestamp int64, value float64) error {
+	series, ok := a.acquiredRef[ref]
+	if !ok {
+		series, ok = a.storage.seriesIndex.acquireRef(ref)
+		if !ok {
+			return fmt.Errorf("series %d: %w", ref, ErrUnknownSeriesRef)
+		}
+		a.acquire(series)
+	}
+	a.append(series, timestamp, value)
+	return nil
+}
+
+// acquire keeps the given series, which is acquired only once by each appender.
+func (a *appender[T]) acquire(series *indexedSeries) {
+	if a.acquired == nil {
+		a.acquired = make(map[string]*indexedSeries)
+		a.acquiredRef = make(map[SeriesRef]*indexedSeries)
+	}
+	a.acquired[series.name] = series
+	a.acquiredRef[series.ref] = series
+}
+
+func (a *appender[T]) append(series *indexedSeries, timestamp int64, value float64) {
+	a.rows = append(a.rows, Row[T]{
+		Metric:    series.metric,
+		Labels:    series.labels,
+		DataPoint: DataPoint[T]{Timestamp: timestamp, Value: value},
+	})
+	a.series = append(a.series, series)
+}
+
+func (a *appender[T]) Commit() (InsertResult, error) {
+	defer a.reset()
+	defer func() {
+		for _, series := range a.acquired {
+			a.storage.seriesIndex.release(series)
+		}
+	}()
+	if len(a.rows) == 0 {
+		return InsertResult{}, nil
+	}
+	return a.storage.insertRows(a.rows, a.series)
+}
+
+func (a *appender[T]) Rollback() {
+	for _, series := range a.acquired {
+		a.storage.seriesIndex.unregister(series)
+	}
+	a.reset()
+}
+
+func (a *appender[T]) reset() {
+	a.rows = a.rows[:0]
+	a.series = a.series[:0]
+	for name, series := range a.acquired {
+		delete(a.acquired, name)
+		delete(a.acquiredRef, series.ref)
+	}
+}
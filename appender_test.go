@@ -0,0 +1,107 @@
+package tstorage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_appender_Commit(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithDuplicatePointPolicy[float64](RejectDuplicates),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+
+	app := s.Appender()
+	labels := []Label{{Name: "host", Value: "host-1"}}
+	ref, err := app.Append("metric1", labels, 1600000000, 0.1)
+	require.NoError(t, err)
+	require.NoError(t, app.AppendRef(ref, 1600000001, 0.2))
+	require.NoError(t, app.AppendRef(ref, 1600000001, 0.3))
+
+	// Nothing is visible until committed.
+	_, err = s.Select("metric1", labels, 1600000000, 1600000002)
+	assert.ErrorIs(t, err, ErrNoDataPoints)
+
+	got, err := app.Commit()
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Accepted)
+	assert.ErrorIs(t, got.Errors[2], ErrDuplicatePoint)
+
+	points, err := s.Select("metric1", labels, 1600000000, 1600000002)
+	require.NoError(t, err)
+	assert.Equal(t, []*DataPoint[float64]{
+		{Timestamp: 1600000000, Value: 0.1},
+		{Timestamp: 1600000001, Value: 0.2},
+	}, points)
+
+	// The reference can be used for the following commits.
+	require.NoError(t, app.AppendRef(ref, 1600000002, 0.4))
+	got, err = app.Commit()
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.Accepted)
+}
+
+func Test_appender_Rollback(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+
+	app := s.Appender()
+	_, err = app.Append("metric1", nil, 1600000000, 0.1)
+	require.NoError(t, err)
+	app.Rollback()
+	got, err := app.Commit()
+	require.NoError(t, err)
+	assert.Equal(t, 0, got.Accepted)
+	_, err = s.Select("metric1", nil, 1600000000, 1600000001)
+	assert.ErrorIs(t, err, ErrNoDataPoints)
+}
+
+func Test_appender_invalid(t *testing.T) {
+	s, err := NewStorage[float64]()
+	require.NoError(t, err)
+	defer s.Close()
+
+	app := s.Appender()
+	_, err = app.Append("", nil, 1600000000, 0.1)
+	assert.ErrorIs(t, err, ErrInvalidRow)
+	err = app.AppendRef(SeriesRef(100), 1600000000, 0.1)
+	assert.ErrorIs(t, err, ErrUnknownSeriesRef)
+}
+
+func Test_appender_Rollback_seriesLimit(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithMaxSeries[float64](2),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+
+	ref, err := s.GetRef("metric1", nil)
+	require.NoError(t, err)
+
+	app := s.Appender()
+	_, err = app.Append("metric1", nil, 1600000000, 0.1)
+	require.NoError(t, err)
+	_, err = app.Append("metric2", nil, 1600000000, 0.1)
+	require.NoError(t, err)
+	_, err = app.Append("metric3", nil, 1600000000, 0.1)
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	app.Rollback()
+
+	// Only the series created by the appender is forgotten.
+	assert.Equal(t, map[string]int{"metric1": 1}, s.Cardinality())
+	require.NoError(t, s.InsertRefs([]RefRow[float64]{{Ref: ref, DataPoint: DataPoint[float64]{Timestamp: 1600000000}}}))
+	_, err = app.Append("metric3", nil, 1600000000, 0.1)
+	require.NoError(t, err)
+	got, err := app.Commit()
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.Accepted)
+	assert.Equal(t, map[string]int{"metric1": 1, "metric3": 1}, s.Cardinality())
+}
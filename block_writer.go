@@ -0,0 +1,284 @@
+package tstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BlockWriter builds disk partitions directly out of rows, bypassing in-memory partitions and WAL.
+// It suits backfilling history far older than the out-of-order window, which InsertRows rejects.
+// Obtain one with Storage.BlockWriter.
+//
+// Rows are only accepted if they are older than both the data points held in memory and the out-of-order window,
+// and don't fall within the range of any existing disk partition. Inserting rows blocks while Commit is in progress.
+// Partitions built are neither written to WAL nor streamed to followers.
+//
+// A BlockWriter isn't goroutine safe; use one for each goroutine.
+type BlockWriter[T any] interface {
+	// Add buffers the given rows, which can be in any order.
+	Add(rows []Row[T])
+	// Commit builds disk partitions out of all buffered rows, each covering up to the partition duration,
+	// and makes them visible to reads. It gives back the outcome for each row in the order buffered.
+	// If it gives back an error, no partition is made visible and nothing is left on disk.
+	// The BlockWriter can be reused after that.
+	Commit() (InsertResult, error)
+	// Rollback discards all buffered rows.
+	Rollback()
+}
+
+type blockWriter[T any] struct {
+	storage *storage[T]
+	rows    []Row[T]
+}
+
+func (s *storage[T]) BlockWriter() BlockWriter[T] {
+	return &blockWriter[T]{
+		storage: s,
+	}
+}
+
+func (b *blockWriter[T]) Add(rows []Row[T]) {
+	b.rows = append(b.rows, rows...)
+}
+
+func (b *blockWriter[T]) Rollback() {
+	b.rows = b.rows[:0]
+}
+
+// Blocks are built into directories with this prefix, and then renamed into partitions.
+const blockTmpDirPrefix = ".tmp-"
+
+// block is a set of rows to be a disk partition.
+type block[T any] struct {
+	minTimestamp int64
+	maxTimestamp int64
+	// Indexes of the rows within the buffered rows, in chronological order.
+	indexes []int
+}
+
+func (b *blockWriter[T]) Commit() (InsertResult, error) {
+	defer b.Rollback()
+	s := b.storage
+	if s.inMemoryMode() {
+		return InsertResult{}, errors.New("block writer is only available for the on-disk storage")
+	}
+	if len(b.rows) == 0 {
+		return InsertResult{}, nil
+	}
+	// Prevent partitions from being flushed or built concurrently, which would change the ranges they cover,
+	// and rows from being inserted meanwhile, which could fall within the blocks built.
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.insertMu.Lock()
+	defer s.insertMu.Unlock()
+
+	// Ranges of existing disk partitions in chronological order, and the bound of timestamps
+	// which either are held in memory or can still be inserted within the out-of-order window.
+	var ranges [][2]int64
+	bound, bounded := s.newestTimestamp()
+	bound -= durationIn(s.outOfOrderWindow, s.timestampPrecision)
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		part := iterator.value()
+		if part.size() == 0 {
+			continue
+		}
+		if _, ok := part.(*memoryPartition[T]); ok {
+			if part.minTimestamp() < bound {
+				bound = part.minTimestamp()
+			}
+			continue
+		}
+		ranges = append(ranges, [2]int64{part.minTimestamp(), part.maxTimestamp()})
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0] < ranges[j][0]
+	})
+
+	var rejected map[int]error
+	accepted := make([]int, 0, len(b.rows))
+	for i := range b.rows {
+		row := &b.rows[i]
+		err := s.validation.validate(row.Metric, row.Labels, row.Value)
+		switch {
+		case err != nil:
+		case row.Timestamp == 0:
+			err = fmt.Errorf("timestamp must be set: %w", ErrInvalidRow)
+		case bounded && row.Timestamp >= bound:
+			err = fmt.Errorf("timestamp %d isn't older than the data points in memory or the out-of-order window from %d: %w", row.Timestamp, bound, ErrOutOfBounds)
+		default:
+			if g := gapIndex(ranges, row.Timestamp); g > 0 && ranges[g-1][1] >= row.Timestamp {
+				err = fmt.Errorf("timestamp %d is within the existing partition %d~%d: %w", row.Timestamp, ranges[g-1][0], ranges[g-1][1], ErrOutOfBounds)
+			}
+		}
+		if err != nil {
+			rejected = rejectRow(rejected, nil, i, err)
+			continue
+		}
+		accepted = append(accepted, i)
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return b.rows[accepted[i]].Timestamp < b.rows[accepted[j]].Timestamp
+	})
+
+	// Split rows into blocks covering up to the partition duration, none of which spans an existing partition.
+	partitionDuration := durationIn(s.partitionDuration, s.timestampPrecision)
+	blocks := make([]*block[T], 0)
+	var current *block[T]
+	for _, i := range accepted {
+		ts := b.rows[i].Timestamp
+		if current == nil || ts-current.minTimestamp >= partitionDuration || gapIndex(ranges, ts) != gapIndex(ranges, current.minTimestamp) {
+			current = &block[T]{minTimestamp: ts}
+			blocks = append(blocks, current)
+		}
+		current.maxTimestamp = ts
+		current.indexes = append(current.indexes, i)
+	}
+
+	// Build all blocks into temporary directories, which aren't regarded as partitions,
+	// before making any of them visible.
+	res := InsertResult{Accepted: len(accepted)}
+	dirs := make([]string, 0, len(blocks))
+	tmpDirs := make([]string, 0, len(blocks))
+	defer func() {
+		for _, dir := range tmpDirs {
+			os.RemoveAll(dir)
+		}
+	}()
+	for _, blk := range blocks {
+		name := fmt.Sprintf("p-%d-%d", blk.minTimestamp, blk.maxTimestamp)
+		dir := filepath.Join(s.dataPath, name)
+		if _, err := os.Stat(dir); err == nil {
+			return InsertResult{}, fmt.Errorf("failed to write block into %s: partition already exists", dir)
+		}
+		tmpDir := filepath.Join(s.dataPath, blockTmpDirPrefix+name)
+		// It may be left by a crash.
+		if err := os.RemoveAll(tmpDir); err != nil {
+			return InsertResult{}, fmt.Errorf("failed to remove %s: %w", tmpDir, err)
+		}
+		tmpDirs = append(tmpDirs, tmpDir)
+		duplicates, err := b.write(tmpDir, blk)
+		if err != nil {
+			return InsertResult{}, fmt.Errorf("failed to write block into %s: %w", tmpDir, err)
+		}
+		for _, i := range duplicates {
+			rejected = rejectRow(rejected, nil, i, fmt.Errorf("timestamp %d in metric %q: %w", b.rows[i].Timestamp, b.rows[i].Metric, ErrDuplicatePoint))
+		}
+		res.Accepted -= len(duplicates)
+		dirs = append(dirs, dir)
+	}
+
+	parts := make([]partition[T], 0, len(blocks))
+	for i, dir := range dirs {
+		err := os.Rename(tmpDirs[i], dir)
+		if err == nil {
+			var part partition[T]
+			if part, err = openDiskPartition[T](dir, s.retention); err == nil {
+				parts = append(parts, part)
+				continue
+			}
+			os.RemoveAll(dir)
+		}
+		// Take back the ones already in place, which nobody has read yet.
+		for _, part := range parts {
+			part.(*diskPartition[T]).close()
+		}
+		for _, dir := range dirs[:i] {
+			os.RemoveAll(dir)
+		}
+		return InsertResult{}, fmt.Errorf("failed to open disk partition for %s: %w", dir, err)
+	}
+	for _, part := range parts {
+		s.partitionList.insertOrdered(part)
+	}
+	res.Errors = rejected
+	return res, nil
+}
+
+// write writes the rows in the block into the given directory, in the same format as flushed partitions.
+// With RejectDuplicates, it gives back the indexes of rows rejected for having the same timestamp as another row of the series.
+func (b *blockWriter[T]) write(dirPath string, blk *block[T]) ([]int, error) {
+	series := make(map[string][]*DataPoint[T])
+	var duplicates []int
+	for _, i := range blk.indexes {
+		row := &b.rows[i]
+		name := marshalMetricName(row.Metric, row.Labels)
+		points := series[name]
+		if b.storage.duplicatePolicy == RejectDuplicates && len(points) > 0 && points[len(points)-1].Timestamp == row.Timestamp {
+			duplicates = append(duplicates, i)
+			continue
+		}
+		series[name] = append(points, &DataPoint[T]{Timestamp: row.Timestamp, Value: row.Value})
+	}
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := os.MkdirAll(dirPath, fs.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to make directory: %w", err)
+	}
+	f, err := os.Create(filepath.Join(dirPath, dataFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create data file: %w", err)
+	}
+	defer f.Close()
+	encoder := newSeriesEncoder[T](f)
+
+	metrics := make(map[string]diskMetric, len(series))
+	numPoints := 0
+	for _, name := range names {
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set file offset of metric %q: %w", name, err)
+		}
+		points := series[name]
+		for _, p := range points {
+			if err := encoder.encodePoint(p); err != nil {
+				return nil, fmt.Errorf("failed to encode a data point that metric is %q: %w", name, err)
+			}
+		}
+		if err := encoder.flush(); err != nil {
+			return nil, fmt.Errorf("failed to flush data points that metric is %q: %w", name, err)
+		}
+		metrics[name] = diskMetric{
+			Name:          name,
+			Offset:        offset,
+			MinTimestamp:  points[0].Timestamp,
+			MaxTimestamp:  points[len(points)-1].Timestamp,
+			NumDataPoints: int64(len(points)),
+		}
+		numPoints += len(points)
+	}
+
+	m, err := json.Marshal(&meta{
+		MinTimestamp:  blk.minTimestamp,
+		MaxTimestamp:  blk.maxTimestamp,
+		NumDataPoints: numPoints,
+		Metrics:       metrics,
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	// The meta file proves the partition is valid, so it comes last.
+	metaPath := filepath.Join(dirPath, metaFileName)
+	if err := os.WriteFile(metaPath, m, fs.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to write metadata to %s: %w", metaPath, err)
+	}
+	return duplicates, nil
+}
+
+// gapIndex gives back the number of the given ranges starting at or before ts,
+// which identifies the gap between ranges that ts falls in unless it's within one of them.
+func gapIndex(ranges [][2]int64, ts int64) int {
+	return sort.Search(len(ranges), func(i int) bool { return ranges[i][0] > ts })
+}
@@ -0,0 +1,199 @@
+package tstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_blockWriter_Commit(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	opts := []Option[float64]{
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+		WithDuplicatePointPolicy[float64](RejectDuplicates),
+	}
+	labels := []Label{{Name: "host", Value: "a"}}
+	row := func(ts int64, v float64) Row[float64] {
+		return Row[float64]{Metric: "metric1", Labels: labels, DataPoint: DataPoint[float64]{Timestamp: ts, Value: v}}
+	}
+
+	// Make a disk partition covering 1600050000~1600050010.
+	s, err := NewStorage(opts...)
+	require.NoError(t, err)
+	require.NoError(t, s.InsertRows([]Row[float64]{row(1600050000, 5), row(1600050010, 6)}))
+	require.NoError(t, s.Close())
+	s, err = NewStorage(opts...)
+	require.NoError(t, err)
+	require.NoError(t, s.InsertRows([]Row[float64]{row(1600100000, 9)}))
+
+	w := s.BlockWriter()
+	w.Add([]Row[float64]{
+		row(1600003700, 3),
+		row(1600000010, 2),
+		row(1600000000, 1),
+		row(1600000010, 2),
+		// Within the existing partition.
+		row(1600050005, 0),
+	})
+	w.Add([]Row[float64]{
+		// Either side of the existing partition.
+		row(1600049000, 4),
+		row(1600050100, 7),
+		// Not older than the data points in memory.
+		row(1600100000, 0),
+		row(0, 0),
+		{Metric: "", DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+	})
+	res, err := w.Commit()
+	require.NoError(t, err)
+	assert.Equal(t, 5, res.Accepted)
+	assert.Equal(t, []int{3, 4, 7, 8, 9}, res.Rejected())
+	assert.True(t, errors.Is(res.Errors[3], ErrDuplicatePoint))
+	assert.True(t, errors.Is(res.Errors[4], ErrOutOfBounds))
+	assert.True(t, errors.Is(res.Errors[7], ErrOutOfBounds))
+	assert.True(t, errors.Is(res.Errors[8], ErrInvalidRow))
+	assert.True(t, errors.Is(res.Errors[9], ErrInvalidRow))
+
+	// Blocks split at the partition duration and the existing partition.
+	for _, name := range []string{"p-1600000000-1600000010", "p-1600003700-1600003700", "p-1600049000-1600049000", "p-1600050100-1600050100"} {
+		_, err := os.Stat(filepath.Join(tmpDir, name, metaFileName))
+		assert.NoError(t, err, name)
+	}
+	mins := make([]int64, 0)
+	iterator := s.(*storage[float64]).partitionList.newIterator()
+	for iterator.next() {
+		if _, ok := iterator.value().(*diskPartition[float64]); ok {
+			mins = append(mins, iterator.value().minTimestamp())
+		}
+	}
+	assert.Equal(t, []int64{1600050100, 1600050000, 1600049000, 1600003700, 1600000000}, mins)
+
+	want := []*DataPoint[float64]{
+		{Timestamp: 1600000000, Value: 1},
+		{Timestamp: 1600000010, Value: 2},
+		{Timestamp: 1600003700, Value: 3},
+		{Timestamp: 1600049000, Value: 4},
+		{Timestamp: 1600050000, Value: 5},
+		{Timestamp: 1600050010, Value: 6},
+		{Timestamp: 1600050100, Value: 7},
+		{Timestamp: 1600100000, Value: 9},
+	}
+	got, err := s.Select("metric1", labels, 1600000000, 1600100001)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// Blocks survive restarts.
+	require.NoError(t, s.Close())
+	s, err = NewStorage(opts...)
+	require.NoError(t, err)
+	defer s.Close()
+	got, err = s.Select("metric1", labels, 1600000000, 1600100001)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// Rows within a partition built by the BlockWriter are refused too.
+	w = s.BlockWriter()
+	w.Add([]Row[float64]{row(1600000005, 0)})
+	res, err = w.Commit()
+	require.NoError(t, err)
+	assert.True(t, errors.Is(res.Errors[0], ErrOutOfBounds))
+}
+
+func Test_blockWriter_Commit_failure(t *testing.T) {
+	tmpDir := t.TempDir()
+	s, err := NewStorage(
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	// Occupy the directory for the second block.
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "p-1600003700-1600003700"), 0755))
+
+	w := s.BlockWriter()
+	w.Add([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600003700}},
+	})
+	_, err = w.Commit()
+	assert.Error(t, err)
+
+	// Neither the first block is visible nor anything is left.
+	_, err = s.Select("metric1", nil, 1600000000, 1600000001)
+	assert.ErrorIs(t, err, ErrNoDataPoints)
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{walDirName, "p-1600003700-1600003700"}, names)
+}
+
+func Test_blockWriter_Commit_outOfOrderWindow(t *testing.T) {
+	tmpDir := t.TempDir()
+	opts := []Option[float64]{
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+		WithPartitionDuration[float64](time.Hour),
+	}
+	s, err := NewStorage(opts...)
+	require.NoError(t, err)
+	require.NoError(t, s.InsertRows([]Row[float64]{{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600100000}}}))
+	require.NoError(t, s.Close())
+	// Nothing is held in memory, but rows within the out-of-order window can still be inserted.
+	s, err = NewStorage(opts...)
+	require.NoError(t, err)
+	defer s.Close()
+
+	w := s.BlockWriter()
+	w.Add([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600098200}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600090000}},
+	})
+	res, err := w.Commit()
+	require.NoError(t, err)
+	assert.Equal(t, 1, res.Accepted)
+	assert.Equal(t, []int{0}, res.Rejected())
+	assert.True(t, errors.Is(res.Errors[0], ErrOutOfBounds))
+}
+
+func TestNewStorage_removeBlockTmpDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	// Left by a crash while building a block.
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, blockTmpDirPrefix+"p-1600000000-1600000010"), 0755))
+	s, err := NewStorage(
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = os.Stat(filepath.Join(tmpDir, blockTmpDirPrefix+"p-1600000000-1600000010"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_blockWriter_Commit_inMemory(t *testing.T) {
+	s, err := NewStorage[float64]()
+	require.NoError(t, err)
+	defer s.Close()
+	w := s.BlockWriter()
+	w.Add([]Row[float64]{{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1}}})
+	_, err = w.Commit()
+	assert.Error(t, err)
+}
+
+func Test_gapIndex(t *testing.T) {
+	ranges := [][2]int64{{10, 20}, {30, 40}}
+	assert.Equal(t, 0, gapIndex(ranges, 5))
+	assert.Equal(t, 1, gapIndex(ranges, 10))
+	assert.Equal(t, 1, gapIndex(ranges, 25))
+	assert.Equal(t, 2, gapIndex(ranges, 45))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// labelFlags is a repeatable flag of name=value.
+type labelFlags []tstorage.Label
+
+func (l *labelFlags) String() string {
+	s := make([]string, 0, len(*l))
+	for _, label := range *l {
+		s = append(s, label.Name+"="+label.Value)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *labelFlags) Set(v string) error {
+	name, value, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid label %q: want name=value", v)
+	}
+	*l = append(*l, tstorage.Label{Name: name, Value: value})
+	return nil
+}
+
+func runDump(args []string, _ io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var sf storageFlags
+	sf.register(fs)
+	metric := fs.String("metric", "", "Metric name of the series (required)")
+	var labels labelFlags
+	fs.Var(&labels, "label", "Label of the series as name=value; can be repeated, and series having other labels are dumped as well")
+	from := fs.String("from", "", "Start of the range to dump, inclusive; the oldest data point if empty")
+	to := fs.String("to", "", "End of the range to dump, exclusive; the newest data point if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	precision, err := sf.validate()
+	if err != nil {
+		return err
+	}
+	if *metric == "" {
+		return errors.New("--metric is required")
+	}
+
+	start, end, ok, err := exportRange(sf.dataPath)
+	if err != nil {
+		return err
+	}
+	if *from != "" {
+		if start, err = parseTimestamp(*from, precision); err != nil {
+			return fmt.Errorf("invalid --from: %w", err)
+		}
+	}
+	if *to != "" {
+		if end, err = parseTimestamp(*to, precision); err != nil {
+			return fmt.Errorf("invalid --to: %w", err)
+		}
+	}
+	if !ok && *from == "" && *to == "" {
+		return nil
+	}
+
+	matchers := make([]*tstorage.Matcher, 0, len(labels)+1)
+	m, err := tstorage.NewMatcher(tstorage.MatchEqual, tstorage.MetricNameLabel, *metric)
+	if err != nil {
+		return err
+	}
+	matchers = append(matchers, m)
+	for _, l := range labels {
+		m, err := tstorage.NewMatcher(tstorage.MatchEqual, l.Name, l.Value)
+		if err != nil {
+			return err
+		}
+		matchers = append(matchers, m)
+	}
+	storage, err := sf.open(precision)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	series, err := storage.SelectSeries(matchers, start, end)
+	if err != nil {
+		storage.Close()
+		return fmt.Errorf("failed to select series: %w", err)
+	}
+	if err := storage.Close(); err != nil {
+		return fmt.Errorf("failed to close storage: %w", err)
+	}
+	if len(series) == 0 {
+		return errors.New("no series found")
+	}
+
+	w := bufio.NewWriter(stdout)
+	for i, s := range series {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, seriesName(s.Metric, s.Labels))
+		for _, p := range s.Points {
+			fmt.Fprintf(w, "%d %s\n", p.Timestamp, formatValue(p.Value))
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/timeutil"
+)
+
+// exportWindow is the span of data points selected at once, which bounds the memory used while exporting.
+const exportWindow = time.Hour
+
+func runExport(args []string, _ io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var sf storageFlags
+	sf.register(fs)
+	metric := fs.String("metric", "", "Metric name to export; all metrics if empty")
+	from := fs.String("from", "", "Start of the range to export, inclusive; the oldest data point if empty")
+	to := fs.String("to", "", "End of the range to export, exclusive; the newest data point if empty")
+	partition := fs.String("partition", "", "Name of a partition directory, e.g. p-1600000000-1600003599, to export the range of instead of --from and --to")
+	format := fs.String("format", formatCSV, "Output format: csv, jsonl or parquet")
+	output := fs.String("output", "-", "Path to the output file, or - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	precision, err := sf.validate()
+	if err != nil {
+		return err
+	}
+
+	start, end, ok, err := exportRange(sf.dataPath)
+	if err != nil {
+		return err
+	}
+	if *from != "" {
+		if start, err = parseTimestamp(*from, precision); err != nil {
+			return fmt.Errorf("invalid --from: %w", err)
+		}
+	}
+	if *to != "" {
+		if end, err = parseTimestamp(*to, precision); err != nil {
+			return fmt.Errorf("invalid --to: %w", err)
+		}
+	}
+	if *partition != "" {
+		if *from != "" || *to != "" {
+			return errors.New("--partition can't be used with --from or --to")
+		}
+		min, max, valid := partitionRange(*partition)
+		if !valid {
+			return fmt.Errorf("invalid --partition %q: want p-<min>-<max>", *partition)
+		}
+		start, end, ok = min, max+1, true
+	}
+
+	var w io.Writer = stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	rw, err := newRowWriter(*format, w, precision)
+	if err != nil {
+		return err
+	}
+	// Nothing to export, but still give back the valid output.
+	if !ok && *from == "" && *to == "" {
+		return rw.flush()
+	}
+
+	var matchers []*tstorage.Matcher
+	if *metric != "" {
+		m, err := tstorage.NewMatcher(tstorage.MatchEqual, tstorage.MetricNameLabel, *metric)
+		if err != nil {
+			return err
+		}
+		matchers = append(matchers, m)
+	}
+	storage, err := sf.open(precision)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	if err := export(storage, matchers, start, end, timeutil.DurationIn(exportWindow, precision), rw); err != nil {
+		storage.Close()
+		return err
+	}
+	if err := storage.Close(); err != nil {
+		return fmt.Errorf("failed to close storage: %w", err)
+	}
+	return nil
+}
+
+// export writes data points of series matching the matchers within [start, end) out,
+// selecting them for each window in chronological order.
+func export(r tstorage.Reader[float64], matchers []*tstorage.Matcher, start, end, window int64, rw rowWriter) error {
+	for s := start; s < end; {
+		e := end
+		if s <= end-window {
+			e = s + window
+		}
+		series, err := r.SelectSeries(matchers, s, e)
+		if err != nil {
+			return fmt.Errorf("failed to select series: %w", err)
+		}
+		for _, ss := range series {
+			for _, p := range ss.Points {
+				if err := rw.write(tstorage.Row[float64]{Metric: ss.Metric, Labels: ss.Labels, DataPoint: *p}); err != nil {
+					return fmt.Errorf("failed to write row: %w", err)
+				}
+			}
+		}
+		s = e
+	}
+	return rw.flush()
+}
+
+// exportRange gives back the range covering all disk partitions in the data directory.
+// It reports false if there is none.
+func exportRange(dataPath string) (start, end int64, ok bool, err error) {
+	entries, err := os.ReadDir(dataPath)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("failed to read data directory: %w", err)
+	}
+	start, end = math.MaxInt64, math.MinInt64
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		min, max, valid := partitionRange(e.Name())
+		if !valid {
+			continue
+		}
+		if min < start {
+			start = min
+		}
+		if max > end {
+			end = max
+		}
+		ok = true
+	}
+	if !ok {
+		return 0, 0, false, nil
+	}
+	// The end is exclusive.
+	if end < math.MaxInt64 {
+		end++
+	}
+	return start, end, true, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/parquet"
+)
+
+const (
+	formatCSV     = "csv"
+	formatJSONL   = "jsonl"
+	formatParquet = "parquet"
+)
+
+// csvHeader is the header of CSV, where labels are a JSON object.
+var csvHeader = []string{"metric", "labels", "timestamp", "value"}
+
+// record is a row in JSON Lines.
+type record struct {
+	Metric    string            `json:"metric"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timestamp int64             `json:"timestamp"`
+	Value     jsonValue         `json:"value"`
+}
+
+// jsonValue is a float encoded as a number, or a string for NaN and infinities that JSON can't represent.
+type jsonValue float64
+
+func (v jsonValue) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return json.Marshal(formatValue(f))
+	}
+	return json.Marshal(f)
+}
+
+func (v *jsonValue) UnmarshalJSON(b []byte) error {
+	var f float64
+	if err := json.Unmarshal(b, &f); err == nil {
+		*v = jsonValue(f)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid value %s", b)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %q", s)
+	}
+	*v = jsonValue(f)
+	return nil
+}
+
+// rowWriter writes rows out in a format.
+type rowWriter interface {
+	write(row tstorage.Row[float64]) error
+	flush() error
+}
+
+// newRowWriter gives back the rowWriter for the format, where timestamps are in the given precision.
+func newRowWriter(format string, w io.Writer, precision tstorage.TimestampPrecision) (rowWriter, error) {
+	switch format {
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, fmt.Errorf("failed to write header: %w", err)
+		}
+		return &csvRowWriter{w: cw}, nil
+	case formatJSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlRowWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case formatParquet:
+		return &parquetRowWriter{w: parquet.NewWriter(w, parquet.WithTimeUnit(timeUnit(precision)))}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvRowWriter) write(row tstorage.Row[float64]) error {
+	labels, err := json.Marshal(labelsMap(row.Labels))
+	if err != nil {
+		return fmt.Errorf("failed to encode labels: %w", err)
+	}
+	return c.w.Write([]string{
+		row.Metric,
+		string(labels),
+		strconv.FormatInt(row.Timestamp, 10),
+		formatValue(row.Value),
+	})
+}
+
+func (c *csvRowWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlRowWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonlRowWriter) write(row tstorage.Row[float64]) error {
+	return j.enc.Encode(&record{
+		Metric:    row.Metric,
+		Labels:    labelsMap(row.Labels),
+		Timestamp: row.Timestamp,
+		Value:     jsonValue(row.Value),
+	})
+}
+
+func (j *jsonlRowWriter) flush() error {
+	return j.w.Flush()
+}
+
+type parquetRowWriter struct {
+	w *parquet.Writer
+}
+
+func (p *parquetRowWriter) write(row tstorage.Row[float64]) error {
+	labels := make([]parquet.Label, 0, len(row.Labels))
+	for _, l := range row.Labels {
+		labels = append(labels, parquet.Label{Name: l.Name, Value: l.Value})
+	}
+	return p.w.Write(parquet.Row{
+		Metric:    row.Metric,
+		Labels:    labels,
+		Timestamp: row.Timestamp,
+		Value:     row.Value,
+	})
+}
+
+func (p *parquetRowWriter) flush() error {
+	return p.w.Close()
+}
+
+// timeUnit gives back the unit of Parquet timestamps. Parquet has none for seconds, which are left as integers.
+func timeUnit(precision tstorage.TimestampPrecision) parquet.TimeUnit {
+	switch precision {
+	case tstorage.Nanoseconds:
+		return parquet.UnitNanoseconds
+	case tstorage.Microseconds:
+		return parquet.UnitMicroseconds
+	case tstorage.Milliseconds:
+		return parquet.UnitMilliseconds
+	default:
+		return parquet.UnitNone
+	}
+}
+
+// rowReader reads rows in a format one by one.
+type rowReader interface {
+	// read gives back the next row, or io.EOF if there is no more.
+	read() (tstorage.Row[float64], error)
+}
+
+// newRowReader gives back the rowReader for the format.
+func newRowReader(format string, r io.Reader) (rowReader, error) {
+	switch format {
+	case formatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(csvHeader)
+		cr.ReuseRecord = true
+		return &csvRowReader{r: cr}, nil
+	case formatJSONL:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 0, 64*1024), 16<<20)
+		return &jsonlRowReader{sc: sc}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+type csvRowReader struct {
+	r    *csv.Reader
+	line int
+}
+
+func (c *csvRowReader) read() (tstorage.Row[float64], error) {
+	c.line++
+	fields, err := c.r.Read()
+	if errors.Is(err, io.EOF) {
+		return tstorage.Row[float64]{}, io.EOF
+	}
+	if err != nil {
+		return tstorage.Row[float64]{}, fmt.Errorf("failed to read CSV: %w", err)
+	}
+	if c.line == 1 && fields[0] == csvHeader[0] {
+		return c.read()
+	}
+	var labels map[string]string
+	if fields[1] != "" {
+		if err := json.Unmarshal([]byte(fields[1]), &labels); err != nil {
+			return tstorage.Row[float64]{}, fmt.Errorf("line %d: invalid labels %q: %w", c.line, fields[1], err)
+		}
+	}
+	ts, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return tstorage.Row[float64]{}, fmt.Errorf("line %d: invalid timestamp %q", c.line, fields[2])
+	}
+	v, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return tstorage.Row[float64]{}, fmt.Errorf("line %d: invalid value %q", c.line, fields[3])
+	}
+	return tstorage.Row[float64]{
+		Metric:    fields[0],
+		Labels:    labelsSlice(labels),
+		DataPoint: tstorage.DataPoint[float64]{Timestamp: ts, Value: v},
+	}, nil
+}
+
+type jsonlRowReader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func (j *jsonlRowReader) read() (tstorage.Row[float64], error) {
+	for j.sc.Scan() {
+		j.line++
+		b := j.sc.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(b, &rec); err != nil {
+			return tstorage.Row[float64]{}, fmt.Errorf("line %d: %w", j.line, err)
+		}
+		return tstorage.Row[float64]{
+			Metric:    rec.Metric,
+			Labels:    labelsSlice(rec.Labels),
+			DataPoint: tstorage.DataPoint[float64]{Timestamp: rec.Timestamp, Value: float64(rec.Value)},
+		}, nil
+	}
+	if err := j.sc.Err(); err != nil {
+		return tstorage.Row[float64]{}, fmt.Errorf("failed to read JSON Lines: %w", err)
+	}
+	return tstorage.Row[float64]{}, io.EOF
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func labelsMap(labels []tstorage.Label) map[string]string {
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+func labelsSlice(m map[string]string) []tstorage.Label {
+	labels := make([]tstorage.Label, 0, len(m))
+	for name, value := range m {
+		labels = append(labels, tstorage.Label{Name: name, Value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+	return labels
+}
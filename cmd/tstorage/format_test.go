@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/parquet"
+)
+
+func TestRowWriter_roundTrip(t *testing.T) {
+	rows := []tstorage.Row[float64]{
+		{
+			Metric:    "cpu",
+			Labels:    []tstorage.Label{{Name: "host", Value: `a,"b"`}, {Name: "region", Value: "us"}},
+			DataPoint: tstorage.DataPoint[float64]{Timestamp: 1, Value: 0.5},
+		},
+		{Metric: "mem", Labels: []tstorage.Label{}, DataPoint: tstorage.DataPoint[float64]{Timestamp: -2, Value: math.Inf(1)}},
+	}
+	for _, format := range []string{formatCSV, formatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newRowWriter(format, &buf, tstorage.Seconds)
+			require.NoError(t, err)
+			for _, r := range rows {
+				require.NoError(t, w.write(r))
+			}
+			require.NoError(t, w.write(tstorage.Row[float64]{Metric: "nan", Labels: []tstorage.Label{}, DataPoint: tstorage.DataPoint[float64]{Value: math.NaN()}}))
+			require.NoError(t, w.flush())
+
+			got, err := readRows(format, &buf)
+			require.NoError(t, err)
+			require.Len(t, got, 3)
+			assert.Equal(t, rows, got[:2])
+			assert.True(t, math.IsNaN(got[2].Value))
+		})
+	}
+}
+
+func TestRowWriter_format(t *testing.T) {
+	row := tstorage.Row[float64]{
+		Metric:    "cpu",
+		Labels:    []tstorage.Label{{Name: "host", Value: "a"}},
+		DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000000, Value: 1.5},
+	}
+	var buf bytes.Buffer
+	w, err := newRowWriter(formatCSV, &buf, tstorage.Seconds)
+	require.NoError(t, err)
+	require.NoError(t, w.write(row))
+	require.NoError(t, w.flush())
+	assert.Equal(t, "metric,labels,timestamp,value\ncpu,\"{\"\"host\"\":\"\"a\"\"}\",1600000000,1.5\n", buf.String())
+
+	buf.Reset()
+	w, err = newRowWriter(formatJSONL, &buf, tstorage.Seconds)
+	require.NoError(t, err)
+	require.NoError(t, w.write(row))
+	require.NoError(t, w.flush())
+	assert.Equal(t, `{"metric":"cpu","labels":{"host":"a"},"timestamp":1600000000,"value":1.5}`+"\n", buf.String())
+
+	_, err = newRowWriter("xml", &buf, tstorage.Seconds)
+	assert.Error(t, err)
+}
+
+func TestRowWriter_parquet(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newRowWriter(formatParquet, &buf, tstorage.Milliseconds)
+	require.NoError(t, err)
+	require.NoError(t, w.write(tstorage.Row[float64]{
+		Metric:    "cpu",
+		Labels:    []tstorage.Label{{Name: "host", Value: "a"}},
+		DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000000000, Value: 1.5},
+	}))
+	require.NoError(t, w.flush())
+
+	got, err := parquet.ReadAll(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	assert.Equal(t, []parquet.Row{
+		{Metric: "cpu", Labels: []parquet.Label{{Name: "host", Value: "a"}}, Timestamp: 1600000000000, Value: 1.5},
+	}, got)
+}
+
+func TestReadRows_errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{name: "too few fields", format: formatCSV, input: "cpu,{},1\n"},
+		{name: "invalid labels", format: formatCSV, input: "cpu,host=a,1,1\n"},
+		{name: "invalid timestamp", format: formatCSV, input: "cpu,{},now,1\n"},
+		{name: "invalid value", format: formatCSV, input: "cpu,{},1,one\n"},
+		{name: "malformed JSON", format: formatJSONL, input: `{"metric":"cpu"` + "\n"},
+		{name: "invalid JSON value", format: formatJSONL, input: `{"metric":"cpu","timestamp":1,"value":"one"}` + "\n"},
+		{name: "unknown format", format: "xml", input: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readRows(tt.format, strings.NewReader(tt.input))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// readRows reads all rows in the given format.
+func readRows(format string, r io.Reader) ([]tstorage.Row[float64], error) {
+	rr, err := newRowReader(format, r)
+	if err != nil {
+		return nil, err
+	}
+	var rows []tstorage.Row[float64]
+	for {
+		row, err := rr.read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
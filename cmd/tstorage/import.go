@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/nakabonne/tstorage"
+)
+
+const (
+	// The maximum number of rejected rows reported.
+	maxReportedErrors = 10
+	// The number of rows read from the input before importing them.
+	importBatchSize = 10000
+)
+
+func runImport(args []string, stdin io.Reader, _, stderr io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var sf storageFlags
+	sf.register(fs)
+	format := fs.String("format", formatCSV, "Input format: csv or jsonl")
+	input := fs.String("input", "-", "Path to the input file, or - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	precision, err := sf.validate()
+	if err != nil {
+		return err
+	}
+	if err := checkClosed(sf.dataPath); err != nil {
+		return err
+	}
+
+	r := stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("failed to open input file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	rr, err := newRowReader(*format, r)
+	if err != nil {
+		return err
+	}
+	res, err := backfill(sf, precision, rr)
+	if err != nil && res.Accepted == 0 && len(res.Errors) == 0 {
+		return err
+	}
+	fmt.Fprintf(stderr, "imported %d rows, rejected %d rows\n", res.Accepted, len(res.Errors))
+	indexes := make([]int, 0, len(res.Errors))
+	for i := range res.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for i, idx := range indexes {
+		if i == maxReportedErrors {
+			fmt.Fprintf(stderr, "  ... and %d more\n", len(indexes)-i)
+			break
+		}
+		fmt.Fprintf(stderr, "  row %d: %v\n", idx+1, res.Errors[idx])
+	}
+	return err
+}
+
+// backfill imports rows read from rr in batches of importBatchSize, so that the input isn't held in memory at once.
+// Rows within the out-of-order window are inserted as usual, and the older ones are built into disk partitions
+// using a BlockWriter, which rejects rows within the range of existing partitions.
+// Batches already imported are kept even if it fails halfway through.
+// Rows get indexed in the order read within the result.
+func backfill(sf storageFlags, precision tstorage.TimestampPrecision, rr rowReader) (tstorage.InsertResult, error) {
+	storage, err := sf.open(precision, tstorage.WithDuplicatePointPolicy[float64](tstorage.RejectDuplicates))
+	if err != nil {
+		return tstorage.InsertResult{}, fmt.Errorf("failed to open storage: %w", err)
+	}
+	res, err := importRows(storage, rr)
+	if err != nil {
+		storage.Close()
+		return res, err
+	}
+	if err := storage.Close(); err != nil {
+		return res, fmt.Errorf("failed to close storage: %w", err)
+	}
+	return res, nil
+}
+
+// importRows imports all rows read from rr into the storage, giving back the outcome of the rows imported so far.
+func importRows(storage tstorage.Storage[float64], rr rowReader) (tstorage.InsertResult, error) {
+	res := tstorage.InsertResult{Errors: make(map[int]error)}
+	w := storage.BlockWriter()
+	batch := make([]tstorage.Row[float64], 0, importBatchSize)
+	for offset := 0; ; offset += len(batch) {
+		batch = batch[:0]
+		var readErr error
+		for len(batch) < importBatchSize {
+			row, err := rr.read()
+			if err != nil {
+				readErr = err
+				break
+			}
+			batch = append(batch, row)
+		}
+		if len(batch) > 0 {
+			if err := importBatch(storage, w, batch, offset, &res); err != nil {
+				return res, err
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			return res, nil
+		}
+		if readErr != nil {
+			return res, readErr
+		}
+	}
+}
+
+// importBatch inserts the rows and backfills the ones older than the out-of-order window,
+// recording their outcome in res with their indexes shifted by offset.
+func importBatch(storage tstorage.Storage[float64], w tstorage.BlockWriter[float64], batch []tstorage.Row[float64], offset int, res *tstorage.InsertResult) error {
+	inserted, err := storage.InsertRowsDetailed(batch)
+	if err != nil {
+		return fmt.Errorf("failed to insert rows: %w", err)
+	}
+	res.Accepted += inserted.Accepted
+	// Indexes within the batch of the rows to be backfilled.
+	var old []int
+	for _, i := range inserted.Rejected() {
+		if errors.Is(inserted.Errors[i], tstorage.ErrOutOfBounds) {
+			old = append(old, i)
+			continue
+		}
+		res.Errors[offset+i] = inserted.Errors[i]
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	rows := make([]tstorage.Row[float64], 0, len(old))
+	for _, i := range old {
+		rows = append(rows, batch[i])
+	}
+	w.Add(rows)
+	backfilled, err := w.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to backfill rows: %w", err)
+	}
+	res.Accepted += backfilled.Accepted
+	for i, err := range backfilled.Errors {
+		res.Errors[offset+old[i]] = err
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func runInspect(args []string, _ io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dataPath := fs.String("data", "", "Path to the data directory (required)")
+	retention := fs.Duration("retention", 14*24*time.Hour, "Retention of the storage, which tells when partitions expire")
+	top := fs.Int("top", 10, "Number of series and labels to list")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dataPath == "" {
+		return errors.New("--data is required")
+	}
+
+	// Reading files doesn't interfere with a running storage, but what it writes meanwhile may be missed.
+	partitions, err := tstorage.InspectPartitions(*dataPath)
+	if err != nil {
+		return err
+	}
+	segments, err := tstorage.InspectWAL(*dataPath)
+	if err != nil {
+		return err
+	}
+	return inspect(stdout, partitions, segments, *retention, *top)
+}
+
+func inspect(w io.Writer, partitions []tstorage.PartitionInfo, segments []tstorage.WALSegmentInfo, retention time.Duration, top int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PARTITION\tMIN\tMAX\tPOINTS\tSERIES\tBYTES\tCREATED\tEXPIRES")
+	for _, p := range partitions {
+		if p.Incomplete {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t%d\t-\t-\n", p.Dir, p.Size)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", p.Dir, p.MinTimestamp, p.MaxTimestamp, p.NumDataPoints, len(p.Series), p.Size,
+			p.CreatedAt.UTC().Format(time.RFC3339), p.CreatedAt.Add(retention).UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "WAL SEGMENT\tVERSION\tBYTES\tRECORDS\tERROR")
+	for _, s := range segments {
+		errMsg := "-"
+		if s.Err != nil {
+			errMsg = s.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", s.Name, s.Version, s.Size, s.NumRecords, errMsg)
+	}
+	fmt.Fprintln(tw)
+
+	series, labels := summarize(partitions)
+	fmt.Fprintf(tw, "TOP SERIES\tPOINTS\n")
+	for i := 0; i < len(series) && i < top; i++ {
+		fmt.Fprintf(tw, "%s\t%d\n", series[i].name, series[i].numDataPoints)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintf(tw, "TOP LABEL\tVALUES\tSERIES\n")
+	for i := 0; i < len(labels) && i < top; i++ {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", labels[i].name, labels[i].numValues, labels[i].numSeries)
+	}
+	return tw.Flush()
+}
+
+type seriesSummary struct {
+	name          string
+	numDataPoints int64
+}
+
+type labelSummary struct {
+	name      string
+	numValues int
+	numSeries int
+}
+
+// summarize gives back series across partitions in descending order of the number of data points,
+// and label names in descending order of the number of values.
+func summarize(partitions []tstorage.PartitionInfo) ([]seriesSummary, []labelSummary) {
+	points := make(map[string]int64)
+	values := make(map[string]map[string]struct{})
+	numSeries := make(map[string]int)
+	for _, p := range partitions {
+		for _, s := range p.Series {
+			name := seriesName(s.Metric, s.Labels)
+			if _, ok := points[name]; !ok {
+				for _, l := range s.Labels {
+					if values[l.Name] == nil {
+						values[l.Name] = make(map[string]struct{})
+					}
+					values[l.Name][l.Value] = struct{}{}
+					numSeries[l.Name]++
+				}
+			}
+			points[name] += s.NumDataPoints
+		}
+	}
+
+	series := make([]seriesSummary, 0, len(points))
+	for name, n := range points {
+		series = append(series, seriesSummary{name: name, numDataPoints: n})
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].numDataPoints != series[j].numDataPoints {
+			return series[i].numDataPoints > series[j].numDataPoints
+		}
+		return series[i].name < series[j].name
+	})
+	labels := make([]labelSummary, 0, len(values))
+	for name, vs := range values {
+		labels = append(labels, labelSummary{name: name, numValues: len(vs), numSeries: numSeries[name]})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].numValues != labels[j].numValues {
+			return labels[i].numValues > labels[j].numValues
+		}
+		return labels[i].name < labels[j].name
+	})
+	return series, labels
+}
+
+// seriesName formats the series as metric{name="value", ...}.
+func seriesName(metric string, labels []tstorage.Label) string {
+	if len(labels) == 0 {
+		return metric
+	}
+	var b strings.Builder
+	b.WriteString(metric)
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(l.Name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l.Value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// newDataDir makes a data directory of a closed storage holding a few series.
+func newDataDir(t *testing.T) string {
+	dataPath := t.TempDir()
+	storage, err := tstorage.NewStorage(
+		tstorage.WithDataPath[float64](dataPath),
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	require.NoError(t, err)
+	require.NoError(t, storage.InsertRows([]tstorage.Row[float64]{
+		{Metric: "cpu", Labels: []tstorage.Label{{Name: "host", Value: "a"}}, DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000000, Value: 1}},
+		{Metric: "cpu", Labels: []tstorage.Label{{Name: "host", Value: "a"}}, DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000001, Value: 2}},
+		{Metric: "cpu", Labels: []tstorage.Label{{Name: "host", Value: "b"}}, DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000001, Value: 3}},
+		{Metric: "mem", DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000002, Value: 4}},
+	}))
+	require.NoError(t, storage.Close())
+	return dataPath
+}
+
+func TestInspect(t *testing.T) {
+	dataPath := newDataDir(t)
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"inspect", "--data", dataPath, "--top", "2"}, nil, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+
+	lines := strings.Split(stdout.String(), "\n")
+	assert.Regexp(t, `^PARTITION\s+MIN\s+MAX\s+POINTS\s+SERIES\s+BYTES\s+CREATED\s+EXPIRES$`, lines[0])
+	assert.Regexp(t, `^p-1600000000-1600000002\s+1600000000\s+1600000002\s+4\s+3\s+\d+\s+\S+\s+\S+$`, lines[1])
+	assert.Regexp(t, `^WAL SEGMENT\s+VERSION\s+BYTES\s+RECORDS\s+ERROR$`, lines[3])
+	assert.Contains(t, stdout.String(), "TOP SERIES")
+	assert.Regexp(t, `cpu\{host="a"\}\s+2\n`, stdout.String())
+	assert.Regexp(t, `host\s+2\s+2\n`, stdout.String())
+	// Only the top 2 series are listed.
+	assert.NotContains(t, stdout.String(), "mem")
+}
+
+func TestInspect_expiry(t *testing.T) {
+	created := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	require.NoError(t, inspect(&buf, []tstorage.PartitionInfo{
+		{Dir: "p-1-2", MinTimestamp: 1, MaxTimestamp: 2, NumDataPoints: 2, Size: 10, CreatedAt: created},
+		{Dir: "p-3-4", Incomplete: true, Size: 5},
+	}, []tstorage.WALSegmentInfo{
+		{Name: "0000000001", Version: 2, Size: 20, NumRecords: 3},
+	}, time.Hour, 10))
+	assert.Regexp(t, `p-1-2\s+1\s+2\s+2\s+0\s+10\s+2020-09-13T12:00:00Z\s+2020-09-13T13:00:00Z\n`, buf.String())
+	assert.Regexp(t, `p-3-4\s+-\s+-\s+-\s+-\s+5\s+-\s+-\n`, buf.String())
+	assert.Regexp(t, `0000000001\s+2\s+20\s+3\s+-\n`, buf.String())
+}
+
+func TestDump(t *testing.T) {
+	dataPath := newDataDir(t)
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"dump", "--data", dataPath, "--precision", "s", "--metric", "cpu", "--label", "host=a"}, nil, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "cpu{host=\"a\"}\n1600000000 1\n1600000001 2\n", stdout.String())
+
+	stdout.Reset()
+	code = run([]string{"dump", "--data", dataPath, "--precision", "s", "--metric", "cpu", "--from", "1600000001"}, nil, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "cpu{host=\"a\"}\n1600000001 2\n\ncpu{host=\"b\"}\n1600000001 3\n", stdout.String())
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing metric", args: []string{"dump", "--data", dataPath}},
+		{name: "invalid label", args: []string{"dump", "--data", dataPath, "--metric", "cpu", "--label", "host"}},
+		{name: "no series", args: []string{"dump", "--data", dataPath, "--precision", "s", "--metric", "disk"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, 1, run(tt.args, nil, &stdout, &stderr))
+		})
+	}
+
+	// It doesn't leave anything behind.
+	entries, err := os.ReadDir(filepath.Join(dataPath, walDirName))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
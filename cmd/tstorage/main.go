@@ -0,0 +1,184 @@
+// Command tstorage operates on the data directory of a closed storage.
+//
+// Usage:
+//
+//	tstorage export --data ./data [--metric name] [--from ts] [--to ts] [--partition p-<min>-<max>] [--format csv|jsonl|parquet] [--output file]
+//	tstorage import --data ./data [--format csv|jsonl] [--input file]
+//	tstorage inspect --data ./data [--retention 336h] [--top 10]
+//	tstorage dump --data ./data --metric name [--label name=value]... [--from ts] [--to ts]
+//
+// Timestamps are either integers in the storage's precision or RFC3339.
+// The storage must not be running while the command operates on its data directory, except for inspect which only reads files.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/timeutil"
+)
+
+const (
+	walDirName = "wal"
+
+	// Retention applied while the command opens the storage, so that it never removes partitions.
+	noRetention = time.Duration(math.MaxInt64)
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+var commands = []command{
+	{name: "export", summary: "Write data points out as CSV, JSON Lines or Parquet", run: runExport},
+	{name: "import", summary: "Backfill data points from CSV or JSON Lines", run: runImport},
+	{name: "inspect", summary: "List partitions, WAL segments, top series and labels", run: runInspect},
+	{name: "dump", summary: "Print the data points of series", run: runDump},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage(stderr)
+		return 2
+	}
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		err := c.run(args[1:], stdin, stdout, stderr)
+		if errors.Is(err, flag.ErrHelp) {
+			return 2
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "tstorage %s: %v\n", c.name, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(stderr, "tstorage: unknown command %q\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: tstorage <command> [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w, "\nRun 'tstorage <command> --help' for the flags of each command.")
+}
+
+// storageFlags are flags shared by commands opening the storage.
+type storageFlags struct {
+	dataPath          string
+	precision         string
+	partitionDuration time.Duration
+}
+
+func (f *storageFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dataPath, "data", "", "Path to the data directory (required)")
+	fs.StringVar(&f.precision, "precision", string(tstorage.Nanoseconds), "Timestamp precision of the storage: ns, us, ms or s")
+	fs.DurationVar(&f.partitionDuration, "partition-duration", time.Hour, "Partition duration of the storage")
+}
+
+func (f *storageFlags) validate() (tstorage.TimestampPrecision, error) {
+	if f.dataPath == "" {
+		return "", errors.New("--data is required")
+	}
+	if f.partitionDuration <= 0 {
+		return "", errors.New("--partition-duration must be positive")
+	}
+	return parsePrecision(f.precision)
+}
+
+// open opens the storage at the given data directory, which must not be used by others.
+// The given options are applied in addition to the ones derived from flags.
+func (f *storageFlags) open(precision tstorage.TimestampPrecision, opts ...tstorage.Option[float64]) (tstorage.Storage[float64], error) {
+	if err := checkClosed(f.dataPath); err != nil {
+		return nil, err
+	}
+	return tstorage.NewStorage(append([]tstorage.Option[float64]{
+		tstorage.WithDataPath[float64](f.dataPath),
+		tstorage.WithTimestampPrecision[float64](precision),
+		tstorage.WithPartitionDuration[float64](f.partitionDuration),
+		tstorage.WithRetention[float64](noRetention),
+		tstorage.WithWALBufferedSize[float64](-1),
+	}, opts...)...)
+}
+
+// checkClosed ensures the storage at the given data directory has been closed,
+// in which case all data points are in disk partitions and no WAL segment is left.
+func checkClosed(dataPath string) error {
+	entries, err := os.ReadDir(filepath.Join(dataPath, walDirName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check WAL: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("WAL segments found in %s; the storage must be closed beforehand", dataPath)
+	}
+	return nil
+}
+
+func parsePrecision(s string) (tstorage.TimestampPrecision, error) {
+	switch p := tstorage.TimestampPrecision(s); p {
+	case tstorage.Nanoseconds, tstorage.Microseconds, tstorage.Milliseconds, tstorage.Seconds:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown precision %q", s)
+	}
+}
+
+// parseTimestamp parses either an integer in the given precision or RFC3339.
+func parseTimestamp(s string, precision tstorage.TimestampPrecision) (int64, error) {
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ts, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q: want an integer or RFC3339", s)
+	}
+	return timeutil.ToUnix(t, precision), nil
+}
+
+// partitionRange gives back the minimum and maximum timestamps of the partition directory,
+// which is named "p-<min>-<max>".
+func partitionRange(name string) (int64, int64, bool) {
+	rest, ok := strings.CutPrefix(name, "p-")
+	if !ok || rest == "" {
+		return 0, 0, false
+	}
+	// The minimum can be negative, so look for the separator after the first character.
+	i := strings.IndexByte(rest[1:], '-')
+	if i < 0 {
+		return 0, 0, false
+	}
+	min, err := strconv.ParseInt(rest[:i+1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	max, err := strconv.ParseInt(rest[i+2:], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return min, max, true
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/parquet"
+)
+
+func TestImportExport(t *testing.T) {
+	dataPath := t.TempDir()
+	// The storage already has data points far newer than the ones imported.
+	storage, err := tstorage.NewStorage(
+		tstorage.WithDataPath[float64](dataPath),
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	require.NoError(t, err)
+	require.NoError(t, storage.InsertRows([]tstorage.Row[float64]{
+		{Metric: "cpu", Labels: []tstorage.Label{{Name: "host", Value: "a"}}, DataPoint: tstorage.DataPoint[float64]{Timestamp: 1700000000, Value: 9}},
+	}))
+	require.NoError(t, storage.Close())
+
+	input := strings.Join([]string{
+		"metric,labels,timestamp,value",
+		`cpu,"{""host"":""a""}",1600007200,3`,
+		`cpu,"{""host"":""a""}",1600000000,1`,
+		`cpu,"{""host"":""a""}",1600003600,2`,
+		`cpu,"{""host"":""a""}",1600003600,2`,
+		`mem,{},1600000000,10`,
+	}, "\n")
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"import", "--data", dataPath, "--precision", "s"}, strings.NewReader(input), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stderr.String(), "imported 4 rows, rejected 1 rows")
+	assert.Contains(t, stderr.String(), "row 4: ")
+
+	// Rows within the range of existing partitions are rejected.
+	stderr.Reset()
+	input = strings.Join([]string{
+		"metric,labels,timestamp,value",
+		`cpu,"{""host"":""a""}",1600000000,5`,
+		`cpu,"{""host"":""a""}",1600010800,4`,
+	}, "\n")
+	code = run([]string{"import", "--data", dataPath, "--precision", "s"}, strings.NewReader(input), &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stderr.String(), "imported 1 rows, rejected 1 rows")
+	assert.Contains(t, stderr.String(), "row 1: ")
+
+	// Nothing but partitions is left.
+	entries, err := os.ReadDir(dataPath)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.True(t, strings.HasPrefix(e.Name(), "p-") || e.Name() == walDirName, e.Name())
+	}
+
+	storage, err = tstorage.NewStorage(
+		tstorage.WithDataPath[float64](dataPath),
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	require.NoError(t, err)
+	points, err := storage.Select("cpu", []tstorage.Label{{Name: "host", Value: "a"}}, 1600000000, 1800000000)
+	require.NoError(t, err)
+	assert.Equal(t, []*tstorage.DataPoint[float64]{
+		{Timestamp: 1600000000, Value: 1},
+		{Timestamp: 1600003600, Value: 2},
+		{Timestamp: 1600007200, Value: 3},
+		{Timestamp: 1600010800, Value: 4},
+		{Timestamp: 1700000000, Value: 9},
+	}, points)
+	require.NoError(t, storage.Close())
+
+	stdout.Reset()
+	stderr.Reset()
+	code = run([]string{"export", "--data", dataPath, "--precision", "s", "--metric", "cpu", "--from", "1600003600", "--to", "2020-09-13T14:26:40Z"}, nil, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	assert.Equal(t, "metric,labels,timestamp,value\ncpu,\"{\"\"host\"\":\"\"a\"\"}\",1600003600,2\n", stdout.String())
+
+	output := filepath.Join(t.TempDir(), "out.jsonl")
+	code = run([]string{"export", "--data", dataPath, "--precision", "s", "--format", "jsonl", "--output", output}, nil, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	b, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		`{"metric":"cpu","labels":{"host":"a"},"timestamp":1600000000,"value":1}`,
+		`{"metric":"mem","timestamp":1600000000,"value":10}`,
+		`{"metric":"cpu","labels":{"host":"a"},"timestamp":1600003600,"value":2}`,
+		`{"metric":"cpu","labels":{"host":"a"},"timestamp":1600007200,"value":3}`,
+		`{"metric":"cpu","labels":{"host":"a"},"timestamp":1600010800,"value":4}`,
+		`{"metric":"cpu","labels":{"host":"a"},"timestamp":1700000000,"value":9}`,
+	}, "\n")+"\n", string(b))
+
+	// The partition holding the imported data points from 1600000000.
+	var partition string
+	entries, err = os.ReadDir(dataPath)
+	require.NoError(t, err)
+	for _, e := range entries {
+		if min, _, ok := partitionRange(e.Name()); ok && min == 1600000000 {
+			partition = e.Name()
+		}
+	}
+	require.NotEmpty(t, partition)
+	output = filepath.Join(t.TempDir(), "out.parquet")
+	code = run([]string{"export", "--data", dataPath, "--precision", "s", "--format", "parquet", "--partition", partition, "--output", output}, nil, &stdout, &stderr)
+	require.Equal(t, 0, code, stderr.String())
+	f, err := os.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+	rows, err := parquet.ReadAll(f, info.Size())
+	require.NoError(t, err)
+	assert.Equal(t, []parquet.Row{
+		{Metric: "cpu", Labels: []parquet.Label{{Name: "host", Value: "a"}}, Timestamp: 1600000000, Value: 1},
+		{Metric: "mem", Labels: []parquet.Label{}, Timestamp: 1600000000, Value: 10},
+	}, rows)
+}
+
+func TestImport_batches(t *testing.T) {
+	dataPath := t.TempDir()
+	// Rows newest first span several batches, the later of which are far older than the out-of-order window.
+	n := 2*importBatchSize + 1
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "cpu,{},%d,%d\n", 1600000000+(n-i)*60, i)
+	}
+	b.WriteString("cpu,{},now,1\n")
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"import", "--data", dataPath, "--precision", "s"}, strings.NewReader(b.String()), &stdout, &stderr)
+	// Rows before the malformed one are kept.
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), fmt.Sprintf("imported %d rows, rejected 0 rows", n))
+	assert.Contains(t, stderr.String(), "invalid timestamp")
+
+	storage, err := tstorage.NewStorage(
+		tstorage.WithDataPath[float64](dataPath),
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	require.NoError(t, err)
+	points, err := storage.Select("cpu", nil, 1600000000, 1800000000)
+	require.NoError(t, err)
+	assert.Len(t, points, n)
+	require.NoError(t, storage.Close())
+}
+
+func TestRun_errors(t *testing.T) {
+	dataPath := t.TempDir()
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "no command", args: nil, want: 2},
+		{name: "unknown command", args: []string{"compact"}, want: 2},
+		{name: "help", args: []string{"export", "--help"}, want: 2},
+		{name: "missing data", args: []string{"export"}, want: 1},
+		{name: "unknown precision", args: []string{"export", "--data", dataPath, "--precision", "m"}, want: 1},
+		{name: "invalid from", args: []string{"export", "--data", dataPath, "--from", "yesterday"}, want: 1},
+		{name: "invalid partition", args: []string{"export", "--data", dataPath, "--partition", "wal"}, want: 1},
+		{name: "partition with from", args: []string{"export", "--data", dataPath, "--partition", "p-1-2", "--from", "1"}, want: 1},
+		{name: "unknown format", args: []string{"import", "--data", dataPath, "--format", "xml"}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			assert.Equal(t, tt.want, run(tt.args, strings.NewReader(""), &stdout, &stderr))
+		})
+	}
+
+	// A storage that is running or wasn't closed has WAL segments.
+	require.NoError(t, os.Mkdir(filepath.Join(dataPath, walDirName), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dataPath, walDirName, "000000"), nil, 0o644))
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 1, run([]string{"import", "--data", dataPath}, strings.NewReader(""), &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "must be closed")
+}
+
+func Test_parseTimestamp(t *testing.T) {
+	got, err := parseTimestamp("1600000000", tstorage.Seconds)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1600000000), got)
+	got, err = parseTimestamp("2020-09-13T12:26:40.5Z", tstorage.Milliseconds)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1600000000500), got)
+	_, err = parseTimestamp("1.5", tstorage.Seconds)
+	assert.Error(t, err)
+}
+
+func Test_partitionRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int64
+		ok       bool
+	}{
+		{name: "p-100-200", min: 100, max: 200, ok: true},
+		{name: "p--200--100", min: -200, max: -100, ok: true},
+		{name: "p-100", ok: false},
+		{name: "p-", ok: false},
+		{name: "wal", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			min, max, ok := partitionRange(tt.name)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.min, min)
+			assert.Equal(t, tt.max, max)
+		})
+	}
+}
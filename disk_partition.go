@@ -109,6 +109,17 @@ func (d *diskPartition[T]) insertRows(_ []Row[T]) ([]Row[T], error) {
 	return nil, fmt.Errorf("can't insert rows into disk partition")
 }
 
+func (d *diskPartition[T]) metricNames() []string {
+	if d.expired() {
+		return nil
+	}
+	names := make([]string, 0, len(d.meta.Metrics))
+	for name := range d.meta.Metrics {
+		names = append(names, name)
+	}
+	return names
+}
+
 func (d *diskPartition[T]) selectDataPoints(metric string, labels []Label, start, end int64) ([]*DataPoint[T], error) {
 	if d.expired() {
 		return nil, fmt.Errorf("this partition is expired: %w", ErrNoDataPoints)
@@ -170,6 +181,15 @@ func (d *diskPartition[T]) clean() error {
 	return nil
 }
 
+// close releases the memory-mapped data file, which must not be read by anyone anymore.
+func (d *diskPartition[T]) close() error {
+	if err := syscall.Munmap(d.mappedFile); err != nil {
+		return fmt.Errorf("failed to unmap the data file of the partition (%d~%d): %w", d.minTimestamp(), d.maxTimestamp(), err)
+	}
+	d.mappedFile = nil
+	return nil
+}
+
 func (d *diskPartition[T]) expired() bool {
 	diff := time.Since(d.meta.CreatedAt)
 	if diff > d.retention {
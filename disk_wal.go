@@ -2,6 +2,7 @@ package tstorage
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,94 +11,300 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-// diskWAL contains multiple segment files. One segment is responsible for one partition.
-// They can be easily sorted because they are named using the created timestamp.
+// diskWAL contains multiple segment files. Segments written while a partition is the head are
+// grouped so that they can be removed together once the partition gets persisted.
+// A segment is rotated within the group when it exceeds the maximum size.
+// They can be easily sorted because they are named using the zero-padded sequence number.
 // Macro layout is like:
 /*
   .wal/
-  ├── 0
-  └── 1
+  ├── 0000000000
+  └── 0000000001
 */
 type diskWAL[T any] struct {
-	dir          string
-	bufferedSize int
+	dir            string
+	bufferedSize   int
+	maxSegmentSize int64
+	syncPolicy     WALSyncPolicy
 	// Buffered-writer to the active segment
 	w *bufio.Writer
 	// File descriptor to the active segment
-	fd    *os.File
+	fd *os.File
+	// The bytes written to the active segment
+	segmentSize int64
+	// The sizes of segments on disk other than the active one, keyed by the sequence number.
+	segmentSizes map[uint32]int64
+	// Whether all segments have been removed, including the active one.
+	removed bool
+	// The bytes written to all segments since opening
+	bytesWritten uint64
+	syncDuration *histogram
+	// Scratch buffer to build a record
+	buf []byte
+	// Nil means no one observes.
+	observer walObserver
+	// The sequence number of the next segment
 	index uint32
-	mu    sync.Mutex
+	// Segments numbered lower than it were created before opening, which are supposed to be recovered.
+	firstIndex uint32
+	// The sequence number of the first segment for each group, oldest first.
+	groups []uint32
+	mu     sync.Mutex
+
+	// The number of appends so far, which must be updated while holding mu.
+	appended uint64
+	// The number of appends fsynced so far.
+	synced uint64
+	// syncMu serializes fsync. It must be acquired before mu.
+	syncMu sync.Mutex
 }
 
-func newDiskWAL[T any](dir string, bufferedSize int) (wal[T], error) {
+// newDiskWAL opens the WAL under the given directory, and then creates a new segment numbered
+// after the existing ones. Giving a non-positive maxSegmentSize means segments never get rotated by size.
+func newDiskWAL[T any](dir string, bufferedSize int, maxSegmentSize int64, syncPolicy WALSyncPolicy) (wal[T], error) {
 	if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
 		return nil, fmt.Errorf("failed to make WAL dir: %w", err)
 	}
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
 	w := &diskWAL[T]{
-		dir:          dir,
-		bufferedSize: bufferedSize,
+		dir:            dir,
+		bufferedSize:   bufferedSize,
+		maxSegmentSize: maxSegmentSize,
+		syncPolicy:     syncPolicy,
+		syncDuration:   newHistogram(defaultDurationBuckets),
+		segmentSizes:   make(map[uint32]int64, len(segments)),
 	}
-	f, err := w.createSegmentFile(dir)
-	if err != nil {
+	for _, seg := range segments {
+		info, err := os.Stat(filepath.Join(dir, seg.name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat WAL segment: %w", err)
+		}
+		w.segmentSizes[seg.index] = info.Size()
+	}
+	if len(segments) > 0 {
+		w.index = segments[len(segments)-1].index + 1
+	}
+	w.firstIndex = w.index
+	if err := w.openSegment(); err != nil {
 		return nil, err
 	}
-	w.fd = f
-	w.w = bufio.NewWriterSize(f, bufferedSize)
+	w.groups = []uint32{w.firstIndex}
 
 	return w, nil
 }
 
 // append appends the given entry to the end of a file via the file descriptor it has.
+// With WALSyncAlways, it doesn't return until the entry gets fsynced.
 func (w *diskWAL[T]) append(op walOperation, rows []Row[T]) error {
+	seq, err := w.write(op, rows)
+	if err != nil {
+		return err
+	}
+	if w.syncPolicy.mode != walSyncAlways {
+		return nil
+	}
+	return w.syncUpTo(seq)
+}
+
+// write writes the given entry to the buffer, and gives back the sequence number of it.
+// It rotates the active segment once it exceeds the maximum size.
+func (w *diskWAL[T]) write(op walOperation, rows []Row[T]) (uint64, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	switch op {
 	case operationInsert:
-		for _, row := range rows {
-			// Write the operation type
-			if err := w.w.WriteByte(byte(op)); err != nil {
-				return fmt.Errorf("failed to write operation: %w", err)
-			}
-			name := marshalMetricName(row.Metric, row.Labels)
-			// Write the length of the metric name
-			lBuf := make([]byte, binary.MaxVarintLen64)
-			n := binary.PutUvarint(lBuf, uint64(len(name)))
-			if _, err := w.w.Write(lBuf[:n]); err != nil {
-				return fmt.Errorf("failed to write the length of the metric name: %w", err)
+		var observed []byte
+		for i := range rows {
+			w.buf = appendInsertRecord(w.buf[:0], &rows[i])
+			if _, err := w.w.Write(w.buf); err != nil {
+				return 0, fmt.Errorf("failed to write the record: %w", err)
 			}
-			// Write the metric name
-			if _, err := w.w.WriteString(name); err != nil {
-				return fmt.Errorf("failed to write the metric name: %w", err)
-			}
-			// Write the timestamp
-			tsBuf := make([]byte, binary.MaxVarintLen64)
-			n = binary.PutVarint(tsBuf, row.DataPoint.Timestamp)
-			if _, err := w.w.Write(tsBuf[:n]); err != nil {
-				return fmt.Errorf("failed to write the timestamp: %w", err)
-			}
-			// Write the value
-			vBuf := make([]byte, binary.MaxVarintLen64)
-			n = binary.PutUvarint(vBuf, math.Float64bits(row.DataPoint.Value))
-			if _, err := w.w.Write(vBuf[:n]); err != nil {
-				return fmt.Errorf("failed to write the value: %w", err)
+			w.segmentSize += int64(len(w.buf))
+			w.bytesWritten += uint64(len(w.buf))
+			if w.observer != nil {
+				observed = append(observed, w.buf...)
 			}
 		}
+		if w.observer != nil {
+			w.observer.observeRecords(observed)
+		}
 	default:
-		return fmt.Errorf("unknown operation %v given", op)
+		return 0, fmt.Errorf("unknown operation %v given", op)
+	}
+	w.appended++
+	if w.maxSegmentSize > 0 && w.segmentSize >= w.maxSegmentSize {
+		// Records are never split across segments, so it may slightly exceed the maximum size.
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+		return w.appended, nil
 	}
 	if w.bufferedSize == 0 {
-		return w.flush()
+		return w.appended, w.flush()
 	}
 
+	return w.appended, nil
+}
+
+// setObserver makes the given observer receive records written from now on.
+func (w *diskWAL[T]) setObserver(o walObserver) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.observer = o
+}
+
+// withRecords calls fn with all rows held in the segments. No record gets written while calling fn.
+// Most of the rows are read without blocking writers; only the ones written meanwhile are read
+// while holding the lock.
+func (w *diskWAL[T]) withRecords(fn func(rows []Row[T])) error {
+	w.mu.Lock()
+	extents, err := w.extents()
+	w.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	rows, err := readExtents[T](w.dir, extents, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		// It has been removed meanwhile; read all while holding the lock instead.
+		extents = nil
+	} else if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	latest, err := w.extents()
+	if err != nil {
+		return err
+	}
+	if len(extents) > 0 && (len(latest) == 0 || latest[0].index > extents[0].index) {
+		// Some of the rows read have been removed along with their partitions, so read all again.
+		extents = nil
+	}
+	if extents == nil {
+		rows = rows[:0]
+	}
+	rows, err = readExtents(w.dir, latest, extents, rows...)
+	if err != nil {
+		return err
+	}
+	fn(rows)
 	return nil
 }
 
+// segmentExtent is the extent of a segment file holding records.
+type segmentExtent struct {
+	segmentFile
+	size int64
+}
+
+// extents flushes buffered records, and then gives back the extent of all segments.
+// The caller must hold mu.
+func (w *diskWAL[T]) extents() ([]segmentExtent, error) {
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	segments, err := listSegments(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	extents := make([]segmentExtent, 0, len(segments))
+	for _, seg := range segments {
+		info, err := os.Stat(filepath.Join(w.dir, seg.name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat WAL segment: %w", err)
+		}
+		extents = append(extents, segmentExtent{segmentFile: seg, size: info.Size()})
+	}
+	return extents, nil
+}
+
+// readExtents appends to dst rows held in the given extents, leaving out the part covered by
+// the extents already read.
+func readExtents[T any](dir string, extents, read []segmentExtent, dst ...Row[T]) ([]Row[T], error) {
+	sizes := make(map[uint32]int64, len(read))
+	for _, e := range read {
+		sizes[e.index] = e.size
+	}
+	for _, e := range extents {
+		if sizes[e.index] >= e.size {
+			continue
+		}
+		var err error
+		if dst, err = readExtent(dir, e, sizes[e.index], dst); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// readExtent appends to dst rows held in the given extent from the given offset, which must be
+// at the boundary of records.
+func readExtent[T any](dir string, e segmentExtent, offset int64, dst []Row[T]) ([]Row[T], error) {
+	fd, err := os.Open(filepath.Join(dir, e.name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL segment file: %w", err)
+	}
+	defer fd.Close()
+	// Records may be being appended beyond the extent.
+	seg, err := newSegmentReader[T](io.NewSectionReader(fd, 0, e.size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header of WAL segment file %q: %w", e.name, err)
+	}
+	if offset > 0 {
+		seg.r = bufio.NewReader(io.NewSectionReader(fd, offset, e.size-offset))
+	}
+	for seg.next() {
+		dst = append(dst, seg.record().row)
+	}
+	if err := seg.error(); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("encounter an error while reading WAL segment file %q: %w", e.name, err)
+	}
+	return dst, nil
+}
+
+// appendInsertRecord appends the insert record of the given row in the latest format to dst.
+// Invalid labels are left out as they are when inserted.
+func appendInsertRecord[T any](dst []byte, row *Row[T]) []byte {
+	dst = append(dst, byte(operationInsert))
+	dst = appendString(dst, row.Metric)
+	var n int
+	for i := range row.Labels {
+		if row.Labels[i].Name != "" && row.Labels[i].Value != "" {
+			n++
+		}
+	}
+	dst = binary.AppendUvarint(dst, uint64(n))
+	for i := range row.Labels {
+		label := &row.Labels[i]
+		if label.Name == "" || label.Value == "" {
+			continue
+		}
+		dst = appendString(dst, label.Name)
+		dst = appendString(dst, label.Value)
+	}
+	dst = binary.AppendVarint(dst, row.Timestamp)
+	return binary.AppendUvarint(dst, math.Float64bits(row.Value))
+}
+
+// appendString appends the given string prefixed with its length to dst.
+func appendString(dst []byte, s string) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
 // flush flushes all buffered entries to the underlying file.
 func (w *diskWAL[T]) flush() error {
 	if err := w.w.Flush(); err != nil {
@@ -106,41 +313,138 @@ func (w *diskWAL[T]) flush() error {
 	return nil
 }
 
-// punctuate set boundary and creates a new segment.
+// syncUpTo fsyncs the active segment unless the entry with the given sequence number is already fsynced.
+// Concurrent callers are grouped into a single fsync: while one is fsyncing,
+// the others wait and then the next one fsyncs all entries written in the meantime.
+func (w *diskWAL[T]) syncUpTo(seq uint64) error {
+	if atomic.LoadUint64(&w.synced) >= seq {
+		return nil
+	}
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	if atomic.LoadUint64(&w.synced) >= seq {
+		return nil
+	}
+	return w.syncLocked()
+}
+
+// sync flushes and fsyncs all entries written so far.
+func (w *diskWAL[T]) sync() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	return w.syncLocked()
+}
+
+// syncLocked flushes and fsyncs all entries written so far. The caller must hold syncMu.
+func (w *diskWAL[T]) syncLocked() error {
+	w.mu.Lock()
+	if err := w.flush(); err != nil {
+		w.mu.Unlock()
+		return err
+	}
+	target := w.appended
+	fd := w.fd
+	w.mu.Unlock()
+
+	// Writers can keep appending while fsyncing. The segment may get rotated by size meanwhile,
+	// but then it has already been fsynced by rotate.
+	start := time.Now()
+	if err := fd.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return fmt.Errorf("failed to fsync WAL segment: %w", err)
+	}
+	w.syncDuration.since(start)
+	w.markSynced(target)
+	return nil
+}
+
+// markSynced records that entries up to the given sequence number have been fsynced.
+func (w *diskWAL[T]) markSynced(seq uint64) {
+	for {
+		synced := atomic.LoadUint64(&w.synced)
+		if synced >= seq || atomic.CompareAndSwapUint64(&w.synced, synced, seq) {
+			return
+		}
+	}
+}
+
+// punctuate set boundary and creates a new segment group.
 func (w *diskWAL[T]) punctuate() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	if err := w.rotate(); err != nil {
+		return err
+	}
+	w.groups = append(w.groups, w.index-1)
+	return nil
+}
+
+// rotate closes the active segment and then opens a new one. The caller must hold mu.
+func (w *diskWAL[T]) rotate() error {
 	if err := w.flush(); err != nil {
 		return err
 	}
+	if w.syncPolicy.mode != walSyncNever {
+		// Make sure the last records of the segment are durable.
+		start := time.Now()
+		if err := w.fd.Sync(); err != nil {
+			return fmt.Errorf("failed to fsync WAL segment: %w", err)
+		}
+		w.syncDuration.since(start)
+		w.markSynced(w.appended)
+	}
 	if err := w.fd.Close(); err != nil {
 		return err
 	}
-	f, err := w.createSegmentFile(w.dir)
-	if err != nil {
+	w.segmentSizes[w.index-1] = w.segmentSize
+	return w.openSegment()
+}
+
+// removeOldest removes the segments of the oldest group, unless it is the only group being written.
+func (w *diskWAL[T]) removeOldest() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.groups) < 2 {
+		return nil
+	}
+	if err := w.removeSegmentsBefore(w.groups[1]); err != nil {
 		return err
 	}
-	w.fd = f
-	w.w = bufio.NewWriterSize(f, w.bufferedSize)
+	w.groups = w.groups[1:]
 	return nil
 }
 
-// truncateOldest removes only the oldest segment.
-func (w *diskWAL[T]) removeOldest() error {
+// removeRecovered removes segments created before opening, whose records are supposed to be
+// recovered and written again into the current segments.
+func (w *diskWAL[T]) removeRecovered() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	files, err := os.ReadDir(w.dir)
+	return w.removeSegmentsBefore(w.firstIndex)
+}
+
+// removeSegmentsBefore removes all segments numbered lower than the given one.
+func (w *diskWAL[T]) removeSegmentsBefore(index uint32) error {
+	segments, err := listSegments(w.dir)
 	if err != nil {
-		return fmt.Errorf("failed to read WAL directory: %w", err)
+		return err
 	}
-	if len(files) == 0 {
-		return fmt.Errorf("no segment found")
+	for _, seg := range segments {
+		if seg.index >= index {
+			break
+		}
+		if err := os.Remove(filepath.Join(w.dir, seg.name)); err != nil {
+			return fmt.Errorf("failed to remove WAL segment: %w", err)
+		}
+		delete(w.segmentSizes, seg.index)
 	}
-	return os.RemoveAll(filepath.Join(w.dir, files[0].Name()))
+	return nil
 }
 
 // removeAll removes all segment files.
 func (w *diskWAL[T]) removeAll() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 	if err := w.fd.Close(); err != nil {
@@ -149,35 +453,83 @@ func (w *diskWAL[T]) removeAll() error {
 	if err := os.RemoveAll(w.dir); err != nil {
 		return fmt.Errorf("failed to remove files under %q: %w", w.dir, err)
 	}
+	w.segmentSizes = make(map[uint32]int64)
+	w.removed = true
 	return os.MkdirAll(w.dir, fs.ModePerm)
 }
 
-// refresh removes all segment files and make a new segment.
-func (w *diskWAL[T]) refresh() error {
-	if err := w.removeAll(); err != nil {
-		return err
-	}
+// stats gives back the total size of segments on disk, which is kept track of without touching them.
+func (w *diskWAL[T]) stats() walStats {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	st := walStats{
+		bytesWritten: w.bytesWritten,
+		syncDuration: w.syncDuration.snapshot(),
+		segments:     len(w.segmentSizes),
+	}
+	for _, size := range w.segmentSizes {
+		st.size += size
+	}
+	if !w.removed {
+		st.size += w.segmentSize - int64(w.w.Buffered())
+		st.segments++
+	}
+	return st
+}
 
-	f, err := w.createSegmentFile(w.dir)
+// openSegment creates a new file with the name of the numbering index, and then makes it active.
+// The caller must hold mu unless it's not shared yet.
+func (w *diskWAL[T]) openSegment() error {
+	name := segmentName(w.index)
+	f, err := os.OpenFile(filepath.Join(w.dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create segment file: %w", err)
 	}
+	w.index++
 	w.fd = f
 	w.w = bufio.NewWriterSize(f, w.bufferedSize)
+	w.segmentSize = 0
+
+	header := append(append([]byte{}, walSegmentMagic...), walFormatLatest)
+	if _, err := w.w.Write(header); err != nil {
+		return fmt.Errorf("failed to write the segment header: %w", err)
+	}
+	w.segmentSize += int64(len(header))
 	return nil
 }
 
-// createSegmentFile creates a new file with the name of the numbering index.
-func (w *diskWAL[T]) createSegmentFile(dir string) (*os.File, error) {
-	name := strconv.Itoa(int(atomic.LoadUint32(&w.index)))
-	f, err := os.OpenFile(filepath.Join(dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// segmentName gives back the zero-padded file name, so that segments are sorted lexically as well.
+func segmentName(index uint32) string {
+	return fmt.Sprintf("%010d", index)
+}
+
+type segmentFile struct {
+	index uint32
+	name  string
+}
+
+// listSegments gives back all segment files under the given directory in order by sequence number.
+// Names without zero-padding, written by older versions, are also accepted.
+func listSegments(dir string) ([]segmentFile, error) {
+	files, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create segment file: %w", err)
+		return nil, fmt.Errorf("failed to read the WAL dir: %w", err)
 	}
-	atomic.AddUint32(&w.index, 1)
-	return f, nil
+	segments := make([]segmentFile, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() {
+			return nil, fmt.Errorf("unexpected directory found under the WAL directory: %s", f.Name())
+		}
+		index, err := strconv.ParseUint(f.Name(), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected file found under the WAL directory: %s", f.Name())
+		}
+		segments = append(segments, segmentFile{index: uint32(index), name: f.Name()})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].index < segments[j].index
+	})
+	return segments, nil
 }
 
 type walRecord[T any] struct {
@@ -187,14 +539,14 @@ type walRecord[T any] struct {
 
 type diskWALReader[T any] struct {
 	dir          string
-	files        []os.DirEntry
+	files        []segmentFile
 	rowsToInsert []Row[T]
 }
 
 func newDiskWALReader[T any](dir string) (*diskWALReader[T], error) {
-	files, err := os.ReadDir(dir)
+	files, err := listSegments(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read the WAL dir: %w", err)
+		return nil, err
 	}
 
 	return &diskWALReader[T]{
@@ -204,19 +556,17 @@ func newDiskWALReader[T any](dir string) (*diskWALReader[T], error) {
 	}, nil
 }
 
-// readAll reads all segment files and caches the result for each operation.
+// readAll reads all segment files in order and caches the result for each operation.
 func (f *diskWALReader[T]) readAll() error {
 	for _, file := range f.files {
-		if file.IsDir() {
-			return fmt.Errorf("unexpected directory found under the WAL directory: %s", file.Name())
-		}
-		fd, err := os.Open(filepath.Join(f.dir, file.Name()))
+		fd, err := os.Open(filepath.Join(f.dir, file.name))
 		if err != nil {
 			return fmt.Errorf("failed to open WAL segment file: %w", err)
 		}
-		segment := &segment[T]{
-			file: fd,
-			r:    bufio.NewReader(fd),
+		segment, err := newSegment[T](fd)
+		if err != nil {
+			fd.Close()
+			return fmt.Errorf("failed to read the header of WAL segment file %q: %w", file.name, err)
 		}
 		for segment.next() {
 			rec := segment.record()
@@ -230,26 +580,81 @@ func (f *diskWALReader[T]) readAll() error {
 		}
 
 		err = segment.error()
-		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) || errors.Is(err, errCorruptedWAL) {
 			// It is not unusual for a line to be invalid, as it may well terminate in the middle of writing to the WAL.
-			return nil
+			// Records following a corrupted one can't be located either, so the rest of the segment is given up.
+			continue
 		}
 		if err != nil {
-			return fmt.Errorf("encounter an error while reading WAL segment file %q: %w", file.Name(), segment.error())
+			return fmt.Errorf("encounter an error while reading WAL segment file %q: %w", file.name, segment.error())
 		}
 	}
 	return nil
 }
 
+// errCorruptedWAL is given back for records that can't have been written, rather than being cut off.
+var errCorruptedWAL = errors.New("corrupted WAL record")
+
+const (
+	// Strings longer than this are read bit by bit, so that a corrupted length can't make it
+	// allocate more than the segment holds.
+	maxWALStringPrealloc = math.MaxUint16
+	// Labels beyond this are regarded as corrupted.
+	maxWALLabels = math.MaxUint16
+)
+
 // segment represents a segment file.
 type segment[T any] struct {
 	file *os.File
 	r    *bufio.Reader
+	// The WAL format the segment is written in
+	version byte
 	// FIXME: Use interface to support other operation type
 	current walRecord[T]
 	err     error
 }
 
+// newSegment reads the header of the given segment file to determine its format.
+func newSegment[T any](file *os.File) (*segment[T], error) {
+	f, err := newSegmentReader[T](file)
+	if err != nil {
+		return nil, err
+	}
+	f.file = file
+	return f, nil
+}
+
+// newSegmentReader is the same as newSegment, except it reads the segment from the given reader.
+func newSegmentReader[T any](r io.Reader) (*segment[T], error) {
+	f := &segment[T]{
+		r:       bufio.NewReader(r),
+		version: walFormatV1,
+	}
+	first, err := f.r.Peek(1)
+	if errors.Is(err, io.EOF) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != walSegmentMagic[0] {
+		// Segments in walFormatV1 have no header.
+		return f, nil
+	}
+	header := make([]byte, len(walSegmentMagic)+1)
+	if _, err := io.ReadFull(f.r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(walSegmentMagic)], walSegmentMagic) {
+		return nil, fmt.Errorf("invalid magic number %x", header[:len(walSegmentMagic)])
+	}
+	f.version = header[len(walSegmentMagic)]
+	if f.version < walFormatV1 || f.version > walFormatLatest {
+		return nil, fmt.Errorf("unsupported WAL format version %d", f.version)
+	}
+	return f, nil
+}
+
 func (f *segment[T]) next() bool {
 	op, err := f.r.ReadByte()
 	if errors.Is(err, io.EOF) {
@@ -261,16 +666,18 @@ func (f *segment[T]) next() bool {
 	}
 	switch walOperation(op) {
 	case operationInsert:
-		// Read the length of metric name.
-		metricLen, err := binary.ReadUvarint(f.r)
+		// Read the metric name.
+		metric, err := f.readString()
 		if err != nil {
-			f.err = fmt.Errorf("failed to read the length of metric name: %w", err)
+			f.err = fmt.Errorf("failed to read the metric name: %w", err)
 			return false
 		}
-		// Read the metric name.
-		metric := make([]byte, int(metricLen))
-		if _, err := io.ReadFull(f.r, metric); err != nil {
-			f.err = fmt.Errorf("failed to read the metric name: %w", err)
+		var labels []Label
+		if f.version == walFormatV1 {
+			// It is the one marshaled with labels.
+			metric, labels = unmarshalMetricName(metric)
+		} else if labels, err = f.readLabels(); err != nil {
+			f.err = fmt.Errorf("failed to read labels: %w", err)
 			return false
 		}
 		// Read timestamp.
@@ -288,7 +695,8 @@ func (f *segment[T]) next() bool {
 		f.current = walRecord[T]{
 			op: walOperation(op),
 			row: Row[T]{
-				Metric: string(metric),
+				Metric: metric,
+				Labels: labels,
 				DataPoint: DataPoint[T]{
 					Timestamp: ts,
 					Value:     math.Float64frombits(val),
@@ -296,13 +704,65 @@ func (f *segment[T]) next() bool {
 			},
 		}
 	default:
-		f.err = fmt.Errorf("unknown operation %v found", op)
+		f.err = fmt.Errorf("unknown operation %v found: %w", op, errCorruptedWAL)
 		return false
 	}
 
 	return true
 }
 
+// readString reads a string prefixed with its length.
+func (f *segment[T]) readString() (string, error) {
+	n, err := binary.ReadUvarint(f.r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the length: %w", err)
+	}
+	if n > math.MaxInt64 {
+		return "", fmt.Errorf("too long string with length %d: %w", n, errCorruptedWAL)
+	}
+	if n > maxWALStringPrealloc {
+		var b strings.Builder
+		if _, err := io.CopyN(&b, f.r, int64(n)); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		return b.String(), nil
+	}
+	b := make([]byte, int(n))
+	if _, err := io.ReadFull(f.r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// readLabels reads labels prefixed with the number of them.
+func (f *segment[T]) readLabels() ([]Label, error) {
+	n, err := binary.ReadUvarint(f.r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the number of labels: %w", err)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if n > maxWALLabels {
+		return nil, fmt.Errorf("too many labels %d: %w", n, errCorruptedWAL)
+	}
+	labels := make([]Label, 0, n)
+	for i := uint64(0); i < n; i++ {
+		var label Label
+		if label.Name, err = f.readString(); err != nil {
+			return nil, fmt.Errorf("failed to read the label name: %w", err)
+		}
+		if label.Value, err = f.readString(); err != nil {
+			return nil, fmt.Errorf("failed to read the label value: %w", err)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
 // error gives back an error if it has been facing an error while reading.
 func (f *segment[T]) error() error {
 	return f.err
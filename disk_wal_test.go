@@ -1,9 +1,12 @@
 package tstorage
 
 import (
+	"encoding/binary"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -26,7 +29,7 @@ func Test_diskWAL_append_read(t *testing.T) {
 	require.NoError(t, err)
 	path := filepath.Join(tmpDir, "wal")
 
-	wal, err := newDiskWAL[float64](path, 4096)
+	wal, err := newDiskWAL[float64](path, 4096, 0, WALSyncNever)
 	require.NoError(t, err)
 
 	// Append into two segments
@@ -51,24 +54,261 @@ func Test_diskWAL_append_read(t *testing.T) {
 	assert.Equal(t, rows, got)
 }
 
+func Test_diskWAL_append_syncAlways(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "wal")
+
+	w, err := newDiskWAL[float64](path, 4096, 0, WALSyncAlways)
+	require.NoError(t, err)
+
+	const writers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := w.append(operationInsert, []Row[float64]{
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Value: float64(i), Timestamp: int64(i + 1)}},
+			})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	dw := w.(*diskWAL[float64])
+	assert.Equal(t, uint64(writers), dw.synced)
+
+	// Every appended entry must be on the file without flushing explicitly.
+	reader, err := newDiskWALReader[float64](path)
+	require.NoError(t, err)
+	require.NoError(t, reader.readAll())
+	assert.Len(t, reader.rowsToInsert, writers)
+}
+
+func Test_diskWAL_sync(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "wal")
+
+	w, err := newDiskWAL[float64](path, 4096, 0, WALSyncNever)
+	require.NoError(t, err)
+	rows := []Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Value: 0.1, Timestamp: 1600000000}},
+	}
+	require.NoError(t, w.append(operationInsert, rows))
+	dw := w.(*diskWAL[float64])
+	assert.Equal(t, uint64(0), dw.synced)
+
+	require.NoError(t, w.sync())
+	assert.Equal(t, uint64(1), dw.synced)
+	reader, err := newDiskWALReader[float64](path)
+	require.NoError(t, err)
+	require.NoError(t, reader.readAll())
+	assert.Equal(t, rows, reader.rowsToInsert)
+}
+
 func Test_diskWAL_removeOldest(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "tstorage-test")
 	require.NoError(t, err)
-	for i := 0; i < 3; i++ {
-		err := os.Mkdir(filepath.Join(tmpDir, strconv.Itoa(i)), os.ModePerm)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "wal")
+
+	// Make three groups, where the first one consists of two segments.
+	w, err := newDiskWAL[float64](path, 4096, 1, WALSyncNever)
+	require.NoError(t, err)
+	row := []Row[float64]{{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1}}}
+	require.NoError(t, w.append(operationInsert, row))
+	require.NoError(t, w.punctuate())
+	require.NoError(t, w.punctuate())
+
+	require.NoError(t, w.removeOldest())
+	assert.Equal(t, []string{"0000000002", "0000000003"}, segmentNames(t, path))
+	require.NoError(t, w.removeOldest())
+	assert.Equal(t, []string{"0000000003"}, segmentNames(t, path))
+	// The group being written is never removed.
+	require.NoError(t, w.removeOldest())
+	assert.Equal(t, []string{"0000000003"}, segmentNames(t, path))
+}
+
+func Test_diskWAL_segmentSize(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "wal")
+
+	// A record of the row below takes 11 bytes.
+	w, err := newDiskWAL[float64](path, 4096, 20, WALSyncNever)
+	require.NoError(t, err)
+	var rows []Row[float64]
+	for i := 1; i <= 5; i++ {
+		row := Row[float64]{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: int64(i)}}
+		require.NoError(t, w.append(operationInsert, []Row[float64]{row}))
+		rows = append(rows, row)
+	}
+	require.NoError(t, w.flush())
+	assert.Equal(t, []string{"0000000000", "0000000001", "0000000002"}, segmentNames(t, path))
+
+	reader, err := newDiskWALReader[float64](path)
+	require.NoError(t, err)
+	require.NoError(t, reader.readAll())
+	assert.Equal(t, rows, reader.rowsToInsert)
+}
+
+func Test_diskWAL_withRecords(t *testing.T) {
+	// Segments get rotated every two records while writing.
+	w, err := newDiskWAL[float64](filepath.Join(t.TempDir(), "wal"), 4096, 20, WALSyncNever)
+	require.NoError(t, err)
+	dw := w.(*diskWAL[float64])
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for i := 1; i <= 1000; i++ {
+			row := Row[float64]{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: int64(i)}}
+			require.NoError(t, w.append(operationInsert, []Row[float64]{row}))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, dw.withRecords(func(rows []Row[float64]) {
+			// Neither missed nor duplicated.
+			for j := range rows {
+				require.Equal(t, int64(j+1), rows[j].Timestamp)
+			}
+		}))
+	}
+	<-doneCh
+	require.NoError(t, dw.withRecords(func(rows []Row[float64]) {
+		assert.Len(t, rows, 1000)
+	}))
+}
+
+func Test_diskWAL_reopen(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// Segments written by older versions aren't zero-padded.
+	rows := make([]Row[float64], 0, 11)
+	for i := 0; i <= 10; i++ {
+		row := Row[float64]{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: int64(i + 1)}}
+		err := os.WriteFile(filepath.Join(tmpDir, strconv.Itoa(i)), appendV1InsertRecord(nil, &row), 0644)
 		require.NoError(t, err)
+		rows = append(rows, row)
+	}
+
+	// They must be read in numerical order.
+	reader, err := newDiskWALReader[float64](tmpDir)
+	require.NoError(t, err)
+	require.NoError(t, reader.readAll())
+	assert.Equal(t, rows, reader.rowsToInsert)
+
+	// A new segment must be numbered after the existing ones.
+	w, err := newDiskWAL[float64](tmpDir, 4096, 0, WALSyncNever)
+	require.NoError(t, err)
+	assert.Contains(t, segmentNames(t, tmpDir), "0000000011")
+
+	require.NoError(t, w.removeRecovered())
+	assert.Equal(t, []string{"0000000011"}, segmentNames(t, tmpDir))
+}
+
+func Test_segment_next(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    []Row[float64]
+		wantErr bool
+	}{
+		{
+			name:    "empty",
+			content: []byte{},
+		},
+		{
+			name:    "header only",
+			content: append(append([]byte{}, walSegmentMagic...), walFormatV2),
+		},
+		{
+			name: "v1 with labels",
+			content: appendV1InsertRecord(nil, &Row[float64]{
+				Metric:    "metric1",
+				Labels:    []Label{{Name: "host", Value: "host-1"}},
+				DataPoint: DataPoint[float64]{Timestamp: 1, Value: 0.1},
+			}),
+			want: []Row[float64]{
+				{Metric: "metric1", Labels: []Label{{Name: "host", Value: "host-1"}}, DataPoint: DataPoint[float64]{Timestamp: 1, Value: 0.1}},
+			},
+		},
+		{
+			name: "v2 with labels",
+			content: appendInsertRecord(append(append([]byte{}, walSegmentMagic...), walFormatV2), &Row[float64]{
+				Metric:    "metric1",
+				Labels:    []Label{{Name: "host", Value: "host-1"}, {Name: "invalid"}},
+				DataPoint: DataPoint[float64]{Timestamp: 1, Value: 0.1},
+			}),
+			want: []Row[float64]{
+				{Metric: "metric1", Labels: []Label{{Name: "host", Value: "host-1"}}, DataPoint: DataPoint[float64]{Timestamp: 1, Value: 0.1}},
+			},
+		},
+		{
+			name:    "unsupported version",
+			content: append(append([]byte{}, walSegmentMagic...), walFormatLatest+1),
+			wantErr: true,
+		},
 	}
-	w := &diskWAL[float64]{
-		dir: tmpDir,
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "0")
+			require.NoError(t, os.WriteFile(path, tt.content, 0644))
+			fd, err := os.Open(path)
+			require.NoError(t, err)
+			defer fd.Close()
+			seg, err := newSegment[float64](fd)
+			assert.Equal(t, tt.wantErr, err != nil)
+			if err != nil {
+				return
+			}
+			var got []Row[float64]
+			for seg.next() {
+				got = append(got, seg.record().row)
+			}
+			require.NoError(t, seg.error())
+			assert.Equal(t, tt.want, got)
+		})
 	}
-	err = w.removeOldest()
+}
+
+func Test_segment_next_corrupted(t *testing.T) {
+	content := append(append([]byte{}, walSegmentMagic...), walFormatV2, byte(operationInsert))
+	content = appendString(content, "metric1")
+	// The number of labels, which is too large to allocate.
+	content = binary.AppendUvarint(content, 1<<62)
+
+	path := filepath.Join(t.TempDir(), "0")
+	require.NoError(t, os.WriteFile(path, content, 0644))
+	fd, err := os.Open(path)
+	require.NoError(t, err)
+	defer fd.Close()
+	seg, err := newSegment[float64](fd)
 	require.NoError(t, err)
-	files, err := os.ReadDir(w.dir)
+	assert.False(t, seg.next())
+	assert.ErrorIs(t, seg.error(), errCorruptedWAL)
+}
+
+// appendV1InsertRecord appends the insert record of the given row in walFormatV1 to dst.
+func appendV1InsertRecord(dst []byte, row *Row[float64]) []byte {
+	dst = append(dst, byte(operationInsert))
+	dst = appendString(dst, marshalMetricName(row.Metric, row.Labels))
+	dst = binary.AppendVarint(dst, row.Timestamp)
+	return binary.AppendUvarint(dst, math.Float64bits(row.Value))
+}
+
+func segmentNames(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := os.ReadDir(dir)
 	require.NoError(t, err)
-	want := []string{"1", "2"}
-	got := []string{}
+	names := make([]string, 0, len(files))
 	for _, f := range files {
-		got = append(got, f.Name())
+		names = append(names, f.Name())
 	}
-	assert.Equal(t, want, got)
+	return names
 }
@@ -17,6 +17,10 @@ func (f *fakePartition[T]) selectDataPoints(_ string, _ []Label, _, _ int64) ([]
 	return nil, f.err
 }
 
+func (f *fakePartition[T]) metricNames() []string {
+	return nil
+}
+
 func (f *fakePartition[T]) minTimestamp() int64 {
 	return f.minT
 }
@@ -0,0 +1,231 @@
+// Package graphite implements ingestion of the Graphite plaintext protocol into tstorage.
+// See https://graphite.readthedocs.io/en/latest/feeding-carbon.html
+//
+// Each line is formatted as "<path> <value> [timestamp]", where the timestamp is in seconds.
+// Tagged paths like "cpu.usage;host=host-1" are supported as well, whose tags become labels.
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// Point is a single line of the plaintext protocol.
+type Point struct {
+	Metric string
+	Labels []tstorage.Label
+	Value  float64
+	// Unix time in seconds. Zero means omitted.
+	Timestamp int64
+}
+
+// Rule maps paths matching the pattern to the metric name and labels.
+type Rule struct {
+	// Dot-separated path, whose segments are either literals or "*" matching any single segment.
+	// For instance, "servers.*.cpu.*" matches "servers.host-1.cpu.user".
+	Pattern string
+	// The metric name, in which "$n" is replaced with the segment matched by the n-th wildcard.
+	// Defaults to the path itself.
+	Metric string
+	// Labels whose values can contain "$n" as well.
+	Labels []tstorage.Label
+}
+
+type rule struct {
+	segments []string
+	metric   string
+	labels   []tstorage.Label
+}
+
+// Parser parses lines, mapping paths to metric names and labels by rules.
+type Parser struct {
+	rules []rule
+}
+
+// NewParser gives back a Parser applying the first matching rule to each path.
+// Paths matching no rules are used as metric names as they are.
+func NewParser(rules ...Rule) (*Parser, error) {
+	p := &Parser{rules: make([]rule, 0, len(rules))}
+	for _, r := range rules {
+		segments := strings.Split(r.Pattern, ".")
+		wildcards := 0
+		for _, s := range segments {
+			if s == "" {
+				return nil, fmt.Errorf("invalid pattern %q: empty segment found", r.Pattern)
+			}
+			if s == "*" {
+				wildcards++
+			}
+		}
+		templates := []string{r.Metric}
+		for _, l := range r.Labels {
+			templates = append(templates, l.Value)
+		}
+		for _, tmpl := range templates {
+			if err := validateTemplate(tmpl, wildcards); err != nil {
+				return nil, fmt.Errorf("invalid rule for %q: %w", r.Pattern, err)
+			}
+		}
+		p.rules = append(p.rules, rule{
+			segments: segments,
+			metric:   r.Metric,
+			labels:   r.Labels,
+		})
+	}
+	return p, nil
+}
+
+// ParseLine parses a single line formatted as "<path>[;tag=value...] <value> [timestamp]".
+// Timestamps being -1 are regarded as omitted, like Graphite does.
+func (p *Parser) ParseLine(line []byte) (Point, error) {
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || len(fields) > 3 {
+		return Point{}, fmt.Errorf("invalid line %q: want \"<path> <value> [timestamp]\"", line)
+	}
+	path, tags, err := splitTags(fields[0])
+	if err != nil {
+		return Point{}, err
+	}
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return Point{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+	if math.IsInf(value, 0) {
+		return Point{}, fmt.Errorf("unsupported value %q", fields[1])
+	}
+	var ts int64
+	if len(fields) == 3 {
+		// Carbon accepts fractional timestamps, and truncates them as long as they fit in int64.
+		f, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return Point{}, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		if f != -1 {
+			ts = int64(f)
+		}
+	}
+
+	point := Point{Value: value, Timestamp: ts}
+	point.Metric, point.Labels = p.mapPath(path)
+	point.Labels = append(point.Labels, tags...)
+	return point, nil
+}
+
+// splitTags splits the tagged path into the path and tags.
+func splitTags(s string) (string, []tstorage.Label, error) {
+	parts := strings.Split(s, ";")
+	if parts[0] == "" {
+		return "", nil, errors.New("missing path")
+	}
+	if len(parts) == 1 {
+		return s, nil, nil
+	}
+	tags := make([]tstorage.Label, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || name == "" || value == "" {
+			return "", nil, fmt.Errorf("invalid tag %q", part)
+		}
+		tags = append(tags, tstorage.Label{Name: name, Value: value})
+	}
+	return parts[0], tags, nil
+}
+
+func (p *Parser) mapPath(path string) (string, []tstorage.Label) {
+	if len(p.rules) == 0 {
+		return path, nil
+	}
+	segments := strings.Split(path, ".")
+	for i := range p.rules {
+		r := &p.rules[i]
+		captures, ok := r.match(segments)
+		if !ok {
+			continue
+		}
+		metric := path
+		if r.metric != "" {
+			metric = expand(r.metric, captures)
+		}
+		labels := make([]tstorage.Label, 0, len(r.labels))
+		for _, l := range r.labels {
+			labels = append(labels, tstorage.Label{Name: l.Name, Value: expand(l.Value, captures)})
+		}
+		return metric, labels
+	}
+	return path, nil
+}
+
+// match gives back the segments matched by wildcards if the path matches.
+func (r *rule) match(segments []string) ([]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	var captures []string
+	for i, s := range r.segments {
+		if s == "*" {
+			captures = append(captures, segments[i])
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return captures, true
+}
+
+// expand replaces "$n" in the template with the n-th capture.
+func expand(tmpl string, captures []string) string {
+	if !strings.Contains(tmpl, "$") {
+		return tmpl
+	}
+	var b strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		n, end := reference(tmpl, i)
+		if end == i {
+			b.WriteByte(tmpl[i])
+			continue
+		}
+		b.WriteString(captures[n-1])
+		i = end - 1
+	}
+	return b.String()
+}
+
+func validateTemplate(tmpl string, wildcards int) error {
+	for i := 0; i < len(tmpl); i++ {
+		n, end := reference(tmpl, i)
+		if end == i {
+			continue
+		}
+		if n < 1 || n > wildcards {
+			return fmt.Errorf("$%d in %q refers to none of %d wildcards", n, tmpl, wildcards)
+		}
+		i = end - 1
+	}
+	return nil
+}
+
+// reference parses "$n" at i, and gives back n and the end of it.
+// The end equals to i if there is no reference.
+func reference(tmpl string, i int) (int, int) {
+	if tmpl[i] != '$' {
+		return 0, i
+	}
+	end := i + 1
+	for end < len(tmpl) && tmpl[end] >= '0' && tmpl[end] <= '9' {
+		end++
+	}
+	if end == i+1 {
+		return 0, i
+	}
+	n, err := strconv.Atoi(tmpl[i+1 : end])
+	if err != nil {
+		return 0, i
+	}
+	return n, end
+}
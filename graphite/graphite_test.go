@@ -0,0 +1,110 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func TestParser_ParseLine(t *testing.T) {
+	parser, err := NewParser(
+		Rule{
+			Pattern: "servers.*.cpu.*",
+			Metric:  "cpu_$2",
+			Labels:  []tstorage.Label{{Name: "host", Value: "$1"}},
+		},
+		Rule{
+			Pattern: "servers.*.*",
+			Labels:  []tstorage.Label{{Name: "host", Value: "$1"}, {Name: "kind", Value: "other-$2"}},
+		},
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		line    string
+		want    Point
+		wantErr bool
+	}{
+		{
+			name: "matching the first rule",
+			line: "servers.host-1.cpu.user 0.5 1600000000",
+			want: Point{
+				Metric:    "cpu_user",
+				Labels:    []tstorage.Label{{Name: "host", Value: "host-1"}},
+				Value:     0.5,
+				Timestamp: 1600000000,
+			},
+		},
+		{
+			name: "matching the second rule without metric",
+			line: "servers.host-1.load 2 1600000000",
+			want: Point{
+				Metric:    "servers.host-1.load",
+				Labels:    []tstorage.Label{{Name: "host", Value: "host-1"}, {Name: "kind", Value: "other-load"}},
+				Value:     2,
+				Timestamp: 1600000000,
+			},
+		},
+		{
+			name: "matching no rules",
+			line: "foo.bar 1 1600000000.9",
+			want: Point{Metric: "foo.bar", Value: 1, Timestamp: 1600000000},
+		},
+		{
+			name: "tagged",
+			line: "servers.host-1.cpu.user;dc=us;rack=a 1",
+			want: Point{
+				Metric: "cpu_user",
+				Labels: []tstorage.Label{{Name: "host", Value: "host-1"}, {Name: "dc", Value: "us"}, {Name: "rack", Value: "a"}},
+				Value:  1,
+			},
+		},
+		{
+			name: "timestamp being -1",
+			line: "foo 1 -1",
+			want: Point{Metric: "foo", Value: 1},
+		},
+		{name: "missing value", line: "foo", wantErr: true},
+		{name: "too many fields", line: "foo 1 2 3", wantErr: true},
+		{name: "invalid value", line: "foo abc 1", wantErr: true},
+		{name: "invalid timestamp", line: "foo 1 abc", wantErr: true},
+		{name: "infinite timestamp", line: "foo 1 +Inf", wantErr: true},
+		{name: "too large timestamp", line: "foo 1 1e19", wantErr: true},
+		{name: "too small timestamp", line: "foo 1 -1e19", wantErr: true},
+		{name: "invalid tag", line: "foo;dc 1", wantErr: true},
+		{name: "missing path", line: ";dc=us 1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.ParseLine([]byte(tt.line))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewParser_invalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{name: "empty segment", rule: Rule{Pattern: "a..b"}},
+		{name: "reference out of range", rule: Rule{Pattern: "a.*", Metric: "$2"}},
+		{name: "reference to zero", rule: Rule{Pattern: "a.*", Metric: "$0"}},
+		{name: "invalid reference in label", rule: Rule{Pattern: "a.b", Labels: []tstorage.Label{{Name: "x", Value: "$1"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewParser(tt.rule)
+			assert.Error(t, err)
+		})
+	}
+}
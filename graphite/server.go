@@ -0,0 +1,115 @@
+package graphite
+
+import (
+	"io"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/lineserver"
+	"github.com/nakabonne/tstorage/internal/timeutil"
+)
+
+// Option is an optional setting for the server.
+type Option func(*options)
+
+type options struct {
+	lineserver.Options
+	rules []Rule
+}
+
+// WithRules specifies the rules to map paths to metric names and labels.
+// See NewParser for details.
+//
+// Defaults to no rules, which means paths are used as metric names as they are.
+func WithRules(rules ...Rule) Option {
+	return func(o *options) {
+		o.rules = rules
+	}
+}
+
+// WithBatchSize specifies the maximum number of rows given to InsertRows at once.
+//
+// Defaults to 5000.
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.BatchSize = size
+	}
+}
+
+// WithMaxLineSize specifies the maximum byte size of lines. Longer lines are dropped.
+//
+// Defaults to 4096.
+func WithMaxLineSize(size int) Option {
+	return func(o *options) {
+		o.MaxLineSize = size
+	}
+}
+
+// WithLogger specifies the logger to report malformed lines and rejected rows.
+//
+// Defaults to a logger implementation that does nothing.
+func WithLogger(logger tstorage.Logger) Option {
+	return func(o *options) {
+		o.Logger = logger
+	}
+}
+
+// Server ingests the plaintext protocol received over TCP and UDP into the storage.
+type Server[T any] struct {
+	*lineserver.Server
+	storage   tstorage.Storage[T]
+	parser    *Parser
+	precision tstorage.TimestampPrecision
+	logger    tstorage.Logger
+}
+
+// NewServer gives back a Server for the given storage. It fails if the given rules are invalid.
+func NewServer[T any](s tstorage.Storage[T], opts ...Option) (*Server[T], error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.Normalize()
+	parser, err := NewParser(o.rules...)
+	if err != nil {
+		return nil, err
+	}
+	srv := &Server[T]{
+		storage:   s,
+		parser:    parser,
+		precision: s.TimestampPrecision(),
+		logger:    o.Logger,
+	}
+	srv.Server = lineserver.New(srv.handle, o.Options)
+	return srv, nil
+}
+
+func (s *Server[T]) handle(_ io.Writer, lines [][]byte) {
+	rows := make([]tstorage.Row[T], 0, len(lines))
+	for _, line := range lines {
+		p, err := s.parser.ParseLine(line)
+		if err != nil {
+			s.logger.Printf("graphite: dropped a malformed line: %v\n", err)
+			continue
+		}
+		ts, err := timeutil.Convert(p.Timestamp, time.Second, timeutil.Unit(s.precision))
+		if err != nil {
+			s.logger.Printf("graphite: dropped a line with timestamp %d: %v\n", p.Timestamp, err)
+			continue
+		}
+		rows = append(rows, tstorage.Row[T]{
+			Metric: p.Metric,
+			Labels: p.Labels,
+			DataPoint: tstorage.DataPoint[T]{
+				Timestamp: ts,
+				Value:     p.Value,
+			},
+		})
+	}
+	if len(rows) == 0 {
+		return
+	}
+	if err := s.storage.InsertRows(rows); err != nil {
+		s.logger.Printf("graphite: failed to insert rows: %v\n", err)
+	}
+}
@@ -0,0 +1,94 @@
+package graphite
+
+import (
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func newTestServer(t *testing.T) (*Server[float64], tstorage.Storage[float64]) {
+	storage, err := tstorage.NewStorage(
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	srv, err := NewServer(storage,
+		WithRules(Rule{Pattern: "servers.*.cpu", Metric: "cpu", Labels: []tstorage.Label{{Name: "host", Value: "$1"}}}),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv, storage
+}
+
+func TestServer_Serve(t *testing.T) {
+	srv, storage := newTestServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "servers.host-1.cpu 0.5 1600000000\nmalformed\nservers.host-1.cpu 0.6 1600000001\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	labels := []tstorage.Label{{Name: "host", Value: "host-1"}}
+	assert.Eventually(t, func() bool {
+		points, err := storage.Select("cpu", labels, 1600000000, 1600000002)
+		return err == nil && len(points) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_ServePacket(t *testing.T) {
+	srv, storage := newTestServer(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.ServePacket(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "foo.bar 1 1600000000\n")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		points, err := storage.Select("foo.bar", nil, 1600000000, 1600000001)
+		return err == nil && len(points) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNewServer_invalidRules(t *testing.T) {
+	_, err := NewServer[float64](nil, WithRules(Rule{Pattern: "a.b", Metric: "$1"}))
+	assert.Error(t, err)
+}
+
+func TestServer_overflowingTimestamp(t *testing.T) {
+	storage, err := tstorage.NewStorage[float64]()
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	srv, err := NewServer(storage)
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	// 10^10 seconds overflow in nanoseconds.
+	_, err = io.WriteString(conn, "foo 1 10000000000\nfoo 2 1600000000\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	assert.Eventually(t, func() bool {
+		points, err := storage.Select("foo", nil, 0, math.MaxInt64)
+		return err == nil && len(points) == 1 && points[0].Value == 2
+	}, time.Second, 10*time.Millisecond)
+}
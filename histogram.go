@@ -0,0 +1,81 @@
+package tstorage
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of histogram buckets for durations, in ascending order.
+var defaultDurationBuckets = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// Histogram is a snapshot of the distribution of durations.
+type Histogram struct {
+	// Cumulative counts of observations less than or equal to each upper bound, in ascending order.
+	// The upper bound of the last bucket is math.MaxInt64, which stands for +Inf.
+	Buckets []HistogramBucket
+	// The number of observations.
+	Count uint64
+	// The sum of observations.
+	Sum time.Duration
+}
+
+// HistogramBucket is a bucket of Histogram.
+type HistogramBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// histogram is a goroutine safe histogram of durations with fixed buckets.
+type histogram struct {
+	bounds []time.Duration
+	// Non-cumulative counts for each bucket, with the extra one for +Inf.
+	counts []uint64
+	// In nanoseconds.
+	sum int64
+}
+
+func newHistogram(bounds []time.Duration) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// since observes the time elapsed since the given time.
+func (h *histogram) since(t time.Time) {
+	h.observe(time.Since(t))
+}
+
+func (h *histogram) snapshot() Histogram {
+	res := Histogram{
+		Buckets: make([]HistogramBucket, 0, len(h.counts)),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		res.Count += atomic.LoadUint64(&h.counts[i])
+		bound := time.Duration(math.MaxInt64)
+		if i < len(h.bounds) {
+			bound = h.bounds[i]
+		}
+		res.Buckets = append(res.Buckets, HistogramBucket{UpperBound: bound, Count: res.Count})
+	}
+	return res
+}
@@ -0,0 +1,28 @@
+package tstorage
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_histogram(t *testing.T) {
+	h := newHistogram([]time.Duration{time.Millisecond, time.Second})
+	h.observe(0)
+	h.observe(time.Millisecond)
+	h.observe(2 * time.Millisecond)
+	h.observe(time.Minute)
+
+	want := Histogram{
+		Buckets: []HistogramBucket{
+			{UpperBound: time.Millisecond, Count: 2},
+			{UpperBound: time.Second, Count: 3},
+			{UpperBound: math.MaxInt64, Count: 4},
+		},
+		Count: 4,
+		Sum:   time.Minute + 3*time.Millisecond,
+	}
+	assert.Equal(t, want, h.snapshot())
+}
@@ -0,0 +1,213 @@
+package influx
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/httputil"
+	"github.com/nakabonne/tstorage/internal/timeutil"
+)
+
+const (
+	defaultMaxRequestSize = 32 << 20
+	defaultBatchSize      = 5000
+)
+
+// Option is an optional setting for the handler.
+type Option func(*options)
+
+type options struct {
+	maxRequestSize int64
+	batchSize      int
+	metricName     func(measurement, field string) string
+}
+
+// WithMaxRequestSize specifies the maximum byte size of decompressed requests.
+//
+// Defaults to 32MiB.
+func WithMaxRequestSize(size int64) Option {
+	return func(o *options) {
+		o.maxRequestSize = size
+	}
+}
+
+// WithBatchSize specifies the maximum number of rows given to InsertRows at once.
+//
+// Defaults to 5000.
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.batchSize = size
+	}
+}
+
+// WithMetricName specifies how to build the metric name from the measurement and the field key.
+//
+// Defaults to joining them with an underscore, like "cpu_usage_idle".
+func WithMetricName(f func(measurement, field string) string) Option {
+	return func(o *options) {
+		o.metricName = f
+	}
+}
+
+// NewWriteHandler gives back a handler that ingests the line protocol, which is supposed to serve at /write.
+// Each field becomes a row whose metric is built from the measurement and the field key, with tags as labels.
+// Boolean fields are stored as 1 or 0, while string fields are ignored.
+//
+// The "precision" query parameter specifies the precision of timestamps, which is one of
+// "ns" (default), "us", "ms", "s", as well as "n", "u", "m" and "h" for InfluxDB 1.x compatibility.
+// They get converted into the storage's precision.
+// Gzip-compressed bodies are accepted with "Content-Encoding: gzip".
+func NewWriteHandler[T any](s tstorage.Storage[T], opts ...Option) http.Handler {
+	o := &options{
+		maxRequestSize: defaultMaxRequestSize,
+		batchSize:      defaultBatchSize,
+		metricName: func(measurement, field string) string {
+			return measurement + "_" + field
+		},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.batchSize <= 0 {
+		o.batchSize = defaultBatchSize
+	}
+	precision := s.TimestampPrecision()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received := time.Now()
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		unit, err := precisionUnit(r.URL.Query().Get("precision"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		body, status, err := httputil.ReadBody(r, o.maxRequestSize)
+		if err != nil {
+			writeError(w, status, err.Error())
+			return
+		}
+		points, err := Parse(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unable to parse: %v", err))
+			return
+		}
+
+		storageUnit := timeutil.Unit(precision)
+		// Points without a timestamp all get the time the request was received.
+		now := timeutil.ToUnix(received, precision)
+		rows := make([]tstorage.Row[T], 0, o.batchSize)
+		var rejected, total int
+		var firstErr error
+		flush := func() error {
+			if len(rows) == 0 {
+				return nil
+			}
+			res, err := s.InsertRowsDetailed(rows)
+			if err != nil {
+				return err
+			}
+			total += len(rows)
+			rejected += len(res.Errors)
+			if firstErr == nil && len(res.Errors) > 0 {
+				firstErr = res.Errors[res.Rejected()[0]]
+			}
+			rows = rows[:0]
+			return nil
+		}
+		for i := range points {
+			p := &points[i]
+			ts := now
+			var tsErr error
+			if p.Timestamp != 0 {
+				if ts, tsErr = timeutil.Convert(p.Timestamp, unit, storageUnit); tsErr != nil {
+					tsErr = fmt.Errorf("timestamp %d: %w", p.Timestamp, tsErr)
+				}
+			}
+			for _, f := range p.Fields {
+				v, ok := toFloat(f.Value)
+				if !ok {
+					continue
+				}
+				if tsErr != nil {
+					total++
+					rejected++
+					if firstErr == nil {
+						firstErr = tsErr
+					}
+					continue
+				}
+				rows = append(rows, tstorage.Row[T]{
+					Metric:    o.metricName(p.Measurement, f.Key),
+					Labels:    p.Tags,
+					DataPoint: tstorage.DataPoint[T]{Timestamp: ts, Value: v},
+				})
+				if len(rows) >= o.batchSize {
+					if err := flush(); err != nil {
+						writeError(w, http.StatusInternalServerError, err.Error())
+						return
+					}
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if rejected > 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("partial write: %d of %d values were rejected, including: %v", rejected, total, firstErr))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Influxdb-Error", msg)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// precisionUnit gives back the duration of a unit of the given precision parameter.
+func precisionUnit(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid precision %q", precision)
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
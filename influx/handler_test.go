@@ -0,0 +1,146 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func newTestServer(t *testing.T, opts ...Option) (string, tstorage.Storage[float64]) {
+	storage, err := tstorage.NewStorage(
+		tstorage.WithTimestampPrecision[float64](tstorage.Milliseconds),
+		tstorage.WithDuplicatePointPolicy[float64](tstorage.RejectDuplicates),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	server := httptest.NewServer(NewWriteHandler(storage, opts...))
+	t.Cleanup(server.Close)
+	return server.URL, storage
+}
+
+func post(t *testing.T, url, body string, header http.Header) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWriteHandler(t *testing.T) {
+	url, storage := newTestServer(t, WithBatchSize(2))
+	body := strings.Join([]string{
+		`cpu,host=host-1 usage_idle=0.5,usage_user=10i,msg="ignored" 1600000000`,
+		`cpu,host=host-1 usage_idle=0.6,usage_user=11i 1600000001`,
+		`disk,host=host-1 healthy=true 1600000001`,
+	}, "\n")
+	require.Equal(t, http.StatusNoContent, post(t, url+"?precision=s", body, nil))
+
+	labels := []tstorage.Label{{Name: "host", Value: "host-1"}}
+	points, err := storage.Select("cpu_usage_idle", labels, 1600000000000, 1600000002000)
+	require.NoError(t, err)
+	assert.Equal(t, []*tstorage.DataPoint[float64]{
+		{Timestamp: 1600000000000, Value: 0.5},
+		{Timestamp: 1600000001000, Value: 0.6},
+	}, points)
+	points, err = storage.Select("cpu_usage_user", labels, 1600000000000, 1600000002000)
+	require.NoError(t, err)
+	assert.Len(t, points, 2)
+	points, err = storage.Select("disk_healthy", labels, 1600000000000, 1600000002000)
+	require.NoError(t, err)
+	assert.Equal(t, []*tstorage.DataPoint[float64]{{Timestamp: 1600000001000, Value: 1}}, points)
+	_, err = storage.Select("cpu_msg", labels, 1600000000000, 1600000002000)
+	assert.ErrorIs(t, err, tstorage.ErrNoDataPoints)
+
+	// Sending it again is rejected as duplicates.
+	assert.Equal(t, http.StatusBadRequest, post(t, url+"?precision=s", body, nil))
+}
+
+func TestWriteHandler_gzip(t *testing.T) {
+	url, storage := newTestServer(t)
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte("mem free=1 1600000000000000000\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	status := post(t, url, buf.String(), http.Header{"Content-Encoding": []string{"gzip"}})
+	require.Equal(t, http.StatusNoContent, status)
+
+	points, err := storage.Select("mem_free", nil, 1600000000000, 1600000000001)
+	require.NoError(t, err)
+	assert.Len(t, points, 1)
+}
+
+func TestWriteHandler_noTimestamp(t *testing.T) {
+	url, storage := newTestServer(t)
+	now := time.Now().UnixMilli()
+	require.Equal(t, http.StatusNoContent, post(t, url, "mem free=1,used=2", nil))
+	free, err := storage.Select("mem_free", nil, now, time.Now().UnixMilli()+1)
+	require.NoError(t, err)
+	require.Len(t, free, 1)
+	used, err := storage.Select("mem_used", nil, now, time.Now().UnixMilli()+1)
+	require.NoError(t, err)
+	require.Len(t, used, 1)
+	// Fields of a point share the timestamp.
+	assert.Equal(t, free[0].Timestamp, used[0].Timestamp)
+}
+
+func TestWriteHandler_timestampOutOfRange(t *testing.T) {
+	url, storage := newTestServer(t)
+	body := "mem free=1 1600000000\nmem free=2 9223372036854775807"
+	assert.Equal(t, http.StatusBadRequest, post(t, url+"?precision=h", body, nil))
+	// The other points are still written.
+	points, err := storage.Select("mem_free", nil, 1600000000*3600000, 1600000000*3600000+1)
+	require.NoError(t, err)
+	assert.Len(t, points, 1)
+}
+
+func TestWriteHandler_invalidRequests(t *testing.T) {
+	url, _ := newTestServer(t, WithMaxRequestSize(16))
+	tests := []struct {
+		name       string
+		method     string
+		query      string
+		body       string
+		header     http.Header
+		wantStatus int
+	}{
+		{name: "wrong method", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+		{name: "invalid precision", method: http.MethodPost, query: "?precision=d", body: "cpu v=1", wantStatus: http.StatusBadRequest},
+		{name: "malformed line", method: http.MethodPost, body: "cpu v=", wantStatus: http.StatusBadRequest},
+		{name: "too large", method: http.MethodPost, body: strings.Repeat("a", 17), wantStatus: http.StatusRequestEntityTooLarge},
+		{
+			name:       "not gzip",
+			method:     http.MethodPost,
+			body:       "cpu v=1",
+			header:     http.Header{"Content-Encoding": []string{"gzip"}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, url+tt.query, strings.NewReader(tt.body))
+			require.NoError(t, err)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
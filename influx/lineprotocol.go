@@ -0,0 +1,214 @@
+// Package influx implements ingestion of the InfluxDB line protocol into tstorage.
+// See https://docs.influxdata.com/influxdb/v2/reference/syntax/line-protocol/
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// Point is a single line of the line protocol.
+type Point struct {
+	Measurement string
+	Tags        []tstorage.Label
+	Fields      []Field
+	// Zero means omitted.
+	Timestamp int64
+}
+
+// Field is a field of a point, whose value is one of float64, int64, uint64, bool and string.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// ParseError describes which line is malformed.
+type ParseError struct {
+	// 1-based line number
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parse parses all lines in data. Empty lines and comments starting with '#' are skipped.
+func Parse(data []byte) ([]Point, error) {
+	points := make([]Point, 0, bytes.Count(data, []byte{'\n'})+1)
+	for n := 1; len(data) > 0; n++ {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		p, err := ParseLine(line)
+		if err != nil {
+			return nil, &ParseError{Line: n, Err: err}
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// ParseLine parses a single line formatted as shown below:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+func ParseLine(line []byte) (Point, error) {
+	var p Point
+	token, i := scan(line, 0, ", ")
+	if len(token) == 0 {
+		return Point{}, errors.New("missing measurement")
+	}
+	p.Measurement = unescape(token)
+
+	// Tags
+	for i < len(line) && line[i] == ',' {
+		var key, value []byte
+		key, i = scan(line, i+1, "=, ")
+		if len(key) == 0 || i >= len(line) || line[i] != '=' {
+			return Point{}, errors.New("missing tag key")
+		}
+		value, i = scan(line, i+1, ", ")
+		if len(value) == 0 {
+			return Point{}, fmt.Errorf("missing value of tag %q", unescape(key))
+		}
+		p.Tags = append(p.Tags, tstorage.Label{Name: unescape(key), Value: unescape(value)})
+	}
+
+	// Fields
+	i = skipSpaces(line, i)
+	for {
+		var key []byte
+		key, i = scan(line, i, "=, ")
+		if len(key) == 0 || i >= len(line) || line[i] != '=' {
+			return Point{}, errors.New("missing field key")
+		}
+		field := Field{Key: unescape(key)}
+		var err error
+		field.Value, i, err = parseFieldValue(line, i+1)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid value of field %q: %w", field.Key, err)
+		}
+		p.Fields = append(p.Fields, field)
+		if i >= len(line) || line[i] != ',' {
+			break
+		}
+		i++
+	}
+
+	// Timestamp
+	i = skipSpaces(line, i)
+	if i < len(line) {
+		ts, err := strconv.ParseInt(string(line[i:]), 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid timestamp %q", line[i:])
+		}
+		p.Timestamp = ts
+	}
+	return p, nil
+}
+
+func parseFieldValue(line []byte, i int) (interface{}, int, error) {
+	if i < len(line) && line[i] == '"' {
+		// String values end with an unescaped double quote.
+		var buf []byte
+		for j := i + 1; j < len(line); j++ {
+			switch line[j] {
+			case '\\':
+				if j+1 < len(line) && (line[j+1] == '"' || line[j+1] == '\\') {
+					j++
+				}
+				buf = append(buf, line[j])
+			case '"':
+				return string(buf), j + 1, nil
+			default:
+				buf = append(buf, line[j])
+			}
+		}
+		return nil, 0, errors.New("unterminated string")
+	}
+
+	token, next := scan(line, i, ", ")
+	if len(token) == 0 {
+		return nil, 0, errors.New("missing value")
+	}
+	s := string(token)
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return true, next, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, next, nil
+	}
+	switch s[len(s)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		return v, next, err
+	case 'u':
+		v, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		return v, next, err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, 0, fmt.Errorf("unsupported value %q", s)
+	}
+	return v, next, nil
+}
+
+// scan gives back the token from i up to the first unescaped byte in stops, along with its position.
+func scan(line []byte, i int, stops string) ([]byte, int) {
+	start := i
+	for i < len(line) {
+		if line[i] == '\\' && i+1 < len(line) {
+			i += 2
+			continue
+		}
+		if bytes.IndexByte([]byte(stops), line[i]) >= 0 {
+			break
+		}
+		i++
+	}
+	return line[start:i], i
+}
+
+func skipSpaces(line []byte, i int) int {
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return i
+}
+
+// unescape removes backslashes escaping commas, equal signs, spaces and backslashes.
+func unescape(b []byte) string {
+	if bytes.IndexByte(b, '\\') < 0 {
+		return string(b)
+	}
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\\' && i+1 < len(b) {
+			switch b[i+1] {
+			case ',', '=', ' ', '\\':
+				i++
+			}
+		}
+		out = append(out, b[i])
+	}
+	return string(out)
+}
@@ -0,0 +1,89 @@
+package influx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Point
+		wantErr bool
+	}{
+		{
+			name: "full",
+			line: `cpu,host=host-1,region=us usage_idle=0.5,count=3i,total=4u,up=t 1600000000000000000`,
+			want: Point{
+				Measurement: "cpu",
+				Tags:        []tstorage.Label{{Name: "host", Value: "host-1"}, {Name: "region", Value: "us"}},
+				Fields: []Field{
+					{Key: "usage_idle", Value: 0.5},
+					{Key: "count", Value: int64(3)},
+					{Key: "total", Value: uint64(4)},
+					{Key: "up", Value: true},
+				},
+				Timestamp: 1600000000000000000,
+			},
+		},
+		{
+			name: "without tags and timestamp",
+			line: `mem free=1e3`,
+			want: Point{Measurement: "mem", Fields: []Field{{Key: "free", Value: 1000.0}}},
+		},
+		{
+			name: "escaped",
+			line: `my\ cpu,host\=name=a\,b msg="say \"hi\"",v\ 1=1 -1`,
+			want: Point{
+				Measurement: "my cpu",
+				Tags:        []tstorage.Label{{Name: "host=name", Value: "a,b"}},
+				Fields:      []Field{{Key: "msg", Value: `say "hi"`}, {Key: "v 1", Value: 1.0}},
+				Timestamp:   -1,
+			},
+		},
+		{
+			name: "string with spaces and commas",
+			line: `log msg="a b,c=d" 10`,
+			want: Point{Measurement: "log", Fields: []Field{{Key: "msg", Value: "a b,c=d"}}, Timestamp: 10},
+		},
+		{name: "missing measurement", line: `,host=a v=1`, wantErr: true},
+		{name: "missing fields", line: `cpu,host=a`, wantErr: true},
+		{name: "missing tag value", line: `cpu,host= v=1`, wantErr: true},
+		{name: "missing field value", line: `cpu v=`, wantErr: true},
+		{name: "invalid integer", line: `cpu v=1.5i`, wantErr: true},
+		{name: "unterminated string", line: `cpu v="abc`, wantErr: true},
+		{name: "nan", line: `cpu v=NaN`, wantErr: true},
+		{name: "invalid timestamp", line: `cpu v=1 abc`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLine([]byte(tt.line))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	data := "# comment\ncpu v=1 1\n\r\n  mem v=2 2\r\n"
+	got, err := Parse([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, []Point{
+		{Measurement: "cpu", Fields: []Field{{Key: "v", Value: 1.0}}, Timestamp: 1},
+		{Measurement: "mem", Fields: []Field{{Key: "v", Value: 2.0}}, Timestamp: 2},
+	}, got)
+
+	_, err = Parse([]byte("cpu v=1\ncpu v=\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 2, perr.Line)
+}
@@ -0,0 +1,77 @@
+package tstorage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// InsertResult describes the outcome of InsertRowsDetailed for each of the given rows.
+type InsertResult struct {
+	// The number of rows ingested.
+	Accepted int
+	// A map from the index of a rejected row within the given rows to the reason.
+	// Each reason wraps one of ErrInvalidRow, ErrOutOfBounds, ErrDuplicatePoint and ErrLimitExceeded.
+	Errors map[int]error
+}
+
+// Rejected gives back the indexes of rejected rows in ascending order.
+func (r *InsertResult) Rejected() []int {
+	indexes := make([]int, 0, len(r.Errors))
+	for i := range r.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// err summarizes the rejected rows into a single error.
+func (r *InsertResult) err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	indexes := r.Rejected()
+	for _, i := range indexes {
+		if !errors.Is(r.Errors[i], ErrOutOfBounds) {
+			return fmt.Errorf("%d of the given rows were rejected, including the row at %d: %w", len(indexes), i, r.Errors[i])
+		}
+	}
+	return &OutOfBoundsError{Indexes: indexes}
+}
+
+// OutOfBoundsError is returned by InsertRows when some of the given rows are older than the out-of-order window.
+// The rest of the rows are ingested as usual.
+type OutOfBoundsError struct {
+	// Indexes of the rejected rows within the given rows, in ascending order.
+	Indexes []int
+}
+
+func (e *OutOfBoundsError) Error() string {
+	return fmt.Sprintf("%d of the given rows are older than the out-of-order window: %v", len(e.Indexes), ErrOutOfBounds)
+}
+
+func (e *OutOfBoundsError) Unwrap() error {
+	return ErrOutOfBounds
+}
+
+// DuplicatePointPolicy specifies how to treat a data point whose timestamp is already taken in the series.
+// See WithDuplicatePointPolicy.
+type DuplicatePointPolicy int
+
+const (
+	// KeepDuplicates ingests all of them, so that the series holds multiple data points at the timestamp.
+	KeepDuplicates DuplicatePointPolicy = iota
+	// RejectDuplicates rejects the ones coming later with ErrDuplicatePoint.
+	RejectDuplicates
+)
+
+// rejectedRowsError is given back by partitions when they refused some of the given rows
+// while the others got ingested.
+type rejectedRowsError struct {
+	// A map from the index within the given rows to the reason.
+	errs map[int]error
+}
+
+func (e *rejectedRowsError) Error() string {
+	return fmt.Sprintf("%d rows were rejected", len(e.errs))
+}
@@ -0,0 +1,57 @@
+package tstorage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertResult_err(t *testing.T) {
+	tests := []struct {
+		name            string
+		result          InsertResult
+		wantErr         error
+		wantOutOfBounds []int
+	}{
+		{
+			name:   "nothing rejected",
+			result: InsertResult{Accepted: 2},
+		},
+		{
+			name: "only out of bounds",
+			result: InsertResult{
+				Accepted: 1,
+				Errors:   map[int]error{2: ErrOutOfBounds, 0: ErrOutOfBounds},
+			},
+			wantErr:         ErrOutOfBounds,
+			wantOutOfBounds: []int{0, 2},
+		},
+		{
+			name: "mixed reasons",
+			result: InsertResult{
+				Accepted: 1,
+				Errors:   map[int]error{0: ErrOutOfBounds, 1: ErrDuplicatePoint},
+			},
+			wantErr: ErrDuplicatePoint,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.result.err()
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+			var outOfBounds *OutOfBoundsError
+			if tt.wantOutOfBounds == nil {
+				assert.False(t, errors.As(err, &outOfBounds))
+				return
+			}
+			require.ErrorAs(t, err, &outOfBounds)
+			assert.Equal(t, tt.wantOutOfBounds, outOfBounds.Indexes)
+		})
+	}
+}
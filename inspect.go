@@ -0,0 +1,168 @@
+package tstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PartitionInfo describes a disk partition in a data directory.
+type PartitionInfo struct {
+	// Name of the partition directory.
+	Dir string
+	// Incomplete is true if the partition has no meta file, e.g. because it's being written
+	// or the process crashed while flushing it. Only Dir and Size are set then.
+	Incomplete    bool
+	MinTimestamp  int64
+	MaxTimestamp  int64
+	NumDataPoints int
+	// Total size in bytes of the files in the partition directory.
+	Size      int64
+	CreatedAt time.Time
+	// Series held in the partition, in no particular order.
+	Series []SeriesInfo
+}
+
+// SeriesInfo describes a series held in a disk partition.
+type SeriesInfo struct {
+	Metric        string
+	Labels        []Label
+	MinTimestamp  int64
+	MaxTimestamp  int64
+	NumDataPoints int64
+}
+
+// WALSegmentInfo describes a WAL segment in a data directory.
+type WALSegmentInfo struct {
+	Name string
+	// The version of the WAL format the segment is written in.
+	Version    int
+	Size       int64
+	NumRecords int
+	// Err is set if the segment ends in a broken record, which is usual for the one being written when the process exits.
+	Err error
+}
+
+// InspectPartitions reads the meta files of all disk partitions in the given data directory without opening a storage.
+// Partitions are in chronological order, followed by incomplete ones.
+func InspectPartitions(dataPath string) ([]PartitionInfo, error) {
+	entries, err := os.ReadDir(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data directory: %w", err)
+	}
+	infos := make([]PartitionInfo, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() || !partitionDirRegex.MatchString(e.Name()) {
+			continue
+		}
+		info, err := inspectPartition(filepath.Join(dataPath, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect partition %s: %w", e.Name(), err)
+		}
+		infos = append(infos, info)
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].Incomplete != infos[j].Incomplete {
+			return !infos[i].Incomplete
+		}
+		return infos[i].MinTimestamp < infos[j].MinTimestamp
+	})
+	return infos, nil
+}
+
+func inspectPartition(dirPath string) (PartitionInfo, error) {
+	info := PartitionInfo{Dir: filepath.Base(dirPath)}
+	files, err := os.ReadDir(dirPath)
+	if err != nil {
+		return info, fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, f := range files {
+		fi, err := f.Info()
+		if err != nil {
+			return info, fmt.Errorf("failed to fetch file info: %w", err)
+		}
+		info.Size += fi.Size()
+	}
+
+	b, err := os.ReadFile(filepath.Join(dirPath, metaFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		info.Incomplete = true
+		return info, nil
+	}
+	if err != nil {
+		return info, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	m := meta{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return info, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	info.MinTimestamp = m.MinTimestamp
+	info.MaxTimestamp = m.MaxTimestamp
+	info.NumDataPoints = m.NumDataPoints
+	info.CreatedAt = m.CreatedAt
+	info.Series = make([]SeriesInfo, 0, len(m.Metrics))
+	for name, mt := range m.Metrics {
+		metric, labels := unmarshalMetricName(name)
+		info.Series = append(info.Series, SeriesInfo{
+			Metric:        metric,
+			Labels:        labels,
+			MinTimestamp:  mt.MinTimestamp,
+			MaxTimestamp:  mt.MaxTimestamp,
+			NumDataPoints: mt.NumDataPoints,
+		})
+	}
+	return info, nil
+}
+
+// InspectWAL counts records in all WAL segments in the given data directory without opening a storage.
+// Segments are in the order they are replayed. It gives back nothing if WAL is disabled.
+func InspectWAL(dataPath string) ([]WALSegmentInfo, error) {
+	dir := filepath.Join(dataPath, walDirName)
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return []WALSegmentInfo{}, nil
+	}
+	files, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]WALSegmentInfo, 0, len(files))
+	for _, file := range files {
+		info, err := inspectSegment(filepath.Join(dir, file.name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect WAL segment %s: %w", file.name, err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func inspectSegment(path string) (WALSegmentInfo, error) {
+	info := WALSegmentInfo{Name: filepath.Base(path)}
+	fd, err := os.Open(path)
+	if err != nil {
+		return info, fmt.Errorf("failed to open WAL segment file: %w", err)
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return info, fmt.Errorf("failed to fetch file info: %w", err)
+	}
+	info.Size = fi.Size()
+	seg, err := newSegment[float64](fd)
+	if err != nil {
+		fd.Close()
+		return info, fmt.Errorf("failed to read the header: %w", err)
+	}
+	defer seg.close()
+	info.Version = int(seg.version)
+	for seg.next() {
+		info.NumRecords++
+	}
+	info.Err = seg.error()
+	return info, nil
+}
@@ -0,0 +1,90 @@
+package tstorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectPartitions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	s, err := NewStorage(
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	require.NoError(t, s.InsertRows([]Row[float64]{
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "a"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 1}},
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "a"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000001, Value: 2}},
+		{Metric: "metric2", DataPoint: DataPoint[float64]{Timestamp: 1600000002, Value: 3}},
+	}))
+	require.NoError(t, s.Close())
+	// A partition left behind by a crash while flushing.
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "p-1-2"), 0o755))
+
+	infos, err := InspectPartitions(tmpDir)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	got := infos[0]
+	assert.Equal(t, "p-1600000000-1600000002", got.Dir)
+	assert.False(t, got.Incomplete)
+	assert.Equal(t, int64(1600000000), got.MinTimestamp)
+	assert.Equal(t, int64(1600000002), got.MaxTimestamp)
+	assert.Equal(t, 3, got.NumDataPoints)
+	assert.Positive(t, got.Size)
+	assert.False(t, got.CreatedAt.IsZero())
+	assert.ElementsMatch(t, []SeriesInfo{
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "a"}}, MinTimestamp: 1600000000, MaxTimestamp: 1600000001, NumDataPoints: 2},
+		{Metric: "metric2", MinTimestamp: 1600000002, MaxTimestamp: 1600000002, NumDataPoints: 1},
+	}, got.Series)
+	assert.Equal(t, PartitionInfo{Dir: "p-1-2", Incomplete: true}, infos[1])
+
+	_, err = InspectPartitions(filepath.Join(tmpDir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestInspectWAL(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	w, err := newDiskWAL[float64](filepath.Join(tmpDir, walDirName), 0, 0, WALSyncNever)
+	require.NoError(t, err)
+	require.NoError(t, w.append(operationInsert, []Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1, Value: 1}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 2, Value: 2}},
+	}))
+	require.NoError(t, w.punctuate())
+	require.NoError(t, w.append(operationInsert, []Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 3, Value: 3}},
+	}))
+	require.NoError(t, w.flush())
+	// Break the last record as if the process crashed while writing.
+	segments, err := listSegments(filepath.Join(tmpDir, walDirName))
+	require.NoError(t, err)
+	require.Len(t, segments, 2)
+	last := filepath.Join(tmpDir, walDirName, segments[1].name)
+	b, err := os.ReadFile(last)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(last, append(b, byte(operationInsert), 7), 0o644))
+
+	infos, err := InspectWAL(tmpDir)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, segments[0].name, infos[0].Name)
+	assert.Equal(t, int(walFormatLatest), infos[0].Version)
+	assert.Equal(t, 2, infos[0].NumRecords)
+	assert.Positive(t, infos[0].Size)
+	assert.NoError(t, infos[0].Err)
+	assert.Equal(t, 1, infos[1].NumRecords)
+	assert.Error(t, infos[1].Err)
+
+	// WAL is disabled.
+	infos, err = InspectWAL(t.TempDir())
+	require.NoError(t, err)
+	assert.Empty(t, infos)
+}
@@ -0,0 +1,36 @@
+// Package httputil provides helpers shared by the HTTP handlers built on top of the storage.
+package httputil
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ReadBody reads the request body of up to maxSize bytes, decompressing it if "Content-Encoding: gzip" is given.
+// On failure, it gives back the status code to respond with: 400 for a malformed gzip body,
+// 413 for a body exceeding maxSize, and 500 for failing to read it.
+func ReadBody(r *http.Request, maxSize int64) ([]byte, int, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+	b, err := io.ReadAll(io.LimitReader(body, maxSize+1))
+	if errors.Is(err, gzip.ErrChecksum) || errors.Is(err, gzip.ErrHeader) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid gzip body: %w", err)
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read the request body: %w", err)
+	}
+	if int64(len(b)) > maxSize {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("the request body exceeds %d bytes", maxSize)
+	}
+	return b, http.StatusOK, nil
+}
@@ -0,0 +1,58 @@
+package httputil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestReadBody(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("hello"))
+	gz.Close()
+
+	tests := []struct {
+		name       string
+		body       io.Reader
+		gzip       bool
+		want       string
+		wantStatus int
+	}{
+		{name: "plain", body: strings.NewReader("hello"), want: "hello", wantStatus: http.StatusOK},
+		{name: "gzip", body: bytes.NewReader(compressed.Bytes()), gzip: true, want: "hello", wantStatus: http.StatusOK},
+		{name: "not gzip", body: strings.NewReader("hello"), gzip: true, wantStatus: http.StatusBadRequest},
+		{name: "truncated gzip", body: bytes.NewReader(compressed.Bytes()[:compressed.Len()-4]), gzip: true, wantStatus: http.StatusBadRequest},
+		{name: "too large", body: strings.NewReader("hello world"), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "read failure", body: failingReader{}, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", tt.body)
+			if tt.gzip {
+				r.Header.Set("Content-Encoding", "gzip")
+			}
+			got, status, err := ReadBody(r, 8)
+			assert.Equal(t, tt.wantStatus, status)
+			if tt.wantStatus != http.StatusOK {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Package lineserver serves line-oriented text protocols, such as Graphite plaintext and OpenTSDB telnet,
+// over TCP and UDP.
+package lineserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/nakabonne/tstorage"
+)
+
+const (
+	// maxPacketSize is the maximum size of UDP datagrams.
+	maxPacketSize = 64 << 10
+
+	defaultBatchSize   = 5000
+	defaultMaxLineSize = 4096
+)
+
+// Options are the settings shared by servers of line-oriented protocols.
+type Options struct {
+	// BatchSize is the maximum number of lines given to the handler at once. Defaults to 5000.
+	BatchSize int
+	// MaxLineSize is the maximum byte size of lines. Longer lines are dropped. Defaults to 4096.
+	MaxLineSize int
+	// Logger reports malformed lines and rejected rows. Defaults to a logger that does nothing.
+	Logger tstorage.Logger
+}
+
+// Normalize replaces the sizes that aren't positive, and the nil logger, with the defaults.
+func (o *Options) Normalize() {
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+	if o.MaxLineSize <= 0 {
+		o.MaxLineSize = defaultMaxLineSize
+	}
+	if o.Logger == nil {
+		o.Logger = nopLogger{}
+	}
+}
+
+// Handler processes lines read at once. Responses written to w are sent back to the client
+// over TCP, while they are discarded over UDP. lines are no longer valid once it returns.
+type Handler func(w io.Writer, lines [][]byte)
+
+// Server hands lines read from connections to the handler in batches.
+type Server struct {
+	handler     Handler
+	batchSize   int
+	maxLineSize int
+
+	mu          sync.Mutex
+	listeners   []net.Listener
+	packetConns []net.PacketConn
+	conns       map[net.Conn]struct{}
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// New gives back a Server that gives the handler at most o.BatchSize lines at once.
+// Sizes that aren't positive are replaced with the defaults.
+func New(handler Handler, o Options) *Server {
+	o.Normalize()
+	return &Server{
+		handler:     handler,
+		batchSize:   o.BatchSize,
+		maxLineSize: o.MaxLineSize,
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts TCP connections on the given listener until the server gets closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("server already closed")
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer s.untrack(conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn reads lines until EOF. Lines already buffered are handled together, so that
+// clients sending many lines at once get them ingested in batches.
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReaderSize(conn, s.maxLineSize)
+	lines := make([][]byte, 0, s.batchSize)
+	// Lines refer to buf, which gets reused once they are handled.
+	buf := make([]byte, 0, s.maxLineSize)
+	handle := func() {
+		if len(lines) > 0 {
+			s.handler(conn, lines)
+		}
+		lines = lines[:0]
+		buf = buf[:0]
+	}
+	for {
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// Drop the line being too long.
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = r.ReadSlice('\n')
+			}
+			line = nil
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if len(buf)+len(line) > cap(buf) {
+				handle()
+			}
+			start := len(buf)
+			buf = append(buf, line...)
+			lines = append(lines, buf[start:len(buf):len(buf)])
+		}
+		if err != nil {
+			handle()
+			return
+		}
+		if len(lines) >= s.batchSize || r.Buffered() == 0 {
+			handle()
+		}
+	}
+}
+
+// ServePacket reads UDP datagrams from the given connection until the server gets closed.
+// A datagram can contain multiple lines.
+func (s *Server) ServePacket(c net.PacketConn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("server already closed")
+	}
+	s.packetConns = append(s.packetConns, c)
+	// Let Close wait for the handler to return, as it does for TCP connections.
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	lines := make([][]byte, 0, s.batchSize)
+	for {
+		n, _, err := c.ReadFrom(buf)
+		if n > 0 {
+			for _, line := range bytes.Split(buf[:n], []byte{'\n'}) {
+				line = bytes.TrimSpace(line)
+				if len(line) == 0 || len(line) > s.maxLineSize {
+					continue
+				}
+				lines = append(lines, line)
+				if len(lines) >= s.batchSize {
+					s.handler(io.Discard, lines)
+					lines = lines[:0]
+				}
+			}
+			if len(lines) > 0 {
+				s.handler(io.Discard, lines)
+				lines = lines[:0]
+			}
+		}
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Close stops accepting connections, closes connections being served, and waits for the handler to return.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for _, c := range s.packetConns {
+		c.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// track registers the connection, and gives back false if the server is already closed.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	conn.Close()
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(_ string, _ ...interface{}) {}
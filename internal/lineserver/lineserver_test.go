@@ -0,0 +1,138 @@
+package lineserver
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recorder struct {
+	mu      sync.Mutex
+	lines   []string
+	batches int
+}
+
+func (r *recorder) handle(w io.Writer, lines [][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches++
+	for _, l := range lines {
+		r.lines = append(r.lines, string(l))
+	}
+	io.WriteString(w, "ok\n")
+}
+
+func (r *recorder) got() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.lines...)
+}
+
+func TestServer_Serve(t *testing.T) {
+	rec := &recorder{}
+	s := New(rec.handle, Options{BatchSize: 2, MaxLineSize: 16})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "a 1\r\n\nb 2\n"+strings.Repeat("x", 40)+"\nc 3\nd 4")
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	// Responses are sent back until the server closes the connection at EOF.
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(resp), "ok\n"))
+	require.NoError(t, conn.Close())
+
+	// The line being too long is dropped.
+	assert.Equal(t, []string{"a 1", "b 2", "c 3", "d 4"}, rec.got())
+
+	require.NoError(t, s.Close())
+	assert.NoError(t, <-errCh)
+}
+
+func TestServer_ServePacket(t *testing.T) {
+	rec := &recorder{}
+	s := New(rec.handle, Options{BatchSize: 2, MaxLineSize: 16})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ServePacket(pc) }()
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "a 1\nb 2\nc 3\n")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(rec.got()) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a 1", "b 2", "c 3"}, rec.got())
+	rec.mu.Lock()
+	assert.Equal(t, 2, rec.batches)
+	rec.mu.Unlock()
+
+	require.NoError(t, s.Close())
+	assert.NoError(t, <-errCh)
+}
+
+func TestServer_Close_waitsForPacketHandler(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := New(func(io.Writer, [][]byte) {
+		close(started)
+		<-release
+	}, Options{BatchSize: 1, MaxLineSize: 16})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.ServePacket(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "a 1\n")
+	require.NoError(t, err)
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the handler")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+}
+
+func TestServer_Close(t *testing.T) {
+	s := New(func(io.Writer, [][]byte) {}, Options{BatchSize: 1, MaxLineSize: 16})
+	require.NoError(t, s.Close())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	assert.Error(t, s.Serve(l))
+	// Closing twice is fine.
+	assert.NoError(t, s.Close())
+}
+
+func TestOptions_Normalize(t *testing.T) {
+	o := Options{BatchSize: -1, MaxLineSize: 16}
+	o.Normalize()
+	assert.Equal(t, defaultBatchSize, o.BatchSize)
+	assert.Equal(t, 16, o.MaxLineSize)
+	assert.NotNil(t, o.Logger)
+}
@@ -0,0 +1,442 @@
+// Package parquet writes and reads Parquet files of data points, which analytics tools such as DuckDB and pandas load natively.
+// Only the subset of the format needed for the fixed schema below is implemented:
+//
+//	message schema {
+//	  required binary metric (STRING);
+//	  required group labels (MAP) {
+//	    repeated group key_value {
+//	      required binary key (STRING);
+//	      required binary value (STRING);
+//	    }
+//	  }
+//	  required int64 timestamp;
+//	  required double value;
+//	}
+//
+// Every column chunk consists of a single PLAIN-encoded data page compressed with snappy.
+// See https://github.com/apache/parquet-format
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/nakabonne/tstorage/internal/snappy"
+)
+
+// ErrInvalidFile is given back if the input is not a Parquet file this package can read.
+var ErrInvalidFile = errors.New("parquet: invalid file")
+
+var magic = []byte("PAR1")
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Row struct {
+	Metric    string
+	Labels    []Label
+	Timestamp int64
+	Value     float64
+}
+
+// TimeUnit is the unit of timestamps.
+type TimeUnit int
+
+const (
+	// Timestamps are plain integers, e.g. the ones in seconds that Parquet has no unit for.
+	UnitNone TimeUnit = iota
+	UnitMilliseconds
+	UnitMicroseconds
+	UnitNanoseconds
+)
+
+// Physical types.
+const (
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+)
+
+// Field repetition types.
+const (
+	required = 0
+	repeated = 2
+)
+
+// Converted types, which readers not supporting logical types fall back on.
+const (
+	convertedUTF8            = 0
+	convertedMap             = 1
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+)
+
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecUncompressed = 0
+	codecSnappy       = 1
+
+	pageTypeData = 0
+)
+
+// column describes a leaf column of the schema.
+type column struct {
+	path      []string
+	typ       int32
+	maxRepDef int32
+}
+
+// Leaf columns in the order of the schema.
+var columns = []column{
+	{path: []string{"metric"}, typ: typeByteArray},
+	{path: []string{"labels", "key_value", "key"}, typ: typeByteArray, maxRepDef: 1},
+	{path: []string{"labels", "key_value", "value"}, typ: typeByteArray, maxRepDef: 1},
+	{path: []string{"timestamp"}, typ: typeInt64},
+	{path: []string{"value"}, typ: typeDouble},
+}
+
+type options struct {
+	timeUnit     TimeUnit
+	rowGroupSize int
+}
+
+type Option func(*options)
+
+// WithTimeUnit annotates the timestamp column with the given unit, so that readers see timestamps instead of integers.
+//
+// Defaults to UnitNone.
+func WithTimeUnit(unit TimeUnit) Option {
+	return func(o *options) {
+		o.timeUnit = unit
+	}
+}
+
+// WithRowGroupSize specifies the number of rows buffered before being written out as a row group.
+//
+// Defaults to 131072.
+func WithRowGroupSize(n int) Option {
+	return func(o *options) {
+		o.rowGroupSize = n
+	}
+}
+
+// Writer writes rows out as a Parquet file.
+// Rows are buffered until a row group fills up, and the file is incomplete until Close is called.
+type Writer struct {
+	w         io.Writer
+	opts      options
+	offset    int64
+	rows      []Row
+	rowGroups tList
+	numRows   int64
+	closed    bool
+}
+
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	o := options{
+		timeUnit:     UnitNone,
+		rowGroupSize: 128 * 1024,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.rowGroupSize < 1 {
+		o.rowGroupSize = 1
+	}
+	return &Writer{w: w, opts: o}
+}
+
+// Write buffers the given row, whose labels must not be modified afterwards.
+func (w *Writer) Write(row Row) error {
+	if w.closed {
+		return errors.New("parquet: writer already closed")
+	}
+	w.rows = append(w.rows, row)
+	if len(w.rows) >= w.opts.rowGroupSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered rows out as a row group.
+func (w *Writer) Flush() error {
+	if err := w.writeMagic(); err != nil {
+		return err
+	}
+	if len(w.rows) == 0 {
+		return nil
+	}
+	groupOffset := w.offset
+	chunks := make(tList, 0, len(columns))
+	var totalSize, totalCompressedSize int64
+	for i, c := range columns {
+		page, numValues, stats := w.encodeColumn(i)
+		compressed := snappy.Encode(nil, page)
+		header := appendStruct(nil, tStruct{
+			{1, int32(pageTypeData)},
+			{2, int32(len(page))},
+			{3, int32(len(compressed))},
+			{5, tStruct{
+				{1, int32(numValues)},
+				{2, int32(encodingPlain)},
+				{3, int32(encodingRLE)},
+				{4, int32(encodingRLE)},
+			}},
+		})
+		chunkOffset := w.offset
+		if err := w.write(header); err != nil {
+			return err
+		}
+		if err := w.write(compressed); err != nil {
+			return err
+		}
+		path := make(tList, 0, len(c.path))
+		for _, p := range c.path {
+			path = append(path, p)
+		}
+		meta := tStruct{
+			{1, c.typ},
+			{2, tList{int32(encodingPlain), int32(encodingRLE)}},
+			{3, path},
+			{4, int32(codecSnappy)},
+			{5, int64(numValues)},
+			{6, int64(len(header) + len(page))},
+			{7, int64(len(header) + len(compressed))},
+			{9, chunkOffset},
+		}
+		if stats != nil {
+			meta = append(meta, tField{12, stats})
+		}
+		chunks = append(chunks, tStruct{
+			{2, chunkOffset},
+			{3, meta},
+		})
+		totalSize += int64(len(header) + len(page))
+		totalCompressedSize += int64(len(header) + len(compressed))
+	}
+	w.rowGroups = append(w.rowGroups, tStruct{
+		{1, chunks},
+		{2, totalSize},
+		{3, int64(len(w.rows))},
+		{5, groupOffset},
+		{6, totalCompressedSize},
+	})
+	w.numRows += int64(len(w.rows))
+	w.rows = w.rows[:0]
+	return nil
+}
+
+// Close writes the buffered rows and the footer out. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	w.closed = true
+	orders := make(tList, 0, len(columns))
+	for range columns {
+		// TYPE_ORDER, under which the min and max values of statistics are valid.
+		orders = append(orders, tStruct{{1, tStruct{}}})
+	}
+	footer := appendStruct(nil, tStruct{
+		{1, int32(1)},
+		{2, w.schema()},
+		{3, w.numRows},
+		{4, w.rowGroups},
+		{6, "tstorage"},
+		{7, orders},
+	})
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, magic...)
+	return w.write(footer)
+}
+
+func (w *Writer) schema() tList {
+	str := func(name string, repetition int32) tStruct {
+		return tStruct{
+			{1, int32(typeByteArray)},
+			{3, repetition},
+			{4, name},
+			{6, int32(convertedUTF8)},
+			{10, tStruct{{1, tStruct{}}}},
+		}
+	}
+	timestamp := tStruct{
+		{1, int32(typeInt64)},
+		{3, int32(required)},
+		{4, "timestamp"},
+	}
+	if w.opts.timeUnit != UnitNone {
+		var unit int16
+		switch w.opts.timeUnit {
+		case UnitMilliseconds:
+			unit = 1
+			timestamp = append(timestamp, tField{6, int32(convertedTimestampMillis)})
+		case UnitMicroseconds:
+			unit = 2
+			timestamp = append(timestamp, tField{6, int32(convertedTimestampMicros)})
+		default:
+			unit = 3
+		}
+		timestamp = append(timestamp, tField{10, tStruct{{8, tStruct{
+			{1, true},
+			{2, tStruct{{unit, tStruct{}}}},
+		}}}})
+	}
+	return tList{
+		tStruct{
+			{4, "schema"},
+			{5, int32(4)},
+		},
+		str("metric", required),
+		tStruct{
+			{3, int32(required)},
+			{4, "labels"},
+			{5, int32(1)},
+			{6, int32(convertedMap)},
+			{10, tStruct{{2, tStruct{}}}},
+		},
+		tStruct{
+			{3, int32(repeated)},
+			{4, "key_value"},
+			{5, int32(2)},
+		},
+		str("key", required),
+		str("value", required),
+		timestamp,
+		tStruct{
+			{1, int32(typeDouble)},
+			{3, int32(required)},
+			{4, "value"},
+		},
+	}
+}
+
+// encodeColumn gives back the uncompressed data page of the i-th column of the buffered rows,
+// along with the number of values including empty maps, and its statistics if worth having.
+func (w *Writer) encodeColumn(i int) ([]byte, int, tStruct) {
+	var page []byte
+	switch i {
+	case 0:
+		min, max := w.rows[0].Metric, w.rows[0].Metric
+		for _, r := range w.rows {
+			page = appendByteArray(page, r.Metric)
+			if r.Metric < min {
+				min = r.Metric
+			}
+			if r.Metric > max {
+				max = r.Metric
+			}
+		}
+		return page, len(w.rows), tStruct{{5, max}, {6, min}}
+	case 1, 2:
+		// An empty map is a single level of 0 with no value, and the following entries of a map have a repetition level of 1.
+		var rep, def levels
+		var values []byte
+		for _, r := range w.rows {
+			if len(r.Labels) == 0 {
+				rep.append(0)
+				def.append(0)
+				continue
+			}
+			for j, l := range r.Labels {
+				if j == 0 {
+					rep.append(0)
+				} else {
+					rep.append(1)
+				}
+				def.append(1)
+				if i == 1 {
+					values = appendByteArray(values, l.Name)
+				} else {
+					values = appendByteArray(values, l.Value)
+				}
+			}
+		}
+		page = rep.appendEncoded(page)
+		page = def.appendEncoded(page)
+		return append(page, values...), def.n, nil
+	case 3:
+		min, max := w.rows[0].Timestamp, w.rows[0].Timestamp
+		for _, r := range w.rows {
+			page = binary.LittleEndian.AppendUint64(page, uint64(r.Timestamp))
+			if r.Timestamp < min {
+				min = r.Timestamp
+			}
+			if r.Timestamp > max {
+				max = r.Timestamp
+			}
+		}
+		return page, len(w.rows), tStruct{
+			{5, binary.LittleEndian.AppendUint64(nil, uint64(max))},
+			{6, binary.LittleEndian.AppendUint64(nil, uint64(min))},
+		}
+	default:
+		for _, r := range w.rows {
+			page = binary.LittleEndian.AppendUint64(page, math.Float64bits(r.Value))
+		}
+		return page, len(w.rows), nil
+	}
+}
+
+func (w *Writer) writeMagic() error {
+	if w.offset > 0 {
+		return nil
+	}
+	return w.write(magic)
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	if err != nil {
+		return fmt.Errorf("parquet: failed to write: %w", err)
+	}
+	return nil
+}
+
+func appendByteArray(dst []byte, s string) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(s)))
+	return append(dst, s...)
+}
+
+// levels holds repetition or definition levels of 0 or 1 as runs.
+type levels struct {
+	runs []run
+	n    int
+}
+
+type run struct {
+	value byte
+	count int
+}
+
+func (l *levels) append(v byte) {
+	l.n++
+	if len(l.runs) > 0 && l.runs[len(l.runs)-1].value == v {
+		l.runs[len(l.runs)-1].count++
+		return
+	}
+	l.runs = append(l.runs, run{value: v, count: 1})
+}
+
+// appendEncoded appends the levels in the RLE/bit-packing hybrid encoding with the bit width of 1, prefixed with its length.
+// Only RLE runs are used, which are valid however short.
+func (l *levels) appendEncoded(dst []byte) []byte {
+	var b []byte
+	for _, r := range l.runs {
+		b = binary.AppendUvarint(b, uint64(r.count)<<1)
+		b = append(b, r.value)
+	}
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(b)))
+	return append(dst, b...)
+}
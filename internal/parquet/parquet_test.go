@@ -0,0 +1,156 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_ReadAll(t *testing.T) {
+	rows := []Row{
+		{Metric: "cpu", Labels: []Label{{Name: "host", Value: "a"}, {Name: "region", Value: "us"}}, Timestamp: 1600000000, Value: 0.5},
+		{Metric: "cpu", Labels: []Label{{Name: "host", Value: "b"}}, Timestamp: 1600000001, Value: -1},
+		{Metric: "up", Labels: []Label{}, Timestamp: -1, Value: math.Inf(1)},
+		{Metric: "up", Labels: []Label{}, Timestamp: 1600000002, Value: 0},
+		{Metric: "memory", Labels: []Label{{Name: "host", Value: ""}}, Timestamp: 1600000003, Value: 1e100},
+	}
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "single row group"},
+		{name: "multiple row groups", opts: []Option{WithRowGroupSize(2)}},
+		{name: "time unit", opts: []Option{WithTimeUnit(UnitMilliseconds)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, tt.opts...)
+			for _, r := range rows {
+				require.NoError(t, w.Write(r))
+			}
+			require.NoError(t, w.Close())
+			assert.Equal(t, magic, buf.Bytes()[:4])
+
+			got, err := ReadAll(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			require.NoError(t, err)
+			assert.Equal(t, rows, got)
+		})
+	}
+}
+
+func TestWriter_ReadAll_empty(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.Close())
+	got, err := ReadAll(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	assert.Error(t, w.Write(Row{Metric: "cpu"}))
+}
+
+func TestWriter_metadata(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, WithTimeUnit(UnitNanoseconds))
+	require.NoError(t, w.Write(Row{Metric: "b", Timestamp: 20}))
+	require.NoError(t, w.Write(Row{Metric: "a", Timestamp: 10}))
+	require.NoError(t, w.Close())
+
+	b := buf.Bytes()
+	footerLen := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	d := &thriftDecoder{b: b[len(b)-8-footerLen : len(b)-8]}
+	meta, err := d.readStruct()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), meta.int(3))
+
+	names := make([]string, 0)
+	for _, e := range meta.list(2) {
+		names = append(names, e.(tStruct).string(4))
+	}
+	assert.Equal(t, []string{"schema", "metric", "labels", "key_value", "key", "value", "timestamp", "value"}, names)
+	// The timestamp is annotated as TIMESTAMP(isAdjustedToUTC=true, unit=NANOS).
+	timestamp := meta.list(2)[6].(tStruct).strct(10).strct(8)
+	v, _ := timestamp.field(1)
+	assert.Equal(t, true, v)
+	_, ok := timestamp.strct(2).field(3)
+	assert.True(t, ok)
+
+	chunks := meta.list(4)[0].(tStruct).list(1)
+	require.Len(t, chunks, len(columns))
+	stats := chunks[0].(tStruct).strct(3).strct(12)
+	assert.Equal(t, "b", stats.string(5))
+	assert.Equal(t, "a", stats.string(6))
+	stats = chunks[3].(tStruct).strct(3).strct(12)
+	assert.Equal(t, binary.LittleEndian.AppendUint64(nil, 20), []byte(stats.string(5)))
+	assert.Equal(t, binary.LittleEndian.AppendUint64(nil, 10), []byte(stats.string(6)))
+}
+
+func TestReadAll_invalid(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.Write(Row{Metric: "cpu", Labels: []Label{{Name: "host", Value: "a"}}, Timestamp: 1}))
+	require.NoError(t, w.Close())
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "empty", input: []byte{}},
+		{name: "no magic", input: append(append([]byte{}, valid[:len(valid)-1]...), 'X')},
+		{name: "truncated", input: append([]byte{}, valid[len(valid)/2:]...)},
+		{name: "footer length out of range", input: append(append([]byte{}, magic...), 0xff, 0xff, 0xff, 0x00, 'P', 'A', 'R', '1')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadAll(bytes.NewReader(tt.input), int64(len(tt.input)))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_decodeLevels(t *testing.T) {
+	// An RLE run of three 1s, followed by a bit-packed group of 1, 0, 1, 1.
+	page := []byte{4, 0, 0, 0, 3 << 1, 1, 1<<1 | 1, 0b1101, 0xaa}
+	got, rest, err := decodeLevels(nil, page, 7)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 1, 1, 1, 0, 1, 1}, got)
+	assert.Equal(t, []byte{0xaa}, rest)
+
+	_, _, err = decodeLevels(nil, []byte{1, 0, 0, 0, 3 << 1}, 3)
+	assert.Error(t, err)
+}
+
+func Test_thrift(t *testing.T) {
+	s := tStruct{
+		{1, int32(-5)},
+		{2, true},
+		{3, "abc"},
+		{20, int64(1) << 40},
+		{21, tList{"x", "y"}},
+		{22, tStruct{{1, false}}},
+		{23, tList{tStruct{{1, int32(1)}}, tStruct{{1, int32(2)}}}},
+	}
+	b := appendStruct(nil, s)
+	d := &thriftDecoder{b: b}
+	got, err := d.readStruct()
+	require.NoError(t, err)
+	assert.Equal(t, len(b), d.off)
+
+	assert.Equal(t, int64(-5), got.int(1))
+	v, _ := got.field(2)
+	assert.Equal(t, true, v)
+	assert.Equal(t, "abc", got.string(3))
+	assert.Equal(t, int64(1)<<40, got.int(20))
+	assert.Equal(t, tList{[]byte("x"), []byte("y")}, got.list(21))
+	v, _ = got.strct(22).field(1)
+	assert.Equal(t, false, v)
+	assert.Equal(t, int64(2), got.list(23)[1].(tStruct).int(1))
+
+	_, err = (&thriftDecoder{b: b[:len(b)-1]}).readStruct()
+	assert.Error(t, err)
+}
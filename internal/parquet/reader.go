@@ -0,0 +1,267 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/nakabonne/tstorage/internal/snappy"
+)
+
+// chunk is the decoded content of a column chunk.
+type chunk struct {
+	rep    []byte
+	def    []byte
+	values []byte
+}
+
+// ReadAll reads all rows out of the Parquet file, which must have the schema Writer writes.
+// Pages have to be PLAIN-encoded data pages, either uncompressed or compressed with snappy.
+func ReadAll(r io.ReaderAt, size int64) ([]Row, error) {
+	if size < int64(2*len(magic)+4) {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidFile)
+	}
+	tail := make([]byte, 4+len(magic))
+	if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, fmt.Errorf("parquet: failed to read footer: %w", err)
+	}
+	if !bytes.Equal(tail[4:], magic) {
+		return nil, fmt.Errorf("%w: magic number not found", ErrInvalidFile)
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(tail))
+	if footerLen > size-int64(len(tail)+len(magic)) {
+		return nil, fmt.Errorf("%w: footer length %d out of range", ErrInvalidFile, footerLen)
+	}
+	footer := make([]byte, footerLen)
+	if _, err := r.ReadAt(footer, size-int64(len(tail))-footerLen); err != nil {
+		return nil, fmt.Errorf("parquet: failed to read footer: %w", err)
+	}
+	d := &thriftDecoder{b: footer}
+	meta, err := d.readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("parquet: failed to decode file metadata: %w", err)
+	}
+
+	rows := make([]Row, 0, meta.int(3))
+	for _, rg := range meta.list(4) {
+		rowGroup, ok := rg.(tStruct)
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed row group", ErrInvalidFile)
+		}
+		chunks, err := readRowGroup(r, size, rowGroup)
+		if err != nil {
+			return nil, err
+		}
+		rows, err = assemble(rows, int(rowGroup.int(3)), chunks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// readRowGroup gives back the chunks of the row group in the order of columns.
+func readRowGroup(r io.ReaderAt, size int64, rowGroup tStruct) ([]*chunk, error) {
+	chunks := make([]*chunk, len(columns))
+	for _, cc := range rowGroup.list(1) {
+		cc, ok := cc.(tStruct)
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed column chunk", ErrInvalidFile)
+		}
+		meta := cc.strct(3)
+		parts := make([]string, 0)
+		for _, p := range meta.list(3) {
+			b, _ := p.([]byte)
+			parts = append(parts, string(b))
+		}
+		path := strings.Join(parts, ".")
+		for i, c := range columns {
+			if strings.Join(c.path, ".") != path {
+				continue
+			}
+			if meta.int(1) != int64(c.typ) {
+				return nil, fmt.Errorf("%w: unexpected type %d of column %q", ErrInvalidFile, meta.int(1), path)
+			}
+			offset, length := meta.int(9), meta.int(7)
+			if _, ok := meta.field(11); ok {
+				return nil, fmt.Errorf("%w: dictionary pages of column %q unsupported", ErrInvalidFile, path)
+			}
+			if offset < 0 || length < 0 || offset+length > size {
+				return nil, fmt.Errorf("%w: column chunk %q out of range", ErrInvalidFile, path)
+			}
+			b := make([]byte, length)
+			if _, err := r.ReadAt(b, offset); err != nil {
+				return nil, fmt.Errorf("parquet: failed to read column chunk %q: %w", path, err)
+			}
+			ch, err := decodeChunk(b, meta.int(4), c.maxRepDef)
+			if err != nil {
+				return nil, fmt.Errorf("parquet: failed to decode column chunk %q: %w", path, err)
+			}
+			chunks[i] = ch
+		}
+	}
+	for i, ch := range chunks {
+		if ch == nil {
+			return nil, fmt.Errorf("%w: column %q not found", ErrInvalidFile, strings.Join(columns[i].path, "."))
+		}
+	}
+	return chunks, nil
+}
+
+func decodeChunk(b []byte, codec int64, maxRepDef int32) (*chunk, error) {
+	ch := &chunk{}
+	d := &thriftDecoder{b: b}
+	for d.off < len(b) {
+		header, err := d.readStruct()
+		if err != nil {
+			return nil, err
+		}
+		if header.int(1) != pageTypeData {
+			return nil, fmt.Errorf("%w: page type %d unsupported", ErrInvalidFile, header.int(1))
+		}
+		dataPage := header.strct(5)
+		if dataPage.int(2) != encodingPlain {
+			return nil, fmt.Errorf("%w: encoding %d unsupported", ErrInvalidFile, dataPage.int(2))
+		}
+		n := header.int(3)
+		if n < 0 || n > int64(len(b)-d.off) {
+			return nil, fmt.Errorf("%w: page size %d out of range", ErrInvalidFile, n)
+		}
+		page := b[d.off : d.off+int(n)]
+		d.off += int(n)
+		switch codec {
+		case codecUncompressed:
+		case codecSnappy:
+			if page, err = snappy.Decode(page); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: compression codec %d unsupported", ErrInvalidFile, codec)
+		}
+
+		numValues := int(dataPage.int(1))
+		if maxRepDef > 0 {
+			if ch.rep, page, err = decodeLevels(ch.rep, page, numValues); err != nil {
+				return nil, err
+			}
+			if ch.def, page, err = decodeLevels(ch.def, page, numValues); err != nil {
+				return nil, err
+			}
+		}
+		ch.values = append(ch.values, page...)
+	}
+	return ch, nil
+}
+
+// decodeLevels appends n levels of the bit width of 1 encoded in the RLE/bit-packing hybrid encoding,
+// and gives back the rest of the page.
+func decodeLevels(dst, page []byte, n int) ([]byte, []byte, error) {
+	if len(page) < 4 {
+		return nil, nil, ErrInvalidFile
+	}
+	length := binary.LittleEndian.Uint32(page)
+	if uint64(length) > uint64(len(page)-4) {
+		return nil, nil, ErrInvalidFile
+	}
+	b, rest := page[4:4+length], page[4+length:]
+	for want := len(dst) + n; len(dst) < want; {
+		header, k := binary.Uvarint(b)
+		if k <= 0 {
+			return nil, nil, ErrInvalidFile
+		}
+		b = b[k:]
+		count := header >> 1
+		if header&1 == 0 {
+			// An RLE run, whose value takes a byte.
+			if len(b) < 1 || count > uint64(want-len(dst)) {
+				return nil, nil, ErrInvalidFile
+			}
+			for i := uint64(0); i < count; i++ {
+				dst = append(dst, b[0]&1)
+			}
+			b = b[1:]
+			continue
+		}
+		// Bit-packed groups of 8 values, each of which takes a byte, padded at the end.
+		if count > uint64(len(b)) {
+			return nil, nil, ErrInvalidFile
+		}
+		for _, c := range b[:count] {
+			for i := 0; i < 8 && len(dst) < want; i++ {
+				dst = append(dst, c>>i&1)
+			}
+		}
+		b = b[count:]
+	}
+	return dst, rest, nil
+}
+
+// assemble appends numRows rows made out of the chunks.
+func assemble(rows []Row, numRows int, chunks []*chunk) ([]Row, error) {
+	metrics, err := byteArrays(chunks[0].values, numRows)
+	if err != nil {
+		return nil, err
+	}
+	keys, values := chunks[1], chunks[2]
+	if !bytes.Equal(keys.rep, values.rep) || !bytes.Equal(keys.def, values.def) {
+		return nil, fmt.Errorf("%w: keys and values of labels mismatch", ErrInvalidFile)
+	}
+	numLabels := bytes.Count(keys.def, []byte{1})
+	names, err := byteArrays(keys.values, numLabels)
+	if err != nil {
+		return nil, err
+	}
+	labelValues, err := byteArrays(values.values, numLabels)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks[3].values) != 8*numRows || len(chunks[4].values) != 8*numRows {
+		return nil, fmt.Errorf("%w: number of values mismatch", ErrInvalidFile)
+	}
+
+	var labels [][]Label
+	for i, label := 0, 0; i < len(keys.rep); i++ {
+		if keys.rep[i] == 0 {
+			labels = append(labels, make([]Label, 0))
+		}
+		if len(labels) == 0 {
+			return nil, fmt.Errorf("%w: labels start with a repeated entry", ErrInvalidFile)
+		}
+		if keys.def[i] == 1 {
+			labels[len(labels)-1] = append(labels[len(labels)-1], Label{Name: names[label], Value: labelValues[label]})
+			label++
+		}
+	}
+	if len(labels) != numRows {
+		return nil, fmt.Errorf("%w: %d labels found for %d rows", ErrInvalidFile, len(labels), numRows)
+	}
+
+	for i := 0; i < numRows; i++ {
+		rows = append(rows, Row{
+			Metric:    metrics[i],
+			Labels:    labels[i],
+			Timestamp: int64(binary.LittleEndian.Uint64(chunks[3].values[8*i:])),
+			Value:     math.Float64frombits(binary.LittleEndian.Uint64(chunks[4].values[8*i:])),
+		})
+	}
+	return rows, nil
+}
+
+func byteArrays(b []byte, n int) ([]string, error) {
+	s := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("%w: byte array truncated", ErrInvalidFile)
+		}
+		l := binary.LittleEndian.Uint32(b)
+		if uint64(l) > uint64(len(b)-4) {
+			return nil, fmt.Errorf("%w: byte array truncated", ErrInvalidFile)
+		}
+		s = append(s, string(b[4:4+l]))
+		b = b[4+l:]
+	}
+	return s, nil
+}
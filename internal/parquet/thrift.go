@@ -0,0 +1,312 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Parquet metadata is serialized with the Thrift compact protocol.
+// See https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+
+const (
+	thriftStop      = 0
+	thriftTrue      = 1
+	thriftFalse     = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+	thriftBoolInSeq = 1
+)
+
+// tStruct is a Thrift struct, whose fields are in ascending order of their IDs.
+// Values are int32, int64, bool, string, []byte, tStruct or tList when encoding.
+// Decoding gives back int64 for all integers and []byte for binaries instead.
+type tStruct []tField
+
+type tField struct {
+	id    int16
+	value any
+}
+
+// tList is a Thrift list whose elements are of the same type.
+type tList []any
+
+func (s tStruct) field(id int16) (any, bool) {
+	for _, f := range s {
+		if f.id == id {
+			return f.value, true
+		}
+	}
+	return nil, false
+}
+
+func (s tStruct) int(id int16) int64 {
+	v, _ := s.field(id)
+	i, _ := v.(int64)
+	return i
+}
+
+func (s tStruct) string(id int16) string {
+	v, _ := s.field(id)
+	b, _ := v.([]byte)
+	return string(b)
+}
+
+func (s tStruct) strct(id int16) tStruct {
+	v, _ := s.field(id)
+	st, _ := v.(tStruct)
+	return st
+}
+
+func (s tStruct) list(id int16) tList {
+	v, _ := s.field(id)
+	l, _ := v.(tList)
+	return l
+}
+
+func thriftType(v any) byte {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return thriftTrue
+		}
+		return thriftFalse
+	case int32:
+		return thriftI32
+	case int64:
+		return thriftI64
+	case string, []byte:
+		return thriftBinary
+	case tStruct:
+		return thriftStruct
+	case tList:
+		return thriftList
+	default:
+		panic(fmt.Sprintf("parquet: unsupported thrift value %T", v))
+	}
+}
+
+func appendStruct(dst []byte, s tStruct) []byte {
+	var last int16
+	for _, f := range s {
+		typ := thriftType(f.value)
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			dst = append(dst, byte(delta)<<4|typ)
+		} else {
+			dst = append(dst, typ)
+			dst = binary.AppendVarint(dst, int64(f.id))
+		}
+		last = f.id
+		if _, ok := f.value.(bool); ok {
+			// The value of a boolean field is in its type.
+			continue
+		}
+		dst = appendValue(dst, f.value)
+	}
+	return append(dst, thriftStop)
+}
+
+func appendValue(dst []byte, v any) []byte {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return append(dst, thriftBoolInSeq)
+		}
+		return append(dst, 0)
+	case int32:
+		return binary.AppendVarint(dst, int64(v))
+	case int64:
+		return binary.AppendVarint(dst, v)
+	case string:
+		dst = binary.AppendUvarint(dst, uint64(len(v)))
+		return append(dst, v...)
+	case []byte:
+		dst = binary.AppendUvarint(dst, uint64(len(v)))
+		return append(dst, v...)
+	case tStruct:
+		return appendStruct(dst, v)
+	case tList:
+		typ := byte(thriftStruct)
+		if len(v) > 0 {
+			typ = thriftType(v[0])
+			if typ == thriftFalse {
+				typ = thriftTrue
+			}
+		}
+		if len(v) < 15 {
+			dst = append(dst, byte(len(v))<<4|typ)
+		} else {
+			dst = append(dst, 0xf0|typ)
+			dst = binary.AppendUvarint(dst, uint64(len(v)))
+		}
+		for _, e := range v {
+			dst = appendValue(dst, e)
+		}
+		return dst
+	default:
+		panic(fmt.Sprintf("parquet: unsupported thrift value %T", v))
+	}
+}
+
+// thriftDecoder reads values in the compact protocol.
+type thriftDecoder struct {
+	b   []byte
+	off int
+}
+
+func (d *thriftDecoder) byte() (byte, error) {
+	if d.off >= len(d.b) {
+		return 0, ErrInvalidFile
+	}
+	c := d.b[d.off]
+	d.off++
+	return c, nil
+}
+
+func (d *thriftDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.b[d.off:])
+	if n <= 0 {
+		return 0, ErrInvalidFile
+	}
+	d.off += n
+	return v, nil
+}
+
+func (d *thriftDecoder) varint() (int64, error) {
+	v, n := binary.Varint(d.b[d.off:])
+	if n <= 0 {
+		return 0, ErrInvalidFile
+	}
+	d.off += n
+	return v, nil
+}
+
+func (d *thriftDecoder) readStruct() (tStruct, error) {
+	s := make(tStruct, 0)
+	var last int16
+	for {
+		h, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if h == thriftStop {
+			return s, nil
+		}
+		typ := h & 0x0f
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			v, err := d.varint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		last = id
+		var v any
+		switch typ {
+		case thriftTrue:
+			v = true
+		case thriftFalse:
+			v = false
+		default:
+			if v, err = d.readValue(typ); err != nil {
+				return nil, err
+			}
+		}
+		s = append(s, tField{id: id, value: v})
+	}
+}
+
+func (d *thriftDecoder) readValue(typ byte) (any, error) {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		// Booleans in lists are a byte each.
+		b, err := d.byte()
+		return b == thriftBoolInSeq, err
+	case thriftByte:
+		b, err := d.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return d.varint()
+	case thriftDouble:
+		if d.off+8 > len(d.b) {
+			return nil, ErrInvalidFile
+		}
+		v := binary.LittleEndian.Uint64(d.b[d.off:])
+		d.off += 8
+		return v, nil
+	case thriftBinary:
+		n, err := d.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(d.b)-d.off) {
+			return nil, ErrInvalidFile
+		}
+		b := d.b[d.off : d.off+int(n)]
+		d.off += int(n)
+		return b, nil
+	case thriftStruct:
+		return d.readStruct()
+	case thriftList, thriftSet:
+		h, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(h >> 4)
+		if n == 15 {
+			if n, err = d.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		if n > uint64(len(d.b)-d.off) {
+			return nil, ErrInvalidFile
+		}
+		l := make(tList, 0, n)
+		for i := uint64(0); i < n; i++ {
+			v, err := d.readValue(h & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		return l, nil
+	case thriftMap:
+		n, err := d.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return tList{}, nil
+		}
+		kv, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(d.b)-d.off) {
+			return nil, ErrInvalidFile
+		}
+		l := make(tList, 0, 2*n)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.readValue(kv >> 4)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.readValue(kv & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, k, v)
+		}
+		return l, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown thrift type %d", ErrInvalidFile, typ)
+	}
+}
@@ -0,0 +1,426 @@
+// Package prompb implements the subset of the Prometheus remote read/write protobuf messages that tstorage serves.
+// See https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto
+package prompb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type WriteRequest struct {
+	Timeseries []TimeSeries
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value float64
+	// Unix timestamp in milliseconds
+	Timestamp int64
+}
+
+type ReadRequest struct {
+	Queries               []Query
+	AcceptedResponseTypes []ResponseType
+}
+
+type ResponseType int32
+
+const (
+	ResponseTypeSamples           ResponseType = 0
+	ResponseTypeStreamedXORChunks ResponseType = 1
+)
+
+type Query struct {
+	// Unix timestamps in milliseconds, both inclusive
+	StartTimestampMs int64
+	EndTimestampMs   int64
+	Matchers         []LabelMatcher
+}
+
+type MatchType int32
+
+const (
+	MatchEqual     MatchType = 0
+	MatchNotEqual  MatchType = 1
+	MatchRegexp    MatchType = 2
+	MatchNotRegexp MatchType = 3
+)
+
+type LabelMatcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+}
+
+type ReadResponse struct {
+	Results []QueryResult
+}
+
+type QueryResult struct {
+	Timeseries []TimeSeries
+}
+
+// Marshal
+
+func (m *WriteRequest) Marshal() []byte {
+	var dst []byte
+	for i := range m.Timeseries {
+		dst = appendMessageField(dst, 1, m.Timeseries[i].Marshal())
+	}
+	return dst
+}
+
+func (m *TimeSeries) Marshal() []byte {
+	var dst []byte
+	for i := range m.Labels {
+		dst = appendMessageField(dst, 1, m.Labels[i].Marshal())
+	}
+	for i := range m.Samples {
+		dst = appendMessageField(dst, 2, m.Samples[i].Marshal())
+	}
+	return dst
+}
+
+func (m *Label) Marshal() []byte {
+	var dst []byte
+	dst = appendStringField(dst, 1, m.Name)
+	return appendStringField(dst, 2, m.Value)
+}
+
+func (m *Sample) Marshal() []byte {
+	var dst []byte
+	dst = appendDoubleField(dst, 1, m.Value)
+	return appendVarintField(dst, 2, uint64(m.Timestamp))
+}
+
+func (m *ReadRequest) Marshal() []byte {
+	var dst []byte
+	for i := range m.Queries {
+		dst = appendMessageField(dst, 1, m.Queries[i].Marshal())
+	}
+	if len(m.AcceptedResponseTypes) > 0 {
+		var packed []byte
+		for _, t := range m.AcceptedResponseTypes {
+			packed = binary.AppendUvarint(packed, uint64(t))
+		}
+		dst = appendMessageField(dst, 2, packed)
+	}
+	return dst
+}
+
+func (m *Query) Marshal() []byte {
+	var dst []byte
+	dst = appendVarintField(dst, 1, uint64(m.StartTimestampMs))
+	dst = appendVarintField(dst, 2, uint64(m.EndTimestampMs))
+	for i := range m.Matchers {
+		dst = appendMessageField(dst, 3, m.Matchers[i].Marshal())
+	}
+	return dst
+}
+
+func (m *LabelMatcher) Marshal() []byte {
+	var dst []byte
+	dst = appendVarintField(dst, 1, uint64(m.Type))
+	dst = appendStringField(dst, 2, m.Name)
+	return appendStringField(dst, 3, m.Value)
+}
+
+func (m *ReadResponse) Marshal() []byte {
+	var dst []byte
+	for i := range m.Results {
+		dst = appendMessageField(dst, 1, m.Results[i].Marshal())
+	}
+	return dst
+}
+
+func (m *QueryResult) Marshal() []byte {
+	var dst []byte
+	for i := range m.Timeseries {
+		dst = appendMessageField(dst, 1, m.Timeseries[i].Marshal())
+	}
+	return dst
+}
+
+// Unmarshal
+
+// unmarshalFields calls fn for each field in the given message. fn must consume the value of the field,
+// or give back false to skip it.
+func unmarshalFields(b []byte, fn func(d *decoder, field, wireType int) (bool, error)) error {
+	d := &decoder{b: b}
+	for !d.done() {
+		field, wireType, err := d.next()
+		if err != nil {
+			return err
+		}
+		ok, err := fn(d, field, wireType)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := d.skip(wireType); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *WriteRequest) Unmarshal(b []byte) error {
+	return unmarshalFields(b, func(d *decoder, field, wireType int) (bool, error) {
+		if field != 1 {
+			// Metadata and anything else are ignored.
+			return false, nil
+		}
+		if err := expect(field, wireType, wireBytes); err != nil {
+			return false, err
+		}
+		msg, err := d.bytes()
+		if err != nil {
+			return false, err
+		}
+		var ts TimeSeries
+		if err := ts.Unmarshal(msg); err != nil {
+			return false, err
+		}
+		m.Timeseries = append(m.Timeseries, ts)
+		return true, nil
+	})
+}
+
+func (m *TimeSeries) Unmarshal(b []byte) error {
+	return unmarshalFields(b, func(d *decoder, field, wireType int) (bool, error) {
+		if field != 1 && field != 2 {
+			// Exemplars and native histograms are ignored.
+			return false, nil
+		}
+		if err := expect(field, wireType, wireBytes); err != nil {
+			return false, err
+		}
+		msg, err := d.bytes()
+		if err != nil {
+			return false, err
+		}
+		if field == 1 {
+			var l Label
+			if err := l.Unmarshal(msg); err != nil {
+				return false, err
+			}
+			m.Labels = append(m.Labels, l)
+			return true, nil
+		}
+		var s Sample
+		if err := s.Unmarshal(msg); err != nil {
+			return false, err
+		}
+		m.Samples = append(m.Samples, s)
+		return true, nil
+	})
+}
+
+func (m *Label) Unmarshal(b []byte) error {
+	return unmarshalFields(b, func(d *decoder, field, wireType int) (bool, error) {
+		var err error
+		switch field {
+		case 1:
+			if err := expect(field, wireType, wireBytes); err != nil {
+				return false, err
+			}
+			m.Name, err = d.string()
+		case 2:
+			if err := expect(field, wireType, wireBytes); err != nil {
+				return false, err
+			}
+			m.Value, err = d.string()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (m *Sample) Unmarshal(b []byte) error {
+	return unmarshalFields(b, func(d *decoder, field, wireType int) (bool, error) {
+		var err error
+		switch field {
+		case 1:
+			if err := expect(field, wireType, wireFixed64); err != nil {
+				return false, err
+			}
+			m.Value, err = d.double()
+		case 2:
+			if err := expect(field, wireType, wireVarint); err != nil {
+				return false, err
+			}
+			var v uint64
+			v, err = d.varint()
+			m.Timestamp = int64(v)
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (m *ReadRequest) Unmarshal(b []byte) error {
+	return unmarshalFields(b, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1:
+			if err := expect(field, wireType, wireBytes); err != nil {
+				return false, err
+			}
+			msg, err := d.bytes()
+			if err != nil {
+				return false, err
+			}
+			var q Query
+			if err := q.Unmarshal(msg); err != nil {
+				return false, err
+			}
+			m.Queries = append(m.Queries, q)
+		case 2:
+			// Repeated enums may be either packed or not.
+			switch wireType {
+			case wireVarint:
+				v, err := d.varint()
+				if err != nil {
+					return false, err
+				}
+				m.AcceptedResponseTypes = append(m.AcceptedResponseTypes, ResponseType(v))
+			case wireBytes:
+				packed, err := d.bytes()
+				if err != nil {
+					return false, err
+				}
+				pd := &decoder{b: packed}
+				for !pd.done() {
+					v, err := pd.varint()
+					if err != nil {
+						return false, err
+					}
+					m.AcceptedResponseTypes = append(m.AcceptedResponseTypes, ResponseType(v))
+				}
+			default:
+				return false, fmt.Errorf("%w: unexpected wire type %d for field %d", ErrInvalidMessage, wireType, field)
+			}
+		default:
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+func (m *Query) Unmarshal(b []byte) error {
+	return unmarshalFields(b, func(d *decoder, field, wireType int) (bool, error) {
+		switch field {
+		case 1, 2:
+			if err := expect(field, wireType, wireVarint); err != nil {
+				return false, err
+			}
+			v, err := d.varint()
+			if err != nil {
+				return false, err
+			}
+			if field == 1 {
+				m.StartTimestampMs = int64(v)
+			} else {
+				m.EndTimestampMs = int64(v)
+			}
+		case 3:
+			if err := expect(field, wireType, wireBytes); err != nil {
+				return false, err
+			}
+			msg, err := d.bytes()
+			if err != nil {
+				return false, err
+			}
+			var lm LabelMatcher
+			if err := lm.Unmarshal(msg); err != nil {
+				return false, err
+			}
+			m.Matchers = append(m.Matchers, lm)
+		default:
+			// Hints are ignored.
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+func (m *LabelMatcher) Unmarshal(b []byte) error {
+	return unmarshalFields(b, func(d *decoder, field, wireType int) (bool, error) {
+		var err error
+		switch field {
+		case 1:
+			if err := expect(field, wireType, wireVarint); err != nil {
+				return false, err
+			}
+			var v uint64
+			v, err = d.varint()
+			m.Type = MatchType(v)
+		case 2:
+			if err := expect(field, wireType, wireBytes); err != nil {
+				return false, err
+			}
+			m.Name, err = d.string()
+		case 3:
+			if err := expect(field, wireType, wireBytes); err != nil {
+				return false, err
+			}
+			m.Value, err = d.string()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+}
+
+func (m *ReadResponse) Unmarshal(b []byte) error {
+	return unmarshalFields(b, func(d *decoder, field, wireType int) (bool, error) {
+		if field != 1 {
+			return false, nil
+		}
+		if err := expect(field, wireType, wireBytes); err != nil {
+			return false, err
+		}
+		msg, err := d.bytes()
+		if err != nil {
+			return false, err
+		}
+		var r QueryResult
+		if err := r.Unmarshal(msg); err != nil {
+			return false, err
+		}
+		m.Results = append(m.Results, r)
+		return true, nil
+	})
+}
+
+func (m *QueryResult) Unmarshal(b []byte) error {
+	return unmarshalFields(b, func(d *decoder, field, wireType int) (bool, error) {
+		if field != 1 {
+			return false, nil
+		}
+		if err := expect(field, wireType, wireBytes); err != nil {
+			return false, err
+		}
+		msg, err := d.bytes()
+		if err != nil {
+			return false, err
+		}
+		var ts TimeSeries
+		if err := ts.Unmarshal(msg); err != nil {
+			return false, err
+		}
+		m.Timeseries = append(m.Timeseries, ts)
+		return true, nil
+	})
+}
@@ -0,0 +1,91 @@
+package prompb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRequest_Marshal_Unmarshal(t *testing.T) {
+	want := &WriteRequest{
+		Timeseries: []TimeSeries{
+			{
+				Labels: []Label{
+					{Name: "__name__", Value: "metric1"},
+					{Name: "host", Value: "host-1"},
+				},
+				Samples: []Sample{
+					{Value: 0.1, Timestamp: 1600000000000},
+					{Value: -1, Timestamp: 1600000001000},
+				},
+			},
+		},
+	}
+	got := &WriteRequest{}
+	require.NoError(t, got.Unmarshal(want.Marshal()))
+	assert.Equal(t, want, got)
+}
+
+func TestReadRequest_Marshal_Unmarshal(t *testing.T) {
+	want := &ReadRequest{
+		Queries: []Query{
+			{
+				StartTimestampMs: 1600000000000,
+				EndTimestampMs:   1600000001000,
+				Matchers: []LabelMatcher{
+					{Type: MatchEqual, Name: "__name__", Value: "metric1"},
+					{Type: MatchRegexp, Name: "host", Value: "host-.*"},
+				},
+			},
+		},
+		AcceptedResponseTypes: []ResponseType{ResponseTypeSamples, ResponseTypeStreamedXORChunks},
+	}
+	got := &ReadRequest{}
+	require.NoError(t, got.Unmarshal(want.Marshal()))
+	assert.Equal(t, want, got)
+}
+
+func TestReadResponse_Marshal_Unmarshal(t *testing.T) {
+	want := &ReadResponse{
+		Results: []QueryResult{
+			{Timeseries: []TimeSeries{{Labels: []Label{{Name: "__name__", Value: "metric1"}}, Samples: []Sample{{Value: 1, Timestamp: 1}}}}},
+			{},
+		},
+	}
+	got := &ReadResponse{}
+	require.NoError(t, got.Unmarshal(want.Marshal()))
+	assert.Equal(t, want, got)
+}
+
+func TestSample_Marshal(t *testing.T) {
+	s := &Sample{Value: 1, Timestamp: 1000}
+	want := []byte{0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, 0x10, 0xe8, 0x07}
+	assert.Equal(t, want, s.Marshal())
+}
+
+func TestUnmarshal_invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "truncated bytes", input: []byte{0x0a, 0x05, 0x01}},
+		{name: "malformed varint", input: []byte{0x0a, 0xff}},
+		{name: "unexpected wire type", input: []byte{0x08, 0x01}},
+		{name: "zero field number", input: []byte{0x00, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&WriteRequest{}).Unmarshal(tt.input)
+			assert.ErrorIs(t, err, ErrInvalidMessage)
+		})
+	}
+}
+
+func TestUnmarshal_skipUnknownFields(t *testing.T) {
+	// A label with an unknown varint field 3 and fixed32 field 4.
+	b := []byte{0x0a, 0x01, 'a', 0x18, 0x01, 0x25, 0, 0, 0, 0, 0x12, 0x01, 'b'}
+	got := &Label{}
+	require.NoError(t, got.Unmarshal(b))
+	assert.Equal(t, &Label{Name: "a", Value: "b"}, got)
+}
@@ -0,0 +1,146 @@
+package prompb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrInvalidMessage is given back if the input is not a valid protobuf message.
+var ErrInvalidMessage = errors.New("prompb: invalid message")
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendTag(dst []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(dst, uint64(field)<<3|uint64(wireType))
+}
+
+func appendVarintField(dst []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return dst
+	}
+	dst = appendTag(dst, field, wireVarint)
+	return binary.AppendUvarint(dst, v)
+}
+
+func appendDoubleField(dst []byte, field int, v float64) []byte {
+	if v == 0 && !math.Signbit(v) {
+		return dst
+	}
+	dst = appendTag(dst, field, wireFixed64)
+	return binary.LittleEndian.AppendUint64(dst, math.Float64bits(v))
+}
+
+func appendStringField(dst []byte, field int, s string) []byte {
+	if s == "" {
+		return dst
+	}
+	dst = appendTag(dst, field, wireBytes)
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// appendMessageField appends the embedded message, which is always emitted even if empty.
+func appendMessageField(dst []byte, field int, msg []byte) []byte {
+	dst = appendTag(dst, field, wireBytes)
+	dst = binary.AppendUvarint(dst, uint64(len(msg)))
+	return append(dst, msg...)
+}
+
+// decoder reads fields from a protobuf message.
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) done() bool {
+	return len(d.b) == 0
+}
+
+// next reads the tag of the next field.
+func (d *decoder) next() (field, wireType int, err error) {
+	tag, err := d.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	field = int(tag >> 3)
+	if field <= 0 {
+		return 0, 0, fmt.Errorf("%w: field number %d", ErrInvalidMessage, field)
+	}
+	return field, int(tag & 0x07), nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		return 0, fmt.Errorf("%w: malformed varint", ErrInvalidMessage)
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) fixed64() (uint64, error) {
+	if len(d.b) < 8 {
+		return 0, fmt.Errorf("%w: truncated fixed64", ErrInvalidMessage)
+	}
+	v := binary.LittleEndian.Uint64(d.b)
+	d.b = d.b[8:]
+	return v, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(d.b)) < n {
+		return nil, fmt.Errorf("%w: truncated bytes", ErrInvalidMessage)
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+func (d *decoder) double() (float64, error) {
+	v, err := d.fixed64()
+	return math.Float64frombits(v), err
+}
+
+func (d *decoder) string() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
+
+// skip skips the value of the field with the given wire type.
+func (d *decoder) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case wireVarint:
+		_, err = d.varint()
+	case wireFixed64:
+		_, err = d.fixed64()
+	case wireBytes:
+		_, err = d.bytes()
+	case wireFixed32:
+		if len(d.b) < 4 {
+			return fmt.Errorf("%w: truncated fixed32", ErrInvalidMessage)
+		}
+		d.b = d.b[4:]
+	default:
+		return fmt.Errorf("%w: unsupported wire type %d", ErrInvalidMessage, wireType)
+	}
+	return err
+}
+
+// expect gives back an error unless the field has the expected wire type.
+func expect(field, wireType, want int) error {
+	if wireType != want {
+		return fmt.Errorf("%w: unexpected wire type %d for field %d", ErrInvalidMessage, wireType, field)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Package snappy implements the snappy block format, which Prometheus uses to compress remote read/write messages.
+// See https://github.com/google/snappy/blob/main/format_description.txt
+package snappy
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrCorrupt is given back if the input is not valid snappy-compressed data.
+var ErrCorrupt = errors.New("snappy: corrupt input")
+
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01
+	tagCopy2   = 0x02
+	tagCopy4   = 0x03
+
+	tableBits = 14
+	// Offsets are limited to the ones tagCopy2 can hold.
+	maxOffset = 1<<16 - 1
+)
+
+// Encode appends the compressed src to dst and returns the result.
+func Encode(dst, src []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+	var table [1 << tableBits]int32
+	lit := 0
+	for i := 0; i+4 <= len(src); {
+		h := hash(binary.LittleEndian.Uint32(src[i:]))
+		// Positions are held plus one, so that zero means none.
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if cand < 0 || i-cand > maxOffset || binary.LittleEndian.Uint32(src[cand:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+		dst = emitLiteral(dst, src[lit:i])
+		n := 4
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+		dst = emitCopy(dst, i-cand, n)
+		i += n
+		lit = i
+	}
+	return emitLiteral(dst, src[lit:])
+}
+
+func hash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - tableBits)
+}
+
+func emitLiteral(dst, lit []byte) []byte {
+	n := len(lit) - 1
+	switch {
+	case n < 0:
+		return dst
+	case n < 60:
+		dst = append(dst, byte(n)<<2|tagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|tagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+// emitCopy emits copies with 2-byte offsets, each of which can hold up to 64 bytes.
+func emitCopy(dst []byte, offset, n int) []byte {
+	for n > 0 {
+		l := n
+		if l > 64 {
+			l = 64
+		}
+		dst = append(dst, byte(l-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+		n -= l
+	}
+	return dst
+}
+
+// DecodedLen gives back the length of the decompressed src.
+func DecodedLen(src []byte) (int, error) {
+	n, m := binary.Uvarint(src)
+	if m <= 0 || n > 1<<32-1 {
+		return 0, ErrCorrupt
+	}
+	return int(n), nil
+}
+
+// Decode gives back the decompressed src.
+// Check DecodedLen in advance if src is untrusted, since the output is allocated as it claims.
+func Decode(src []byte) ([]byte, error) {
+	n, m := binary.Uvarint(src)
+	if m <= 0 || n > 1<<32-1 {
+		return nil, ErrCorrupt
+	}
+	dst := make([]byte, 0, int(n))
+	src = src[m:]
+	for len(src) > 0 {
+		var length, offset int
+		switch src[0] & 0x03 {
+		case tagLiteral:
+			l := int(src[0] >> 2)
+			src = src[1:]
+			if l >= 60 {
+				size := l - 59
+				if len(src) < size {
+					return nil, ErrCorrupt
+				}
+				l = 0
+				for i := size - 1; i >= 0; i-- {
+					l = l<<8 | int(src[i])
+				}
+				src = src[size:]
+			}
+			l++
+			if l <= 0 || len(src) < l || len(dst)+l > int(n) {
+				return nil, ErrCorrupt
+			}
+			dst = append(dst, src[:l]...)
+			src = src[l:]
+			continue
+		case tagCopy1:
+			if len(src) < 2 {
+				return nil, ErrCorrupt
+			}
+			length = 4 + int(src[0]>>2)&0x07
+			offset = int(src[0]&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case tagCopy2:
+			if len(src) < 3 {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(src[0]>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case tagCopy4:
+			if len(src) < 5 {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(src[0]>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(n) {
+			return nil, ErrCorrupt
+		}
+		// Copy byte by byte since the source and destination may overlap.
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if len(dst) != int(n) {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
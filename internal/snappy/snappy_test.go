@@ -0,0 +1,81 @@
+package snappy
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode_Decode(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "empty", input: []byte{}},
+		{name: "short", input: []byte("abc")},
+		{name: "repeated", input: bytes.Repeat([]byte("abcdefgh"), 10000)},
+		{name: "random", input: random},
+		{name: "long literal", input: append(append([]byte{}, random[:70000]...), random[:70000]...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := Encode(nil, tt.input)
+			n, err := DecodedLen(encoded)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.input), n)
+			got, err := Decode(encoded)
+			require.NoError(t, err)
+			assert.Equal(t, tt.input, got)
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:  "literal",
+			input: []byte{0x05, 0x10, 'h', 'e', 'l', 'l', 'o'},
+			want:  []byte("hello"),
+		},
+		{
+			name: "overlapping copy with 1-byte offset",
+			// "ab" followed by a copy of length 6 at offset 2.
+			input: []byte{0x08, 0x04, 'a', 'b', 0x09, 0x02},
+			want:  []byte("abababab"),
+		},
+		{
+			name:    "offset beyond output",
+			input:   []byte{0x08, 0x04, 'a', 'b', 0x09, 0x03},
+			wantErr: true,
+		},
+		{
+			name:    "length mismatch",
+			input:   []byte{0x06, 0x10, 'h', 'e', 'l', 'l', 'o'},
+			wantErr: true,
+		},
+		{
+			name:    "truncated literal",
+			input:   []byte{0x05, 0x10, 'h', 'e'},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.input)
+			assert.Equal(t, tt.wantErr, err != nil)
+			if err == nil {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
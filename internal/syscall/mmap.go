@@ -3,3 +3,7 @@ package syscall
 func Mmap(fd, length int) ([]byte, error) {
 	return mmap(fd, length)
 }
+
+func Munmap(b []byte) error {
+	return munmap(b)
+}
@@ -13,3 +13,7 @@ func mmap(fd, length int) ([]byte, error) {
 		syscall.MAP_SHARED,
 	)
 }
+
+func munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
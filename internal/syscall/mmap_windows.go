@@ -37,3 +37,10 @@ func mmap(fd, size int) ([]byte, error) {
 
 	return (*[maxMapSize]byte)(unsafe.Pointer(addr))[:size], nil
 }
+
+func munmap(b []byte) error {
+	if err := syscall.UnmapViewOfFile(uintptr(unsafe.Pointer(&b[0]))); err != nil {
+		return os.NewSyscallError("UnmapViewOfFile", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Package timeutil provides conversions between time and timestamps in the precision of a storage,
+// shared by the packages built on top of it.
+package timeutil
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// ErrOutOfRange means a timestamp can't be represented in the precision it's converted into.
+var ErrOutOfRange = errors.New("timestamp out of range")
+
+// ToUnix gives back the given time as a timestamp in the given precision.
+func ToUnix(t time.Time, precision tstorage.TimestampPrecision) int64 {
+	switch precision {
+	case tstorage.Microseconds:
+		return t.UnixMicro()
+	case tstorage.Milliseconds:
+		return t.UnixMilli()
+	case tstorage.Seconds:
+		return t.Unix()
+	default:
+		return t.UnixNano()
+	}
+}
+
+// DurationIn converts the duration into the given precision, which is at least 1.
+func DurationIn(d time.Duration, precision tstorage.TimestampPrecision) int64 {
+	var n int64
+	switch precision {
+	case tstorage.Microseconds:
+		n = d.Microseconds()
+	case tstorage.Milliseconds:
+		n = d.Milliseconds()
+	case tstorage.Seconds:
+		n = int64(d.Seconds())
+	default:
+		n = d.Nanoseconds()
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// unitsPerMilli gives back how many units in the given precision a millisecond has, or how many
+// milliseconds a unit has if it's coarser, as a negative number.
+func unitsPerMilli(precision tstorage.TimestampPrecision) int64 {
+	switch precision {
+	case tstorage.Microseconds:
+		return 1e3
+	case tstorage.Milliseconds:
+		return 1
+	case tstorage.Seconds:
+		return -1e3
+	default:
+		return 1e6
+	}
+}
+
+// FromMillis converts the timestamp in milliseconds into the given precision, rounding down.
+func FromMillis(ms int64, precision tstorage.TimestampPrecision) int64 {
+	u := unitsPerMilli(precision)
+	if u > 0 {
+		return ms * u
+	}
+	q := ms / -u
+	if ms%-u < 0 {
+		q--
+	}
+	return q
+}
+
+// CeilFromMillis is the same as FromMillis, except it rounds up.
+func CeilFromMillis(ms int64, precision tstorage.TimestampPrecision) int64 {
+	u := unitsPerMilli(precision)
+	if u > 0 {
+		return ms * u
+	}
+	q := FromMillis(ms, precision)
+	if q*-u != ms {
+		q++
+	}
+	return q
+}
+
+// ToMillis converts the timestamp in the given precision into milliseconds, rounding down.
+func ToMillis(ts int64, precision tstorage.TimestampPrecision) int64 {
+	u := unitsPerMilli(precision)
+	if u > 0 {
+		q := ts / u
+		if ts%u < 0 {
+			q--
+		}
+		return q
+	}
+	return ts * -u
+}
+
+// Unit gives back the duration of a unit in the given precision.
+func Unit(precision tstorage.TimestampPrecision) time.Duration {
+	switch precision {
+	case tstorage.Microseconds:
+		return time.Microsecond
+	case tstorage.Milliseconds:
+		return time.Millisecond
+	case tstorage.Seconds:
+		return time.Second
+	default:
+		return time.Nanosecond
+	}
+}
+
+// Convert converts the timestamp from one unit to another, rounding down.
+// It fails with ErrOutOfRange if the result overflows.
+func Convert(ts int64, from, to time.Duration) (int64, error) {
+	if from >= to {
+		m := int64(from / to)
+		if ts > math.MaxInt64/m || ts < math.MinInt64/m {
+			return 0, ErrOutOfRange
+		}
+		return ts * m, nil
+	}
+	d := int64(to / from)
+	q := ts / d
+	if ts%d < 0 {
+		q--
+	}
+	return q, nil
+}
@@ -0,0 +1,83 @@
+package timeutil
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func TestToUnix(t *testing.T) {
+	tm := time.Unix(1600000000, 500000000)
+	assert.Equal(t, int64(1600000000500000000), ToUnix(tm, tstorage.Nanoseconds))
+	assert.Equal(t, int64(1600000000500000), ToUnix(tm, tstorage.Microseconds))
+	assert.Equal(t, int64(1600000000500), ToUnix(tm, tstorage.Milliseconds))
+	assert.Equal(t, int64(1600000000), ToUnix(tm, tstorage.Seconds))
+}
+
+func TestDurationIn(t *testing.T) {
+	assert.Equal(t, int64(3600), DurationIn(time.Hour, tstorage.Seconds))
+	assert.Equal(t, int64(1), DurationIn(time.Millisecond, tstorage.Seconds))
+}
+
+func TestFromMillis(t *testing.T) {
+	tests := []struct {
+		name      string
+		ms        int64
+		precision tstorage.TimestampPrecision
+		want      int64
+		wantCeil  int64
+	}{
+		{name: "nanoseconds", ms: 1500, precision: tstorage.Nanoseconds, want: 1500000000, wantCeil: 1500000000},
+		{name: "microseconds", ms: 1500, precision: tstorage.Microseconds, want: 1500000, wantCeil: 1500000},
+		{name: "milliseconds", ms: 1500, precision: tstorage.Milliseconds, want: 1500, wantCeil: 1500},
+		{name: "seconds", ms: 1500, precision: tstorage.Seconds, want: 1, wantCeil: 2},
+		{name: "exact seconds", ms: 2000, precision: tstorage.Seconds, want: 2, wantCeil: 2},
+		{name: "negative seconds", ms: -1500, precision: tstorage.Seconds, want: -2, wantCeil: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, FromMillis(tt.ms, tt.precision))
+			assert.Equal(t, tt.wantCeil, CeilFromMillis(tt.ms, tt.precision))
+		})
+	}
+}
+
+func TestToMillis(t *testing.T) {
+	assert.Equal(t, int64(1500), ToMillis(1500000000, tstorage.Nanoseconds))
+	assert.Equal(t, int64(-2), ToMillis(-1500000, tstorage.Nanoseconds))
+	assert.Equal(t, int64(2000), ToMillis(2, tstorage.Seconds))
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		ts       int64
+		from, to time.Duration
+		want     int64
+		wantErr  bool
+	}{
+		{name: "seconds to milliseconds", ts: 2, from: time.Second, to: time.Millisecond, want: 2000},
+		{name: "nanoseconds to milliseconds", ts: 1500000, from: time.Nanosecond, to: time.Millisecond, want: 1},
+		{name: "negative nanoseconds to milliseconds", ts: -1500000, from: time.Nanosecond, to: time.Millisecond, want: -2},
+		{name: "hours to seconds", ts: 1, from: time.Hour, to: time.Second, want: 3600},
+		{name: "same", ts: 7, from: time.Microsecond, to: time.Microsecond, want: 7},
+		{name: "seconds to the unit of nanoseconds", ts: 3, from: time.Second, to: Unit(tstorage.Nanoseconds), want: 3000000000},
+		{name: "overflowing hours", ts: math.MaxInt64 / 3600, from: time.Hour, to: time.Nanosecond, wantErr: true},
+		{name: "overflowing negative minutes", ts: math.MinInt64 / 60, from: time.Minute, to: time.Nanosecond, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Convert(tt.ts, tt.from, tt.to)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrOutOfRange)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -9,12 +9,12 @@ import (
 const (
 	// The maximum length of label name.
 	//
-	// Longer names are truncated.
+	// Longer names are truncated, or rejected if WithStrictValidation is given.
 	maxLabelNameLen = 256
 
 	// The maximum length of label value.
 	//
-	// Longer values are truncated.
+	// Longer values are truncated, or rejected if WithStrictValidation is given.
 	maxLabelValueLen = 16 * 1024
 )
 
@@ -36,9 +36,13 @@ func marshalMetricName(metric string, labels []Label) string {
 
 	// Determine the bytes size in advance.
 	size := len(metric) + 2
-	sort.Slice(labels, func(i, j int) bool {
+	less := func(i, j int) bool {
 		return labels[i].Name < labels[j].Name
-	})
+	}
+	// Avoid writing to labels if possible, since they may be shared by goroutines.
+	if !sort.SliceIsSorted(labels, less) {
+		sort.Slice(labels, less)
+	}
 	for i := range labels {
 		label := &labels[i]
 		if invalid(label.Name, label.Value) {
@@ -71,3 +75,35 @@ func marshalMetricName(metric string, labels []Label) string {
 	}
 	return string(out)
 }
+
+// unmarshalMetricName is the inverse of marshalMetricName.
+// The given name is regarded as a bare metric if it isn't encoded with labels.
+func unmarshalMetricName(name string) (string, []Label) {
+	readString := func(src string) (string, string, bool) {
+		if len(src) < 2 {
+			return "", "", false
+		}
+		n := int(encoding.UnmarshalUint16([]byte(src[:2])))
+		if len(src) < 2+n {
+			return "", "", false
+		}
+		return src[2 : 2+n], src[2+n:], true
+	}
+
+	metric, rest, ok := readString(name)
+	if !ok {
+		return name, nil
+	}
+	var labels []Label
+	for rest != "" {
+		var label Label
+		if label.Name, rest, ok = readString(rest); !ok {
+			return name, nil
+		}
+		if label.Value, rest, ok = readString(rest); !ok {
+			return name, nil
+		}
+		labels = append(labels, label)
+	}
+	return metric, labels
+}
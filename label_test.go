@@ -52,3 +52,43 @@ func TestMarshalMetricName(t *testing.T) {
 		})
 	}
 }
+
+func TestUnmarshalMetricName(t *testing.T) {
+	tests := []struct {
+		name       string
+		metric     string
+		labels     []Label
+		wantLabels []Label
+	}{
+		{
+			name:   "only metric",
+			metric: "metric1",
+		},
+		{
+			name:   "metric with labels",
+			metric: "metric1",
+			labels: []Label{
+				{Name: "name2", Value: "value2"},
+				{Name: "name1", Value: "value1"},
+			},
+			wantLabels: []Label{
+				{Name: "name1", Value: "value1"},
+				{Name: "name2", Value: "value2"},
+			},
+		},
+		{
+			name:   "only invalid labels",
+			metric: "metric1",
+			labels: []Label{
+				{Name: "name1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, labels := unmarshalMetricName(marshalMetricName(tt.metric, tt.labels))
+			assert.Equal(t, tt.metric, metric)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+}
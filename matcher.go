@@ -0,0 +1,146 @@
+package tstorage
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// MetricNameLabel is the label name with which matchers match the metric name.
+const MetricNameLabel = "__name__"
+
+// MatchType is the type of comparison a Matcher performs.
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	default:
+		return fmt.Sprintf("MatchType(%d)", int(t))
+	}
+}
+
+// Matcher matches series by the value of a label, or of the metric name if the name is MetricNameLabel.
+// A missing label is regarded as the one with an empty value.
+// Build it with NewMatcher, since Matches on the one built otherwise compiles its regular expression on every call.
+// SelectSeries and Subscribe compile it only once, and refuse an invalid one.
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+
+	re *regexp.Regexp
+}
+
+// NewMatcher gives back a Matcher. Regular expressions are fully anchored.
+func NewMatcher(t MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{
+		Type:  t,
+		Name:  name,
+		Value: value,
+	}
+	switch t {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := compileAnchored(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", value, err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match type %d given", t)
+	}
+	return m, nil
+}
+
+// Matches reports whether the given label value matches.
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp, MatchNotRegexp:
+		re := m.re
+		if re == nil {
+			// Built without NewMatcher; an invalid regular expression matches nothing.
+			var err error
+			if re, err = compileAnchored(m.Value); err != nil {
+				return false
+			}
+		}
+		return re.MatchString(value) == (m.Type == MatchRegexp)
+	default:
+		return false
+	}
+}
+
+// compileAnchored compiles the regular expression so that it matches whole values.
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func (m *Matcher) String() string {
+	return m.Name + m.Type.String() + strconv.Quote(m.Value)
+}
+
+// compileMatchers gives back the given matchers with their regular expressions compiled,
+// in place of the ones built without NewMatcher, so that they aren't compiled on every match.
+// The given matchers are copied only if any of them has to be compiled.
+func compileMatchers(matchers []*Matcher) ([]*Matcher, error) {
+	compiled := matchers
+	copied := false
+	for i, m := range matchers {
+		if m.re != nil || (m.Type != MatchRegexp && m.Type != MatchNotRegexp) {
+			continue
+		}
+		c, err := NewMatcher(m.Type, m.Name, m.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !copied {
+			compiled = make([]*Matcher, len(matchers))
+			copy(compiled, matchers)
+			copied = true
+		}
+		compiled[i] = c
+	}
+	return compiled, nil
+}
+
+// matchSeries reports whether the series matches all of the given matchers.
+func matchSeries(matchers []*Matcher, metric string, labels []Label) bool {
+	for _, m := range matchers {
+		if !m.Matches(labelValue(metric, labels, m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// labelValue gives back the value of the given label, or empty if it doesn't exist.
+func labelValue(metric string, labels []Label, name string) string {
+	if name == MetricNameLabel {
+		return metric
+	}
+	for i := range labels {
+		if labels[i].Name == name {
+			return labels[i].Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package tstorage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcher_Matches(t *testing.T) {
+	tests := []struct {
+		name      string
+		matchType MatchType
+		value     string
+		input     string
+		want      bool
+	}{
+		{name: "equal", matchType: MatchEqual, value: "a", input: "a", want: true},
+		{name: "not equal", matchType: MatchNotEqual, value: "a", input: "a", want: false},
+		{name: "regexp", matchType: MatchRegexp, value: "a|b", input: "b", want: true},
+		{name: "regexp is anchored", matchType: MatchRegexp, value: "a", input: "ab", want: false},
+		{name: "not regexp", matchType: MatchNotRegexp, value: "a.*", input: "ab", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMatcher(tt.matchType, "name", tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, m.Matches(tt.input))
+			// The one built without NewMatcher behaves the same.
+			literal := &Matcher{Type: tt.matchType, Name: "name", Value: tt.value}
+			assert.Equal(t, tt.want, literal.Matches(tt.input))
+		})
+	}
+}
+
+func TestMatcher_Matches_invalidLiteral(t *testing.T) {
+	assert.False(t, (&Matcher{Type: MatchRegexp, Name: "name", Value: "("}).Matches("("))
+	assert.False(t, (&Matcher{Type: MatchNotRegexp, Name: "name", Value: "("}).Matches("a"))
+}
+
+func Test_compileMatchers(t *testing.T) {
+	built, err := NewMatcher(MatchRegexp, "host", "a|b")
+	require.NoError(t, err)
+	literal := &Matcher{Type: MatchNotRegexp, Name: "host", Value: "c"}
+	matchers := []*Matcher{built, {Type: MatchEqual, Name: "host", Value: "a"}, literal}
+	got, err := compileMatchers(matchers)
+	require.NoError(t, err)
+	require.Len(t, got, 3)
+	assert.Same(t, built, got[0])
+	assert.Same(t, matchers[1], got[1])
+	assert.NotNil(t, got[2].re)
+	assert.Equal(t, "host!~\"c\"", got[2].String())
+	// The given ones are left as they are.
+	assert.Same(t, literal, matchers[2])
+	assert.Nil(t, literal.re)
+
+	// Nothing is copied unless it has to be compiled.
+	matchers = matchers[:2]
+	got, err = compileMatchers(matchers)
+	require.NoError(t, err)
+	assert.Same(t, &matchers[0], &got[0])
+
+	_, err = compileMatchers([]*Matcher{{Type: MatchRegexp, Name: "host", Value: "("}})
+	assert.Error(t, err)
+}
+
+func TestNewMatcher_invalid(t *testing.T) {
+	_, err := NewMatcher(MatchRegexp, "name", "(")
+	assert.Error(t, err)
+	_, err = NewMatcher(MatchType(100), "name", "a")
+	assert.Error(t, err)
+}
+
+func Test_matchSeries(t *testing.T) {
+	metric := "metric1"
+	labels := []Label{{Name: "host", Value: "host-1"}}
+	mustMatcher := func(t MatchType, name, value string) *Matcher {
+		m, _ := NewMatcher(t, name, value)
+		return m
+	}
+	assert.True(t, matchSeries(nil, metric, labels))
+	assert.True(t, matchSeries([]*Matcher{mustMatcher(MatchEqual, MetricNameLabel, "metric1")}, metric, labels))
+	assert.True(t, matchSeries([]*Matcher{mustMatcher(MatchEqual, "region", "")}, metric, labels))
+	assert.False(t, matchSeries([]*Matcher{
+		mustMatcher(MatchEqual, MetricNameLabel, "metric1"),
+		mustMatcher(MatchNotEqual, "host", "host-1"),
+	}, metric, labels))
+}
@@ -0,0 +1,122 @@
+package tstorage
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"github.com/nakabonne/tstorage/internal/cgroup"
+)
+
+// Rough estimation of heap used by in-memory partitions.
+const (
+	// A pointer held by the points slice, and the data point it points to.
+	pointBytes = 8 + 16
+	// A memoryMetric, its entry in sync.Map, and the initial capacity of its points slice.
+	metricBytes = 128 + 64 + 1000*8
+)
+
+// The ratio of the memory budget at which in-memory partitions start being flushed early.
+const earlyFlushRatio = 0.9
+
+// MemoryBudget is the amount of heap in-memory partitions are allowed to use. See WithMemoryBudget.
+type MemoryBudget struct {
+	bytes    int64
+	fraction float64
+}
+
+// MemoryBudgetBytes gives back a memory budget of the given bytes.
+func MemoryBudgetBytes(bytes int64) MemoryBudget {
+	return MemoryBudget{bytes: bytes}
+}
+
+// MemoryBudgetFraction gives back a memory budget of the given fraction of the cgroup memory limit,
+// e.g. 0.5 means half of the limit.
+// No budget is applied if the process doesn't run under a cgroup memory limit.
+func MemoryBudgetFraction(fraction float64) MemoryBudget {
+	return MemoryBudget{fraction: fraction}
+}
+
+// resolve gives back the budget in bytes. Zero means unlimited.
+func (b MemoryBudget) resolve() int64 {
+	if b.bytes > 0 || b.fraction <= 0 {
+		return b.bytes
+	}
+	limit := cgroup.GetMemoryLimit()
+	if limit <= 0 || limit >= math.MaxInt64/2 {
+		// It may not be set on the current cgroup level.
+		limit = cgroup.GetHierarchicalMemoryLimit()
+	}
+	if limit <= 0 || limit >= math.MaxInt64/2 {
+		return 0
+	}
+	return int64(float64(limit) * b.fraction)
+}
+
+// memoryUsage gives back the estimated bytes of heap used by all in-memory partitions.
+func (s *storage[T]) memoryUsage() int64 {
+	var n int64
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		if memPart, ok := iterator.value().(*memoryPartition[T]); ok {
+			n += memPart.heapBytes()
+		}
+	}
+	return n
+}
+
+// checkMemoryBudget refuses writes if in-memory partitions use up the memory budget.
+// Once they get close to the budget, it starts flushing the oldest in-memory partition early.
+// For the in-memory mode, there is nowhere to flush to, so it only refuses writes.
+func (s *storage[T]) checkMemoryBudget() error {
+	if s.memoryBudgetBytes <= 0 {
+		return nil
+	}
+	usage := s.memoryUsage()
+	if usage < int64(float64(s.memoryBudgetBytes)*earlyFlushRatio) {
+		return nil
+	}
+	if !s.inMemoryMode() && atomic.CompareAndSwapInt32(&s.flushingEarly, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&s.flushingEarly, 0)
+			if err := s.flushEarly(); err != nil {
+				s.logger.Printf("failed to flush in-memory partitions early: %v\n", err)
+			}
+		}()
+	}
+	if usage >= s.memoryBudgetBytes {
+		return fmt.Errorf("in-memory partitions use %d bytes, beyond the memory budget of %d bytes: %w",
+			usage, s.memoryBudgetBytes, ErrLimitExceeded)
+	}
+	return nil
+}
+
+// flushEarly cuts off the head partition as many times as needed for the oldest in-memory partition
+// holding data points to fall out of the writable range, and then flushes it.
+// WAL is punctuated only once, for the newest partition cut off.
+func (s *storage[T]) flushEarly() error {
+	oldest := -1
+	i := 0
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		if memPart, ok := iterator.value().(*memoryPartition[T]); ok && memPart.size() > 0 {
+			oldest = i
+		}
+		i++
+	}
+	if oldest < 0 {
+		return nil
+	}
+	// Rows written meanwhile go to the new group, which outlives the partitions cut off before the newest.
+	if err := s.wal.punctuate(); err != nil {
+		return err
+	}
+	for i := oldest; i < s.writablePartitionsNum(); i++ {
+		memPart := s.createMemoryPartition()
+		memPart.noWALGroup = i < s.writablePartitionsNum()-1
+		if err := s.newPartition(memPart, false); err != nil {
+			return err
+		}
+	}
+	return s.flushPartitions()
+}
@@ -0,0 +1,70 @@
+package tstorage
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBudget_resolve(t *testing.T) {
+	assert.Equal(t, int64(1024), MemoryBudgetBytes(1024).resolve())
+	assert.Equal(t, int64(0), MemoryBudget{}.resolve())
+}
+
+func Test_storage_InsertRows_memoryBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataPath bool
+	}{
+		{name: "in-memory mode refuses writes"},
+		{name: "on-disk mode flushes early", dataPath: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option[float64]{
+				WithTimestampPrecision[float64](Seconds),
+				WithMemoryBudget[float64](MemoryBudgetBytes(4 * metricBytes)),
+			}
+			if tt.dataPath {
+				tmpDir, err := os.MkdirTemp("", "tstorage-test")
+				require.NoError(t, err)
+				defer os.RemoveAll(tmpDir)
+				opts = append(opts, WithDataPath[float64](tmpDir))
+			}
+			s, err := NewStorage(opts...)
+			require.NoError(t, err)
+			defer s.Close()
+
+			insert := func(metric string) error {
+				return s.InsertRows([]Row[float64]{
+					{Metric: metric, DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+				})
+			}
+			require.NoError(t, insert("metric1"))
+			require.NoError(t, insert("metric2"))
+			require.NoError(t, insert("metric3"))
+			require.NoError(t, insert("metric4"))
+			if !tt.dataPath {
+				assert.ErrorIs(t, insert("metric5"), ErrLimitExceeded)
+				return
+			}
+			assert.Eventually(t, func() bool {
+				err := insert("metric5")
+				return err == nil || !errors.Is(err, ErrLimitExceeded)
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Less(t, s.(*storage[float64]).memoryUsage(), int64(4*metricBytes))
+
+			// Only the group for the new head partition is left in WAL.
+			wal := s.(*storage[float64]).wal.(*diskWAL[float64])
+			assert.Eventually(t, func() bool {
+				wal.mu.Lock()
+				defer wal.mu.Unlock()
+				return len(wal.groups) == 1
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
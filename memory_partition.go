@@ -13,6 +13,8 @@ import (
 type memoryPartition[T any] struct {
 	// The number of data points
 	numPoints int64
+	// The estimated bytes of heap used
+	numBytes int64
 	// minT is immutable.
 	minT int64
 	maxT int64
@@ -20,48 +22,39 @@ type memoryPartition[T any] struct {
 	// A hash map from metric name to memoryMetric.
 	metrics sync.Map
 
-	// Write ahead log.
-	wal wal[T]
+	// Series held by all in-memory partitions. Nil means not tracking series.
+	seriesIndex *seriesIndex
+	// Rejects data points whose timestamp is already taken in the series.
+	rejectDuplicates bool
+	// Counts rows inserted out of order, which is shared among partitions. Nil means not counting.
+	outOfOrderRows *uint64
+	// True if no WAL segment group was started for it, so that flushing it leaves WAL as is.
+	noWALGroup bool
 	// The timestamp range of partitions after which they get persisted
 	partitionDuration  int64
 	timestampPrecision TimestampPrecision
 	once               sync.Once
 }
 
-func newMemoryPartition[T any](wal wal[T], partitionDuration time.Duration, precision TimestampPrecision) partition[T] {
-	if wal == nil {
-		wal = &nopWAL[T]{}
-	}
-	var d int64
-	switch precision {
-	case Nanoseconds:
-		d = partitionDuration.Nanoseconds()
-	case Microseconds:
-		d = partitionDuration.Microseconds()
-	case Milliseconds:
-		d = partitionDuration.Milliseconds()
-	case Seconds:
-		d = int64(partitionDuration.Seconds())
-	default:
-		d = partitionDuration.Nanoseconds()
-	}
+func newMemoryPartition[T any](partitionDuration time.Duration, precision TimestampPrecision) partition[T] {
 	return &memoryPartition[T]{
-		partitionDuration:  d,
-		wal:                wal,
+		partitionDuration:  durationIn(partitionDuration, precision),
 		timestampPrecision: precision,
 	}
 }
 
 // insertRows inserts the given rows to partition.
 func (m *memoryPartition[T]) insertRows(rows []Row[T]) ([]Row[T], error) {
+	return m.insertSeriesRows(rows, nil)
+}
+
+// insertSeriesRows is the same as insertRows, except it takes the already identified series
+// for each row, in order to skip identifying them again. A nil series means unidentified.
+// The whole series can be nil as well.
+func (m *memoryPartition[T]) insertSeriesRows(rows []Row[T], series []*indexedSeries) ([]Row[T], error) {
 	if len(rows) == 0 {
 		return nil, fmt.Errorf("no rows given")
 	}
-	// FIXME: Just emitting log is enough
-	err := m.wal.append(operationInsert, rows)
-	if err != nil {
-		return nil, fmt.Errorf("failed to write to WAL: %w", err)
-	}
 
 	// Set min timestamp at only first.
 	m.once.Do(func() {
@@ -77,7 +70,8 @@ func (m *memoryPartition[T]) insertRows(rows []Row[T]) ([]Row[T], error) {
 
 	outdatedRows := make([]Row[T], 0)
 	maxTimestamp := rows[0].Timestamp
-	var rowsNum int64
+	var rowsNum, outOfOrderNum int64
+	var rejected map[int]error
 	for i := range rows {
 		row := rows[i]
 		if row.Timestamp < m.minTimestamp() {
@@ -90,18 +84,44 @@ func (m *memoryPartition[T]) insertRows(rows []Row[T]) ([]Row[T], error) {
 		if row.Timestamp > maxTimestamp {
 			maxTimestamp = row.Timestamp
 		}
-		name := marshalMetricName(row.Metric, row.Labels)
-		mt := m.getMetric(name)
-		mt.insertPoint(&row.DataPoint)
+		var mt *memoryMetric[T]
+		var err error
+		if series != nil && series[i] != nil {
+			mt, err = m.getCachedMetric(series[i])
+		} else {
+			name := marshalMetricName(row.Metric, row.Labels)
+			mt, err = m.getOrCreateMetric(name, row.Metric, row.Labels)
+		}
+		var outOfOrder bool
+		if err == nil {
+			outOfOrder, err = mt.insertPoint(&row.DataPoint, m.rejectDuplicates)
+		}
+		if err != nil {
+			if rejected == nil {
+				rejected = make(map[int]error)
+			}
+			rejected[i] = err
+			continue
+		}
 		rowsNum++
+		if outOfOrder {
+			outOfOrderNum++
+		}
 	}
 	atomic.AddInt64(&m.numPoints, rowsNum)
+	if m.outOfOrderRows != nil && outOfOrderNum > 0 {
+		atomic.AddUint64(m.outOfOrderRows, uint64(outOfOrderNum))
+	}
+	atomic.AddInt64(&m.numBytes, rowsNum*pointBytes)
 
 	// Make max timestamp up-to-date.
 	if atomic.LoadInt64(&m.maxT) < maxTimestamp {
 		atomic.SwapInt64(&m.maxT, maxTimestamp)
 	}
 
+	if len(rejected) > 0 {
+		return outdatedRows, &rejectedRowsError{errs: rejected}
+	}
 	return outdatedRows, nil
 }
 
@@ -120,25 +140,83 @@ func toUnix(t time.Time, precision TimestampPrecision) int64 {
 	}
 }
 
-func (m *memoryPartition[T]) selectDataPoints(metric string, labels []Label, start, end int64) ([]*DataPoint[T], error) {
-	name := marshalMetricName(metric, labels)
-	mt := m.getMetric(name)
-	return mt.selectPoints(start, end), nil
+// durationIn converts the given duration into the number of units in the given precision.
+func durationIn(d time.Duration, precision TimestampPrecision) int64 {
+	switch precision {
+	case Nanoseconds:
+		return d.Nanoseconds()
+	case Microseconds:
+		return d.Microseconds()
+	case Milliseconds:
+		return d.Milliseconds()
+	case Seconds:
+		return int64(d.Seconds())
+	default:
+		return d.Nanoseconds()
+	}
 }
 
-// getMetric gives back the reference to the metrics list whose name is the given one.
-// If none, it creates a new one.
-func (m *memoryPartition[T]) getMetric(name string) *memoryMetric[T] {
+func (m *memoryPartition[T]) selectDataPoints(metric string, labels []Label, start, end int64) ([]*DataPoint[T], error) {
+	name := marshalMetricName(metric, labels)
 	value, ok := m.metrics.Load(name)
 	if !ok {
-		value = &memoryMetric[T]{
-			name:             name,
-			points:           make([]*DataPoint[T], 0, 1000),
-			outOfOrderPoints: make([]*DataPoint[T], 0),
+		return []*DataPoint[T]{}, nil
+	}
+	return value.(*memoryMetric[T]).selectPoints(start, end), nil
+}
+
+func (m *memoryPartition[T]) metricNames() []string {
+	names := make([]string, 0)
+	m.metrics.Range(func(key, _ interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	return names
+}
+
+// getOrCreateMetric gives back the reference to the metrics list whose name is the given one.
+// If none, it creates a new one unless the series index refuses it.
+func (m *memoryPartition[T]) getOrCreateMetric(name, metric string, labels []Label) (*memoryMetric[T], error) {
+	if value, ok := m.metrics.Load(name); ok {
+		return value.(*memoryMetric[T]), nil
+	}
+	if m.seriesIndex != nil {
+		// Keep the series from being removed until the partition holds it.
+		s, err := m.seriesIndex.acquire(name, metric, labels)
+		if err != nil {
+			return nil, err
 		}
-		m.metrics.Store(name, value)
+		defer m.seriesIndex.release(s)
+	}
+	value, loaded := m.metrics.LoadOrStore(name, &memoryMetric[T]{
+		name:             name,
+		points:           make([]*DataPoint[T], 0, 1000),
+		outOfOrderPoints: make([]*DataPoint[T], 0),
+	})
+	if !loaded {
+		atomic.AddInt64(&m.numBytes, int64(metricBytes+len(name)))
+	}
+	return value.(*memoryMetric[T]), nil
+}
+
+// getCachedMetric is the same as getOrCreateMetric, except it tries the memoryMetric cached in the
+// given series first, and caches the one it gives back.
+func (m *memoryPartition[T]) getCachedMetric(series *indexedSeries) (*memoryMetric[T], error) {
+	if c, ok := series.cache.Load().(*cachedMetric[T]); ok && c.partition == m {
+		return c.metric, nil
+	}
+	mt, err := m.getOrCreateMetric(series.name, series.metric, series.labels)
+	if err != nil {
+		return nil, err
 	}
-	return value.(*memoryMetric[T])
+	series.cache.Store(&cachedMetric[T]{partition: m, metric: mt})
+	return mt, nil
+}
+
+// hasMetric reports whether it holds the metric whose name is the given one.
+func (m *memoryPartition[T]) hasMetric(name string) bool {
+	_, ok := m.metrics.Load(name)
+	return ok
 }
 
 func (m *memoryPartition[T]) minTimestamp() int64 {
@@ -153,6 +231,11 @@ func (m *memoryPartition[T]) size() int {
 	return int(atomic.LoadInt64(&m.numPoints))
 }
 
+// heapBytes gives back the estimated bytes of heap it uses.
+func (m *memoryPartition[T]) heapBytes() int64 {
+	return atomic.LoadInt64(&m.numBytes)
+}
+
 func (m *memoryPartition[T]) active() bool {
 	return m.maxTimestamp()-m.minTimestamp()+1 < m.partitionDuration
 }
@@ -176,10 +259,14 @@ type memoryMetric[T any] struct {
 	// points must kept in order
 	points           []*DataPoint[T]
 	outOfOrderPoints []*DataPoint[T]
-	mu               sync.RWMutex
+	// Timestamps of outOfOrderPoints, which is only populated while rejecting duplicates.
+	outOfOrderTimestamps map[int64]struct{}
+	mu                   sync.RWMutex
 }
 
-func (m *memoryMetric[T]) insertPoint(point *DataPoint[T]) {
+// insertPoint reports whether the point isn't newer than the ones it holds.
+// If rejectDuplicates is set, it gives back ErrDuplicatePoint when a data point with the same timestamp already exists.
+func (m *memoryMetric[T]) insertPoint(point *DataPoint[T], rejectDuplicates bool) (outOfOrder bool, err error) {
 	size := atomic.LoadInt64(&m.size)
 	// TODO: Consider to stop using mutex every time.
 	//   Instead, fix the capacity of points slice, kind of like:
@@ -198,17 +285,40 @@ func (m *memoryMetric[T]) insertPoint(point *DataPoint[T]) {
 		atomic.StoreInt64(&m.minTimestamp, point.Timestamp)
 		atomic.StoreInt64(&m.maxTimestamp, point.Timestamp)
 		atomic.AddInt64(&m.size, 1)
-		return
+		return false, nil
 	}
 	// Insert point in order
 	if m.points[size-1].Timestamp < point.Timestamp {
 		m.points = append(m.points, point)
 		atomic.StoreInt64(&m.maxTimestamp, point.Timestamp)
 		atomic.AddInt64(&m.size, 1)
-		return
+		return false, nil
 	}
 
+	if rejectDuplicates {
+		if m.contains(point.Timestamp) {
+			return false, fmt.Errorf("timestamp %d in metric %q: %w", point.Timestamp, m.name, ErrDuplicatePoint)
+		}
+		if m.outOfOrderTimestamps == nil {
+			m.outOfOrderTimestamps = make(map[int64]struct{})
+		}
+		m.outOfOrderTimestamps[point.Timestamp] = struct{}{}
+	}
 	m.outOfOrderPoints = append(m.outOfOrderPoints, point)
+	return true, nil
+}
+
+// contains reports whether a data point with the given timestamp exists.
+// The caller must hold the lock, and keep outOfOrderTimestamps populated.
+func (m *memoryMetric[T]) contains(timestamp int64) bool {
+	i := sort.Search(len(m.points), func(i int) bool {
+		return m.points[i].Timestamp >= timestamp
+	})
+	if i < len(m.points) && m.points[i].Timestamp == timestamp {
+		return true
+	}
+	_, ok := m.outOfOrderTimestamps[timestamp]
+	return ok
 }
 
 // selectPoints returns a new slice by re-slicing with [startIdx:endIdx].
@@ -20,7 +20,7 @@ func Test_memoryPartition_InsertRows(t *testing.T) {
 	}{
 		{
 			name:            "insert in-order rows",
-			memoryPartition: newMemoryPartition[float64](nil, 0, "").(*memoryPartition[float64]),
+			memoryPartition: newMemoryPartition[float64](0, "").(*memoryPartition[float64]),
 			rows: []Row[float64]{
 				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1, Value: 0.1}},
 				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 2, Value: 0.1}},
@@ -36,7 +36,7 @@ func Test_memoryPartition_InsertRows(t *testing.T) {
 		{
 			name: "insert out-of-order rows",
 			memoryPartition: func() *memoryPartition[float64] {
-				m := newMemoryPartition[float64](nil, 0, "").(*memoryPartition[float64])
+				m := newMemoryPartition[float64](0, "").(*memoryPartition[float64])
 				m.insertRows([]Row[float64]{
 					{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 2, Value: 0.1}},
 				})
@@ -80,7 +80,7 @@ func Test_memoryPartition_SelectDataPoints(t *testing.T) {
 			metric:          "unknown",
 			start:           1,
 			end:             2,
-			memoryPartition: newMemoryPartition[float64](nil, 0, "").(*memoryPartition[float64]),
+			memoryPartition: newMemoryPartition[float64](0, "").(*memoryPartition[float64]),
 			want:            []*DataPoint[float64]{},
 		},
 		{
@@ -89,7 +89,7 @@ func Test_memoryPartition_SelectDataPoints(t *testing.T) {
 			start:  2,
 			end:    4,
 			memoryPartition: func() *memoryPartition[float64] {
-				m := newMemoryPartition[float64](nil, 0, "").(*memoryPartition[float64])
+				m := newMemoryPartition[float64](0, "").(*memoryPartition[float64])
 				m.insertRows([]Row[float64]{
 					{
 						Metric:    "metric1",
@@ -125,7 +125,7 @@ func Test_memoryPartition_SelectDataPoints(t *testing.T) {
 			start:  1,
 			end:    4,
 			memoryPartition: func() *memoryPartition[float64] {
-				m := newMemoryPartition[float64](nil, 0, "").(*memoryPartition[float64])
+				m := newMemoryPartition[float64](0, "").(*memoryPartition[float64])
 				m.insertRows([]Row[float64]{
 					{
 						Metric:    "metric1",
@@ -232,3 +232,38 @@ func Test_toUnix(t *testing.T) {
 		})
 	}
 }
+
+func Test_memoryPartition_InsertRows_duplicate(t *testing.T) {
+	m := newMemoryPartition[float64](0, "").(*memoryPartition[float64])
+	m.rejectDuplicates = true
+	_, err := m.insertRows([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1, Value: 0.1}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 3, Value: 0.1}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 2, Value: 0.1}},
+	})
+	require.NoError(t, err)
+
+	_, err = m.insertRows([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 3, Value: 0.2}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 4, Value: 0.2}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 2, Value: 0.2}},
+	})
+	var rejectedErr *rejectedRowsError
+	require.ErrorAs(t, err, &rejectedErr)
+	assert.Len(t, rejectedErr.errs, 2)
+	assert.ErrorIs(t, rejectedErr.errs[0], ErrDuplicatePoint)
+	assert.ErrorIs(t, rejectedErr.errs[2], ErrDuplicatePoint)
+	assert.Equal(t, 4, m.size())
+}
+
+func Test_memoryPartition_InsertRows_keepDuplicates(t *testing.T) {
+	m := newMemoryPartition[float64](0, "").(*memoryPartition[float64])
+	_, err := m.insertRows([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1, Value: 0.1}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 2, Value: 0.1}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 2, Value: 0.2}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1, Value: 0.2}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, m.size())
+}
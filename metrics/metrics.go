@@ -0,0 +1,463 @@
+// Package metrics exposes the internals of storages in the Prometheus text exposition format,
+// so that embedded storages can be monitored by anything scraping it.
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+//
+// A Collector can serve multiple storages at once, each of which is told apart by the labels given
+// on registration:
+//
+//	c := metrics.NewCollector()
+//	c.Register(storage1, tstorage.Label{Name: "instance", Value: "1"})
+//	c.Register(storage2, tstorage.Label{Name: "instance", Value: "2"})
+//	http.Handle("/metrics", c)
+//
+// Durations are exposed in seconds, and timestamps of data points in the precision of each storage.
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Label names the collector puts by itself, which can't be given on registration.
+var reservedLabelNames = map[string]struct{}{
+	"type": {},
+	"le":   {},
+}
+
+// Source is what metrics are collected from. Any tstorage.Storage satisfies it.
+// Both methods are called on every collection, so they should be cheap.
+type Source interface {
+	Counters() tstorage.Counters
+	WriterStats() tstorage.WriterStats
+}
+
+// Option is an optional setting for the collector.
+type Option func(*options)
+
+type options struct {
+	namespace string
+}
+
+// WithNamespace specifies the prefix of metric names, which is joined to them with an underscore.
+// Giving an empty string means no prefix.
+//
+// Defaults to "tstorage".
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// Collector collects metrics from registered sources. It's goroutine safe, and serves them over HTTP.
+type Collector struct {
+	namespace string
+
+	mu      sync.RWMutex
+	sources []source
+}
+
+type source struct {
+	src    Source
+	labels []tstorage.Label
+}
+
+// NewCollector gives back a collector with no sources.
+func NewCollector(opts ...Option) *Collector {
+	o := &options{
+		namespace: "tstorage",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Collector{namespace: o.namespace}
+}
+
+// NewHandler gives back a handler serving the metrics of the given storage alone.
+func NewHandler(s Source, opts ...Option) http.Handler {
+	c := NewCollector(opts...)
+	// Registering the only source never fails.
+	_ = c.Register(s)
+	return c
+}
+
+// Register makes the collector collect metrics from the given source, which get the given labels attached.
+// It gives back an error if labels are invalid, or any source is already registered with the same labels.
+func (c *Collector) Register(s Source, labels ...tstorage.Label) error {
+	names := make(map[string]struct{}, len(labels))
+	for _, l := range labels {
+		if !labelNameRegex.MatchString(l.Name) {
+			return fmt.Errorf("invalid label name %q", l.Name)
+		}
+		if _, ok := reservedLabelNames[l.Name]; ok {
+			return fmt.Errorf("label name %q is reserved", l.Name)
+		}
+		if _, ok := names[l.Name]; ok {
+			return fmt.Errorf("duplicate label name %q", l.Name)
+		}
+		names[l.Name] = struct{}{}
+	}
+	labels = append([]tstorage.Label(nil), labels...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, registered := range c.sources {
+		if sameLabels(registered.labels, labels) {
+			return errors.New("a source with the same labels is already registered")
+		}
+	}
+	c.sources = append(c.sources, source{src: s, labels: labels})
+	return nil
+}
+
+// Unregister stops collecting metrics from the given source. It reports whether the source was registered.
+func (c *Collector) Unregister(s Source) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, registered := range c.sources {
+		if registered.src == s {
+			c.sources = append(c.sources[:i], c.sources[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// sameLabels reports whether both have the same set of labels regardless of the order.
+func sameLabels(a, b []tstorage.Label) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	values := make(map[string]string, len(a))
+	for _, l := range a {
+		values[l.Name] = l.Value
+	}
+	for _, l := range b {
+		if v, ok := values[l.Name]; !ok || v != l.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP writes the metrics of all sources in the text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	if _, err := c.WriteTo(&buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
+}
+
+// WriteTo writes the metrics of all sources in the text exposition format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.RLock()
+	snapshots := make([]snapshot, 0, len(c.sources))
+	for _, s := range c.sources {
+		snapshots = append(snapshots, snapshot{
+			labels:   s.labels,
+			counters: s.src.Counters(),
+			writer:   s.src.WriterStats(),
+		})
+	}
+	c.mu.RUnlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metricList {
+		name := m.name
+		if c.namespace != "" {
+			name = c.namespace + "_" + name
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", name, helpEscaper.Replace(m.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, m.typ)
+		for i := range snapshots {
+			for _, v := range m.values(&snapshots[i]) {
+				labels := append(append([]tstorage.Label(nil), snapshots[i].labels...), v.labels...)
+				if m.typ == typeHistogram {
+					writeHistogram(bw, name, labels, v.histogram)
+					continue
+				}
+				writeSample(bw, name, labels, v.value)
+			}
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type snapshot struct {
+	labels   []tstorage.Label
+	counters tstorage.Counters
+	writer   tstorage.WriterStats
+}
+
+const (
+	typeGauge     = "gauge"
+	typeCounter   = "counter"
+	typeHistogram = "histogram"
+)
+
+type metric struct {
+	name string
+	help string
+	typ  string
+	// values gives back the values of the metric for a source, each with its own labels.
+	values func(s *snapshot) []value
+}
+
+type value struct {
+	labels []tstorage.Label
+	// Set for histograms only.
+	histogram tstorage.Histogram
+	value     float64
+}
+
+func single(v float64) []value {
+	return []value{{value: v}}
+}
+
+func byType(memory, disk value) []value {
+	memory.labels = []tstorage.Label{{Name: "type", Value: "memory"}}
+	disk.labels = []tstorage.Label{{Name: "type", Value: "disk"}}
+	return []value{memory, disk}
+}
+
+// metricList is the list of metrics exposed for each source, in the order they are written.
+var metricList = []metric{
+	{
+		name:   "inserted_rows_total",
+		help:   "Total number of rows ingested.",
+		typ:    typeCounter,
+		values: func(s *snapshot) []value { return single(float64(s.counters.InsertedRows)) },
+	},
+	{
+		name:   "insert_duration_seconds",
+		help:   "How long each insertion took, including waiting for other writers.",
+		typ:    typeHistogram,
+		values: func(s *snapshot) []value { return []value{{histogram: s.counters.InsertDuration}} },
+	},
+	{
+		name:   "out_of_bounds_rows_total",
+		help:   "Total number of rows dropped for being older than the out-of-order window.",
+		typ:    typeCounter,
+		values: func(s *snapshot) []value { return single(float64(s.counters.OutOfBoundsRows)) },
+	},
+	{
+		name:   "out_of_order_rows_total",
+		help:   "Total number of rows ingested with a timestamp older than the newest one of their series in the partition.",
+		typ:    typeCounter,
+		values: func(s *snapshot) []value { return single(float64(s.counters.OutOfOrderRows)) },
+	},
+	{
+		name:   "writers_max",
+		help:   "Maximum number of concurrent writers.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.writer.MaxConcurrentWriters)) },
+	},
+	{
+		name:   "writers_active",
+		help:   "Number of writers currently ingesting.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.writer.ActiveWriters)) },
+	},
+	{
+		name:   "write_queue_depth",
+		help:   "Number of writers waiting for others to finish.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.writer.QueueDepth)) },
+	},
+	{
+		name:   "write_timeouts_total",
+		help:   "Total number of writes that gave up waiting for other writers.",
+		typ:    typeCounter,
+		values: func(s *snapshot) []value { return single(float64(s.writer.TimedOutWrites)) },
+	},
+	{
+		name:   "write_wait_duration_seconds",
+		help:   "How long writers waited for others before starting to ingest.",
+		typ:    typeHistogram,
+		values: func(s *snapshot) []value { return []value{{histogram: s.writer.WaitDuration}} },
+	},
+	{
+		name:   "wal_written_bytes_total",
+		help:   "Total bytes of records written to WAL since opening.",
+		typ:    typeCounter,
+		values: func(s *snapshot) []value { return single(float64(s.counters.WALBytesWritten)) },
+	},
+	{
+		name:   "wal_fsync_duration_seconds",
+		help:   "How long each fsync of WAL took.",
+		typ:    typeHistogram,
+		values: func(s *snapshot) []value { return []value{{histogram: s.counters.WALSyncDuration}} },
+	},
+	{
+		name:   "wal_size_bytes",
+		help:   "Total size of WAL segments on disk.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.counters.WALSize)) },
+	},
+	{
+		name:   "wal_segments",
+		help:   "Number of WAL segments on disk.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.counters.WALSegments)) },
+	},
+	{
+		name: "partitions",
+		help: "Number of partitions by type.",
+		typ:  typeGauge,
+		values: func(s *snapshot) []value {
+			return byType(value{value: float64(s.counters.MemoryPartitions)}, value{value: float64(s.counters.DiskPartitions)})
+		},
+	},
+	{
+		name:   "head_min_timestamp",
+		help:   "The oldest timestamp held by the head partition, which is 0 if it holds none.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.counters.HeadMinTimestamp)) },
+	},
+	{
+		name:   "head_max_timestamp",
+		help:   "The newest timestamp held by the head partition, which is 0 if it holds none.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.counters.HeadMaxTimestamp)) },
+	},
+	{
+		name:   "data_points",
+		help:   "Number of data points across all partitions.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.counters.NumDataPoints)) },
+	},
+	{
+		name:   "memory_series",
+		help:   "Number of series held in memory.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.counters.MemorySeries)) },
+	},
+	{
+		name:   "mapped_bytes",
+		help:   "Total size of data files of disk partitions mapped into memory.",
+		typ:    typeGauge,
+		values: func(s *snapshot) []value { return single(float64(s.counters.MappedBytes)) },
+	},
+	{
+		name: "select_duration_seconds",
+		help: "How long selecting data points from each partition took, by the type of it.",
+		typ:  typeHistogram,
+		values: func(s *snapshot) []value {
+			return byType(value{histogram: s.counters.MemorySelectDuration}, value{histogram: s.counters.DiskSelectDuration})
+		},
+	},
+	{
+		name:   "flush_duration_seconds",
+		help:   "How long flushing each in-memory partition into a disk partition took.",
+		typ:    typeHistogram,
+		values: func(s *snapshot) []value { return []value{{histogram: s.counters.FlushDuration}} },
+	},
+	{
+		name: "last_flush_error_timestamp_seconds",
+		help: "Unix time when flushing partitions failed last, which is 0 if it has never failed.",
+		typ:  typeGauge,
+		values: func(s *snapshot) []value {
+			if s.counters.LastFlushError == nil {
+				return single(0)
+			}
+			return single(float64(s.counters.LastFlushErrorTime.UnixNano()) / 1e9)
+		},
+	},
+}
+
+func writeSample(w *bufio.Writer, name string, labels []tstorage.Label, v float64) {
+	w.WriteString(name)
+	writeLabels(w, labels)
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+// writeHistogram writes the histogram with cumulative buckets, its sum and its count.
+// Histograms that have never been observed are written without buckets except +Inf.
+func writeHistogram(w *bufio.Writer, name string, labels []tstorage.Label, h tstorage.Histogram) {
+	le := len(labels)
+	labels = append(labels, tstorage.Label{Name: "le"})
+	hasInf := false
+	for _, b := range h.Buckets {
+		if b.UpperBound == time.Duration(math.MaxInt64) {
+			labels[le].Value = "+Inf"
+			hasInf = true
+		} else {
+			labels[le].Value = formatFloat(b.UpperBound.Seconds())
+		}
+		writeSample(w, name+"_bucket", labels, float64(b.Count))
+	}
+	if !hasInf {
+		labels[le].Value = "+Inf"
+		writeSample(w, name+"_bucket", labels, float64(h.Count))
+	}
+	writeSample(w, name+"_sum", labels[:le], h.Sum.Seconds())
+	writeSample(w, name+"_count", labels[:le], float64(h.Count))
+}
+
+func writeLabels(w *bufio.Writer, labels []tstorage.Label) {
+	if len(labels) == 0 {
+		return
+	}
+	w.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(l.Name)
+		w.WriteString(`="`)
+		w.WriteString(labelValueEscaper.Replace(l.Value))
+		w.WriteByte('"')
+	}
+	w.WriteByte('}')
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
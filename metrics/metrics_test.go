@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+type fakeSource struct {
+	counters tstorage.Counters
+	writer   tstorage.WriterStats
+}
+
+func (f *fakeSource) Counters() tstorage.Counters       { return f.counters }
+func (f *fakeSource) WriterStats() tstorage.WriterStats { return f.writer }
+
+func TestCollector_WriteTo(t *testing.T) {
+	src := &fakeSource{
+		counters: tstorage.Counters{
+			MemoryPartitions: 2,
+			DiskPartitions:   3,
+			InsertedRows:     10,
+			InsertDuration: tstorage.Histogram{
+				Buckets: []tstorage.HistogramBucket{
+					{UpperBound: time.Millisecond, Count: 1},
+					{UpperBound: time.Second, Count: 3},
+					{UpperBound: time.Duration(math.MaxInt64), Count: 4},
+				},
+				Count: 4,
+				Sum:   2500 * time.Millisecond,
+			},
+			LastFlushError:     errors.New("error"),
+			LastFlushErrorTime: time.Unix(1600000000, 500000000),
+		},
+		writer: tstorage.WriterStats{MaxConcurrentWriters: 8},
+	}
+	c := NewCollector()
+	require.NoError(t, c.Register(src, tstorage.Label{Name: "instance", Value: `a"b`}))
+	require.NoError(t, c.Register(&fakeSource{}, tstorage.Label{Name: "instance", Value: "c"}))
+
+	var buf bytes.Buffer
+	n, err := c.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	got := buf.String()
+	for _, want := range []string{
+		"# HELP tstorage_inserted_rows_total Total number of rows ingested.\n" +
+			"# TYPE tstorage_inserted_rows_total counter\n" +
+			`tstorage_inserted_rows_total{instance="a\"b"} 10` + "\n" +
+			`tstorage_inserted_rows_total{instance="c"} 0` + "\n",
+		"# TYPE tstorage_insert_duration_seconds histogram\n" +
+			`tstorage_insert_duration_seconds_bucket{instance="a\"b",le="0.001"} 1` + "\n" +
+			`tstorage_insert_duration_seconds_bucket{instance="a\"b",le="1"} 3` + "\n" +
+			`tstorage_insert_duration_seconds_bucket{instance="a\"b",le="+Inf"} 4` + "\n" +
+			`tstorage_insert_duration_seconds_sum{instance="a\"b"} 2.5` + "\n" +
+			`tstorage_insert_duration_seconds_count{instance="a\"b"} 4` + "\n" +
+			`tstorage_insert_duration_seconds_bucket{instance="c",le="+Inf"} 0` + "\n" +
+			`tstorage_insert_duration_seconds_sum{instance="c"} 0` + "\n" +
+			`tstorage_insert_duration_seconds_count{instance="c"} 0` + "\n",
+		`tstorage_partitions{instance="a\"b",type="memory"} 2` + "\n" +
+			`tstorage_partitions{instance="a\"b",type="disk"} 3` + "\n",
+		`tstorage_writers_max{instance="a\"b"} 8` + "\n",
+		`tstorage_last_flush_error_timestamp_seconds{instance="a\"b"} 1.6000000005e+09` + "\n" +
+			`tstorage_last_flush_error_timestamp_seconds{instance="c"} 0` + "\n",
+	} {
+		assert.Contains(t, got, want)
+	}
+	// Each metric family appears once.
+	assert.Equal(t, len(metricList), strings.Count(got, "# TYPE "))
+
+	require.True(t, c.Unregister(src))
+	assert.False(t, c.Unregister(src))
+	buf.Reset()
+	_, err = c.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), `instance="a\"b"`)
+}
+
+func TestCollector_Register(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []tstorage.Label
+	}{
+		{name: "invalid label name", labels: []tstorage.Label{{Name: "0instance", Value: "a"}}},
+		{name: "reserved label name", labels: []tstorage.Label{{Name: "le", Value: "a"}}},
+		{name: "duplicate label name", labels: []tstorage.Label{{Name: "instance", Value: "a"}, {Name: "instance", Value: "b"}}},
+		{name: "same labels as registered", labels: []tstorage.Label{{Name: "region", Value: "us"}, {Name: "instance", Value: "a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollector()
+			require.NoError(t, c.Register(&fakeSource{}, tstorage.Label{Name: "instance", Value: "a"}, tstorage.Label{Name: "region", Value: "us"}))
+			assert.Error(t, c.Register(&fakeSource{}, tt.labels...))
+		})
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	storage, err := tstorage.NewStorage(
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	require.NoError(t, err)
+	defer storage.Close()
+	require.NoError(t, storage.InsertRows([]tstorage.Row[float64]{
+		{Metric: "metric1", DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000001}},
+		{Metric: "metric1", DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000000}},
+	}))
+
+	server := httptest.NewServer(NewHandler(storage, WithNamespace("app")))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+	got := string(b)
+	assert.Contains(t, got, "\napp_inserted_rows_total 2\n")
+	assert.Contains(t, got, "\napp_out_of_order_rows_total 1\n")
+	assert.Contains(t, got, "\napp_insert_duration_seconds_count 1\n")
+	assert.Contains(t, got, "\napp_memory_series 1\n")
+	assert.Contains(t, got, "\napp_partitions{type=\"memory\"} 1\n")
+	assert.Contains(t, got, "\napp_data_points 2\n")
+}
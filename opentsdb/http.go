@@ -0,0 +1,171 @@
+package opentsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/httputil"
+)
+
+// putRequest is a data point in the body of /api/put.
+type putRequest struct {
+	Metric    string            `json:"metric"`
+	Timestamp json.Number       `json:"timestamp"`
+	Value     json.Number       `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type putError struct {
+	Datapoint json.RawMessage `json:"datapoint"`
+	Error     string          `json:"error"`
+}
+
+type putSummary struct {
+	Failed  int         `json:"failed"`
+	Success int         `json:"success"`
+	Errors  *[]putError `json:"errors,omitempty"`
+}
+
+// NewPutHandler gives back a handler that serves the OpenTSDB /api/put endpoint, which takes either
+// a single data point or an array of them in JSON. Invalid data points are responded with 400
+// while the rest are still ingested. Gzip-compressed bodies are accepted with "Content-Encoding: gzip".
+//
+// Like OpenTSDB, it responds with 204 on success, or with a summary in 200 if the "summary" or
+// the "details" query parameter is given. The latter lists the errors as well.
+func NewPutHandler[T any](s tstorage.Storage[T], opts ...Option) http.Handler {
+	o := newOptions(opts)
+	precision := s.TimestampPrecision()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		body, status, err := httputil.ReadBody(r, o.maxRequestSize)
+		if err != nil {
+			writeError(w, status, err.Error())
+			return
+		}
+		raws, err := splitDatapoints(body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Unable to parse the given JSON: %v", err))
+			return
+		}
+
+		var errs []putError
+		rows := make([]tstorage.Row[T], 0, len(raws))
+		// The raw data points corresponding to rows.
+		sources := make([]json.RawMessage, 0, len(raws))
+		for _, raw := range raws {
+			p, err := decodeDatapoint(raw)
+			if err != nil {
+				errs = append(errs, putError{Datapoint: raw, Error: err.Error()})
+				continue
+			}
+			row, err := toRow[T](&p, precision)
+			if err != nil {
+				errs = append(errs, putError{Datapoint: raw, Error: err.Error()})
+				continue
+			}
+			rows = append(rows, row)
+			sources = append(sources, raw)
+		}
+		for start := 0; start < len(rows); start += o.BatchSize {
+			end := start + o.BatchSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+			res, err := s.InsertRowsDetailed(rows[start:end])
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			for _, i := range res.Rejected() {
+				errs = append(errs, putError{Datapoint: sources[start+i], Error: res.Errors[i].Error()})
+			}
+		}
+
+		status = http.StatusNoContent
+		if len(errs) > 0 {
+			status = http.StatusBadRequest
+		}
+		q := r.URL.Query()
+		_, details := q["details"]
+		_, summary := q["summary"]
+		if !details && !summary {
+			if len(errs) > 0 {
+				writeError(w, status, "One or more data points had errors")
+				return
+			}
+			w.WriteHeader(status)
+			return
+		}
+		if status == http.StatusNoContent {
+			status = http.StatusOK
+		}
+		resp := putSummary{Failed: len(errs), Success: len(raws) - len(errs)}
+		if details {
+			if errs == nil {
+				errs = []putError{}
+			}
+			resp.Errors = &errs
+		}
+		writeJSON(w, status, resp)
+	})
+}
+
+// splitDatapoints gives back the data points in the body being either an object or an array.
+func splitDatapoints(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, errors.New("empty body")
+	}
+	if body[0] != '[' {
+		return []json.RawMessage{body}, nil
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(body, &raws); err != nil {
+		return nil, err
+	}
+	return raws, nil
+}
+
+func decodeDatapoint(raw json.RawMessage) (Point, error) {
+	var req putRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return Point{}, fmt.Errorf("invalid data point: %w", err)
+	}
+	if req.Timestamp == "" {
+		return Point{}, errors.New("missing timestamp")
+	}
+	if req.Value == "" {
+		return Point{}, errors.New("missing value")
+	}
+	ts, err := parseTimestamp(req.Timestamp.String())
+	if err != nil {
+		return Point{}, err
+	}
+	value, err := parseValue(req.Value.String())
+	if err != nil {
+		return Point{}, err
+	}
+	return newPoint(req.Metric, ts, value, req.Tags)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": msg,
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,123 @@
+package opentsdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func put(t *testing.T, url, body string, header http.Header) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(b)
+}
+
+func TestPutHandler(t *testing.T) {
+	storage := newTestStorage(t)
+	server := httptest.NewServer(NewPutHandler(storage, WithBatchSize(1)))
+	defer server.Close()
+
+	status, _ := put(t, server.URL, `{"metric":"sys.cpu.nice","timestamp":1600000000,"value":18,"tags":{"host":"web01"}}`, nil)
+	require.Equal(t, http.StatusNoContent, status)
+
+	body := `[
+		{"metric":"sys.cpu.nice","timestamp":1600000001000,"value":"19","tags":{"host":"web01"}},
+		{"metric":"sys.cpu.nice","timestamp":1600000000,"value":18,"tags":{"host":"web01"}},
+		{"metric":"sys.cpu.nice","timestamp":1600000002,"value":"abc","tags":{"host":"web01"}}
+	]`
+	status, resp := put(t, server.URL+"?details", body, nil)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, resp, `"failed":2,"success":1`)
+	assert.Contains(t, resp, `invalid data point`)
+	assert.Contains(t, resp, `duplicate`)
+
+	points, err := storage.Select("sys.cpu.nice", []tstorage.Label{{Name: "host", Value: "web01"}}, 1600000000000, 1600000002000)
+	require.NoError(t, err)
+	assert.Equal(t, []*tstorage.DataPoint[float64]{
+		{Timestamp: 1600000000000, Value: 18},
+		{Timestamp: 1600000001000, Value: 19},
+	}, points)
+
+	status, resp = put(t, server.URL+"?summary", `[{"metric":"sys.cpu.idle","timestamp":1600000000,"value":1}]`, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"failed":0,"success":1}`, resp)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err = gz.Write([]byte(`{"metric":"sys.cpu.idle","timestamp":1600000001,"value":2}`))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	status, _ = put(t, server.URL, buf.String(), http.Header{"Content-Encoding": []string{"gzip"}})
+	assert.Equal(t, http.StatusNoContent, status)
+}
+
+func TestPutHandler_overflowingTimestamp(t *testing.T) {
+	storage, err := tstorage.NewStorage[float64]()
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	server := httptest.NewServer(NewPutHandler(storage))
+	defer server.Close()
+
+	body := `[
+		{"metric":"sys.cpu.nice","timestamp":9999999999999,"value":1},
+		{"metric":"sys.cpu.nice","timestamp":1600000000,"value":2}
+	]`
+	status, resp := put(t, server.URL+"?details", body, nil)
+	require.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, resp, `"failed":1,"success":1`)
+	assert.Contains(t, resp, `invalid timestamp 9999999999999`)
+
+	points, err := storage.Select("sys.cpu.nice", nil, 0, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Equal(t, []*tstorage.DataPoint[float64]{{Timestamp: 1600000000000000000, Value: 2}}, points)
+}
+
+func TestPutHandler_invalidRequests(t *testing.T) {
+	storage := newTestStorage(t)
+	server := httptest.NewServer(NewPutHandler(storage, WithMaxRequestSize(64)))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		body       string
+		header     http.Header
+		wantStatus int
+	}{
+		{name: "empty", body: "", wantStatus: http.StatusBadRequest},
+		{name: "malformed json", body: "[{", wantStatus: http.StatusBadRequest},
+		{name: "missing timestamp", body: `{"metric":"a","value":1}`, wantStatus: http.StatusBadRequest},
+		{name: "missing metric", body: `{"timestamp":1,"value":1}`, wantStatus: http.StatusBadRequest},
+		{name: "too large", body: strings.Repeat(" ", 65), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "not gzip", body: `{"metric":"a","timestamp":1,"value":1}`, header: http.Header{"Content-Encoding": []string{"gzip"}}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := put(t, server.URL, tt.body, tt.header)
+			assert.Equal(t, tt.wantStatus, status)
+		})
+	}
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,125 @@
+// Package opentsdb implements ingestion of OpenTSDB data points into tstorage,
+// via both the telnet-style "put" command and the HTTP /api/put endpoint.
+// See http://opentsdb.net/docs/build/html/user_guide/writing/index.html
+package opentsdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/timeutil"
+)
+
+// Point is a single data point of OpenTSDB.
+type Point struct {
+	Metric string
+	Tags   []tstorage.Label
+	Value  float64
+	// Unix time in milliseconds.
+	Timestamp int64
+}
+
+// ParsePut parses the arguments of the put command, formatted as shown below:
+//
+//	put <metric> <timestamp> <value> <tagk1=tagv1[ tagk2=tagv2 ...tagkN=tagvN]>
+//
+// The leading "put" can be omitted.
+func ParsePut(line []byte) (Point, error) {
+	fields := strings.Fields(string(line))
+	if len(fields) > 0 && fields[0] == "put" {
+		fields = fields[1:]
+	}
+	if len(fields) < 3 {
+		return Point{}, fmt.Errorf("not enough arguments (need at least 3, got %d)", len(fields))
+	}
+	ts, err := parseTimestamp(fields[1])
+	if err != nil {
+		return Point{}, err
+	}
+	value, err := parseValue(fields[2])
+	if err != nil {
+		return Point{}, err
+	}
+	tags := make(map[string]string, len(fields)-3)
+	for _, f := range fields[3:] {
+		name, v, ok := strings.Cut(f, "=")
+		if !ok || name == "" || v == "" {
+			return Point{}, fmt.Errorf("invalid tag: %s", f)
+		}
+		tags[name] = v
+	}
+	return newPoint(fields[0], ts, value, tags)
+}
+
+func newPoint(metric string, ts int64, value float64, tags map[string]string) (Point, error) {
+	if metric == "" {
+		return Point{}, errors.New("empty metric name")
+	}
+	p := Point{
+		Metric:    metric,
+		Tags:      make([]tstorage.Label, 0, len(tags)),
+		Value:     value,
+		Timestamp: ts,
+	}
+	for name, v := range tags {
+		p.Tags = append(p.Tags, tstorage.Label{Name: name, Value: v})
+	}
+	sort.Slice(p.Tags, func(i, j int) bool {
+		return p.Tags[i].Name < p.Tags[j].Name
+	})
+	return p, nil
+}
+
+// parseTimestamp parses the timestamp in either seconds or milliseconds, and gives back the one in milliseconds.
+// Like OpenTSDB, timestamps not fitting in 32 bits are regarded as in milliseconds.
+// Milliseconds can also be given as the fraction of seconds, such as "1600000000.123".
+func parseTimestamp(s string) (int64, error) {
+	if sec, frac, ok := strings.Cut(s, "."); ok {
+		if len(frac) == 0 || len(frac) > 3 {
+			return 0, fmt.Errorf("invalid timestamp: %s", s)
+		}
+		ms, err := strconv.ParseInt(sec+frac+strings.Repeat("0", 3-len(frac)), 10, 64)
+		if err != nil || ms < 0 {
+			return 0, fmt.Errorf("invalid timestamp: %s", s)
+		}
+		return ms, nil
+	}
+	ts, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || ts < 0 {
+		return 0, fmt.Errorf("invalid timestamp: %s", s)
+	}
+	if ts&^0xffffffff != 0 {
+		return ts, nil
+	}
+	return ts * 1e3, nil
+}
+
+func parseValue(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid value: %s", s)
+	}
+	return v, nil
+}
+
+// toRow converts the point into a row, failing if its timestamp doesn't fit in the given precision.
+func toRow[T any](p *Point, precision tstorage.TimestampPrecision) (tstorage.Row[T], error) {
+	ts, err := timeutil.Convert(p.Timestamp, time.Millisecond, timeutil.Unit(precision))
+	if err != nil {
+		return tstorage.Row[T]{}, fmt.Errorf("invalid timestamp %d: %w", p.Timestamp, err)
+	}
+	return tstorage.Row[T]{
+		Metric: p.Metric,
+		Labels: p.Tags,
+		DataPoint: tstorage.DataPoint[T]{
+			Timestamp: ts,
+			Value:     p.Value,
+		},
+	}, nil
+}
@@ -0,0 +1,58 @@
+package opentsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func TestParsePut(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Point
+		wantErr bool
+	}{
+		{
+			name: "seconds",
+			line: "put sys.cpu.user 1600000000 42.5 host=web01 cpu=0",
+			want: Point{
+				Metric:    "sys.cpu.user",
+				Tags:      []tstorage.Label{{Name: "cpu", Value: "0"}, {Name: "host", Value: "web01"}},
+				Value:     42.5,
+				Timestamp: 1600000000000,
+			},
+		},
+		{
+			name: "milliseconds",
+			line: "put sys.cpu.user 1600000000123 1 host=web01",
+			want: Point{Metric: "sys.cpu.user", Tags: []tstorage.Label{{Name: "host", Value: "web01"}}, Value: 1, Timestamp: 1600000000123},
+		},
+		{
+			name: "fractional seconds without put",
+			line: "sys.cpu.user 1600000000.12 -1",
+			want: Point{Metric: "sys.cpu.user", Tags: []tstorage.Label{}, Value: -1, Timestamp: 1600000000120},
+		},
+		{name: "not enough arguments", line: "put sys.cpu.user 1600000000", wantErr: true},
+		{name: "invalid timestamp", line: "put sys.cpu.user abc 1", wantErr: true},
+		{name: "negative timestamp", line: "put sys.cpu.user -1 1", wantErr: true},
+		{name: "too precise timestamp", line: "put sys.cpu.user 1600000000.1234 1", wantErr: true},
+		{name: "invalid value", line: "put sys.cpu.user 1600000000 abc", wantErr: true},
+		{name: "nan", line: "put sys.cpu.user 1600000000 NaN", wantErr: true},
+		{name: "invalid tag", line: "put sys.cpu.user 1600000000 1 host", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePut([]byte(tt.line))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
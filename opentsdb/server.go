@@ -0,0 +1,126 @@
+package opentsdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/lineserver"
+)
+
+const (
+	defaultMaxRequestSize = 32 << 20
+
+	version = "tstorage OpenTSDB-compatible receiver"
+)
+
+// Option is an optional setting for the server and the handler.
+type Option func(*options)
+
+type options struct {
+	lineserver.Options
+	maxRequestSize int64
+}
+
+// WithBatchSize specifies the maximum number of rows given to InsertRows at once.
+//
+// Defaults to 5000.
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.BatchSize = size
+	}
+}
+
+// WithMaxLineSize specifies the maximum byte size of lines the server reads. Longer lines are dropped.
+//
+// Defaults to 4096.
+func WithMaxLineSize(size int) Option {
+	return func(o *options) {
+		o.MaxLineSize = size
+	}
+}
+
+// WithMaxRequestSize specifies the maximum byte size of decompressed requests the handler reads.
+//
+// Defaults to 32MiB.
+func WithMaxRequestSize(size int64) Option {
+	return func(o *options) {
+		o.maxRequestSize = size
+	}
+}
+
+// WithLogger specifies the logger to report malformed lines and rejected rows.
+//
+// Defaults to a logger implementation that does nothing.
+func WithLogger(logger tstorage.Logger) Option {
+	return func(o *options) {
+		o.Logger = logger
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		maxRequestSize: defaultMaxRequestSize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.Normalize()
+	return o
+}
+
+// Server ingests the telnet-style protocol received over TCP and UDP into the storage.
+// Besides "put", it answers "version". Errors are written back to TCP clients like OpenTSDB does.
+type Server[T any] struct {
+	*lineserver.Server
+	storage   tstorage.Storage[T]
+	precision tstorage.TimestampPrecision
+	logger    tstorage.Logger
+}
+
+// NewServer gives back a Server for the given storage.
+func NewServer[T any](s tstorage.Storage[T], opts ...Option) *Server[T] {
+	o := newOptions(opts)
+	srv := &Server[T]{
+		storage:   s,
+		precision: s.TimestampPrecision(),
+		logger:    o.Logger,
+	}
+	srv.Server = lineserver.New(srv.handle, o.Options)
+	return srv
+}
+
+func (s *Server[T]) handle(w io.Writer, lines [][]byte) {
+	rows := make([]tstorage.Row[T], 0, len(lines))
+	for _, line := range lines {
+		command, _, _ := bytes.Cut(line, []byte{' '})
+		switch string(command) {
+		case "put":
+			p, err := ParsePut(line)
+			if err != nil {
+				s.logger.Printf("opentsdb: dropped a malformed line: %v\n", err)
+				fmt.Fprintf(w, "put: illegal argument: %v\n", err)
+				continue
+			}
+			row, err := toRow[T](&p, s.precision)
+			if err != nil {
+				s.logger.Printf("opentsdb: dropped a line: %v\n", err)
+				fmt.Fprintf(w, "put: illegal argument: %v\n", err)
+				continue
+			}
+			rows = append(rows, row)
+		case "version":
+			fmt.Fprintf(w, "%s\n", version)
+		default:
+			fmt.Fprintf(w, "unknown command: %s.  Try `help'.\n", command)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	if err := s.storage.InsertRows(rows); err != nil {
+		s.logger.Printf("opentsdb: failed to insert rows: %v\n", err)
+		fmt.Fprintf(w, "put: %v\n", err)
+	}
+}
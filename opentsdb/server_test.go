@@ -0,0 +1,96 @@
+package opentsdb
+
+import (
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func newTestStorage(t *testing.T) tstorage.Storage[float64] {
+	storage, err := tstorage.NewStorage(
+		tstorage.WithTimestampPrecision[float64](tstorage.Milliseconds),
+		tstorage.WithDuplicatePointPolicy[float64](tstorage.RejectDuplicates),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestServer_Serve(t *testing.T) {
+	storage := newTestStorage(t)
+	srv := NewServer(storage)
+	defer srv.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "put sys.cpu.user 1600000000 1 host=web01\nput sys.cpu.user 1600000001 2 host=web01\nput broken\nversion\nhelp\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "put: illegal argument: not enough arguments (need at least 3, got 1)\n"+version+"\nunknown command: help.  Try `help'.\n", string(resp))
+
+	points, err := storage.Select("sys.cpu.user", []tstorage.Label{{Name: "host", Value: "web01"}}, 1600000000000, 1600000002000)
+	require.NoError(t, err)
+	assert.Equal(t, []*tstorage.DataPoint[float64]{
+		{Timestamp: 1600000000000, Value: 1},
+		{Timestamp: 1600000001000, Value: 2},
+	}, points)
+}
+
+func TestServer_ServePacket(t *testing.T) {
+	storage := newTestStorage(t)
+	srv := NewServer(storage)
+	defer srv.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.ServePacket(pc)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "put sys.cpu.user 1600000000 1 host=web01\n")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		points, err := storage.Select("sys.cpu.user", []tstorage.Label{{Name: "host", Value: "web01"}}, 1600000000000, 1600000000001)
+		return err == nil && len(points) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestServer_overflowingTimestamp(t *testing.T) {
+	storage, err := tstorage.NewStorage[float64]()
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	srv := NewServer(storage)
+	defer srv.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	// 9999999999999 milliseconds overflow in nanoseconds.
+	_, err = io.WriteString(conn, "put sys.cpu.user 9999999999999 1\nput sys.cpu.user 1600000000 2\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "put: illegal argument: invalid timestamp 9999999999999")
+
+	points, err := storage.Select("sys.cpu.user", nil, 0, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Equal(t, []*tstorage.DataPoint[float64]{{Timestamp: 1600000000000000000, Value: 2}}, points)
+}
@@ -14,7 +14,9 @@ type partition[T any] interface {
 	//
 	// insertRows is a goroutine safe way to insert data points into itself.
 	// If data points older than its min timestamp were given, they won't be
-	// ingested, instead, gave back as a first returned value.
+	// ingested, instead, gave back as a first returned value in the given order.
+	// If some rows were refused for other reasons, the rest are still ingested
+	// and a *rejectedRowsError is given back.
 	insertRows(rows []Row[T]) (outdatedRows []Row[T], err error)
 	// clean removes everything managed by this partition.
 	clean() error
@@ -23,6 +25,8 @@ type partition[T any] interface {
 	//
 	// selectDataPoints gives back certain metric's data points within the given range.
 	selectDataPoints(metric string, labels []Label, start, end int64) ([]*DataPoint[T], error)
+	// metricNames gives back the marshaled names of all metrics it holds.
+	metricNames() []string
 	// minTimestamp returns the minimum Unix timestamp in milliseconds.
 	minTimestamp() int64
 	// maxTimestamp returns the maximum Unix timestamp in milliseconds.
@@ -16,6 +16,9 @@ import (
 type partitionList[T any] interface {
 	// insert appends a new node to the head.
 	insert(partition partition[T])
+	// insertOrdered puts the given partition behind all in-memory partitions,
+	// in order of minTimestamp among the others.
+	insertOrdered(partition partition[T])
 	// remove eliminates the given partition from the list.
 	remove(partition partition[T]) error
 	// swap replaces the old partition with the new one.
@@ -24,6 +27,8 @@ type partitionList[T any] interface {
 	getHead() partition[T]
 	// size returns the number of partitions of itself.
 	size() int
+	// diskStats gives back the totals of disk partitions, which are kept up to date without walking through the list.
+	diskStats() diskStats
 	// newIterator gives back the iterator object fot this list.
 	// If you need to inspect all nodes within the list, use this one.
 	newIterator() partitionIterator[T]
@@ -50,11 +55,23 @@ type partitionIterator[T any] interface {
 	currentNode() *partitionNode[T]
 }
 
+// diskStats describes the disk partitions within a partition list.
+type diskStats struct {
+	partitions  int
+	dataPoints  int64
+	mappedBytes int64
+}
+
 type partitionListImpl[T any] struct {
 	numPartitions int64
 	head          *partitionNode[T]
 	tail          *partitionNode[T]
 	mu            sync.RWMutex
+
+	// The totals of disk partitions, which must be updated atomically.
+	numDiskPartitions int64
+	diskDataPoints    int64
+	mappedBytes       int64
 }
 
 func newPartitionList[T any]() partitionList[T] {
@@ -74,15 +91,44 @@ func (p *partitionListImpl[T]) insert(partition partition[T]) {
 	node := &partitionNode[T]{
 		val: partition,
 	}
-	p.mu.RLock()
-	head := p.head
-	p.mu.RUnlock()
-	if head != nil {
-		node.next = head
+	p.mu.Lock()
+	node.next = p.head
+	p.head = node
+	p.mu.Unlock()
+
+	atomic.AddInt64(&p.numPartitions, 1)
+	p.countDisk(partition, 1)
+}
+
+func (p *partitionListImpl[T]) insertOrdered(partition partition[T]) {
+	node := &partitionNode[T]{
+		val: partition,
 	}
+	// Hold the lock while finding the place as well, so that no node gets linked or unlinked meanwhile.
+	p.mu.Lock()
+	var prev, next *partitionNode[T]
+	for current := p.head; current != nil; current = current.getNext() {
+		_, inMemory := current.value().(*memoryPartition[T])
+		if !inMemory && current.value().minTimestamp() < partition.minTimestamp() {
+			next = current
+			break
+		}
+		prev = current
+	}
+
+	node.next = next
+	if prev == nil {
+		p.head = node
+	} else {
+		prev.setNext(node)
+	}
+	if next == nil {
+		p.tail = node
+	}
+	p.mu.Unlock()
 
-	p.setHead(node)
 	atomic.AddInt64(&p.numPartitions, 1)
+	p.countDisk(partition, 1)
 }
 
 func (p *partitionListImpl[T]) remove(target partition[T]) error {
@@ -90,41 +136,41 @@ func (p *partitionListImpl[T]) remove(target partition[T]) error {
 		return fmt.Errorf("empty partition")
 	}
 
-	// Iterate over itself from the head.
-	var prev, next *partitionNode[T]
-	iterator := p.newIterator()
-	for iterator.next() {
-		current := iterator.currentNode()
-		if !samePartitions(current.value(), target) {
-			prev = current
-			continue
-		}
-
-		// remove the current node.
-
-		iterator.next()
-		next = iterator.currentNode()
-		switch {
-		case prev == nil:
-			// removing the head node
-			p.setHead(next)
-		case next == nil:
-			// removing the tail node
-			prev.setNext(nil)
-			p.setTail(prev)
-		default:
-			// removing the middle node
-			prev.setNext(next)
-		}
-		atomic.AddInt64(&p.numPartitions, -1)
+	// Iterate over itself from the head, holding the lock so that no node gets linked meanwhile.
+	p.mu.Lock()
+	var prev *partitionNode[T]
+	current := p.head
+	for current != nil && !samePartitions(current.value(), target) {
+		prev = current
+		current = current.getNext()
+	}
+	if current == nil {
+		p.mu.Unlock()
+		return fmt.Errorf("the given partition was not found")
+	}
 
-		if err := current.value().clean(); err != nil {
-			return fmt.Errorf("failed to clean resources managed by partition to be removed: %w", err)
-		}
-		return nil
+	// remove the current node.
+	next := current.getNext()
+	switch {
+	case prev == nil:
+		// removing the head node
+		p.head = next
+	case next == nil:
+		// removing the tail node
+		prev.setNext(nil)
+		p.tail = prev
+	default:
+		// removing the middle node
+		prev.setNext(next)
 	}
+	p.mu.Unlock()
+	atomic.AddInt64(&p.numPartitions, -1)
+	p.countDisk(current.value(), -1)
 
-	return fmt.Errorf("the given partition was not found")
+	if err := current.value().clean(); err != nil {
+		return fmt.Errorf("failed to clean resources managed by partition to be removed: %w", err)
+	}
+	return nil
 }
 
 func (p *partitionListImpl[T]) swap(old, new partition[T]) error {
@@ -132,40 +178,39 @@ func (p *partitionListImpl[T]) swap(old, new partition[T]) error {
 		return fmt.Errorf("empty partition")
 	}
 
-	// Iterate over itself from the head.
-	var prev, next *partitionNode[T]
-	iterator := p.newIterator()
-	for iterator.next() {
-		current := iterator.currentNode()
-		if !samePartitions(current.value(), old) {
-			prev = current
-			continue
-		}
-
-		// swap the current node.
-
-		newNode := &partitionNode[T]{
-			val:  new,
-			next: current.getNext(),
-		}
-		iterator.next()
-		next = iterator.currentNode()
-		switch {
-		case prev == nil:
-			// swapping the head node
-			p.setHead(newNode)
-		case next == nil:
-			// swapping the tail node
-			prev.setNext(newNode)
-			p.setTail(newNode)
-		default:
-			// swapping the middle node
-			prev.setNext(newNode)
-		}
-		return nil
+	// Iterate over itself from the head, holding the lock so that no node gets linked meanwhile.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var prev *partitionNode[T]
+	current := p.head
+	for current != nil && !samePartitions(current.value(), old) {
+		prev = current
+		current = current.getNext()
+	}
+	if current == nil {
+		return fmt.Errorf("the given partition was not found")
 	}
 
-	return fmt.Errorf("the given partition was not found")
+	// swap the current node.
+	newNode := &partitionNode[T]{
+		val:  new,
+		next: current.getNext(),
+	}
+	switch {
+	case prev == nil:
+		// swapping the head node
+		p.head = newNode
+	case newNode.next == nil:
+		// swapping the tail node
+		prev.setNext(newNode)
+		p.tail = newNode
+	default:
+		// swapping the middle node
+		prev.setNext(newNode)
+	}
+	p.countDisk(current.value(), -1)
+	p.countDisk(new, 1)
+	return nil
 }
 
 func samePartitions[T any](x, y partition[T]) bool {
@@ -176,6 +221,26 @@ func (p *partitionListImpl[T]) size() int {
 	return int(atomic.LoadInt64(&p.numPartitions))
 }
 
+func (p *partitionListImpl[T]) diskStats() diskStats {
+	return diskStats{
+		partitions:  int(atomic.LoadInt64(&p.numDiskPartitions)),
+		dataPoints:  atomic.LoadInt64(&p.diskDataPoints),
+		mappedBytes: atomic.LoadInt64(&p.mappedBytes),
+	}
+}
+
+// countDisk adds the given partition to the totals of disk partitions if it's a disk one,
+// or subtracts it with the negative sign.
+func (p *partitionListImpl[T]) countDisk(part partition[T], sign int64) {
+	d, ok := part.(*diskPartition[T])
+	if !ok {
+		return
+	}
+	atomic.AddInt64(&p.numDiskPartitions, sign)
+	atomic.AddInt64(&p.diskDataPoints, sign*int64(d.size()))
+	atomic.AddInt64(&p.mappedBytes, sign*int64(len(d.mappedFile)))
+}
+
 func (p *partitionListImpl[T]) newIterator() partitionIterator[T] {
 	p.mu.RLock()
 	head := p.head
@@ -189,18 +254,6 @@ func (p *partitionListImpl[T]) newIterator() partitionIterator[T] {
 	}
 }
 
-func (p *partitionListImpl[T]) setHead(node *partitionNode[T]) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.head = node
-}
-
-func (p *partitionListImpl[T]) setTail(node *partitionNode[T]) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.tail = node
-}
-
 func (p *partitionListImpl[T]) String() string {
 	b := &strings.Builder{}
 	iterator := p.newIterator()
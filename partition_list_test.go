@@ -1,6 +1,7 @@
 package tstorage
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -9,19 +10,20 @@ import (
 func Test_partitionList_Remove(t *testing.T) {
 	tests := []struct {
 		name              string
-		partitionList     partitionListImpl[float64]
+		partitionList     *partitionListImpl[float64]
 		target            partition[float64]
 		wantErr           bool
-		wantPartitionList partitionListImpl[float64]
+		wantPartitionList *partitionListImpl[float64]
 	}{
 		{
-			name:          "empty partition",
-			partitionList: partitionListImpl[float64]{},
-			wantErr:       true,
+			name:              "empty partition",
+			partitionList:     &partitionListImpl[float64]{},
+			wantErr:           true,
+			wantPartitionList: &partitionListImpl[float64]{},
 		},
 		{
 			name: "remove the head node",
-			partitionList: func() partitionListImpl[float64] {
+			partitionList: func() *partitionListImpl[float64] {
 				second := &partitionNode[float64]{
 					val: &fakePartition[float64]{
 						minT: 2,
@@ -34,7 +36,7 @@ func Test_partitionList_Remove(t *testing.T) {
 					},
 					next: second,
 				}
-				return partitionListImpl[float64]{
+				return &partitionListImpl[float64]{
 					numPartitions: 2,
 					head:          first,
 					tail:          second,
@@ -43,7 +45,7 @@ func Test_partitionList_Remove(t *testing.T) {
 			target: &fakePartition[float64]{
 				minT: 1,
 			},
-			wantPartitionList: partitionListImpl[float64]{
+			wantPartitionList: &partitionListImpl[float64]{
 				numPartitions: 1,
 				head: &partitionNode[float64]{
 					val: &fakePartition[float64]{
@@ -59,7 +61,7 @@ func Test_partitionList_Remove(t *testing.T) {
 		},
 		{
 			name: "remove the tail node",
-			partitionList: func() partitionListImpl[float64] {
+			partitionList: func() *partitionListImpl[float64] {
 				second := &partitionNode[float64]{
 					val: &fakePartition[float64]{
 						minT: 2,
@@ -72,7 +74,7 @@ func Test_partitionList_Remove(t *testing.T) {
 					},
 					next: second,
 				}
-				return partitionListImpl[float64]{
+				return &partitionListImpl[float64]{
 					numPartitions: 2,
 					head:          first,
 					tail:          second,
@@ -81,7 +83,7 @@ func Test_partitionList_Remove(t *testing.T) {
 			target: &fakePartition[float64]{
 				minT: 2,
 			},
-			wantPartitionList: partitionListImpl[float64]{
+			wantPartitionList: &partitionListImpl[float64]{
 				numPartitions: 1,
 				head: &partitionNode[float64]{
 					val: &fakePartition[float64]{
@@ -97,7 +99,7 @@ func Test_partitionList_Remove(t *testing.T) {
 		},
 		{
 			name: "remove the middle node",
-			partitionList: func() partitionListImpl[float64] {
+			partitionList: func() *partitionListImpl[float64] {
 				third := &partitionNode[float64]{
 					val: &fakePartition[float64]{
 						minT: 3,
@@ -115,7 +117,7 @@ func Test_partitionList_Remove(t *testing.T) {
 					},
 					next: second,
 				}
-				return partitionListImpl[float64]{
+				return &partitionListImpl[float64]{
 					numPartitions: 3,
 					head:          first,
 					tail:          third,
@@ -124,7 +126,7 @@ func Test_partitionList_Remove(t *testing.T) {
 			target: &fakePartition[float64]{
 				minT: 2,
 			},
-			wantPartitionList: partitionListImpl[float64]{
+			wantPartitionList: &partitionListImpl[float64]{
 				numPartitions: 2,
 				head: &partitionNode[float64]{
 					val: &fakePartition[float64]{
@@ -145,7 +147,7 @@ func Test_partitionList_Remove(t *testing.T) {
 		},
 		{
 			name: "given node not found",
-			partitionList: func() partitionListImpl[float64] {
+			partitionList: func() *partitionListImpl[float64] {
 				second := &partitionNode[float64]{
 					val: &fakePartition[float64]{
 						minT: 2,
@@ -158,7 +160,7 @@ func Test_partitionList_Remove(t *testing.T) {
 					},
 					next: second,
 				}
-				return partitionListImpl[float64]{
+				return &partitionListImpl[float64]{
 					numPartitions: 2,
 					head:          first,
 					tail:          second,
@@ -167,7 +169,7 @@ func Test_partitionList_Remove(t *testing.T) {
 			target: &fakePartition[float64]{
 				minT: 3,
 			},
-			wantPartitionList: func() partitionListImpl[float64] {
+			wantPartitionList: func() *partitionListImpl[float64] {
 				second := &partitionNode[float64]{
 					val: &fakePartition[float64]{
 						minT: 2,
@@ -180,7 +182,7 @@ func Test_partitionList_Remove(t *testing.T) {
 					},
 					next: second,
 				}
-				return partitionListImpl[float64]{
+				return &partitionListImpl[float64]{
 					numPartitions: 2,
 					head:          first,
 					tail:          second,
@@ -201,20 +203,21 @@ func Test_partitionList_Remove(t *testing.T) {
 func Test_partitionList_Swap(t *testing.T) {
 	tests := []struct {
 		name              string
-		partitionList     partitionListImpl[float64]
+		partitionList     *partitionListImpl[float64]
 		old               partition[float64]
 		new               partition[float64]
 		wantErr           bool
-		wantPartitionList partitionListImpl[float64]
+		wantPartitionList *partitionListImpl[float64]
 	}{
 		{
-			name:          "empty partition",
-			partitionList: partitionListImpl[float64]{},
-			wantErr:       true,
+			name:              "empty partition",
+			partitionList:     &partitionListImpl[float64]{},
+			wantErr:           true,
+			wantPartitionList: &partitionListImpl[float64]{},
 		},
 		{
 			name: "swap the head node",
-			partitionList: func() partitionListImpl[float64] {
+			partitionList: func() *partitionListImpl[float64] {
 				second := &partitionNode[float64]{
 					val: &fakePartition[float64]{
 						minT: 2,
@@ -227,7 +230,7 @@ func Test_partitionList_Swap(t *testing.T) {
 					},
 					next: second,
 				}
-				return partitionListImpl[float64]{
+				return &partitionListImpl[float64]{
 					numPartitions: 2,
 					head:          first,
 					tail:          second,
@@ -239,7 +242,7 @@ func Test_partitionList_Swap(t *testing.T) {
 			new: &fakePartition[float64]{
 				minT: 100,
 			},
-			wantPartitionList: partitionListImpl[float64]{
+			wantPartitionList: &partitionListImpl[float64]{
 				numPartitions: 2,
 				head: &partitionNode[float64]{
 					val: &fakePartition[float64]{
@@ -260,7 +263,7 @@ func Test_partitionList_Swap(t *testing.T) {
 		},
 		{
 			name: "swap the tail node",
-			partitionList: func() partitionListImpl[float64] {
+			partitionList: func() *partitionListImpl[float64] {
 				second := &partitionNode[float64]{
 					val: &fakePartition[float64]{
 						minT: 2,
@@ -273,7 +276,7 @@ func Test_partitionList_Swap(t *testing.T) {
 					},
 					next: second,
 				}
-				return partitionListImpl[float64]{
+				return &partitionListImpl[float64]{
 					numPartitions: 2,
 					head:          first,
 					tail:          second,
@@ -285,7 +288,7 @@ func Test_partitionList_Swap(t *testing.T) {
 			new: &fakePartition[float64]{
 				minT: 100,
 			},
-			wantPartitionList: partitionListImpl[float64]{
+			wantPartitionList: &partitionListImpl[float64]{
 				numPartitions: 2,
 				head: &partitionNode[float64]{
 					val: &fakePartition[float64]{
@@ -306,7 +309,7 @@ func Test_partitionList_Swap(t *testing.T) {
 		},
 		{
 			name: "swap the middle node",
-			partitionList: func() partitionListImpl[float64] {
+			partitionList: func() *partitionListImpl[float64] {
 				third := &partitionNode[float64]{
 					val: &fakePartition[float64]{
 						minT: 3,
@@ -325,7 +328,7 @@ func Test_partitionList_Swap(t *testing.T) {
 					},
 					next: second,
 				}
-				return partitionListImpl[float64]{
+				return &partitionListImpl[float64]{
 					numPartitions: 3,
 					head:          first,
 					tail:          third,
@@ -337,7 +340,7 @@ func Test_partitionList_Swap(t *testing.T) {
 			new: &fakePartition[float64]{
 				minT: 100,
 			},
-			wantPartitionList: partitionListImpl[float64]{
+			wantPartitionList: &partitionListImpl[float64]{
 				numPartitions: 3,
 				head: &partitionNode[float64]{
 					val: &fakePartition[float64]{
@@ -363,7 +366,7 @@ func Test_partitionList_Swap(t *testing.T) {
 		},
 		{
 			name: "given node not found",
-			partitionList: func() partitionListImpl[float64] {
+			partitionList: func() *partitionListImpl[float64] {
 				second := &partitionNode[float64]{
 					val: &fakePartition[float64]{
 						minT: 2,
@@ -376,7 +379,7 @@ func Test_partitionList_Swap(t *testing.T) {
 					},
 					next: second,
 				}
-				return partitionListImpl[float64]{
+				return &partitionListImpl[float64]{
 					numPartitions: 2,
 					head:          first,
 					tail:          second,
@@ -385,7 +388,7 @@ func Test_partitionList_Swap(t *testing.T) {
 			old: &fakePartition[float64]{
 				minT: 100,
 			},
-			wantPartitionList: partitionListImpl[float64]{
+			wantPartitionList: &partitionListImpl[float64]{
 				numPartitions: 2,
 				head: &partitionNode[float64]{
 					val: &fakePartition[float64]{
@@ -414,3 +417,53 @@ func Test_partitionList_Swap(t *testing.T) {
 		})
 	}
 }
+
+func Test_partitionList_insertOrdered(t *testing.T) {
+	list := newPartitionList[float64]()
+	list.insertOrdered(&fakePartition[float64]{minT: 50})
+	list.insert(&fakePartition[float64]{minT: 100})
+	list.insert(&fakePartition[float64]{minT: 300})
+	// Empty in-memory partitions have the zero minimum, yet stay in front.
+	list.insert(newMemoryPartition[float64](0, Seconds))
+
+	list.insertOrdered(&fakePartition[float64]{minT: 200})
+	list.insertOrdered(&fakePartition[float64]{minT: 400})
+	list.insertOrdered(&fakePartition[float64]{minT: 10})
+
+	got := make([]int64, 0)
+	iterator := list.newIterator()
+	for iterator.next() {
+		got = append(got, iterator.value().minTimestamp())
+	}
+	assert.Equal(t, []int64{0, 400, 300, 200, 100, 50, 10}, got)
+	assert.Equal(t, 7, list.size())
+	assert.Equal(t, int64(10), list.(*partitionListImpl[float64]).tail.value().minTimestamp())
+}
+
+func Test_partitionList_insertOrdered_concurrent(t *testing.T) {
+	list := newPartitionList[float64]()
+	const n = 1000
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			list.insert(&fakePartition[float64]{minT: int64(2*n + i)})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			list.insertOrdered(&fakePartition[float64]{minT: int64(4*n + i)})
+		}
+	}()
+	wg.Wait()
+
+	var got int
+	iterator := list.newIterator()
+	for iterator.next() {
+		got++
+	}
+	assert.Equal(t, 2*n, got)
+	assert.Equal(t, 2*n, list.size())
+}
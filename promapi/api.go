@@ -0,0 +1,376 @@
+// Package promapi implements a subset of the Prometheus HTTP API backed by tstorage,
+// so that clients speaking it, such as Grafana, can query an embedded storage.
+// See https://prometheus.io/docs/prometheus/latest/querying/api/
+//
+// Served are /api/v1/query, /api/v1/query_range, /api/v1/series, /api/v1/labels and /api/v1/label/<name>/values.
+// Queries are evaluated by the promql package, so only the subset of PromQL it supports is available.
+package promapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/timeutil"
+	"github.com/nakabonne/tstorage/promql"
+)
+
+// Option is an optional setting for the handler.
+type Option func(*options)
+
+type options struct {
+	lookbackDelta time.Duration
+}
+
+// WithLookbackDelta specifies how far vector selectors look back for the latest sample.
+//
+// Defaults to 5m.
+func WithLookbackDelta(d time.Duration) Option {
+	return func(o *options) {
+		o.lookbackDelta = d
+	}
+}
+
+type errorType string
+
+const (
+	errorBadData   errorType = "bad_data"
+	errorExecution errorType = "execution"
+	errorInternal  errorType = "internal"
+)
+
+type apiError struct {
+	typ errorType
+	err error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+type response struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType errorType   `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type queryData struct {
+	ResultType string      `json:"resultType"`
+	Result     interface{} `json:"result"`
+}
+
+type api[T any] struct {
+	storage   tstorage.Storage[T]
+	engine    *promql.Engine[T]
+	precision tstorage.TimestampPrecision
+}
+
+// NewHandler gives back a handler serving the API for the given storage.
+func NewHandler[T any](s tstorage.Storage[T], opts ...Option) http.Handler {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	a := &api[T]{
+		storage: s,
+		engine: promql.NewEngine(s,
+			promql.WithLookbackDelta(o.lookbackDelta),
+		),
+		precision: s.TimestampPrecision(),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/query", a.wrap(a.query))
+	mux.Handle("/api/v1/query_range", a.wrap(a.queryRange))
+	mux.Handle("/api/v1/series", a.wrap(a.series))
+	mux.Handle("/api/v1/labels", a.wrap(a.labelNames))
+	mux.Handle("/api/v1/label/", a.wrap(a.labelValues))
+	return mux
+}
+
+func (a *api[T]) wrap(f func(r *http.Request) (interface{}, *apiError)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeResponse(w, http.StatusBadRequest, &response{Status: "error", ErrorType: errorBadData, Error: err.Error()})
+			return
+		}
+		data, apiErr := f(r)
+		if apiErr != nil {
+			status := http.StatusInternalServerError
+			switch apiErr.typ {
+			case errorBadData:
+				status = http.StatusBadRequest
+			case errorExecution:
+				status = http.StatusUnprocessableEntity
+			}
+			writeResponse(w, status, &response{Status: "error", ErrorType: apiErr.typ, Error: apiErr.Error()})
+			return
+		}
+		writeResponse(w, http.StatusOK, &response{Status: "success", Data: data})
+	})
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp *response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (a *api[T]) query(r *http.Request) (interface{}, *apiError) {
+	ts := time.Now()
+	if s := r.FormValue("time"); s != "" {
+		var err error
+		if ts, err = parseTime(s); err != nil {
+			return nil, badData(fmt.Errorf("invalid parameter \"time\": %w", err))
+		}
+	}
+	value, err := a.engine.InstantQuery(r.FormValue("query"), ts)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	vec, ok := value.(promql.Vector)
+	if !ok {
+		scalar := value.(promql.Scalar)
+		return &queryData{ResultType: "scalar", Result: point{T: scalar.T, V: scalar.V}}, nil
+	}
+	result := make([]vectorSample, 0, len(vec))
+	for _, s := range vec {
+		result = append(result, vectorSample{Metric: labelsMap(s.Labels), Value: point(s.Point)})
+	}
+	return &queryData{ResultType: "vector", Result: result}, nil
+}
+
+func (a *api[T]) queryRange(r *http.Request) (interface{}, *apiError) {
+	start, err := parseTime(r.FormValue("start"))
+	if err != nil {
+		return nil, badData(fmt.Errorf("invalid parameter \"start\": %w", err))
+	}
+	end, err := parseTime(r.FormValue("end"))
+	if err != nil {
+		return nil, badData(fmt.Errorf("invalid parameter \"end\": %w", err))
+	}
+	step, err := parseDuration(r.FormValue("step"))
+	if err != nil {
+		return nil, badData(fmt.Errorf("invalid parameter \"step\": %w", err))
+	}
+	if end.Before(start) {
+		return nil, badData(errors.New("end timestamp must not be before start time"))
+	}
+	if step <= 0 {
+		return nil, badData(errors.New("zero or negative query resolution step widths are not accepted. Try a positive integer"))
+	}
+	matrix, err := a.engine.RangeQuery(r.FormValue("query"), start, end, step)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	result := make([]matrixSeries, 0, len(matrix))
+	for _, s := range matrix {
+		values := make([]point, 0, len(s.Points))
+		for _, p := range s.Points {
+			values = append(values, point(p))
+		}
+		result = append(result, matrixSeries{Metric: labelsMap(s.Labels), Values: values})
+	}
+	return &queryData{ResultType: "matrix", Result: result}, nil
+}
+
+func (a *api[T]) series(r *http.Request) (interface{}, *apiError) {
+	if len(r.Form["match[]"]) == 0 {
+		return nil, badData(errors.New("no match[] parameter provided"))
+	}
+	sets, apiErr := a.selectLabelSets(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	result := make([]map[string]string, 0, len(sets))
+	for _, set := range sets {
+		result = append(result, labelsMap(set))
+	}
+	return result, nil
+}
+
+func (a *api[T]) labelNames(r *http.Request) (interface{}, *apiError) {
+	sets, apiErr := a.selectLabelSets(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	names := make(map[string]struct{})
+	for _, set := range sets {
+		for _, l := range set {
+			names[l.Name] = struct{}{}
+		}
+	}
+	return sortedKeys(names), nil
+}
+
+func (a *api[T]) labelValues(r *http.Request) (interface{}, *apiError) {
+	// The path is /api/v1/label/<name>/values.
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/label/"), "/values")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return nil, &apiError{typ: errorBadData, err: fmt.Errorf("invalid path %q", r.URL.Path)}
+	}
+	sets, apiErr := a.selectLabelSets(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	values := make(map[string]struct{})
+	for _, set := range sets {
+		for _, l := range set {
+			if l.Name == name {
+				values[l.Value] = struct{}{}
+			}
+		}
+	}
+	return sortedKeys(values), nil
+}
+
+// selectLabelSets gives back the label sets of series matching any of match[] within the range given by start and end.
+// All series are selected if no match[] is given.
+func (a *api[T]) selectLabelSets(r *http.Request) ([][]tstorage.Label, *apiError) {
+	start, end := int64(math.MinInt64), int64(math.MaxInt64)
+	if s := r.FormValue("start"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return nil, badData(fmt.Errorf("invalid parameter \"start\": %w", err))
+		}
+		start = timeutil.ToUnix(t, a.precision)
+	}
+	if s := r.FormValue("end"); s != "" {
+		t, err := parseTime(s)
+		if err != nil {
+			return nil, badData(fmt.Errorf("invalid parameter \"end\": %w", err))
+		}
+		// The storage's end is exclusive.
+		end = timeutil.ToUnix(t, a.precision) + 1
+	}
+
+	var matcherSets [][]*tstorage.Matcher
+	for _, s := range r.Form["match[]"] {
+		matchers, err := promql.ParseMetricSelector(s)
+		if err != nil {
+			return nil, badData(err)
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+	if len(matcherSets) == 0 {
+		matcherSets = [][]*tstorage.Matcher{nil}
+	}
+
+	sets := make(map[string][]tstorage.Label)
+	for _, matchers := range matcherSets {
+		series, err := a.storage.SelectSeries(matchers, start, end)
+		if err != nil {
+			return nil, &apiError{typ: errorExecution, err: err}
+		}
+		for _, s := range series {
+			set := make([]tstorage.Label, 0, len(s.Labels)+1)
+			set = append(set, tstorage.Label{Name: tstorage.MetricNameLabel, Value: s.Metric})
+			set = append(set, s.Labels...)
+			sort.Slice(set, func(i, j int) bool {
+				return set[i].Name < set[j].Name
+			})
+			sets[fmt.Sprint(set)] = set
+		}
+	}
+	keys := make([]string, 0, len(sets))
+	for key := range sets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([][]tstorage.Label, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, sets[key])
+	}
+	return out, nil
+}
+
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  point             `json:"value"`
+}
+
+type matrixSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values []point           `json:"values"`
+}
+
+// point is marshaled into [<unix time in seconds>, "<value>"].
+type point promql.Point
+
+func (p point) MarshalJSON() ([]byte, error) {
+	ts := strconv.FormatFloat(float64(p.T)/1000, 'f', -1, 64)
+	v := strconv.FormatFloat(p.V, 'f', -1, 64)
+	return []byte(`[` + ts + `,"` + v + `"]`), nil
+}
+
+func labelsMap(labels []tstorage.Label) map[string]string {
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		m[l.Name] = l.Value
+	}
+	return m
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func badData(err error) *apiError {
+	return &apiError{typ: errorBadData, err: err}
+}
+
+// queryError classifies the error given back by the engine.
+func queryError(err error) *apiError {
+	var perr *promql.ParseError
+	if errors.As(err, &perr) {
+		return badData(err)
+	}
+	return &apiError{typ: errorExecution, err: err}
+}
+
+// parseTime parses either Unix time in seconds, which can be fractional, or RFC3339.
+func parseTime(s string) (time.Time, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).Round(time.Millisecond), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parseDuration parses either seconds, which can be fractional, or a PromQL duration like "1m".
+func parseDuration(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		d := f * float64(time.Second)
+		if math.IsNaN(d) || d >= math.MaxInt64 || d <= math.MinInt64 {
+			return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+		}
+		return time.Duration(d), nil
+	}
+	if d, err := promql.ParseDuration(s); err == nil && s != "" {
+		return d, nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
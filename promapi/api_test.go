@@ -0,0 +1,253 @@
+package promapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func newTestServer(t *testing.T) string {
+	storage, err := tstorage.NewStorage(
+		tstorage.WithTimestampPrecision[float64](tstorage.Milliseconds),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+
+	rows := make([]tstorage.Row[float64], 0)
+	for i := int64(0); i <= 6; i++ {
+		for _, instance := range []string{"a", "b"} {
+			rows = append(rows, tstorage.Row[float64]{
+				Metric:    "requests",
+				Labels:    []tstorage.Label{{Name: "job", Value: "api"}, {Name: "instance", Value: instance}},
+				DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000000000 + i*10000, Value: float64(i * 10)},
+			})
+		}
+	}
+	rows = append(rows, tstorage.Row[float64]{
+		Metric:    "up",
+		Labels:    []tstorage.Label{{Name: "job", Value: "db"}},
+		DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000000000, Value: 1},
+	})
+	require.NoError(t, storage.InsertRows(rows))
+
+	server := httptest.NewServer(NewHandler(storage,
+		WithLookbackDelta(time.Minute),
+	))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func get(t *testing.T, u string, params url.Values) (int, string) {
+	t.Helper()
+	resp, err := http.Get(u + "?" + params.Encode())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(b)
+}
+
+func TestAPI_query(t *testing.T) {
+	u := newTestServer(t)
+	status, body := get(t, u+"/api/v1/query", url.Values{
+		"query": {`requests{instance="a"}`},
+		"time":  {"1600000015.5"},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{
+		"status": "success",
+		"data": {
+			"resultType": "vector",
+			"result": [
+				{"metric": {"__name__": "requests", "instance": "a", "job": "api"}, "value": [1600000015.5, "10"]}
+			]
+		}
+	}`, body)
+}
+
+func TestAPI_queryScalar(t *testing.T) {
+	u := newTestServer(t)
+	status, body := get(t, u+"/api/v1/query", url.Values{
+		"query": {`2 * 3`},
+		"time":  {"1600000015"},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"status": "success", "data": {"resultType": "scalar", "result": [1600000015, "6"]}}`, body)
+
+	status, body = get(t, u+"/api/v1/query", url.Values{
+		"query": {`sum by (job) (requests)`},
+		"time":  {"1600000015"},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{
+		"status": "success",
+		"data": {"resultType": "vector", "result": [{"metric": {"job": "api"}, "value": [1600000015, "20"]}]}
+	}`, body)
+}
+
+func TestAPI_queryRange(t *testing.T) {
+	u := newTestServer(t)
+	// POST with a form is accepted as well.
+	resp, err := http.PostForm(u+"/api/v1/query_range", url.Values{
+		"query": {`rate(requests[20s])`},
+		"start": {"2020-09-13T12:27:30Z"},
+		"end":   {"1600000060"},
+		"step":  {"10s"},
+	})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"status": "success",
+		"data": {
+			"resultType": "matrix",
+			"result": [
+				{"metric": {"instance": "a", "job": "api"}, "values": [[1600000050, "1"], [1600000060, "1"]]},
+				{"metric": {"instance": "b", "job": "api"}, "values": [[1600000050, "1"], [1600000060, "1"]]}
+			]
+		}
+	}`, string(b))
+}
+
+func TestAPI_series(t *testing.T) {
+	u := newTestServer(t)
+	status, body := get(t, u+"/api/v1/series", url.Values{
+		"match[]": {`requests{instance="a"}`, `up`},
+		"start":   {"1600000000"},
+		"end":     {"1600000060"},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{
+		"status": "success",
+		"data": [
+			{"__name__": "requests", "instance": "a", "job": "api"},
+			{"__name__": "up", "job": "db"}
+		]
+	}`, body)
+
+	// Series outside of the range aren't given.
+	status, body = get(t, u+"/api/v1/series", url.Values{
+		"match[]": {`up`},
+		"start":   {"1600000010"},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"status": "success", "data": []}`, body)
+}
+
+func TestAPI_labels(t *testing.T) {
+	u := newTestServer(t)
+	status, body := get(t, u+"/api/v1/labels", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"status": "success", "data": ["__name__", "instance", "job"]}`, body)
+
+	status, body = get(t, u+"/api/v1/labels", url.Values{"match[]": {"up"}})
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"status": "success", "data": ["__name__", "job"]}`, body)
+
+	status, body = get(t, u+"/api/v1/label/job/values", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"status": "success", "data": ["api", "db"]}`, body)
+
+	status, body = get(t, u+"/api/v1/label/__name__/values", url.Values{"match[]": {`{job="api"}`}})
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"status": "success", "data": ["requests"]}`, body)
+}
+
+func TestAPI_errors(t *testing.T) {
+	u := newTestServer(t)
+	tests := []struct {
+		name          string
+		path          string
+		params        url.Values
+		wantStatus    int
+		wantErrorType string
+	}{
+		{
+			name:          "malformed query",
+			path:          "/api/v1/query",
+			params:        url.Values{"query": {"requests{"}},
+			wantStatus:    http.StatusBadRequest,
+			wantErrorType: "bad_data",
+		},
+		{
+			name:          "invalid time",
+			path:          "/api/v1/query",
+			params:        url.Values{"query": {"up"}, "time": {"yesterday"}},
+			wantStatus:    http.StatusBadRequest,
+			wantErrorType: "bad_data",
+		},
+		{
+			name:          "missing step",
+			path:          "/api/v1/query_range",
+			params:        url.Values{"query": {"up"}, "start": {"1600000000"}, "end": {"1600000060"}},
+			wantStatus:    http.StatusBadRequest,
+			wantErrorType: "bad_data",
+		},
+		{
+			name:          "end before start",
+			path:          "/api/v1/query_range",
+			params:        url.Values{"query": {"up"}, "start": {"1600000060"}, "end": {"1600000000"}, "step": {"1"}},
+			wantStatus:    http.StatusBadRequest,
+			wantErrorType: "bad_data",
+		},
+		{
+			name:          "too many steps",
+			path:          "/api/v1/query_range",
+			params:        url.Values{"query": {"up"}, "start": {"0"}, "end": {"1600000000"}, "step": {"1"}},
+			wantStatus:    http.StatusUnprocessableEntity,
+			wantErrorType: "execution",
+		},
+		{
+			name:          "missing match[]",
+			path:          "/api/v1/series",
+			wantStatus:    http.StatusBadRequest,
+			wantErrorType: "bad_data",
+		},
+		{
+			name:          "invalid label values path",
+			path:          "/api/v1/label/job",
+			wantStatus:    http.StatusBadRequest,
+			wantErrorType: "bad_data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := get(t, u+tt.path, tt.params)
+			assert.Equal(t, tt.wantStatus, status)
+			var resp response
+			require.NoError(t, json.NewDecoder(strings.NewReader(body)).Decode(&resp))
+			assert.Equal(t, "error", resp.Status)
+			assert.Equal(t, errorType(tt.wantErrorType), resp.ErrorType)
+		})
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, u+"/api/v1/query", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func Test_parseTime(t *testing.T) {
+	got, err := parseTime("1600000000.123")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1600000000123), got.UnixMilli())
+	got, err = parseTime("2020-09-13T12:26:40.5Z")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1600000000500), got.UnixMilli())
+	_, err = parseTime("NaN")
+	assert.Error(t, err)
+}
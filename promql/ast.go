@@ -0,0 +1,198 @@
+package promql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// ValueType is the type an expression evaluates to.
+type ValueType string
+
+const (
+	ValueTypeScalar ValueType = "scalar"
+	ValueTypeVector ValueType = "vector"
+	ValueTypeMatrix ValueType = "matrix"
+)
+
+// Expr is a node of the parsed query.
+type Expr interface {
+	// Type gives back the type the expression evaluates to.
+	Type() ValueType
+	String() string
+}
+
+// VectorSelector selects the latest sample of each series matching the matchers.
+type VectorSelector struct {
+	// The metric name given in front of the braces, which is empty if omitted.
+	Name string
+	// Matchers including the one for the metric name.
+	Matchers []*tstorage.Matcher
+}
+
+// MatrixSelector selects samples within the range of each series selected by the vector selector.
+type MatrixSelector struct {
+	VectorSelector *VectorSelector
+	Range          time.Duration
+}
+
+// Call is a function call.
+type Call struct {
+	Func *Function
+	Args []Expr
+}
+
+// NumberLiteral is a number like 1, 1.5e3, 0x1f, Inf and NaN.
+type NumberLiteral struct {
+	Val float64
+}
+
+// ParenExpr is an expression wrapped in parentheses.
+type ParenExpr struct {
+	Expr Expr
+}
+
+// UnaryExpr is an expression with the unary operator, which is either "+" or "-".
+type UnaryExpr struct {
+	Op   string
+	Expr Expr
+}
+
+// BinaryExpr is an arithmetic operation, whose operator is one of "+", "-", "*", "/", "%" and "^".
+type BinaryExpr struct {
+	Op       string
+	LHS, RHS Expr
+	// How samples of vectors on both sides are matched, which is nil unless both are vectors.
+	Matching *VectorMatching
+}
+
+// VectorMatching describes how samples of vectors on both sides of a binary operation are matched.
+// Only one-to-one matching is supported.
+type VectorMatching struct {
+	// Labels to match by, or to ignore if On is false.
+	MatchingLabels []string
+	// Whether on(...) is given, rather than ignoring(...).
+	On bool
+}
+
+// AggregateExpr is an aggregation like "sum by (job) (expr)".
+type AggregateExpr struct {
+	// One of "sum", "avg", "min", "max", "count", "topk" and "bottomk".
+	Op   string
+	Expr Expr
+	// The parameter of topk and bottomk.
+	Param Expr
+	// Labels to group by, or to drop if Without is true.
+	Grouping []string
+	Without  bool
+}
+
+func (e *VectorSelector) Type() ValueType { return ValueTypeVector }
+func (e *MatrixSelector) Type() ValueType { return ValueTypeMatrix }
+func (e *Call) Type() ValueType           { return e.Func.ReturnType }
+func (e *NumberLiteral) Type() ValueType  { return ValueTypeScalar }
+func (e *ParenExpr) Type() ValueType      { return e.Expr.Type() }
+func (e *UnaryExpr) Type() ValueType      { return e.Expr.Type() }
+func (e *AggregateExpr) Type() ValueType  { return ValueTypeVector }
+
+func (e *BinaryExpr) Type() ValueType {
+	if e.LHS.Type() == ValueTypeScalar && e.RHS.Type() == ValueTypeScalar {
+		return ValueTypeScalar
+	}
+	return ValueTypeVector
+}
+
+func (e *VectorSelector) String() string {
+	matchers := make([]string, 0, len(e.Matchers))
+	for _, m := range e.Matchers {
+		// The metric name is written in front of the braces.
+		if e.Name != "" && m.Name == tstorage.MetricNameLabel && m.Type == tstorage.MatchEqual {
+			continue
+		}
+		matchers = append(matchers, m.String())
+	}
+	if len(matchers) == 0 {
+		return e.Name
+	}
+	return fmt.Sprintf("%s{%s}", e.Name, strings.Join(matchers, ","))
+}
+
+func (e *MatrixSelector) String() string {
+	return fmt.Sprintf("%s[%s]", e.VectorSelector, formatDuration(e.Range))
+}
+
+func (e *Call) String() string {
+	args := make([]string, 0, len(e.Args))
+	for _, a := range e.Args {
+		args = append(args, a.String())
+	}
+	return fmt.Sprintf("%s(%s)", e.Func.Name, strings.Join(args, ", "))
+}
+
+func (e *NumberLiteral) String() string {
+	return strconv.FormatFloat(e.Val, 'f', -1, 64)
+}
+
+func (e *ParenExpr) String() string {
+	return fmt.Sprintf("(%s)", e.Expr)
+}
+
+func (e *UnaryExpr) String() string {
+	return e.Op + e.Expr.String()
+}
+
+func (e *BinaryExpr) String() string {
+	var matching string
+	if m := e.Matching; m != nil && (m.On || len(m.MatchingLabels) > 0) {
+		keyword := "ignoring"
+		if m.On {
+			keyword = "on"
+		}
+		matching = fmt.Sprintf(" %s (%s)", keyword, strings.Join(m.MatchingLabels, ", "))
+	}
+	return fmt.Sprintf("%s %s%s %s", e.LHS, e.Op, matching, e.RHS)
+}
+
+func (e *AggregateExpr) String() string {
+	var grouping string
+	if e.Without {
+		grouping = fmt.Sprintf(" without (%s) ", strings.Join(e.Grouping, ", "))
+	} else if len(e.Grouping) > 0 {
+		grouping = fmt.Sprintf(" by (%s) ", strings.Join(e.Grouping, ", "))
+	}
+	if e.Param != nil {
+		return fmt.Sprintf("%s%s(%s, %s)", e.Op, grouping, e.Param, e.Expr)
+	}
+	return fmt.Sprintf("%s%s(%s)", e.Op, grouping, e.Expr)
+}
+
+// formatDuration formats the duration in the same way as PromQL, such as "1h30m".
+func formatDuration(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms == 0 {
+		return "0s"
+	}
+	units := []struct {
+		name string
+		ms   int64
+	}{
+		{"y", 365 * 24 * 60 * 60 * 1000},
+		{"w", 7 * 24 * 60 * 60 * 1000},
+		{"d", 24 * 60 * 60 * 1000},
+		{"h", 60 * 60 * 1000},
+		{"m", 60 * 1000},
+		{"s", 1000},
+		{"ms", 1},
+	}
+	var b strings.Builder
+	for _, u := range units {
+		if ms >= u.ms {
+			fmt.Fprintf(&b, "%d%s", ms/u.ms, u.name)
+			ms %= u.ms
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,598 @@
+// Package promql implements a subset of PromQL evaluated over tstorage.
+// See https://prometheus.io/docs/prometheus/latest/querying/basics/
+//
+// Supported are vector selectors with matchers, range vectors, the range functions
+// rate, irate, increase, delta, and {avg,sum,min,max,count,last}_over_time,
+// the aggregations sum, avg, min, max, count, topk and bottomk with by/without,
+// and the arithmetic operators +, -, *, /, % and ^ between scalars and instant vectors
+// with one-to-one vector matching.
+//
+// Timestamps in queries and results are Unix time in milliseconds like Prometheus,
+// which get converted from and into the precision of the storage.
+package promql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/timeutil"
+)
+
+const (
+	defaultLookbackDelta = 5 * time.Minute
+
+	// The maximum number of steps of range queries, the same as Prometheus.
+	maxSteps = 11000
+)
+
+// Point is a sample of a series.
+type Point struct {
+	// Unix time in milliseconds.
+	T int64
+	V float64
+}
+
+// Series is a series with its samples. Labels are sorted by name, including the metric name as MetricNameLabel.
+type Series struct {
+	Labels []tstorage.Label
+	Points []Point
+}
+
+// Matrix is the result of range queries.
+type Matrix []Series
+
+// Sample is a single sample of a series.
+type Sample struct {
+	Labels []tstorage.Label
+	Point
+}
+
+// Vector is a set of samples at the same time, one per series.
+type Vector []Sample
+
+// Scalar is a single number at the given time.
+type Scalar struct {
+	// Unix time in milliseconds.
+	T int64
+	V float64
+}
+
+// Value is the result of queries, which is either Scalar, Vector or Matrix.
+type Value interface {
+	Type() ValueType
+}
+
+func (Scalar) Type() ValueType { return ValueTypeScalar }
+func (Vector) Type() ValueType { return ValueTypeVector }
+func (Matrix) Type() ValueType { return ValueTypeMatrix }
+
+// Option is an optional setting for the engine.
+type Option func(*options)
+
+type options struct {
+	lookbackDelta time.Duration
+}
+
+// WithLookbackDelta specifies how far vector selectors look back for the latest sample.
+//
+// Defaults to 5m.
+func WithLookbackDelta(d time.Duration) Option {
+	return func(o *options) {
+		o.lookbackDelta = d
+	}
+}
+
+// Engine evaluates queries over the storage.
+type Engine[T any] struct {
+	storage       tstorage.Storage[T]
+	precision     tstorage.TimestampPrecision
+	lookbackDelta int64
+}
+
+// NewEngine gives back an Engine for the given storage.
+func NewEngine[T any](s tstorage.Storage[T], opts ...Option) *Engine[T] {
+	o := &options{
+		lookbackDelta: defaultLookbackDelta,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.lookbackDelta <= 0 {
+		o.lookbackDelta = defaultLookbackDelta
+	}
+	return &Engine[T]{
+		storage:       s,
+		precision:     s.TimestampPrecision(),
+		lookbackDelta: o.lookbackDelta.Milliseconds(),
+	}
+}
+
+// InstantQuery evaluates the query at the given time, giving back either Scalar or Vector.
+func (e *Engine[T]) InstantQuery(query string, ts time.Time) (Value, error) {
+	expr, err := ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	t := ts.UnixMilli()
+	ev := e.newEvaluator(t, t)
+	switch expr.Type() {
+	case ValueTypeScalar:
+		v, err := ev.evalScalar(expr, t)
+		if err != nil {
+			return nil, err
+		}
+		return Scalar{T: t, V: v}, nil
+	case ValueTypeVector:
+		vec, err := ev.eval(expr, t)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkDuplicates(vec); err != nil {
+			return nil, err
+		}
+		return vec, nil
+	default:
+		return nil, fmt.Errorf("instant queries of %s aren't supported", expr.Type())
+	}
+}
+
+// RangeQuery evaluates the query at each step from start to end, inclusive.
+func (e *Engine[T]) RangeQuery(query string, start, end time.Time, step time.Duration) (Matrix, error) {
+	expr, err := ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	if typ := expr.Type(); typ != ValueTypeVector && typ != ValueTypeScalar {
+		return nil, fmt.Errorf("range queries must evaluate to %s or %s, got %s", ValueTypeVector, ValueTypeScalar, typ)
+	}
+	startMs, endMs, stepMs := start.UnixMilli(), end.UnixMilli(), step.Milliseconds()
+	if endMs < startMs {
+		return nil, errors.New("end timestamp must not be before start time")
+	}
+	if stepMs <= 0 {
+		return nil, errors.New("zero or negative query resolution step widths are not accepted")
+	}
+	if (endMs-startMs)/stepMs >= maxSteps {
+		return nil, fmt.Errorf("exceeded maximum resolution of %d points per timeseries", maxSteps)
+	}
+
+	ev := e.newEvaluator(startMs, endMs)
+	series := make(map[string]*Series)
+	for t := startMs; t <= endMs; t += stepMs {
+		var vec Vector
+		if expr.Type() == ValueTypeScalar {
+			// Scalars are given as a series without labels.
+			v, err := ev.evalScalar(expr, t)
+			if err != nil {
+				return nil, err
+			}
+			vec = Vector{{Labels: []tstorage.Label{}, Point: Point{T: t, V: v}}}
+		} else if vec, err = ev.eval(expr, t); err != nil {
+			return nil, err
+		}
+		if err := checkDuplicates(vec); err != nil {
+			return nil, err
+		}
+		for _, s := range vec {
+			key := labelsKey(s.Labels)
+			ss, ok := series[key]
+			if !ok {
+				ss = &Series{Labels: s.Labels}
+				series[key] = ss
+			}
+			ss.Points = append(ss.Points, s.Point)
+		}
+	}
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	matrix := make(Matrix, 0, len(keys))
+	for _, key := range keys {
+		matrix = append(matrix, *series[key])
+	}
+	return matrix, nil
+}
+
+// evaluator evaluates an expression at steps within [start, end].
+type evaluator[T any] struct {
+	engine     *Engine[T]
+	start, end int64
+	// Series fetched for each selector, which are reused across steps.
+	fetched map[*VectorSelector][]Series
+}
+
+func (e *Engine[T]) newEvaluator(start, end int64) *evaluator[T] {
+	return &evaluator[T]{
+		engine:  e,
+		start:   start,
+		end:     end,
+		fetched: make(map[*VectorSelector][]Series),
+	}
+}
+
+// evalScalar evaluates the expression of type scalar at t.
+func (ev *evaluator[T]) evalScalar(expr Expr, t int64) (float64, error) {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		return e.Val, nil
+	case *ParenExpr:
+		return ev.evalScalar(e.Expr, t)
+	case *UnaryExpr:
+		v, err := ev.evalScalar(e.Expr, t)
+		if err != nil {
+			return 0, err
+		}
+		if e.Op == "-" {
+			v = -v
+		}
+		return v, nil
+	case *BinaryExpr:
+		lhs, err := ev.evalScalar(e.LHS, t)
+		if err != nil {
+			return 0, err
+		}
+		rhs, err := ev.evalScalar(e.RHS, t)
+		if err != nil {
+			return 0, err
+		}
+		return arithmetic(e.Op, lhs, rhs), nil
+	default:
+		return 0, fmt.Errorf("unsupported scalar expression %s", expr)
+	}
+}
+
+// eval evaluates the expression of type instant vector at t.
+func (ev *evaluator[T]) eval(expr Expr, t int64) (Vector, error) {
+	switch e := expr.(type) {
+	case *VectorSelector:
+		series, err := ev.fetch(e, ev.engine.lookbackDelta)
+		if err != nil {
+			return nil, err
+		}
+		vec := make(Vector, 0, len(series))
+		for _, s := range series {
+			points := window(s.Points, t-ev.engine.lookbackDelta, t)
+			if len(points) == 0 {
+				continue
+			}
+			last := points[len(points)-1]
+			if tstorage.IsStaleNaN(last.V) {
+				continue
+			}
+			vec = append(vec, Sample{Labels: s.Labels, Point: Point{T: t, V: last.V}})
+		}
+		return vec, nil
+	case *Call:
+		return ev.evalCall(e, t)
+	case *ParenExpr:
+		return ev.eval(e.Expr, t)
+	case *UnaryExpr:
+		vec, err := ev.eval(e.Expr, t)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op != "-" {
+			return vec, nil
+		}
+		out := make(Vector, 0, len(vec))
+		for _, s := range vec {
+			out = append(out, Sample{Labels: dropMetricName(s.Labels), Point: Point{T: t, V: -s.V}})
+		}
+		return out, nil
+	case *BinaryExpr:
+		return ev.evalBinary(e, t)
+	case *AggregateExpr:
+		return ev.evalAggregate(e, t)
+	default:
+		return nil, fmt.Errorf("unsupported expression %s", expr)
+	}
+}
+
+func (ev *evaluator[T]) evalBinary(e *BinaryExpr, t int64) (Vector, error) {
+	if e.LHS.Type() == ValueTypeScalar || e.RHS.Type() == ValueTypeScalar {
+		// Either side is a vector, which gets combined with the scalar sample by sample.
+		vecExpr, scalarExpr, scalarLeft := e.LHS, e.RHS, false
+		if e.LHS.Type() == ValueTypeScalar {
+			vecExpr, scalarExpr, scalarLeft = e.RHS, e.LHS, true
+		}
+		scalar, err := ev.evalScalar(scalarExpr, t)
+		if err != nil {
+			return nil, err
+		}
+		vec, err := ev.eval(vecExpr, t)
+		if err != nil {
+			return nil, err
+		}
+		out := make(Vector, 0, len(vec))
+		for _, s := range vec {
+			lhs, rhs := s.V, scalar
+			if scalarLeft {
+				lhs, rhs = scalar, s.V
+			}
+			out = append(out, Sample{Labels: dropMetricName(s.Labels), Point: Point{T: t, V: arithmetic(e.Op, lhs, rhs)}})
+		}
+		return out, nil
+	}
+
+	lhs, err := ev.eval(e.LHS, t)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := ev.eval(e.RHS, t)
+	if err != nil {
+		return nil, err
+	}
+	rightSamples := make(map[string]Sample, len(rhs))
+	for _, s := range rhs {
+		sig := labelsKey(matchingLabels(s.Labels, e.Matching))
+		if _, ok := rightSamples[sig]; ok {
+			return nil, fmt.Errorf("found duplicate series for the match group on the right hand-side of the operation: %s", e)
+		}
+		rightSamples[sig] = s
+	}
+	matched := make(map[string]bool, len(lhs))
+	out := make(Vector, 0, len(lhs))
+	for _, s := range lhs {
+		labels := matchingLabels(s.Labels, e.Matching)
+		sig := labelsKey(labels)
+		r, ok := rightSamples[sig]
+		if !ok {
+			continue
+		}
+		if matched[sig] {
+			return nil, fmt.Errorf("multiple matches for labels: many-to-one matching isn't supported: %s", e)
+		}
+		matched[sig] = true
+		out = append(out, Sample{Labels: dropMetricName(labels), Point: Point{T: t, V: arithmetic(e.Op, s.V, r.V)}})
+	}
+	return out, nil
+}
+
+// matchingLabels gives back the labels samples get matched by, which are also kept in results.
+func matchingLabels(labels []tstorage.Label, m *VectorMatching) []tstorage.Label {
+	names := make(map[string]bool, len(m.MatchingLabels))
+	for _, name := range m.MatchingLabels {
+		names[name] = true
+	}
+	out := make([]tstorage.Label, 0, len(labels))
+	for _, l := range labels {
+		if m.On == names[l.Name] && (m.On || l.Name != tstorage.MetricNameLabel) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func arithmetic(op string, lhs, rhs float64) float64 {
+	switch op {
+	case "+":
+		return lhs + rhs
+	case "-":
+		return lhs - rhs
+	case "*":
+		return lhs * rhs
+	case "/":
+		return lhs / rhs
+	case "%":
+		return math.Mod(lhs, rhs)
+	case "^":
+		return math.Pow(lhs, rhs)
+	default:
+		panic(fmt.Sprintf("unknown operator %q", op))
+	}
+}
+
+// aggregationGroup is the state of a group while aggregating.
+type aggregationGroup struct {
+	labels []tstorage.Label
+	value  float64
+	count  int
+	// Samples belonging to the group, only for topk and bottomk.
+	samples Vector
+}
+
+func (ev *evaluator[T]) evalAggregate(e *AggregateExpr, t int64) (Vector, error) {
+	vec, err := ev.eval(e.Expr, t)
+	if err != nil {
+		return nil, err
+	}
+	var k int
+	if e.Param != nil {
+		param, err := ev.evalScalar(e.Param, t)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(param) {
+			return nil, fmt.Errorf("parameter value is NaN for %s", e.Op)
+		}
+		if param < 1 {
+			return Vector{}, nil
+		}
+		k = len(vec)
+		if param < float64(k) {
+			k = int(param)
+		}
+	}
+
+	grouping := make(map[string]bool, len(e.Grouping))
+	for _, name := range e.Grouping {
+		grouping[name] = true
+	}
+	groups := make(map[string]*aggregationGroup)
+	// Groups in the order they first appear, for the deterministic result.
+	order := make([]*aggregationGroup, 0)
+	for _, s := range vec {
+		labels := make([]tstorage.Label, 0, len(s.Labels))
+		for _, l := range s.Labels {
+			if e.Without != grouping[l.Name] && (!e.Without || l.Name != tstorage.MetricNameLabel) {
+				labels = append(labels, l)
+			}
+		}
+		key := labelsKey(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &aggregationGroup{labels: labels, value: s.V}
+			groups[key] = g
+			order = append(order, g)
+		} else {
+			switch e.Op {
+			case "sum", "avg":
+				g.value += s.V
+			case "min":
+				if g.value > s.V || math.IsNaN(g.value) {
+					g.value = s.V
+				}
+			case "max":
+				if g.value < s.V || math.IsNaN(g.value) {
+					g.value = s.V
+				}
+			}
+		}
+		g.count++
+		if e.Op == "topk" || e.Op == "bottomk" {
+			g.samples = append(g.samples, s)
+		}
+	}
+
+	out := make(Vector, 0, len(order))
+	for _, g := range order {
+		switch e.Op {
+		case "avg":
+			out = append(out, Sample{Labels: g.labels, Point: Point{T: t, V: g.value / float64(g.count)}})
+		case "count":
+			out = append(out, Sample{Labels: g.labels, Point: Point{T: t, V: float64(g.count)}})
+		case "topk", "bottomk":
+			top := e.Op == "topk"
+			sort.SliceStable(g.samples, func(i, j int) bool {
+				a, b := g.samples[i].V, g.samples[j].V
+				// NaN comes last in both orders.
+				if math.IsNaN(a) || math.IsNaN(b) {
+					return !math.IsNaN(a)
+				}
+				if top {
+					return a > b
+				}
+				return a < b
+			})
+			if len(g.samples) > k {
+				g.samples = g.samples[:k]
+			}
+			for _, s := range g.samples {
+				out = append(out, Sample{Labels: s.Labels, Point: Point{T: t, V: s.V}})
+			}
+		default:
+			out = append(out, Sample{Labels: g.labels, Point: Point{T: t, V: g.value}})
+		}
+	}
+	return out, nil
+}
+
+// checkDuplicates ensures that no two samples in the vector have the same labels, which can happen
+// once metric names get dropped.
+func checkDuplicates(vec Vector) error {
+	seen := make(map[string]struct{}, len(vec))
+	for _, s := range vec {
+		key := labelsKey(s.Labels)
+		if _, ok := seen[key]; ok {
+			return errors.New("vector cannot contain metrics with the same labelset")
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+func (ev *evaluator[T]) evalCall(call *Call, t int64) (Vector, error) {
+	if call.Func.rangeFunc == nil {
+		return nil, fmt.Errorf("unsupported function %q", call.Func.Name)
+	}
+	ms := call.Args[0].(*MatrixSelector)
+	rangeMs := ms.Range.Milliseconds()
+	series, err := ev.fetch(ms.VectorSelector, rangeMs)
+	if err != nil {
+		return nil, err
+	}
+	vec := make(Vector, 0, len(series))
+	points := make([]Point, 0)
+	for _, s := range series {
+		points = points[:0]
+		for _, p := range window(s.Points, t-rangeMs, t) {
+			if !tstorage.IsStaleNaN(p.V) {
+				points = append(points, p)
+			}
+		}
+		v, ok := call.Func.rangeFunc(points, t-rangeMs, t)
+		if !ok {
+			continue
+		}
+		vec = append(vec, Sample{Labels: dropMetricName(s.Labels), Point: Point{T: t, V: v}})
+	}
+	return vec, nil
+}
+
+// fetch gives back the series selected by the selector, with samples needed to evaluate it at any steps.
+// rangeMs is how far it looks back from each step.
+func (ev *evaluator[T]) fetch(vs *VectorSelector, rangeMs int64) ([]Series, error) {
+	if series, ok := ev.fetched[vs]; ok {
+		return series, nil
+	}
+	precision := ev.engine.precision
+	start := timeutil.FromMillis(ev.start-rangeMs, precision)
+	// The storage's end is exclusive.
+	end := timeutil.CeilFromMillis(ev.end+1, precision)
+	selected, err := ev.engine.storage.SelectSeries(vs.Matchers, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select series: %w", err)
+	}
+	series := make([]Series, 0, len(selected))
+	for _, s := range selected {
+		labels := make([]tstorage.Label, 0, len(s.Labels)+1)
+		labels = append(labels, tstorage.Label{Name: tstorage.MetricNameLabel, Value: s.Metric})
+		labels = append(labels, s.Labels...)
+		sort.Slice(labels, func(i, j int) bool {
+			return labels[i].Name < labels[j].Name
+		})
+		points := make([]Point, 0, len(s.Points))
+		for _, p := range s.Points {
+			points = append(points, Point{T: timeutil.ToMillis(p.Timestamp, precision), V: p.Value})
+		}
+		series = append(series, Series{Labels: labels, Points: points})
+	}
+	ev.fetched[vs] = series
+	return series, nil
+}
+
+// window gives back the points within (start, end].
+func window(points []Point, start, end int64) []Point {
+	i := sort.Search(len(points), func(i int) bool { return points[i].T > start })
+	j := sort.Search(len(points), func(i int) bool { return points[i].T > end })
+	return points[i:j]
+}
+
+func dropMetricName(labels []tstorage.Label) []tstorage.Label {
+	out := make([]tstorage.Label, 0, len(labels))
+	for _, l := range labels {
+		if l.Name != tstorage.MetricNameLabel {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// labelsKey gives back a string identifying the sorted labels.
+func labelsKey(labels []tstorage.Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte(0xfe)
+		b.WriteString(l.Value)
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
@@ -0,0 +1,256 @@
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// newTestEngine gives back an engine over a storage in seconds holding:
+//
+//	requests{job="api", instance="a"}: 0, 10, ..., 100 at 0s, 10s, ..., 100s
+//	requests{job="api", instance="b"}: 5 at 0s, then the stale marker at 30s
+//	up{job="api"}: 1 at 0s
+func newTestEngine(t *testing.T) *Engine[float64] {
+	storage, err := tstorage.NewStorage(
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+
+	base := int64(1600000000)
+	rows := make([]tstorage.Row[float64], 0)
+	for i := int64(0); i <= 10; i++ {
+		rows = append(rows, tstorage.Row[float64]{
+			Metric:    "requests",
+			Labels:    []tstorage.Label{{Name: "job", Value: "api"}, {Name: "instance", Value: "a"}},
+			DataPoint: tstorage.DataPoint[float64]{Timestamp: base + i*10, Value: float64(i * 10)},
+		})
+	}
+	b := []tstorage.Label{{Name: "job", Value: "api"}, {Name: "instance", Value: "b"}}
+	rows = append(rows,
+		tstorage.Row[float64]{Metric: "requests", Labels: b, DataPoint: tstorage.DataPoint[float64]{Timestamp: base, Value: 5}},
+		tstorage.Row[float64]{Metric: "requests", Labels: b, DataPoint: tstorage.DataPoint[float64]{Timestamp: base + 30, Value: tstorage.StaleNaN}},
+		tstorage.Row[float64]{Metric: "up", Labels: []tstorage.Label{{Name: "job", Value: "api"}}, DataPoint: tstorage.DataPoint[float64]{Timestamp: base, Value: 1}},
+	)
+	require.NoError(t, storage.InsertRows(rows))
+	return NewEngine(storage, WithLookbackDelta(time.Minute))
+}
+
+func labels(kv ...string) []tstorage.Label {
+	out := make([]tstorage.Label, 0, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		out = append(out, tstorage.Label{Name: kv[i], Value: kv[i+1]})
+	}
+	return out
+}
+
+func TestEngine_InstantQuery(t *testing.T) {
+	e := newTestEngine(t)
+	tests := []struct {
+		name    string
+		query   string
+		ts      int64
+		want    Value
+		wantErr bool
+	}{
+		{
+			name:  "vector selector",
+			query: `requests{job="api"}`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("__name__", "requests", "instance", "a", "job", "api"), Point: Point{T: 1600000015000, V: 10}},
+				{Labels: labels("__name__", "requests", "instance", "b", "job", "api"), Point: Point{T: 1600000015000, V: 5}},
+			},
+		},
+		{
+			name:  "stale series are gone",
+			query: `requests{instance="b"}`,
+			ts:    1600000035,
+			want:  Vector{},
+		},
+		{
+			name:  "samples older than the lookback delta are gone",
+			query: `up`,
+			ts:    1600000060,
+			want:  Vector{},
+		},
+		{
+			name:  "function",
+			query: `increase(requests[30s])`,
+			ts:    1600000100,
+			want: Vector{
+				{Labels: labels("instance", "a", "job", "api"), Point: Point{T: 1600000100000, V: 30}},
+			},
+		},
+		{
+			name:  "scalar",
+			query: `1 + 2 * 3 ^ 2 % 5`,
+			ts:    1600000015,
+			want:  Scalar{T: 1600000015000, V: 4},
+		},
+		{
+			name:  "vector and scalar",
+			query: `requests * 2`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("instance", "a", "job", "api"), Point: Point{T: 1600000015000, V: 20}},
+				{Labels: labels("instance", "b", "job", "api"), Point: Point{T: 1600000015000, V: 10}},
+			},
+		},
+		{
+			name:  "scalar and vector",
+			query: `100 - -requests{instance="a"}`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("instance", "a", "job", "api"), Point: Point{T: 1600000015000, V: 110}},
+			},
+		},
+		{
+			name:  "vectors",
+			query: `requests - requests`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("instance", "a", "job", "api"), Point: Point{T: 1600000015000, V: 0}},
+				{Labels: labels("instance", "b", "job", "api"), Point: Point{T: 1600000015000, V: 0}},
+			},
+		},
+		{
+			name:  "vectors matched on labels",
+			query: `requests{instance="a"} + on(job) up`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("job", "api"), Point: Point{T: 1600000015000, V: 11}},
+			},
+		},
+		{
+			name:  "vectors without matching samples",
+			query: `requests + up`,
+			ts:    1600000015,
+			want:  Vector{},
+		},
+		{name: "many-to-one matching", query: `up + ignoring(instance) requests`, ts: 1600000015, wantErr: true},
+		{
+			name:  "sum",
+			query: `sum(requests)`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels(), Point: Point{T: 1600000015000, V: 15}},
+			},
+		},
+		{
+			name:  "avg by",
+			query: `avg by (job) (requests)`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("job", "api"), Point: Point{T: 1600000015000, V: 7.5}},
+			},
+		},
+		{
+			name:  "max without",
+			query: `max(requests) without (instance)`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("job", "api"), Point: Point{T: 1600000015000, V: 10}},
+			},
+		},
+		{
+			name:  "count by instance",
+			query: `count by (instance) (requests)`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("instance", "a"), Point: Point{T: 1600000015000, V: 1}},
+				{Labels: labels("instance", "b"), Point: Point{T: 1600000015000, V: 1}},
+			},
+		},
+		{
+			name:  "topk",
+			query: `topk(1, requests)`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("__name__", "requests", "instance", "a", "job", "api"), Point: Point{T: 1600000015000, V: 10}},
+			},
+		},
+		{
+			name:  "bottomk by",
+			query: `bottomk by (instance) (5, requests)`,
+			ts:    1600000015,
+			want: Vector{
+				{Labels: labels("__name__", "requests", "instance", "a", "job", "api"), Point: Point{T: 1600000015000, V: 10}},
+				{Labels: labels("__name__", "requests", "instance", "b", "job", "api"), Point: Point{T: 1600000015000, V: 5}},
+			},
+		},
+		{
+			name:  "topk of zero",
+			query: `topk(0, requests)`,
+			ts:    1600000015,
+			want:  Vector{},
+		},
+		{name: "matrix", query: `requests[1m]`, ts: 1600000100, wantErr: true},
+		{name: "malformed", query: `requests{`, ts: 1600000100, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.InstantQuery(tt.query, time.Unix(tt.ts, 0))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEngine_RangeQuery(t *testing.T) {
+	e := newTestEngine(t)
+	got, err := e.RangeQuery(`requests`, time.Unix(1600000000, 0), time.Unix(1600000040, 0), 20*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Matrix{
+		{
+			Labels: labels("__name__", "requests", "instance", "a", "job", "api"),
+			Points: []Point{{T: 1600000000000, V: 0}, {T: 1600000020000, V: 20}, {T: 1600000040000, V: 40}},
+		},
+		{
+			Labels: labels("__name__", "requests", "instance", "b", "job", "api"),
+			Points: []Point{{T: 1600000000000, V: 5}, {T: 1600000020000, V: 5}},
+		},
+	}, got)
+
+	got, err = e.RangeQuery(`rate(requests{instance="a"}[20s])`, time.Unix(1600000050, 0), time.Unix(1600000060, 0), 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Matrix{
+		{
+			Labels: labels("instance", "a", "job", "api"),
+			Points: []Point{{T: 1600000050000, V: 1}, {T: 1600000060000, V: 1}},
+		},
+	}, got)
+
+	got, err = e.RangeQuery(`sum(requests) * 2`, time.Unix(1600000020, 0), time.Unix(1600000040, 0), 20*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Matrix{
+		{Labels: labels(), Points: []Point{{T: 1600000020000, V: 50}, {T: 1600000040000, V: 80}}},
+	}, got)
+
+	got, err = e.RangeQuery(`1 / 2`, time.Unix(1600000000, 0), time.Unix(1600000010, 0), 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Matrix{
+		{Labels: labels(), Points: []Point{{T: 1600000000000, V: 0.5}, {T: 1600000010000, V: 0.5}}},
+	}, got)
+
+	_, err = e.RangeQuery(`requests`, time.Unix(1600000060, 0), time.Unix(1600000000, 0), time.Second)
+	assert.Error(t, err)
+	_, err = e.RangeQuery(`requests`, time.Unix(1600000000, 0), time.Unix(1600000060, 0), 0)
+	assert.Error(t, err)
+	_, err = e.RangeQuery(`requests`, time.Unix(0, 0), time.Unix(1600000000, 0), time.Second)
+	assert.Error(t, err)
+}
+
+func Test_checkDuplicates(t *testing.T) {
+	assert.NoError(t, checkDuplicates(Vector{{Labels: labels("job", "a")}, {Labels: labels("job", "b")}}))
+	assert.Error(t, checkDuplicates(Vector{{Labels: labels("job", "a")}, {Labels: labels("job", "a")}}))
+}
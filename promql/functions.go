@@ -0,0 +1,149 @@
+package promql
+
+import "math"
+
+// Function is a function callable in queries.
+type Function struct {
+	Name       string
+	ArgTypes   []ValueType
+	ReturnType ValueType
+
+	// rangeFunc computes the value from the samples within (rangeStart, rangeEnd] of a series,
+	// and gives back false if it has no value.
+	rangeFunc func(points []Point, rangeStart, rangeEnd int64) (float64, bool)
+}
+
+// functions holds the supported functions keyed by the name.
+var functions = map[string]*Function{}
+
+func init() {
+	rangeFuncs := map[string]func(points []Point, rangeStart, rangeEnd int64) (float64, bool){
+		"rate": func(points []Point, rangeStart, rangeEnd int64) (float64, bool) {
+			return extrapolatedRate(points, rangeStart, rangeEnd, true, true)
+		},
+		"increase": func(points []Point, rangeStart, rangeEnd int64) (float64, bool) {
+			return extrapolatedRate(points, rangeStart, rangeEnd, true, false)
+		},
+		"delta": func(points []Point, rangeStart, rangeEnd int64) (float64, bool) {
+			return extrapolatedRate(points, rangeStart, rangeEnd, false, false)
+		},
+		"irate":           instantRate,
+		"avg_over_time":   aggrOverTime(func(points []Point) float64 { return sum(points) / float64(len(points)) }),
+		"sum_over_time":   aggrOverTime(sum),
+		"count_over_time": aggrOverTime(func(points []Point) float64 { return float64(len(points)) }),
+		"last_over_time":  aggrOverTime(func(points []Point) float64 { return points[len(points)-1].V }),
+		"min_over_time": aggrOverTime(func(points []Point) float64 {
+			min := points[0].V
+			for _, p := range points[1:] {
+				if p.V < min || math.IsNaN(min) {
+					min = p.V
+				}
+			}
+			return min
+		}),
+		"max_over_time": aggrOverTime(func(points []Point) float64 {
+			max := points[0].V
+			for _, p := range points[1:] {
+				if p.V > max || math.IsNaN(max) {
+					max = p.V
+				}
+			}
+			return max
+		}),
+	}
+	for name, f := range rangeFuncs {
+		functions[name] = &Function{
+			Name:       name,
+			ArgTypes:   []ValueType{ValueTypeMatrix},
+			ReturnType: ValueTypeVector,
+			rangeFunc:  f,
+		}
+	}
+}
+
+func aggrOverTime(f func(points []Point) float64) func(points []Point, rangeStart, rangeEnd int64) (float64, bool) {
+	return func(points []Point, _, _ int64) (float64, bool) {
+		if len(points) == 0 {
+			return 0, false
+		}
+		return f(points), true
+	}
+}
+
+func sum(points []Point) float64 {
+	var s float64
+	for _, p := range points {
+		s += p.V
+	}
+	return s
+}
+
+// extrapolatedRate computes the rate, increase or delta in the same way as Prometheus does.
+// The result is extrapolated to the boundaries of the range, unless the samples are too far from them.
+// Counter resets are taken into account if isCounter is true.
+func extrapolatedRate(points []Point, rangeStart, rangeEnd int64, isCounter, isRate bool) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	first, last := points[0], points[len(points)-1]
+	result := last.V - first.V
+	if isCounter {
+		prev := first.V
+		for _, p := range points[1:] {
+			if p.V < prev {
+				result += prev
+			}
+			prev = p.V
+		}
+	}
+
+	durationToStart := float64(first.T-rangeStart) / 1000
+	durationToEnd := float64(rangeEnd-last.T) / 1000
+	sampledInterval := float64(last.T-first.T) / 1000
+	averageInterval := sampledInterval / float64(len(points)-1)
+	if isCounter && result > 0 && first.V >= 0 {
+		// Counters can't go below zero, so don't extrapolate beyond the time it would reach zero.
+		durationToZero := sampledInterval * (first.V / result)
+		if durationToZero < durationToStart {
+			durationToStart = durationToZero
+		}
+	}
+
+	// Extrapolate to the boundary only if the sample is close enough to it,
+	// otherwise by half of the average interval.
+	threshold := averageInterval * 1.1
+	extrapolatedInterval := sampledInterval
+	if durationToStart < threshold {
+		extrapolatedInterval += durationToStart
+	} else {
+		extrapolatedInterval += averageInterval / 2
+	}
+	if durationToEnd < threshold {
+		extrapolatedInterval += durationToEnd
+	} else {
+		extrapolatedInterval += averageInterval / 2
+	}
+	result *= extrapolatedInterval / sampledInterval
+	if isRate {
+		result /= float64(rangeEnd-rangeStart) / 1000
+	}
+	return result, true
+}
+
+// instantRate computes the per-second rate from the last two samples.
+func instantRate(points []Point, _, _ int64) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	last, prev := points[len(points)-1], points[len(points)-2]
+	interval := float64(last.T-prev.T) / 1000
+	if interval == 0 {
+		return 0, false
+	}
+	result := last.V - prev.V
+	if last.V < prev.V {
+		// The counter got reset.
+		result = last.V
+	}
+	return result / interval, true
+}
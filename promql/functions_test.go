@@ -0,0 +1,56 @@
+package promql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunctions(t *testing.T) {
+	// A counter increasing by 10 every 10s, which got reset at 40s.
+	counter := []Point{{T: 10000, V: 10}, {T: 20000, V: 20}, {T: 30000, V: 30}, {T: 40000, V: 5}, {T: 50000, V: 15}}
+	tests := []struct {
+		name       string
+		points     []Point
+		rangeStart int64
+		rangeEnd   int64
+		want       float64
+		wantOK     bool
+	}{
+		{name: "rate", points: counter, rangeStart: 0, rangeEnd: 60000, want: 52.5 / 60, wantOK: true},
+		{name: "increase", points: counter, rangeStart: 0, rangeEnd: 60000, want: 52.5, wantOK: true},
+		// The reset isn't taken into account.
+		{name: "delta", points: counter, rangeStart: 0, rangeEnd: 60000, want: 7.5, wantOK: true},
+		{name: "irate", points: counter, rangeStart: 0, rangeEnd: 60000, want: 1, wantOK: true},
+		{name: "avg_over_time", points: counter, want: 16, wantOK: true},
+		{name: "sum_over_time", points: counter, want: 80, wantOK: true},
+		{name: "min_over_time", points: counter, want: 5, wantOK: true},
+		{name: "max_over_time", points: counter, want: 30, wantOK: true},
+		{name: "count_over_time", points: counter, want: 5, wantOK: true},
+		{name: "last_over_time", points: counter, want: 15, wantOK: true},
+		{name: "rate", points: counter[:1], rangeStart: 0, rangeEnd: 60000},
+		{name: "irate", points: counter[:1], rangeStart: 0, rangeEnd: 60000},
+		{name: "avg_over_time", points: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := functions[tt.name].rangeFunc(tt.points, tt.rangeStart, tt.rangeEnd)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.InDelta(t, tt.want, got, 1e-9)
+		})
+	}
+}
+
+func Test_extrapolatedRate(t *testing.T) {
+	// Samples far from the range boundaries are extrapolated by half of the average interval.
+	points := []Point{{T: 100000, V: 0}, {T: 110000, V: 10}, {T: 120000, V: 20}}
+	got, ok := extrapolatedRate(points, 0, 300000, false, false)
+	assert.True(t, ok)
+	assert.InDelta(t, 30, got, 1e-9)
+
+	// Counters aren't extrapolated before they would reach zero.
+	points = []Point{{T: 55000, V: 1}, {T: 60000, V: 2}}
+	got, ok = extrapolatedRate(points, 0, 60000, true, false)
+	assert.True(t, ok)
+	assert.InDelta(t, 2, got, 1e-9)
+}
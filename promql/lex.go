@@ -0,0 +1,290 @@
+package promql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type itemType int
+
+const (
+	itemEOF itemType = iota
+	itemIdentifier
+	itemString
+	itemNumber
+	itemDuration
+	itemLeftBrace
+	itemRightBrace
+	itemLeftParen
+	itemRightParen
+	itemLeftBracket
+	itemRightBracket
+	itemComma
+	itemEQL
+	itemNEQ
+	itemEQLRegex
+	itemNEQRegex
+	itemADD
+	itemSUB
+	itemMUL
+	itemDIV
+	itemMOD
+	itemPOW
+)
+
+var itemNames = map[itemType]string{
+	itemEOF:          "end of input",
+	itemIdentifier:   "identifier",
+	itemString:       "string",
+	itemNumber:       "number",
+	itemDuration:     "duration",
+	itemLeftBrace:    `"{"`,
+	itemRightBrace:   `"}"`,
+	itemLeftParen:    `"("`,
+	itemRightParen:   `")"`,
+	itemLeftBracket:  `"["`,
+	itemRightBracket: `"]"`,
+	itemComma:        `","`,
+	itemEQL:          `"="`,
+	itemNEQ:          `"!="`,
+	itemEQLRegex:     `"=~"`,
+	itemNEQRegex:     `"!~"`,
+	itemADD:          `"+"`,
+	itemSUB:          `"-"`,
+	itemMUL:          `"*"`,
+	itemDIV:          `"/"`,
+	itemMOD:          `"%"`,
+	itemPOW:          `"^"`,
+}
+
+func (t itemType) String() string {
+	if s, ok := itemNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("item(%d)", int(t))
+}
+
+// item is a token of the query.
+type item struct {
+	typ itemType
+	// The position in the query.
+	pos int
+	val string
+}
+
+func (i item) String() string {
+	switch i.typ {
+	case itemEOF:
+		return i.typ.String()
+	case itemIdentifier, itemString, itemNumber, itemDuration:
+		return fmt.Sprintf("%s %q", i.typ, i.val)
+	default:
+		return i.typ.String()
+	}
+}
+
+// lex splits the query into tokens, ending with itemEOF.
+func lex(input string) ([]item, error) {
+	var items []item
+	pos := 0
+	for {
+		for pos < len(input) && isSpace(input[pos]) {
+			pos++
+		}
+		if pos >= len(input) {
+			return append(items, item{typ: itemEOF, pos: pos}), nil
+		}
+		if input[pos] == '#' {
+			// Comments last until the end of the line.
+			for pos < len(input) && input[pos] != '\n' {
+				pos++
+			}
+			continue
+		}
+
+		start := pos
+		c := input[pos]
+		var typ itemType
+		switch {
+		case c == '{':
+			typ, pos = itemLeftBrace, pos+1
+		case c == '}':
+			typ, pos = itemRightBrace, pos+1
+		case c == '(':
+			typ, pos = itemLeftParen, pos+1
+		case c == ')':
+			typ, pos = itemRightParen, pos+1
+		case c == '[':
+			typ, pos = itemLeftBracket, pos+1
+		case c == ']':
+			typ, pos = itemRightBracket, pos+1
+		case c == ',':
+			typ, pos = itemComma, pos+1
+		case c == '+':
+			typ, pos = itemADD, pos+1
+		case c == '-':
+			typ, pos = itemSUB, pos+1
+		case c == '*':
+			typ, pos = itemMUL, pos+1
+		case c == '/':
+			typ, pos = itemDIV, pos+1
+		case c == '%':
+			typ, pos = itemMOD, pos+1
+		case c == '^':
+			typ, pos = itemPOW, pos+1
+		case c == '=':
+			typ, pos = itemEQL, pos+1
+			if pos < len(input) && input[pos] == '~' {
+				typ, pos = itemEQLRegex, pos+1
+			}
+		case c == '!':
+			if pos+1 >= len(input) || (input[pos+1] != '=' && input[pos+1] != '~') {
+				return nil, &ParseError{Pos: pos, Err: fmt.Errorf("unexpected character after '!'")}
+			}
+			typ = itemNEQ
+			if input[pos+1] == '~' {
+				typ = itemNEQRegex
+			}
+			pos += 2
+		case c == '"' || c == '\'' || c == '`':
+			end, err := scanString(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			typ, pos = itemString, end
+		case isDigit(c) || (c == '.' && pos+1 < len(input) && isDigit(input[pos+1])):
+			typ, pos = scanNumber(input, pos)
+		case isAlpha(c) || c == ':':
+			for pos < len(input) && (isAlpha(input[pos]) || isDigit(input[pos]) || input[pos] == ':') {
+				pos++
+			}
+			typ = itemIdentifier
+			switch strings.ToLower(input[start:pos]) {
+			case "inf", "nan":
+				typ = itemNumber
+			}
+		default:
+			r, _ := utf8.DecodeRuneInString(input[pos:])
+			return nil, &ParseError{Pos: pos, Err: fmt.Errorf("unexpected character %q", r)}
+		}
+		items = append(items, item{typ: typ, pos: start, val: input[start:pos]})
+	}
+}
+
+// scanString gives back the end of the quoted string starting at pos.
+func scanString(input string, pos int) (int, error) {
+	quote := input[pos]
+	for i := pos + 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			return i + 1, nil
+		case '\n':
+			if quote != '`' {
+				return 0, &ParseError{Pos: pos, Err: fmt.Errorf("unterminated quoted string")}
+			}
+		}
+	}
+	return 0, &ParseError{Pos: pos, Err: fmt.Errorf("unterminated quoted string")}
+}
+
+// scanNumber scans either a number or a duration like "1h30m", and gives back its end.
+func scanNumber(input string, pos int) (itemType, int) {
+	start := pos
+	if strings.HasPrefix(input[pos:], "0x") || strings.HasPrefix(input[pos:], "0X") {
+		pos += 2
+		for pos < len(input) && isHexDigit(input[pos]) {
+			pos++
+		}
+		return itemNumber, pos
+	}
+	for pos < len(input) && isDigit(input[pos]) {
+		pos++
+	}
+	if pos < len(input) && isDurationUnit(input[pos]) {
+		// Durations consist of integers followed by units.
+		for pos < len(input) && (isDigit(input[pos]) || isDurationUnit(input[pos])) {
+			pos++
+		}
+		return itemDuration, pos
+	}
+	if pos < len(input) && input[pos] == '.' {
+		pos++
+		for pos < len(input) && isDigit(input[pos]) {
+			pos++
+		}
+	}
+	if pos < len(input) && (input[pos] == 'e' || input[pos] == 'E') && pos > start {
+		next := pos + 1
+		if next < len(input) && (input[next] == '+' || input[next] == '-') {
+			next++
+		}
+		if next < len(input) && isDigit(input[next]) {
+			pos = next
+			for pos < len(input) && isDigit(input[pos]) {
+				pos++
+			}
+		}
+	}
+	return itemNumber, pos
+}
+
+// unquote gives back the content of the quoted string.
+func unquote(s string) (string, error) {
+	switch s[0] {
+	case '`':
+		return s[1 : len(s)-1], nil
+	case '\'':
+		// Turn it into a double-quoted string, which strconv can handle.
+		var b strings.Builder
+		b.WriteByte('"')
+		body := s[1 : len(s)-1]
+		for i := 0; i < len(body); i++ {
+			switch {
+			case body[i] == '\\' && i+1 < len(body) && body[i+1] == '\'':
+				b.WriteByte('\'')
+				i++
+			case body[i] == '\\' && i+1 < len(body):
+				b.WriteString(body[i : i+2])
+				i++
+			case body[i] == '"':
+				b.WriteString(`\"`)
+			default:
+				b.WriteByte(body[i])
+			}
+		}
+		b.WriteByte('"')
+		return strconv.Unquote(b.String())
+	default:
+		return strconv.Unquote(s)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isAlpha(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDurationUnit(c byte) bool {
+	switch c {
+	case 's', 'm', 'h', 'd', 'w', 'y':
+		return true
+	}
+	return false
+}
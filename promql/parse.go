@@ -0,0 +1,529 @@
+package promql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// ParseError describes where and why the query is malformed.
+type ParseError struct {
+	// 0-based byte offset in the query.
+	Pos int
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at position %d: %v", e.Pos+1, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+type parser struct {
+	items []item
+	pos   int
+}
+
+// ParseExpr parses the query into the expression.
+func ParseExpr(input string) (Expr, error) {
+	items, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{items: items}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != itemEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return expr, nil
+}
+
+// ParseMetricSelector parses the vector selector like `metric{label="value"}` into matchers.
+func ParseMetricSelector(input string) ([]*tstorage.Matcher, error) {
+	expr, err := ParseExpr(input)
+	if err != nil {
+		return nil, err
+	}
+	vs, ok := expr.(*VectorSelector)
+	if !ok {
+		return nil, &ParseError{Err: fmt.Errorf("want a vector selector, got %s", expr)}
+	}
+	return vs.Matchers, nil
+}
+
+func (p *parser) peek() item {
+	return p.items[p.pos]
+}
+
+func (p *parser) next() item {
+	t := p.items[p.pos]
+	if t.typ != itemEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(typ itemType, context string) (item, error) {
+	t := p.next()
+	if t.typ != typ {
+		return item{}, p.errorf(t, "unexpected %s in %s, want %s", t, context, typ)
+	}
+	return t, nil
+}
+
+func (p *parser) errorf(t item, format string, args ...interface{}) error {
+	return &ParseError{Pos: t.pos, Err: fmt.Errorf(format, args...)}
+}
+
+// Precedences of binary operators, where "^" binds the tightest.
+var binaryPrecedences = map[itemType]int{
+	itemADD: 1,
+	itemSUB: 1,
+	itemMUL: 2,
+	itemDIV: 2,
+	itemMOD: 2,
+	itemPOW: 3,
+}
+
+// aggregators are the names of the supported aggregation operators.
+var aggregators = map[string]bool{
+	"sum":     true,
+	"avg":     true,
+	"min":     true,
+	"max":     true,
+	"count":   true,
+	"topk":    true,
+	"bottomk": true,
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseBinaryExpr(1)
+}
+
+// parseBinaryExpr parses the binary expression consisting of operators whose precedences are at least minPrec.
+func (p *parser) parseBinaryExpr(minPrec int) (Expr, error) {
+	lhs, err := p.parseUnaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		prec, ok := binaryPrecedences[op.typ]
+		if !ok || prec < minPrec {
+			return lhs, nil
+		}
+		p.next()
+		matching, err := p.parseVectorMatching()
+		if err != nil {
+			return nil, err
+		}
+		// "^" is right-associative.
+		nextPrec := prec + 1
+		if op.typ == itemPOW {
+			nextPrec = prec
+		}
+		rhs, err := p.parseBinaryExpr(nextPrec)
+		if err != nil {
+			return nil, err
+		}
+		if lhs, err = p.newBinaryExpr(op, lhs, rhs, matching); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parseVectorMatching parses the optional on(...) or ignoring(...) following the binary operator.
+func (p *parser) parseVectorMatching() (*VectorMatching, error) {
+	t := p.peek()
+	if t.typ != itemIdentifier || (t.val != "on" && t.val != "ignoring") || p.items[p.pos+1].typ != itemLeftParen {
+		return nil, nil
+	}
+	p.next()
+	labels, err := p.parseLabelList()
+	if err != nil {
+		return nil, err
+	}
+	return &VectorMatching{MatchingLabels: labels, On: t.val == "on"}, nil
+}
+
+func (p *parser) newBinaryExpr(op item, lhs, rhs Expr, matching *VectorMatching) (Expr, error) {
+	for _, operand := range []Expr{lhs, rhs} {
+		if typ := operand.Type(); typ != ValueTypeScalar && typ != ValueTypeVector {
+			return nil, p.errorf(op, "binary expression must contain only scalar and instant vector types")
+		}
+	}
+	bothVectors := lhs.Type() == ValueTypeVector && rhs.Type() == ValueTypeVector
+	if matching != nil && !bothVectors {
+		return nil, p.errorf(op, "vector matching only allowed between instant vectors")
+	}
+	if matching == nil && bothVectors {
+		matching = &VectorMatching{}
+	}
+	return &BinaryExpr{Op: op.val, LHS: lhs, RHS: rhs, Matching: matching}, nil
+}
+
+// parseUnaryExpr parses the expression with the optional unary operator, which binds looser than "^".
+func (p *parser) parseUnaryExpr() (Expr, error) {
+	op := p.peek()
+	if op.typ != itemADD && op.typ != itemSUB {
+		return p.parsePrimaryExpr()
+	}
+	p.next()
+	expr, err := p.parseBinaryExpr(binaryPrecedences[itemPOW])
+	if err != nil {
+		return nil, err
+	}
+	if typ := expr.Type(); typ != ValueTypeScalar && typ != ValueTypeVector {
+		return nil, p.errorf(op, "unary expression only allowed on expressions of type scalar or instant vector, got %s", typ)
+	}
+	if op.typ == itemADD {
+		return expr, nil
+	}
+	if n, ok := expr.(*NumberLiteral); ok {
+		return &NumberLiteral{Val: -n.Val}, nil
+	}
+	return &UnaryExpr{Op: op.val, Expr: expr}, nil
+}
+
+func (p *parser) parsePrimaryExpr() (Expr, error) {
+	var expr Expr
+	var err error
+	t := p.peek()
+	switch {
+	case t.typ == itemNumber:
+		expr, err = p.parseNumber()
+	case t.typ == itemLeftParen:
+		p.next()
+		var inner Expr
+		if inner, err = p.parseExpr(); err == nil {
+			_, err = p.expect(itemRightParen, "parenthesized expression")
+		}
+		expr = &ParenExpr{Expr: inner}
+	case t.typ == itemIdentifier && aggregators[t.val] && p.isAggregateExpr():
+		expr, err = p.parseAggregateExpr()
+	case t.typ == itemIdentifier && p.items[p.pos+1].typ == itemLeftParen:
+		expr, err = p.parseCall()
+	case t.typ == itemIdentifier || t.typ == itemLeftBrace:
+		expr, err = p.parseVectorSelector()
+	default:
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ == itemLeftBracket {
+		return p.parseMatrixSelector(expr)
+	}
+	return expr, nil
+}
+
+func (p *parser) parseNumber() (Expr, error) {
+	t := p.next()
+	var v float64
+	if len(t.val) > 1 && (t.val[1] == 'x' || t.val[1] == 'X') {
+		n, err := strconv.ParseInt(t.val, 0, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %q", t.val)
+		}
+		v = float64(n)
+	} else {
+		var err error
+		if v, err = strconv.ParseFloat(t.val, 64); err != nil {
+			return nil, p.errorf(t, "invalid number %q", t.val)
+		}
+	}
+	return &NumberLiteral{Val: v}, nil
+}
+
+// isAggregateExpr reports whether the aggregator name at the current position starts an aggregation,
+// rather than being a metric name.
+func (p *parser) isAggregateExpr() bool {
+	switch t := p.items[p.pos+1]; {
+	case t.typ == itemLeftParen:
+		return true
+	case t.typ == itemIdentifier && (t.val == "by" || t.val == "without"):
+		return p.items[p.pos+2].typ == itemLeftParen
+	default:
+		return false
+	}
+}
+
+// parseAggregateExpr parses aggregations in either form of "sum by (job) (expr)" or "sum(expr) by (job)".
+func (p *parser) parseAggregateExpr() (Expr, error) {
+	op := p.next()
+	agg := &AggregateExpr{Op: op.val}
+	parseGrouping := func() error {
+		t := p.peek()
+		if t.typ != itemIdentifier || (t.val != "by" && t.val != "without") {
+			return nil
+		}
+		p.next()
+		labels, err := p.parseLabelList()
+		if err != nil {
+			return err
+		}
+		agg.Grouping, agg.Without = labels, t.val == "without"
+		return nil
+	}
+	if err := parseGrouping(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(itemLeftParen, "aggregation"); err != nil {
+		return nil, err
+	}
+	var args []Expr
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek().typ != itemComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(itemRightParen, "aggregation"); err != nil {
+		return nil, err
+	}
+	if agg.Grouping == nil && !agg.Without {
+		if err := parseGrouping(); err != nil {
+			return nil, err
+		}
+	}
+
+	wantArgs := 1
+	if agg.Op == "topk" || agg.Op == "bottomk" {
+		wantArgs = 2
+	}
+	if len(args) != wantArgs {
+		return nil, p.errorf(op, "wrong number of arguments for aggregate expression provided, expected %d, got %d", wantArgs, len(args))
+	}
+	if wantArgs == 2 {
+		agg.Param = args[0]
+		if agg.Param.Type() != ValueTypeScalar {
+			return nil, p.errorf(op, "expected type %s in aggregation parameter, got %s", ValueTypeScalar, agg.Param.Type())
+		}
+	}
+	agg.Expr = args[len(args)-1]
+	if agg.Expr.Type() != ValueTypeVector {
+		return nil, p.errorf(op, "expected type %s in aggregation expression, got %s", ValueTypeVector, agg.Expr.Type())
+	}
+	return agg, nil
+}
+
+// parseLabelList parses the list of label names like "(job, instance)".
+func (p *parser) parseLabelList() ([]string, error) {
+	if _, err := p.expect(itemLeftParen, "grouping"); err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0)
+	for {
+		if p.peek().typ == itemRightParen {
+			p.next()
+			return labels, nil
+		}
+		name, err := p.expect(itemIdentifier, "grouping")
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, name.val)
+
+		switch t := p.next(); t.typ {
+		case itemComma:
+		case itemRightParen:
+			return labels, nil
+		default:
+			return nil, p.errorf(t, "unexpected %s in grouping, want \",\" or \")\"", t)
+		}
+	}
+}
+
+func (p *parser) parseCall() (Expr, error) {
+	name := p.next()
+	fn, ok := functions[name.val]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %q", name.val)
+	}
+	p.next() // "("
+	var args []Expr
+	if p.peek().typ != itemRightParen {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().typ != itemComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if _, err := p.expect(itemRightParen, "function call"); err != nil {
+		return nil, err
+	}
+	if len(args) != len(fn.ArgTypes) {
+		return nil, p.errorf(name, "function %q takes %d arguments, got %d", fn.Name, len(fn.ArgTypes), len(args))
+	}
+	for i, arg := range args {
+		if arg.Type() != fn.ArgTypes[i] {
+			return nil, p.errorf(name, "expected type %s in call to %q, got %s", fn.ArgTypes[i], fn.Name, arg.Type())
+		}
+	}
+	return &Call{Func: fn, Args: args}, nil
+}
+
+func (p *parser) parseVectorSelector() (Expr, error) {
+	start := p.peek()
+	vs := &VectorSelector{}
+	if start.typ == itemIdentifier {
+		vs.Name = p.next().val
+		m, err := tstorage.NewMatcher(tstorage.MatchEqual, tstorage.MetricNameLabel, vs.Name)
+		if err != nil {
+			return nil, err
+		}
+		vs.Matchers = append(vs.Matchers, m)
+	}
+	if p.peek().typ == itemLeftBrace {
+		p.next()
+		matchers, err := p.parseMatchers()
+		if err != nil {
+			return nil, err
+		}
+		vs.Matchers = append(vs.Matchers, matchers...)
+	}
+
+	for _, m := range vs.Matchers {
+		if !m.Matches("") {
+			return vs, nil
+		}
+	}
+	return nil, p.errorf(start, "vector selector must contain at least one non-empty matcher")
+}
+
+func (p *parser) parseMatchers() ([]*tstorage.Matcher, error) {
+	var matchers []*tstorage.Matcher
+	for {
+		if p.peek().typ == itemRightBrace {
+			p.next()
+			return matchers, nil
+		}
+		name, err := p.expect(itemIdentifier, "label matching")
+		if err != nil {
+			return nil, err
+		}
+		op := p.next()
+		var typ tstorage.MatchType
+		switch op.typ {
+		case itemEQL:
+			typ = tstorage.MatchEqual
+		case itemNEQ:
+			typ = tstorage.MatchNotEqual
+		case itemEQLRegex:
+			typ = tstorage.MatchRegexp
+		case itemNEQRegex:
+			typ = tstorage.MatchNotRegexp
+		default:
+			return nil, p.errorf(op, "unexpected %s in label matching, want a match operator", op)
+		}
+		str, err := p.expect(itemString, "label matching")
+		if err != nil {
+			return nil, err
+		}
+		value, err := unquote(str.val)
+		if err != nil {
+			return nil, p.errorf(str, "invalid string %s: %v", str.val, err)
+		}
+		m, err := tstorage.NewMatcher(typ, name.val, value)
+		if err != nil {
+			return nil, p.errorf(str, "%v", err)
+		}
+		matchers = append(matchers, m)
+
+		switch t := p.next(); t.typ {
+		case itemComma:
+		case itemRightBrace:
+			return matchers, nil
+		default:
+			return nil, p.errorf(t, "unexpected %s in label matching, want \",\" or \"}\"", t)
+		}
+	}
+}
+
+func (p *parser) parseMatrixSelector(expr Expr) (Expr, error) {
+	bracket := p.next()
+	vs, ok := expr.(*VectorSelector)
+	if !ok {
+		return nil, p.errorf(bracket, "ranges only allowed for vector selectors")
+	}
+	t, err := p.expect(itemDuration, "matrix selector")
+	if err != nil {
+		return nil, err
+	}
+	d, err := ParseDuration(t.val)
+	if err != nil {
+		return nil, p.errorf(t, "%v", err)
+	}
+	if d <= 0 {
+		return nil, p.errorf(t, "range must be positive")
+	}
+	if _, err := p.expect(itemRightBracket, "matrix selector"); err != nil {
+		return nil, err
+	}
+	return &MatrixSelector{VectorSelector: vs, Range: d}, nil
+}
+
+// ParseDuration parses durations like "1h30m" with units ms, s, m, h, d, w and y.
+func ParseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  24 * time.Hour,
+		"w":  7 * 24 * time.Hour,
+		"y":  365 * 24 * time.Hour,
+	}
+	orig := s
+	var d time.Duration
+	for s != "" {
+		i := 0
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		n, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		s = s[i:]
+		if s == "" {
+			return 0, fmt.Errorf("missing unit in duration %q", orig)
+		}
+		unit := s[:1]
+		if len(s) >= 2 && s[:2] == "ms" {
+			unit = "ms"
+		}
+		u, ok := units[unit]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", orig)
+		}
+		s = s[len(unit):]
+		if n > int64((1<<63-1)/u) {
+			return 0, errors.New("duration out of range")
+		}
+		d += time.Duration(n) * u
+	}
+	return d, nil
+}
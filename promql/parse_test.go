@@ -0,0 +1,130 @@
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// The expression formatted back.
+		want    string
+		wantErr bool
+	}{
+		{name: "metric name", input: "up", want: "up"},
+		{name: "metric name with colons", input: "job:requests:rate5m", want: "job:requests:rate5m"},
+		{name: "matchers", input: `http_requests_total{job="api", code=~"5.." , method!='GET',path!~` + "`/debug.*`" + `,}`, want: `http_requests_total{job="api",code=~"5..",method!="GET",path!~"/debug.*"}`},
+		{name: "matchers only", input: `{__name__=~"http_.*"}`, want: `{__name__=~"http_.*"}`},
+		{name: "escaped string", input: `up{path="a\"b\\c", q='it\'s'}`, want: `up{path="a\"b\\c",q="it's"}`},
+		{name: "matrix selector", input: "up[1h30m]", want: "up[1h30m]"},
+		{name: "function", input: `rate(http_requests_total{job="api"}[5m])`, want: `rate(http_requests_total{job="api"}[5m])`},
+		{name: "comment", input: "up # the comment", want: "up"},
+		{name: "numbers", input: "1.5e3 + 0x1f - -2 + Inf", want: "1500 + 31 - -2 + +Inf"},
+		{name: "arithmetic", input: "(up + 1) * 2 ^ 3", want: "(up + 1) * 2 ^ 3"},
+		{name: "unary", input: "-up", want: "-up"},
+		{name: "vector matching", input: `a / on(job) b + ignoring (instance, path) c`, want: "a / on (job) b + ignoring (instance, path) c"},
+		{name: "aggregation", input: "sum(rate(up[5m])) by (job)", want: "sum by (job) (rate(up[5m]))"},
+		{name: "aggregation with leading grouping", input: "avg without (instance) (up)", want: "avg without (instance) (up)"},
+		{name: "topk", input: "topk(3, sum by (job) (up))", want: "topk(3, sum by (job) (up))"},
+		{name: "aggregator name as metric name", input: "sum + count", want: "sum + count"},
+		{name: "arithmetic on range vector", input: "up[5m] * 2", wantErr: true},
+		{name: "vector matching between scalars", input: "1 + on(job) 2", wantErr: true},
+		{name: "aggregation of scalar", input: "sum(1)", wantErr: true},
+		{name: "topk without parameter", input: "topk(up)", wantErr: true},
+		{name: "topk with vector parameter", input: "topk(up, up)", wantErr: true},
+		{name: "unclosed paren", input: "(up + 1", wantErr: true},
+		{name: "missing operand", input: "up *", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+		{name: "empty matchers", input: "{}", wantErr: true},
+		{name: "only empty-matching matchers", input: `{job=~".*"}`, wantErr: true},
+		{name: "unknown function", input: "foo(up[5m])", wantErr: true},
+		{name: "wrong argument type", input: "rate(up)", wantErr: true},
+		{name: "wrong number of arguments", input: "rate(up[5m], up[5m])", wantErr: true},
+		{name: "range of function", input: "rate(up[5m])[5m]", wantErr: true},
+		{name: "duration without unit", input: "up[5]", wantErr: true},
+		{name: "unterminated string", input: `up{job="api}`, wantErr: true},
+		{name: "invalid regexp", input: `up{job=~"("}`, wantErr: true},
+		{name: "missing operator", input: `up{job "api"}`, wantErr: true},
+		{name: "trailing tokens", input: "up up", wantErr: true},
+		{name: "unexpected character", input: "up{job=\"a\"}; up", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExpr(tt.input)
+			if tt.wantErr {
+				var perr *ParseError
+				assert.ErrorAs(t, err, &perr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+}
+
+func TestParseExpr_precedence(t *testing.T) {
+	// 1 - 2 * -3 ^ 2 ^ 0.5 - 4 is (1 - (2 * -(3 ^ (2 ^ 0.5)))) - 4.
+	got, err := ParseExpr("1 - 2 * -3 ^ 2 ^ 0.5 - 4")
+	require.NoError(t, err)
+	outer, ok := got.(*BinaryExpr)
+	require.True(t, ok)
+	assert.Equal(t, "-", outer.Op)
+	assert.Equal(t, "4", outer.RHS.String())
+
+	inner := outer.LHS.(*BinaryExpr)
+	assert.Equal(t, "-", inner.Op)
+	mul := inner.RHS.(*BinaryExpr)
+	assert.Equal(t, "*", mul.Op)
+	neg := mul.RHS.(*UnaryExpr)
+	pow := neg.Expr.(*BinaryExpr)
+	assert.Equal(t, "3", pow.LHS.String())
+	assert.Equal(t, "2 ^ 0.5", pow.RHS.String())
+	assert.Equal(t, ValueTypeScalar, got.Type())
+}
+
+func TestParseMetricSelector(t *testing.T) {
+	got, err := ParseMetricSelector(`up{job="api"}`)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, `__name__="up"`, got[0].String())
+	assert.Equal(t, tstorage.MatchEqual, got[1].Type)
+
+	_, err = ParseMetricSelector(`rate(up[5m])`)
+	assert.Error(t, err)
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{input: "5m", want: 5 * time.Minute},
+		{input: "1h30m", want: 90 * time.Minute},
+		{input: "100ms", want: 100 * time.Millisecond},
+		{input: "2d", want: 48 * time.Hour},
+		{input: "1w", want: 7 * 24 * time.Hour},
+		{input: "1y", want: 365 * 24 * time.Hour},
+		{input: "5", wantErr: true},
+		{input: "5x", wantErr: true},
+		{input: "m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseDuration(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,272 @@
+// Package remote implements the Prometheus remote write receiver and remote read endpoint backed by tstorage,
+// which lets tstorage act as a long-term storage for Prometheus.
+//
+// Give the handler to the http.Server, then configure Prometheus like:
+//
+//	remote_write:
+//	  - url: http://localhost:9201/api/v1/write
+//	remote_read:
+//	  - url: http://localhost:9201/api/v1/read
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/prompb"
+	"github.com/nakabonne/tstorage/internal/snappy"
+	"github.com/nakabonne/tstorage/internal/timeutil"
+)
+
+const defaultMaxRequestSize = 32 << 20
+
+// Option is an optional setting for handlers.
+type Option func(*options)
+
+type options struct {
+	maxRequestSize int
+}
+
+// WithMaxRequestSize specifies the maximum byte size of decompressed requests.
+//
+// Defaults to 32MiB.
+func WithMaxRequestSize(size int) Option {
+	return func(o *options) {
+		o.maxRequestSize = size
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		maxRequestSize: defaultMaxRequestSize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// NewHandler gives back a handler that serves remote write at /api/v1/write and remote read at /api/v1/read.
+func NewHandler[T any](s tstorage.Storage[T], opts ...Option) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/write", NewWriteHandler(s, opts...))
+	mux.Handle("/api/v1/read", NewReadHandler(s, opts...))
+	return mux
+}
+
+// NewWriteHandler gives back a handler that ingests Prometheus remote write requests into the given storage.
+// Samples rejected by the storage, such as duplicates under tstorage.RejectDuplicates, are responded with 400 so that Prometheus doesn't retry them,
+// while the rest are still ingested. Stale markers are stored as tstorage.StaleNaN like the ones the scraper writes.
+// Timestamps in milliseconds Prometheus uses get converted into the storage's precision.
+func NewWriteHandler[T any](s tstorage.Storage[T], opts ...Option) http.Handler {
+	o := newOptions(opts)
+	precision := s.TimestampPrecision()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req prompb.WriteRequest
+		if status, err := readRequest(r, o.maxRequestSize, req.Unmarshal); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		rows := make([]tstorage.Row[T], 0)
+		for i := range req.Timeseries {
+			ts := &req.Timeseries[i]
+			metric, labels := fromLabels(ts.Labels)
+			if metric == "" {
+				http.Error(w, fmt.Sprintf("series without %s label found", tstorage.MetricNameLabel), http.StatusBadRequest)
+				return
+			}
+			for _, sample := range ts.Samples {
+				rows = append(rows, tstorage.Row[T]{
+					Metric: metric,
+					Labels: labels,
+					DataPoint: tstorage.DataPoint[T]{
+						Timestamp: timeutil.FromMillis(sample.Timestamp, precision),
+						Value:     sample.Value,
+					},
+				})
+			}
+		}
+		if len(rows) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		res, err := s.InsertRowsDetailed(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(res.Errors) > 0 {
+			first := res.Rejected()[0]
+			http.Error(w, fmt.Sprintf("%d of %d samples were rejected, including: %v", len(res.Errors), len(rows), res.Errors[first]), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// NewReadHandler gives back a handler that serves Prometheus remote read requests from the given storage.
+// Only the SAMPLES response type is supported.
+func NewReadHandler[T any](s tstorage.Storage[T], opts ...Option) http.Handler {
+	o := newOptions(opts)
+	precision := s.TimestampPrecision()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req prompb.ReadRequest
+		if status, err := readRequest(r, o.maxRequestSize, req.Unmarshal); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if !acceptsSamples(req.AcceptedResponseTypes) {
+			http.Error(w, "only the SAMPLES response type is supported", http.StatusBadRequest)
+			return
+		}
+
+		resp := prompb.ReadResponse{
+			Results: make([]prompb.QueryResult, 0, len(req.Queries)),
+		}
+		for i := range req.Queries {
+			result, err := query(s, &req.Queries[i], precision)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			resp.Results = append(resp.Results, result)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Header().Set("Content-Encoding", "snappy")
+		w.Write(snappy.Encode(nil, resp.Marshal()))
+	})
+}
+
+// readRequest reads the snappy-compressed message from the body, and gives back the status code on failure.
+func readRequest(r *http.Request, maxSize int, unmarshal func([]byte) error) (int, error) {
+	compressed, err := io.ReadAll(io.LimitReader(r.Body, int64(maxSize)+1))
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to read the request body: %w", err)
+	}
+	if len(compressed) > maxSize {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("the request body exceeds %d bytes", maxSize)
+	}
+	n, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if n > maxSize {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("the decompressed request exceeds %d bytes", maxSize)
+	}
+	b, err := snappy.Decode(compressed)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := unmarshal(b); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, nil
+}
+
+func acceptsSamples(types []prompb.ResponseType) bool {
+	// Empty means SAMPLES for backward compatibility.
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == prompb.ResponseTypeSamples {
+			return true
+		}
+	}
+	return false
+}
+
+func query[T any](s tstorage.Storage[T], q *prompb.Query, precision tstorage.TimestampPrecision) (prompb.QueryResult, error) {
+	matchers := make([]*tstorage.Matcher, 0, len(q.Matchers))
+	for _, m := range q.Matchers {
+		matcher, err := toMatcher(m)
+		if err != nil {
+			return prompb.QueryResult{}, err
+		}
+		matchers = append(matchers, matcher)
+	}
+	// The end in remote read is inclusive.
+	start := timeutil.FromMillis(q.StartTimestampMs, precision)
+	end := timeutil.CeilFromMillis(q.EndTimestampMs+1, precision)
+	result := prompb.QueryResult{
+		Timeseries: make([]prompb.TimeSeries, 0),
+	}
+	if start >= end {
+		return result, nil
+	}
+	series, err := s.SelectSeries(matchers, start, end)
+	if err != nil {
+		return prompb.QueryResult{}, fmt.Errorf("failed to select series: %w", err)
+	}
+	for _, ss := range series {
+		ts := prompb.TimeSeries{
+			Labels:  toLabels(ss.Metric, ss.Labels),
+			Samples: make([]prompb.Sample, 0, len(ss.Points)),
+		}
+		for _, p := range ss.Points {
+			ts.Samples = append(ts.Samples, prompb.Sample{
+				Value:     p.Value,
+				Timestamp: timeutil.ToMillis(p.Timestamp, precision),
+			})
+		}
+		result.Timeseries = append(result.Timeseries, ts)
+	}
+	return result, nil
+}
+
+func toMatcher(m prompb.LabelMatcher) (*tstorage.Matcher, error) {
+	var t tstorage.MatchType
+	switch m.Type {
+	case prompb.MatchEqual:
+		t = tstorage.MatchEqual
+	case prompb.MatchNotEqual:
+		t = tstorage.MatchNotEqual
+	case prompb.MatchRegexp:
+		t = tstorage.MatchRegexp
+	case prompb.MatchNotRegexp:
+		t = tstorage.MatchNotRegexp
+	default:
+		return nil, errors.New("unknown matcher type")
+	}
+	return tstorage.NewMatcher(t, m.Name, m.Value)
+}
+
+// fromLabels splits the Prometheus labels into the metric name and the rest.
+func fromLabels(labels []prompb.Label) (string, []tstorage.Label) {
+	var metric string
+	out := make([]tstorage.Label, 0, len(labels))
+	for _, l := range labels {
+		if l.Name == tstorage.MetricNameLabel {
+			metric = l.Value
+			continue
+		}
+		out = append(out, tstorage.Label{Name: l.Name, Value: l.Value})
+	}
+	return metric, out
+}
+
+// toLabels gives back the Prometheus labels sorted by name, including the metric name.
+func toLabels(metric string, labels []tstorage.Label) []prompb.Label {
+	out := make([]prompb.Label, 0, len(labels)+1)
+	out = append(out, prompb.Label{Name: tstorage.MetricNameLabel, Value: metric})
+	for _, l := range labels {
+		out = append(out, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,233 @@
+package remote
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/prompb"
+	"github.com/nakabonne/tstorage/internal/snappy"
+)
+
+// client sends requests in the same manner as Prometheus does.
+type client struct {
+	t   *testing.T
+	url string
+}
+
+func (c *client) post(path string, msg []byte) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, c.url+path, bytes.NewReader(snappy.Encode(nil, msg)))
+	require.NoError(c.t, err)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(c.t, err)
+	return resp
+}
+
+func (c *client) write(req *prompb.WriteRequest) int {
+	c.t.Helper()
+	resp := c.post("/api/v1/write", req.Marshal())
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func (c *client) read(req *prompb.ReadRequest) *prompb.ReadResponse {
+	c.t.Helper()
+	resp := c.post("/api/v1/read", req.Marshal())
+	defer resp.Body.Close()
+	require.Equal(c.t, http.StatusOK, resp.StatusCode)
+	assert.Equal(c.t, "snappy", resp.Header.Get("Content-Encoding"))
+	compressed, err := io.ReadAll(resp.Body)
+	require.NoError(c.t, err)
+	b, err := snappy.Decode(compressed)
+	require.NoError(c.t, err)
+	var out prompb.ReadResponse
+	require.NoError(c.t, out.Unmarshal(b))
+	return &out
+}
+
+func newTestServer(t *testing.T, opts ...Option) (*client, tstorage.Storage[float64]) {
+	storage, err := tstorage.NewStorage(
+		tstorage.WithTimestampPrecision[float64](tstorage.Milliseconds),
+		tstorage.WithDuplicatePointPolicy[float64](tstorage.RejectDuplicates),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	server := httptest.NewServer(NewHandler(storage, opts...))
+	t.Cleanup(server.Close)
+	return &client{t: t, url: server.URL}, storage
+}
+
+func TestHandler_write_read(t *testing.T) {
+	c, storage := newTestServer(t)
+	status := c.write(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{{Name: "__name__", Value: "metric1"}, {Name: "host", Value: "host-1"}},
+				Samples: []prompb.Sample{
+					{Value: 0.1, Timestamp: 1600000000000},
+					{Value: 0.2, Timestamp: 1600000001000},
+					{Value: tstorage.StaleNaN, Timestamp: 1600000002000},
+				},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "metric1"}, {Name: "host", Value: "host-2"}},
+				Samples: []prompb.Sample{{Value: 0.3, Timestamp: 1600000000000}},
+			},
+		},
+	})
+	require.Equal(t, http.StatusNoContent, status)
+	points, err := storage.Select("metric1", []tstorage.Label{{Name: "host", Value: "host-1"}}, 1600000000000, 1600000003000)
+	require.NoError(t, err)
+	assert.Len(t, points, 3)
+
+	got := c.read(&prompb.ReadRequest{
+		Queries: []prompb.Query{
+			{
+				StartTimestampMs: 1600000000000,
+				EndTimestampMs:   1600000001000,
+				Matchers: []prompb.LabelMatcher{
+					{Type: prompb.MatchEqual, Name: "__name__", Value: "metric1"},
+					{Type: prompb.MatchRegexp, Name: "host", Value: "host-1|host-3"},
+				},
+			},
+			{
+				StartTimestampMs: 1600000001000,
+				EndTimestampMs:   1600000002000,
+				Matchers: []prompb.LabelMatcher{
+					{Type: prompb.MatchNotEqual, Name: "host", Value: "host-1"},
+				},
+			},
+		},
+		AcceptedResponseTypes: []prompb.ResponseType{prompb.ResponseTypeSamples},
+	})
+	want := &prompb.ReadResponse{
+		Results: []prompb.QueryResult{
+			{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Labels: []prompb.Label{{Name: "__name__", Value: "metric1"}, {Name: "host", Value: "host-1"}},
+						Samples: []prompb.Sample{
+							{Value: 0.1, Timestamp: 1600000000000},
+							// The end is inclusive.
+							{Value: 0.2, Timestamp: 1600000001000},
+						},
+					},
+				},
+			},
+			{},
+		},
+	}
+	assert.Equal(t, want, got)
+
+	// The stale marker is read back as is.
+	got = c.read(&prompb.ReadRequest{
+		Queries: []prompb.Query{
+			{
+				StartTimestampMs: 1600000002000,
+				EndTimestampMs:   1600000002000,
+				Matchers: []prompb.LabelMatcher{
+					{Type: prompb.MatchEqual, Name: "host", Value: "host-1"},
+				},
+			},
+		},
+		AcceptedResponseTypes: []prompb.ResponseType{prompb.ResponseTypeSamples},
+	})
+	require.Len(t, got.Results, 1)
+	require.Len(t, got.Results[0].Timeseries, 1)
+	samples := got.Results[0].Timeseries[0].Samples
+	require.Len(t, samples, 1)
+	assert.Equal(t, int64(1600000002000), samples[0].Timestamp)
+	assert.True(t, tstorage.IsStaleNaN(samples[0].Value))
+}
+
+func TestHandler_write_rejected(t *testing.T) {
+	c, _ := newTestServer(t)
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "metric1"}},
+				Samples: []prompb.Sample{{Value: 0.1, Timestamp: 1600000000000}},
+			},
+		},
+	}
+	require.Equal(t, http.StatusNoContent, c.write(req))
+	// Sending it again is rejected as duplicates.
+	assert.Equal(t, http.StatusBadRequest, c.write(req))
+
+	assert.Equal(t, http.StatusBadRequest, c.write(&prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "host", Value: "host-1"}},
+				Samples: []prompb.Sample{{Value: 0.1, Timestamp: 1600000000000}},
+			},
+		},
+	}))
+}
+
+func TestHandler_invalidRequests(t *testing.T) {
+	c, _ := newTestServer(t, WithMaxRequestSize(1024))
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       []byte
+		wantStatus int
+	}{
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			path:       "/api/v1/write",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "not compressed",
+			method:     http.MethodPost,
+			path:       "/api/v1/write",
+			body:       []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "not protobuf",
+			method:     http.MethodPost,
+			path:       "/api/v1/write",
+			body:       snappy.Encode(nil, []byte{0x0a, 0x05}),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too large",
+			method:     http.MethodPost,
+			path:       "/api/v1/write",
+			body:       snappy.Encode(nil, make([]byte, 2048)),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "streamed chunks only",
+			method: http.MethodPost,
+			path:   "/api/v1/read",
+			body: snappy.Encode(nil, (&prompb.ReadRequest{
+				AcceptedResponseTypes: []prompb.ResponseType{prompb.ResponseTypeStreamedXORChunks},
+			}).Marshal()),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, c.url+tt.path, bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
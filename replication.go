@@ -0,0 +1,408 @@
+package tstorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// The replication stream starts with the same header as WAL segments, followed by frames as shown below:
+/*
+   +----------+------------------------+---------+
+   | kind(1b) | len payload(varints)   | payload |
+   +----------+------------------------+---------+
+*/
+type replicationFrameKind byte
+
+const (
+	// The payload consists of WAL records in the format given in the header.
+	frameRecords replicationFrameKind = iota + 1
+	// The payload consists of the min and max timestamps (varints) of the partition persisted on the primary.
+	framePartitionFlushed
+)
+
+const (
+	// The number of frames buffered for each follower, beyond which it gets disconnected.
+	replicationBufferSize = 1024
+	// The number of rows held in a single frame when catching up.
+	replicationBatchSize = 4096
+	// Frames larger than it are regarded as corrupted.
+	maxReplicationFrameSize = 1 << 30
+)
+
+var errReplicaTooSlow = errors.New("follower fell too far behind")
+
+// ReplicationSource streams WAL records and partition flush events written to a primary storage,
+// so that Followers can replicate it as a hot standby.
+//
+// A follower first receives the records held in the current WAL, followed by the ones written afterwards.
+// Data points already persisted into disk partitions aren't streamed, so a follower should start with
+// a copy of the primary's data directory, or both should start empty.
+// Records are streamed once written to WAL, so failing over loses at most the records yet to be fsynced.
+type ReplicationSource[T any] struct {
+	hub *replicationHub
+	wal *diskWAL[T]
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	doneCh    chan struct{}
+	closed    bool
+}
+
+// NewReplicationSource gives back a ReplicationSource for the given storage,
+// which must be an on-disk storage using WAL.
+func NewReplicationSource[T any](s Storage[T]) (*ReplicationSource[T], error) {
+	st, ok := s.(*storage[T])
+	if !ok {
+		return nil, fmt.Errorf("unsupported storage implementation %T", s)
+	}
+	w, ok := st.wal.(*diskWAL[T])
+	if !ok {
+		return nil, fmt.Errorf("replication requires WAL, which is enabled by giving WithDataPath")
+	}
+	w.setObserver(st.replication)
+	return &ReplicationSource[T]{
+		hub:    st.replication,
+		wal:    w,
+		doneCh: make(chan struct{}),
+	}, nil
+}
+
+// WriteTo streams to w until the source or the storage gets closed, or an error occurs.
+// It gives back an error if the follower can't keep up with the primary.
+func (r *ReplicationSource[T]) WriteTo(w io.Writer) (int64, error) {
+	rep, err := r.subscribe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.hub.remove(rep)
+
+	bw := bufio.NewWriter(w)
+	var written int64
+	write := func(frame []byte) error {
+		n, err := bw.Write(frame)
+		written += int64(n)
+		return err
+	}
+	header := append(append([]byte{}, walSegmentMagic...), walFormatLatest)
+	if err := write(header); err != nil {
+		return written, err
+	}
+	for _, frame := range rep.pending {
+		if err := write(frame); err != nil {
+			return written, err
+		}
+	}
+	rep.pending = nil
+
+	for {
+		if err := bw.Flush(); err != nil {
+			return written, err
+		}
+		select {
+		case frame := <-rep.frames:
+			if err := write(frame); err != nil {
+				return written, err
+			}
+			// Write out frames already queued before flushing.
+			for len(rep.frames) > 0 {
+				if err := write(<-rep.frames); err != nil {
+					return written, err
+				}
+			}
+		case <-rep.droppedCh:
+			if rep.err != nil {
+				return written, rep.err
+			}
+			// The storage has been closed; write out the rest.
+			for len(rep.frames) > 0 {
+				if err := write(<-rep.frames); err != nil {
+					return written, err
+				}
+			}
+			return written, bw.Flush()
+		case <-r.doneCh:
+			return written, bw.Flush()
+		}
+	}
+}
+
+// subscribe registers a new follower, which holds the records in the current WAL as pending frames.
+func (r *ReplicationSource[T]) subscribe() (*replica, error) {
+	r.mu.Lock()
+	closed := r.closed
+	r.mu.Unlock()
+	if closed {
+		return nil, fmt.Errorf("replication source already closed")
+	}
+
+	var rep *replica
+	err := r.wal.withRecords(func(rows []Row[T]) {
+		pending := make([][]byte, 0, len(rows)/replicationBatchSize+1)
+		var records []byte
+		for i := range rows {
+			records = appendInsertRecord(records, &rows[i])
+			if (i+1)%replicationBatchSize == 0 {
+				pending = append(pending, newReplicationFrame(frameRecords, records))
+				records = nil
+			}
+		}
+		if len(records) > 0 {
+			pending = append(pending, newReplicationFrame(frameRecords, records))
+		}
+		// Register while holding the WAL lock so that no record is missed or duplicated.
+		rep = r.hub.add(pending)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL: %w", err)
+	}
+	return rep, nil
+}
+
+// Serve accepts connections on the given listener, and streams to each of them
+// until the source gets closed. Connections get closed when streaming ends.
+func (r *ReplicationSource[T]) Serve(l net.Listener) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return fmt.Errorf("replication source already closed")
+	}
+	r.listeners = append(r.listeners, l)
+	r.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-r.doneCh:
+				return nil
+			default:
+				return err
+			}
+		}
+		go func() {
+			defer conn.Close()
+			r.WriteTo(conn)
+		}()
+	}
+}
+
+// Close stops streaming to all followers and closes the listeners given to Serve.
+func (r *ReplicationSource[T]) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.doneCh)
+	for _, l := range r.listeners {
+		l.Close()
+	}
+	return nil
+}
+
+// Follower applies the replication stream from a ReplicationSource to its own storage.
+// The storage should be configured the same as the primary, in terms of partition duration
+// and timestamp precision. To fail over, stop reading the stream and use the storage as a primary.
+//
+// The storage must be created with WithDuplicatePointPolicy(RejectDuplicates), since the primary
+// streams its whole WAL again on every connection. Hence data points sharing a timestamp in a series
+// are held only once, even if the primary keeps all of them.
+type Follower[T any] struct {
+	storage Storage[T]
+}
+
+// NewFollower gives back a Follower that applies the replication stream to the given storage.
+func NewFollower[T any](s Storage[T]) *Follower[T] {
+	return &Follower[T]{storage: s}
+}
+
+// ReadFrom applies the replication stream read from r until EOF.
+// Data points already held, such as the ones streamed again after reconnecting, are ignored.
+func (f *Follower[T]) ReadFrom(r io.Reader) (int64, error) {
+	if s, ok := f.storage.(*storage[T]); ok && s.duplicatePolicy != RejectDuplicates {
+		return 0, fmt.Errorf("follower storage must reject duplicate data points, which is enabled by giving WithDuplicatePointPolicy(RejectDuplicates)")
+	}
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	header := make([]byte, len(walSegmentMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return cr.n, fmt.Errorf("failed to read the stream header: %w", err)
+	}
+	if !bytes.Equal(header[:len(walSegmentMagic)], walSegmentMagic) {
+		return cr.n, fmt.Errorf("invalid magic number %x", header[:len(walSegmentMagic)])
+	}
+	version := header[len(walSegmentMagic)]
+	if version < walFormatV1 || version > walFormatLatest {
+		return cr.n, fmt.Errorf("unsupported WAL format version %d", version)
+	}
+
+	for {
+		kind, err := br.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return cr.n, nil
+		}
+		if err != nil {
+			return cr.n, err
+		}
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return cr.n, fmt.Errorf("failed to read the frame size: %w", err)
+		}
+		if size > maxReplicationFrameSize {
+			return cr.n, fmt.Errorf("too large frame with size %d", size)
+		}
+		payload := make([]byte, int(size))
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return cr.n, fmt.Errorf("failed to read the frame: %w", err)
+		}
+		if err := f.apply(replicationFrameKind(kind), version, payload); err != nil {
+			return cr.n, err
+		}
+	}
+}
+
+func (f *Follower[T]) apply(kind replicationFrameKind, version byte, payload []byte) error {
+	switch kind {
+	case frameRecords:
+		seg := &segment[T]{
+			r:       bufio.NewReader(bytes.NewReader(payload)),
+			version: version,
+		}
+		var rows []Row[T]
+		for seg.next() {
+			rows = append(rows, seg.record().row)
+		}
+		if err := seg.error(); err != nil {
+			return fmt.Errorf("failed to read records: %w", err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		// Rejected rows are ignored since they are rejected by the primary as well, or already held.
+		if _, err := f.storage.InsertRowsDetailed(rows); err != nil {
+			return fmt.Errorf("failed to insert replicated rows: %w", err)
+		}
+	case framePartitionFlushed:
+		// Persist partitions as the primary does, to keep the WAL from growing.
+		if s, ok := f.storage.(*storage[T]); ok {
+			if err := s.flushPartitions(); err != nil {
+				return fmt.Errorf("failed to flush partitions: %w", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown frame kind %d found", kind)
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// replicationHub fans out frames to followers of a storage.
+type replicationHub struct {
+	mu       sync.Mutex
+	replicas map[*replica]struct{}
+	closed   bool
+}
+
+// replica is a follower registered with a replicationHub.
+type replica struct {
+	// Frames to be streamed before the ones in frames.
+	pending [][]byte
+	frames  chan []byte
+	// droppedCh gets closed when it's dropped, with err set unless the storage has been closed.
+	droppedCh chan struct{}
+	err       error
+}
+
+func newReplicationHub() *replicationHub {
+	return &replicationHub{
+		replicas: make(map[*replica]struct{}),
+	}
+}
+
+func (h *replicationHub) add(pending [][]byte) *replica {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rep := &replica{
+		pending:   pending,
+		frames:    make(chan []byte, replicationBufferSize),
+		droppedCh: make(chan struct{}),
+	}
+	if h.closed {
+		close(rep.droppedCh)
+		return rep
+	}
+	h.replicas[rep] = struct{}{}
+	return rep
+}
+
+func (h *replicationHub) remove(rep *replica) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.replicas, rep)
+}
+
+// observeRecords implements walObserver.
+func (h *replicationHub) observeRecords(records []byte) {
+	h.publish(frameRecords, records)
+}
+
+// partitionFlushed notifies followers that the partition in the given range has been persisted.
+func (h *replicationHub) partitionFlushed(minT, maxT int64) {
+	payload := binary.AppendVarint(nil, minT)
+	h.publish(framePartitionFlushed, binary.AppendVarint(payload, maxT))
+}
+
+// publish queues the frame for each follower, and drops the ones whose queue is full
+// instead of blocking the writers.
+func (h *replicationHub) publish(kind replicationFrameKind, payload []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.replicas) == 0 {
+		return
+	}
+	frame := newReplicationFrame(kind, payload)
+	for rep := range h.replicas {
+		select {
+		case rep.frames <- frame:
+		default:
+			rep.err = errReplicaTooSlow
+			close(rep.droppedCh)
+			delete(h.replicas, rep)
+		}
+	}
+}
+
+// close drops all followers after they receive the frames queued so far.
+func (h *replicationHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for rep := range h.replicas {
+		close(rep.droppedCh)
+		delete(h.replicas, rep)
+	}
+}
+
+func newReplicationFrame(kind replicationFrameKind, payload []byte) []byte {
+	frame := make([]byte, 0, 1+binary.MaxVarintLen64+len(payload))
+	frame = append(frame, byte(kind))
+	frame = binary.AppendUvarint(frame, uint64(len(payload)))
+	return append(frame, payload...)
+}
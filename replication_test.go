@@ -0,0 +1,266 @@
+package tstorage
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplication(t *testing.T) {
+	primary, err := NewStorage(
+		WithDataPath[float64](t.TempDir()),
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer primary.Close()
+	follower, err := NewStorage(
+		WithDataPath[float64](t.TempDir()),
+		WithTimestampPrecision[float64](Seconds),
+		WithDuplicatePointPolicy[float64](RejectDuplicates),
+	)
+	require.NoError(t, err)
+	defer follower.Close()
+
+	// Written before the follower connects.
+	require.NoError(t, primary.InsertRows([]Row[float64]{
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "host-1"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.1}},
+	}))
+
+	source, err := NewReplicationSource(primary)
+	require.NoError(t, err)
+	pr, pw := io.Pipe()
+	writeErrCh := make(chan error, 1)
+	go func() {
+		_, err := source.WriteTo(pw)
+		pw.Close()
+		writeErrCh <- err
+	}()
+	readErrCh := make(chan error, 1)
+	go func() {
+		_, err := NewFollower(follower).ReadFrom(pr)
+		readErrCh <- err
+	}()
+
+	// Written after the follower connects.
+	require.NoError(t, primary.InsertRows([]Row[float64]{
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "host-1"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000001, Value: 0.2}},
+	}))
+
+	want := []*DataPoint[float64]{
+		{Timestamp: 1600000000, Value: 0.1},
+		{Timestamp: 1600000001, Value: 0.2},
+	}
+	assert.Eventually(t, func() bool {
+		got, _ := follower.Select("metric1", []Label{{Name: "host", Value: "host-1"}}, 1600000000, 1600000002)
+		return assert.ObjectsAreEqual(want, got)
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, source.Close())
+	assert.NoError(t, <-writeErrCh)
+	assert.NoError(t, <-readErrCh)
+}
+
+func TestReplication_rejectedAndOutdated(t *testing.T) {
+	primary, err := NewStorage(
+		WithDataPath[float64](t.TempDir()),
+		WithTimestampPrecision[float64](Seconds),
+		WithPartitionDuration[float64](time.Hour),
+		WithMaxSeries[float64](1),
+	)
+	require.NoError(t, err)
+	defer primary.Close()
+	follower, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithPartitionDuration[float64](time.Hour),
+		WithDuplicatePointPolicy[float64](RejectDuplicates),
+	)
+	require.NoError(t, err)
+	defer follower.Close()
+
+	insert := func(metric string, ts int64) {
+		_, err := primary.InsertRowsDetailed([]Row[float64]{{Metric: metric, DataPoint: DataPoint[float64]{Timestamp: ts, Value: 0.1}}})
+		require.NoError(t, err)
+	}
+	insert("metric1", 1600000000)
+	insert("metric1", 1600003600)
+	insert("metric1", 1600003601)
+	// Rejected for the series limit.
+	insert("metric2", 1600003602)
+	// Outdated for the head partition, so ingested into the previous one.
+	insert("metric1", 1600003000)
+
+	source, err := NewReplicationSource(primary)
+	require.NoError(t, err)
+	defer source.Close()
+	pr, pw := io.Pipe()
+	go func() {
+		source.WriteTo(pw)
+		pw.Close()
+	}()
+	go NewFollower(follower).ReadFrom(pr)
+
+	insert("metric2", 1600003603)
+	insert("metric1", 1600003001)
+	insert("metric1", 1600003604)
+
+	// Streamed last, so all the others have been applied once it arrives.
+	insert("metric1", 1600003605)
+	assert.Eventually(t, func() bool {
+		_, err := follower.Select("metric1", nil, 1600003605, 1600003606)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// Nothing more or less than the primary holds.
+	require.Equal(t, int64(7), primary.Stats().NumDataPoints)
+	assert.Equal(t, int64(7), follower.Stats().NumDataPoints)
+	assert.Equal(t, map[string]int{"metric1": 1}, follower.Cardinality())
+}
+
+func TestReplication_reconnect(t *testing.T) {
+	primary, err := NewStorage(
+		WithDataPath[float64](t.TempDir()),
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer primary.Close()
+	follower, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithDuplicatePointPolicy[float64](RejectDuplicates),
+	)
+	require.NoError(t, err)
+	defer follower.Close()
+
+	source, err := NewReplicationSource(primary)
+	require.NoError(t, err)
+	defer source.Close()
+	// follow streams to the follower until the returned function is called.
+	follow := func() func() {
+		pr, pw := io.Pipe()
+		go func() {
+			source.WriteTo(pw)
+			pw.Close()
+		}()
+		doneCh := make(chan struct{})
+		go func() {
+			NewFollower(follower).ReadFrom(pr)
+			close(doneCh)
+		}()
+		return func() {
+			pr.Close()
+			<-doneCh
+		}
+	}
+	insert := func(ts int64) {
+		require.NoError(t, primary.InsertRows([]Row[float64]{{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: ts, Value: 0.1}}}))
+	}
+	waitFor := func(ts int64) {
+		assert.Eventually(t, func() bool {
+			_, err := follower.Select("metric1", nil, ts, ts+1)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	stop := follow()
+	insert(1600000000)
+	insert(1600000002)
+	// Out of order.
+	insert(1600000001)
+	waitFor(1600000001)
+	stop()
+
+	insert(1600000003)
+	stop = follow()
+	defer stop()
+	waitFor(1600000003)
+
+	// The ones streamed again aren't held twice.
+	require.Equal(t, int64(4), primary.Stats().NumDataPoints)
+	assert.Equal(t, int64(4), follower.Stats().NumDataPoints)
+}
+
+func TestFollower_ReadFrom_keepDuplicates(t *testing.T) {
+	follower, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer follower.Close()
+	_, err = NewFollower(follower).ReadFrom(bytes.NewReader(nil))
+	assert.Error(t, err)
+}
+
+func TestReplicationSource_Serve(t *testing.T) {
+	primary, err := NewStorage(
+		WithDataPath[float64](t.TempDir()),
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer primary.Close()
+	follower, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithDuplicatePointPolicy[float64](RejectDuplicates),
+	)
+	require.NoError(t, err)
+	defer follower.Close()
+
+	source, err := NewReplicationSource(primary)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serveErrCh := make(chan error, 1)
+	go func() {
+		serveErrCh <- source.Serve(l)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	go NewFollower(follower).ReadFrom(conn)
+
+	// Keep writing until the follower gets connected.
+	ts := int64(1600000000)
+	assert.Eventually(t, func() bool {
+		ts++
+		err := primary.InsertRows([]Row[float64]{
+			{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: ts, Value: 0.1}},
+		})
+		got, _ := follower.Select("metric1", nil, 1600000000, ts+1)
+		return err == nil && len(got) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, source.Close())
+	assert.NoError(t, <-serveErrCh)
+}
+
+func TestNewReplicationSource_inMemory(t *testing.T) {
+	s, err := NewStorage[float64]()
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = NewReplicationSource(s)
+	assert.Error(t, err)
+}
+
+func Test_replicationHub_publish(t *testing.T) {
+	h := newReplicationHub()
+	slow := h.add(nil)
+	for i := 0; i < replicationBufferSize; i++ {
+		h.observeRecords([]byte{byte(operationInsert)})
+	}
+	assert.Len(t, slow.frames, replicationBufferSize)
+
+	// The slow follower gets dropped instead of blocking.
+	h.observeRecords([]byte{byte(operationInsert)})
+	<-slow.droppedCh
+	assert.ErrorIs(t, slow.err, errReplicaTooSlow)
+
+	// Followers are dropped without error once closed.
+	rep := h.add(nil)
+	h.partitionFlushed(1, 2)
+	h.close()
+	<-rep.droppedCh
+	assert.NoError(t, rep.err)
+	assert.Equal(t, []byte{byte(framePartitionFlushed), 2, 2, 4}, <-rep.frames)
+}
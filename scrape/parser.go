@@ -0,0 +1,202 @@
+package scrape
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// Sample is a single sample exposed by a target.
+type Sample struct {
+	Metric string
+	Labels []tstorage.Label
+	Value  float64
+}
+
+// ParseError describes which line is malformed.
+type ParseError struct {
+	// 1-based line number
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parse parses samples in either the Prometheus text format or the OpenMetrics text format.
+// Metadata such as HELP and TYPE are skipped, as well as timestamps and exemplars of samples.
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+func Parse(data []byte) ([]Sample, error) {
+	samples := make([]Sample, 0, bytes.Count(data, []byte{'\n'})+1)
+	for n := 1; len(data) > 0; n++ {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '#' {
+			if bytes.Equal(line, []byte("# EOF")) {
+				break
+			}
+			continue
+		}
+		s, err := parseSample(string(line))
+		if err != nil {
+			return nil, &ParseError{Line: n, Err: err}
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// parseSample parses a line formatted as shown below:
+//
+//	metric_name[{label="value",...}] value [timestamp] [# exemplar]
+func parseSample(line string) (Sample, error) {
+	var s Sample
+	i := 0
+	for i < len(line) && isNameChar(line[i], i == 0, true) {
+		i++
+	}
+	if i == 0 {
+		return Sample{}, fmt.Errorf("invalid metric name in %q", line)
+	}
+	s.Metric = line[:i]
+
+	if i < len(line) && line[i] == '{' {
+		labels, n, err := parseLabels(line[i+1:])
+		if err != nil {
+			return Sample{}, err
+		}
+		s.Labels = labels
+		i += n + 1
+	}
+
+	rest := strings.TrimLeft(line[i:], " \t")
+	if len(rest) == len(line[i:]) {
+		return Sample{}, fmt.Errorf("missing space after the metric in %q", line)
+	}
+	if j := strings.Index(rest, " #"); j >= 0 {
+		// Drop the exemplar.
+		rest = rest[:j]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Sample{}, fmt.Errorf("want a value and an optional timestamp in %q", line)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return Sample{}, fmt.Errorf("invalid value %q", fields[0])
+	}
+	s.Value = v
+	if len(fields) == 2 {
+		if _, err := strconv.ParseFloat(fields[1], 64); err != nil {
+			return Sample{}, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+	}
+	return s, nil
+}
+
+// parseLabels parses labels following "{", and gives back the number of bytes read including "}".
+func parseLabels(s string) ([]tstorage.Label, int, error) {
+	var labels []tstorage.Label
+	i := 0
+	for {
+		i = skipBlanks(s, i)
+		if i < len(s) && s[i] == '}' {
+			return labels, i + 1, nil
+		}
+		start := i
+		for i < len(s) && isNameChar(s[i], i == start, false) {
+			i++
+		}
+		if i == start {
+			return nil, 0, errors.New("invalid label name")
+		}
+		name := s[start:i]
+		i = skipBlanks(s, i)
+		if i >= len(s) || s[i] != '=' {
+			return nil, 0, fmt.Errorf("missing '=' after label %q", name)
+		}
+		i = skipBlanks(s, i+1)
+		if i >= len(s) || s[i] != '"' {
+			return nil, 0, fmt.Errorf("missing quoted value of label %q", name)
+		}
+		value, n, err := unquote(s[i+1:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid value of label %q: %w", name, err)
+		}
+		i += n + 1
+		labels = append(labels, tstorage.Label{Name: name, Value: value})
+
+		i = skipBlanks(s, i)
+		if i < len(s) && s[i] == ',' {
+			i++
+			continue
+		}
+		if i < len(s) && s[i] == '}' {
+			return labels, i + 1, nil
+		}
+		return nil, 0, errors.New("missing '}'")
+	}
+}
+
+// unquote reads the label value up to the closing quote, and gives back the number of bytes read including it.
+func unquote(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, errors.New("unterminated escape")
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+func isNameChar(c byte, first, colon bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		return true
+	case c == ':':
+		return colon
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+func skipBlanks(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,81 @@
+package scrape
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Sample
+		wantErr bool
+	}{
+		{
+			name: "prometheus text format",
+			input: `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# A normal comment.
+metric_without_timestamp_and_labels 12.47
+rpc_duration_seconds{quantile="0.5",} 4773
+escaped{path="C:\\dir\\",msg="say \"hi\"\n"} -Inf
+`,
+			want: []Sample{
+				{Metric: "http_requests_total", Labels: []tstorage.Label{{Name: "method", Value: "post"}, {Name: "code", Value: "200"}}, Value: 1027},
+				{Metric: "http_requests_total", Labels: []tstorage.Label{{Name: "method", Value: "post"}, {Name: "code", Value: "400"}}, Value: 3},
+				{Metric: "metric_without_timestamp_and_labels", Value: 12.47},
+				{Metric: "rpc_duration_seconds", Labels: []tstorage.Label{{Name: "quantile", Value: "0.5"}}, Value: 4773},
+				{Metric: "escaped", Labels: []tstorage.Label{{Name: "path", Value: `C:\dir\`}, {Name: "msg", Value: "say \"hi\"\n"}}, Value: math.Inf(-1)},
+			},
+		},
+		{
+			name: "openmetrics text format",
+			input: `# TYPE foo counter
+# UNIT foo seconds
+foo_total{a="b"} 17.0 1520879607.789 # {trace_id="KOO5S4vxi0o"} 0.67
+foo_created{a="b"} 1520872607.123
+# EOF
+ignored 1
+`,
+			want: []Sample{
+				{Metric: "foo_total", Labels: []tstorage.Label{{Name: "a", Value: "b"}}, Value: 17},
+				{Metric: "foo_created", Labels: []tstorage.Label{{Name: "a", Value: "b"}}, Value: 1520872607.123},
+			},
+		},
+		{name: "missing value", input: "foo\n", wantErr: true},
+		{name: "invalid metric name", input: "0foo 1\n", wantErr: true},
+		{name: "invalid value", input: "foo abc\n", wantErr: true},
+		{name: "invalid timestamp", input: "foo 1 abc\n", wantErr: true},
+		{name: "unquoted label value", input: "foo{a=b} 1\n", wantErr: true},
+		{name: "unterminated labels", input: `foo{a="b" 1`, wantErr: true},
+		{name: "invalid escape", input: `foo{a="\t"} 1`, wantErr: true},
+		{name: "missing space", input: `foo{a="b"}1`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.input))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParse_nan(t *testing.T) {
+	got, err := Parse([]byte("foo NaN\n"))
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.True(t, math.IsNaN(got[0].Value))
+}
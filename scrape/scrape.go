@@ -0,0 +1,396 @@
+// Package scrape implements a scraper that periodically collects metrics exposed in the Prometheus text format
+// or the OpenMetrics text format, which lets small deployments use tstorage without a separate Prometheus.
+//
+// Like Prometheus, each sample gets the "job" and "instance" labels, and series that stop being exposed,
+// including the ones of targets that disappear, get the stale marker tstorage.StaleNaN.
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nakabonne/tstorage"
+	"github.com/nakabonne/tstorage/internal/timeutil"
+)
+
+const (
+	defaultInterval    = 15 * time.Second
+	defaultTimeout     = 10 * time.Second
+	defaultMaxBodySize = 64 << 20
+
+	acceptHeader = "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+)
+
+// Target is an endpoint to scrape.
+type Target struct {
+	// The value of the "job" label.
+	Job string
+	// The URL of the endpoint, like "http://localhost:9100/metrics".
+	// Its host becomes the value of the "instance" label.
+	URL string
+	// Additional labels given to all samples.
+	Labels []tstorage.Label
+}
+
+// Option is an optional setting for the scraper.
+type Option func(*options)
+
+type options struct {
+	interval    time.Duration
+	timeout     time.Duration
+	maxBodySize int64
+	client      *http.Client
+	logger      tstorage.Logger
+}
+
+// WithInterval specifies how often targets get scraped.
+//
+// Defaults to 15s.
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.interval = interval
+	}
+}
+
+// WithTimeout specifies the timeout of each scrape, which is capped by the interval.
+//
+// Defaults to 10s.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithMaxBodySize specifies the maximum byte size of responses. Scrapes exceeding it fail.
+//
+// Defaults to 64MiB.
+func WithMaxBodySize(size int64) Option {
+	return func(o *options) {
+		o.maxBodySize = size
+	}
+}
+
+// WithHTTPClient specifies the client to scrape with.
+//
+// Defaults to http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithLogger specifies the logger to report failed scrapes.
+//
+// Defaults to a logger implementation that does nothing.
+func WithLogger(logger tstorage.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Manager scrapes targets and inserts the samples into the storage.
+// Besides the exposed samples, it writes the "up", "scrape_duration_seconds" and
+// "scrape_samples_scraped" series for each target like Prometheus does.
+type Manager[T any] struct {
+	storage tstorage.Storage[T]
+	opts    *options
+
+	mu     sync.Mutex
+	loops  map[string]*loop[T]
+	closed bool
+}
+
+// NewManager gives back a Manager with no targets. Give targets with SetTargets.
+func NewManager[T any](s tstorage.Storage[T], opts ...Option) *Manager[T] {
+	o := &options{
+		interval:    defaultInterval,
+		timeout:     defaultTimeout,
+		maxBodySize: defaultMaxBodySize,
+		client:      http.DefaultClient,
+		logger:      nopLogger{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.interval <= 0 {
+		o.interval = defaultInterval
+	}
+	if o.timeout <= 0 || o.timeout > o.interval {
+		o.timeout = o.interval
+	}
+	return &Manager[T]{
+		storage: s,
+		opts:    o,
+		loops:   make(map[string]*loop[T]),
+	}
+}
+
+// SetTargets replaces the targets to scrape. New targets get scraped right away, while
+// targets no longer given stop being scraped, and their series get the stale marker.
+func (m *Manager[T]) SetTargets(targets []Target) error {
+	loops := make(map[string]*loop[T], len(targets))
+	for _, t := range targets {
+		l, err := newLoop(m, t)
+		if err != nil {
+			return err
+		}
+		loops[l.key] = l
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return errors.New("scrape manager already closed")
+	}
+	var removed []*loop[T]
+	for key, l := range m.loops {
+		if _, ok := loops[key]; !ok {
+			removed = append(removed, l)
+			delete(m.loops, key)
+		}
+	}
+	for key, l := range loops {
+		if _, ok := m.loops[key]; ok {
+			continue
+		}
+		m.loops[key] = l
+		go l.run()
+	}
+	m.mu.Unlock()
+
+	for _, l := range removed {
+		l.stop()
+		l.markStale(time.Now())
+	}
+	return nil
+}
+
+// Close stops scraping all targets. Unlike removing targets, it doesn't write stale markers.
+func (m *Manager[T]) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	loops := m.loops
+	m.loops = nil
+	m.mu.Unlock()
+
+	for _, l := range loops {
+		l.stop()
+	}
+	return nil
+}
+
+// loop scrapes a single target periodically.
+type loop[T any] struct {
+	m      *Manager[T]
+	key    string
+	url    string
+	labels []tstorage.Label
+	// The series exposed by the last scrape, keyed by seriesKey.
+	series map[string]series
+	// The timestamp of the last scrape.
+	lastTimestamp int64
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+type series struct {
+	metric string
+	labels []tstorage.Label
+}
+
+func newLoop[T any](m *Manager[T], t Target) (*loop[T], error) {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL %q: %w", t.URL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid target URL %q: missing host", t.URL)
+	}
+	labels := []tstorage.Label{{Name: "job", Value: t.Job}, {Name: "instance", Value: u.Host}}
+	for _, l := range t.Labels {
+		if l.Name == "job" || l.Name == "instance" {
+			continue
+		}
+		labels = append(labels, l)
+	}
+	sortLabels(labels)
+	return &loop[T]{
+		m:      m,
+		key:    seriesKey(t.URL, labels),
+		url:    t.URL,
+		labels: labels,
+		series: make(map[string]series),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}, nil
+}
+
+func (l *loop[T]) run() {
+	defer close(l.doneCh)
+	ticker := time.NewTicker(l.m.opts.interval)
+	defer ticker.Stop()
+	for {
+		l.scrapeAndInsert(time.Now())
+		select {
+		case <-l.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *loop[T]) stop() {
+	close(l.stopCh)
+	<-l.doneCh
+}
+
+// scrapeAndInsert scrapes the target once, and inserts the samples timestamped with now.
+func (l *loop[T]) scrapeAndInsert(now time.Time) {
+	o := l.m.opts
+	ts := timeutil.ToUnix(now, l.m.storage.TimestampPrecision())
+	l.lastTimestamp = ts
+	start := time.Now()
+	samples, err := l.scrape()
+	duration := time.Since(start)
+
+	rows := make([]tstorage.Row[T], 0, len(samples)+len(l.series)+3)
+	current := make(map[string]series, len(samples))
+	if err != nil {
+		o.logger.Printf("scrape: failed to scrape %s: %v\n", l.url, err)
+	}
+	for i := range samples {
+		s := &samples[i]
+		labels := l.mergeLabels(s.Labels)
+		current[seriesKey(s.Metric, labels)] = series{metric: s.Metric, labels: labels}
+		rows = append(rows, newRow[T](s.Metric, labels, ts, s.Value))
+	}
+	for key, s := range l.series {
+		if _, ok := current[key]; !ok {
+			rows = append(rows, newRow[T](s.metric, s.labels, ts, tstorage.StaleNaN))
+		}
+	}
+	l.series = current
+
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+	rows = append(rows,
+		newRow[T]("up", l.labels, ts, up),
+		newRow[T]("scrape_duration_seconds", l.labels, ts, duration.Seconds()),
+		newRow[T]("scrape_samples_scraped", l.labels, ts, float64(len(samples))),
+	)
+	if err := l.m.storage.InsertRows(rows); err != nil {
+		o.logger.Printf("scrape: failed to insert samples from %s: %v\n", l.url, err)
+	}
+}
+
+// markStale writes the stale marker to all series of the target, including the ones written by the scraper.
+func (l *loop[T]) markStale(now time.Time) {
+	ts := timeutil.ToUnix(now, l.m.storage.TimestampPrecision())
+	if ts <= l.lastTimestamp {
+		// Markers must follow the last samples, which can share the timestamp in coarse precisions.
+		ts = l.lastTimestamp + 1
+	}
+	rows := make([]tstorage.Row[T], 0, len(l.series)+3)
+	for _, s := range l.series {
+		rows = append(rows, newRow[T](s.metric, s.labels, ts, tstorage.StaleNaN))
+	}
+	for _, metric := range []string{"up", "scrape_duration_seconds", "scrape_samples_scraped"} {
+		rows = append(rows, newRow[T](metric, l.labels, ts, tstorage.StaleNaN))
+	}
+	l.series = nil
+	if err := l.m.storage.InsertRows(rows); err != nil {
+		l.m.opts.logger.Printf("scrape: failed to insert stale markers for %s: %v\n", l.url, err)
+	}
+}
+
+func (l *loop[T]) scrape() ([]Sample, error) {
+	o := l.m.opts
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", fmt.Sprintf("%g", o.timeout.Seconds()))
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, o.maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the response body: %w", err)
+	}
+	if int64(len(body)) > o.maxBodySize {
+		return nil, fmt.Errorf("the response body exceeds %d bytes", o.maxBodySize)
+	}
+	return Parse(body)
+}
+
+// mergeLabels adds the target labels to the exposed ones. Exposed labels conflicting with
+// the target labels get renamed with the "exported_" prefix, like Prometheus does.
+func (l *loop[T]) mergeLabels(exposed []tstorage.Label) []tstorage.Label {
+	labels := make([]tstorage.Label, 0, len(exposed)+len(l.labels))
+	for _, e := range exposed {
+		for _, t := range l.labels {
+			if e.Name == t.Name {
+				e.Name = "exported_" + e.Name
+				break
+			}
+		}
+		labels = append(labels, e)
+	}
+	labels = append(labels, l.labels...)
+	sortLabels(labels)
+	return labels
+}
+
+func newRow[T any](metric string, labels []tstorage.Label, ts int64, value float64) tstorage.Row[T] {
+	return tstorage.Row[T]{
+		Metric:    metric,
+		Labels:    labels,
+		DataPoint: tstorage.DataPoint[T]{Timestamp: ts, Value: value},
+	}
+}
+
+// seriesKey gives back a string identifying the series. labels must be sorted.
+func seriesKey(metric string, labels []tstorage.Label) string {
+	var b strings.Builder
+	b.WriteString(metric)
+	for _, l := range labels {
+		b.WriteByte(0xff)
+		b.WriteString(l.Name)
+		b.WriteByte(0xfe)
+		b.WriteString(l.Value)
+	}
+	return b.String()
+}
+
+func sortLabels(labels []tstorage.Label) {
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(_ string, _ ...interface{}) {}
@@ -0,0 +1,142 @@
+package scrape
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nakabonne/tstorage"
+)
+
+// exporter serves the given metrics, which can be replaced.
+type exporter struct {
+	mu      sync.Mutex
+	metrics string
+	status  int
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.status != 0 {
+		w.WriteHeader(e.status)
+		return
+	}
+	io.WriteString(w, e.metrics)
+}
+
+func (e *exporter) set(metrics string, status int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.metrics = metrics
+	e.status = status
+}
+
+func newTestStorage(t *testing.T) tstorage.Storage[float64] {
+	storage, err := tstorage.NewStorage(
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func selectValues(t *testing.T, storage tstorage.Storage[float64], metric string, labels []tstorage.Label) []float64 {
+	t.Helper()
+	points, err := storage.Select(metric, labels, 1600000000, 1600000010)
+	if err == tstorage.ErrNoDataPoints {
+		return nil
+	}
+	require.NoError(t, err)
+	values := make([]float64, 0, len(points))
+	for _, p := range points {
+		values = append(values, p.Value)
+	}
+	return values
+}
+
+func TestLoop_scrapeAndInsert(t *testing.T) {
+	exp := &exporter{}
+	server := httptest.NewServer(exp)
+	defer server.Close()
+	storage := newTestStorage(t)
+	m := NewManager(storage)
+	l, err := newLoop(m, Target{
+		Job:    "node",
+		URL:    server.URL + "/metrics",
+		Labels: []tstorage.Label{{Name: "env", Value: "prod"}},
+	})
+	require.NoError(t, err)
+	instance := strings.TrimPrefix(server.URL, "http://")
+	target := []tstorage.Label{{Name: "env", Value: "prod"}, {Name: "instance", Value: instance}, {Name: "job", Value: "node"}}
+	labelsOf := func(path string) []tstorage.Label {
+		return []tstorage.Label{{Name: "env", Value: "prod"}, {Name: "exported_job", Value: "app"}, {Name: "instance", Value: instance}, {Name: "job", Value: "node"}, {Name: "path", Value: path}}
+	}
+
+	exp.set("requests{path=\"/a\",job=\"app\"} 1\nrequests{path=\"/b\",job=\"app\"} 2\n", 0)
+	l.scrapeAndInsert(time.Unix(1600000000, 0))
+	// /b disappears.
+	exp.set("requests{path=\"/a\",job=\"app\"} 3\n", 0)
+	l.scrapeAndInsert(time.Unix(1600000001, 0))
+	// The target goes down.
+	exp.set("", http.StatusInternalServerError)
+	l.scrapeAndInsert(time.Unix(1600000002, 0))
+	exp.set("requests{path=\"/a\",job=\"app\"} 4\n", 0)
+	l.scrapeAndInsert(time.Unix(1600000003, 0))
+	// The target is removed.
+	l.markStale(time.Unix(1600000004, 0))
+
+	a := selectValues(t, storage, "requests", labelsOf("/a"))
+	require.Len(t, a, 5)
+	assert.Equal(t, []float64{1, 3}, a[:2])
+	assert.True(t, tstorage.IsStaleNaN(a[2]))
+	assert.Equal(t, 4.0, a[3])
+	assert.True(t, tstorage.IsStaleNaN(a[4]))
+
+	b := selectValues(t, storage, "requests", labelsOf("/b"))
+	require.Len(t, b, 2)
+	assert.Equal(t, 2.0, b[0])
+	assert.True(t, tstorage.IsStaleNaN(b[1]))
+
+	up := selectValues(t, storage, "up", target)
+	require.Len(t, up, 5)
+	assert.Equal(t, []float64{1, 1, 0, 1}, up[:4])
+	assert.True(t, tstorage.IsStaleNaN(up[4]))
+	assert.Equal(t, []float64{2, 1, 0, 1}, selectValues(t, storage, "scrape_samples_scraped", target)[:4])
+}
+
+func TestManager_SetTargets(t *testing.T) {
+	exp := &exporter{}
+	exp.set("foo 1\n", 0)
+	server := httptest.NewServer(exp)
+	defer server.Close()
+	storage := newTestStorage(t)
+	m := NewManager(storage, WithInterval(time.Hour))
+	defer m.Close()
+
+	target := Target{Job: "app", URL: server.URL}
+	require.NoError(t, m.SetTargets([]Target{target}))
+	labels := []tstorage.Label{{Name: "instance", Value: strings.TrimPrefix(server.URL, "http://")}, {Name: "job", Value: "app"}}
+	// Targets get scraped right away.
+	assert.Eventually(t, func() bool {
+		points, err := storage.Select("foo", labels, 0, time.Now().Unix()+1)
+		return err == nil && len(points) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Removing the target marks its series as stale.
+	require.NoError(t, m.SetTargets(nil))
+	points, err := storage.Select("up", labels, 0, time.Now().Unix()+1)
+	require.NoError(t, err)
+	assert.True(t, tstorage.IsStaleNaN(points[len(points)-1].Value))
+
+	assert.Error(t, m.SetTargets([]Target{{Job: "app", URL: "/metrics"}}))
+	require.NoError(t, m.Close())
+	assert.Error(t, m.SetTargets([]Target{target}))
+}
@@ -0,0 +1,274 @@
+package tstorage
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// SeriesRef is a reference to a series, which lets writers skip identifying the series by its metric
+// name and labels. It stays valid as long as the series is held in memory, or has been got recently.
+// Zero is never a valid reference.
+type SeriesRef uint64
+
+// SeriesLimitError is given back for rows that would create a new series beyond the limit.
+// It wraps ErrLimitExceeded. See WithMaxSeries and WithMaxSeriesPerMetric.
+type SeriesLimitError struct {
+	// The metric name of the rejected row.
+	Metric string
+	// The limit that was hit.
+	Limit int
+	// PerMetric is true if the per-metric limit was hit, false if the total one was.
+	PerMetric bool
+}
+
+func (e *SeriesLimitError) Error() string {
+	if e.PerMetric {
+		return fmt.Sprintf("%v: metric %q already has %d series", ErrLimitExceeded, e.Metric, e.Limit)
+	}
+	return fmt.Sprintf("%v: already has %d series", ErrLimitExceeded, e.Limit)
+}
+
+func (e *SeriesLimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// seriesIndex keeps track of series held by in-memory partitions, in order to limit their cardinality.
+// A series is registered when any in-memory partition creates it first or its reference is requested,
+// and is removed once no in-memory partition holds it and nobody has got it for a generation.
+type seriesIndex struct {
+	// A map from the marshaled metric name to *indexedSeries.
+	series sync.Map
+	// A map from SeriesRef to *indexedSeries.
+	refs sync.Map
+	// The last issued SeriesRef.
+	lastRef uint64
+	// The number of series for each metric.
+	perMetric map[string]int
+	// The number of all series, which must be updated atomically while holding mu.
+	total int64
+	// gen is incremented whenever unused series are removed. It must be updated while holding mu.
+	gen uint64
+
+	// Zero means unlimited.
+	maxSeries int
+	// Zero means unlimited.
+	maxSeriesPerMetric int
+	// Limits that take precedence over maxSeriesPerMetric.
+	metricLimits map[string]int
+
+	// mu must be held to add or remove series.
+	mu sync.Mutex
+}
+
+type indexedSeries struct {
+	ref SeriesRef
+	// The marshaled metric name.
+	name   string
+	metric string
+	// Sorted by name.
+	labels []Label
+	// The latest generation when anyone got it.
+	gen uint64
+	// The number of holders preventing it from being removed, or -1 once removed.
+	pins int32
+	// 1 if anyone other than the one creating it has got it.
+	shared int32
+	// The last memoryMetric the series was inserted into, to skip looking it up.
+	// It holds a *cachedMetric.
+	cache atomic.Value
+}
+
+// cachedMetric is the memoryMetric held by a specific in-memory partition.
+type cachedMetric[T any] struct {
+	partition *memoryPartition[T]
+	metric    *memoryMetric[T]
+}
+
+func newSeriesIndex() *seriesIndex {
+	return &seriesIndex{
+		perMetric:    make(map[string]int),
+		metricLimits: make(map[string]int),
+	}
+}
+
+// register makes the given series known if not yet, and gives back it.
+// It gives back a *SeriesLimitError if the series is new and any limits would be exceeded.
+// The given labels must be sorted by name, as marshalMetricName does.
+//
+// Even if no in-memory partition holds it, the series survives the next removal of unused series,
+// so that the reference stays valid until the head partition taking it gets flushed.
+func (i *seriesIndex) register(name, metric string, labels []Label) (*indexedSeries, error) {
+	s, err := i.acquire(name, metric, labels)
+	if err != nil {
+		return nil, err
+	}
+	i.release(s)
+	return s, nil
+}
+
+// acquire is the same as register, except the series is never removed until release is called.
+func (i *seriesIndex) acquire(name, metric string, labels []Label) (*indexedSeries, error) {
+	if value, ok := i.series.Load(name); ok {
+		if s := value.(*indexedSeries); i.pin(s) {
+			return s, nil
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if value, ok := i.series.Load(name); ok {
+		// Series aren't removed without holding mu, so it never fails.
+		s := value.(*indexedSeries)
+		i.pin(s)
+		return s, nil
+	}
+	if i.maxSeries > 0 && atomic.LoadInt64(&i.total) >= int64(i.maxSeries) {
+		return nil, &SeriesLimitError{Metric: metric, Limit: i.maxSeries}
+	}
+	limit, ok := i.metricLimits[metric]
+	if !ok {
+		limit = i.maxSeriesPerMetric
+	}
+	if limit > 0 && i.perMetric[metric] >= limit {
+		return nil, &SeriesLimitError{Metric: metric, Limit: limit, PerMetric: true}
+	}
+	s := &indexedSeries{
+		ref:    SeriesRef(atomic.AddUint64(&i.lastRef, 1)),
+		name:   name,
+		metric: metric,
+		labels: append([]Label(nil), labels...),
+		gen:    i.gen,
+		pins:   1,
+	}
+	i.series.Store(name, s)
+	i.refs.Store(s.ref, s)
+	i.perMetric[metric]++
+	atomic.AddInt64(&i.total, 1)
+	return s, nil
+}
+
+// lookup gives back the series the given reference points to.
+// It keeps the series in the same way as register does.
+func (i *seriesIndex) lookup(ref SeriesRef) (*indexedSeries, bool) {
+	s, ok := i.acquireRef(ref)
+	if !ok {
+		return nil, false
+	}
+	i.release(s)
+	return s, true
+}
+
+// acquireRef is the same as lookup, except the series is never removed until release is called.
+func (i *seriesIndex) acquireRef(ref SeriesRef) (*indexedSeries, bool) {
+	value, ok := i.refs.Load(ref)
+	if !ok {
+		return nil, false
+	}
+	s := value.(*indexedSeries)
+	if !i.pin(s) {
+		return nil, false
+	}
+	return s, true
+}
+
+// pin prevents the given series from being removed until release is called, and marks it as got
+// in the current generation. It fails if the series has already been removed.
+func (i *seriesIndex) pin(s *indexedSeries) bool {
+	for {
+		n := atomic.LoadInt32(&s.pins)
+		if n < 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&s.pins, n, n+1) {
+			break
+		}
+	}
+	if atomic.LoadInt32(&s.shared) == 0 {
+		atomic.StoreInt32(&s.shared, 1)
+	}
+	gen := atomic.LoadUint64(&i.gen)
+	for {
+		old := atomic.LoadUint64(&s.gen)
+		if old >= gen || atomic.CompareAndSwapUint64(&s.gen, old, gen) {
+			return true
+		}
+	}
+}
+
+func (i *seriesIndex) release(s *indexedSeries) {
+	atomic.AddInt32(&s.pins, -1)
+}
+
+// unregister is the same as release, except it also removes the series if it was created by the
+// given holder and nobody else has got it since then, as if it had never been acquired.
+func (i *seriesIndex) unregister(s *indexedSeries) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if atomic.LoadInt32(&s.shared) != 0 || !atomic.CompareAndSwapInt32(&s.pins, 1, -1) {
+		i.release(s)
+		return
+	}
+	// It may have been got since checked above.
+	if atomic.LoadInt32(&s.shared) != 0 {
+		atomic.StoreInt32(&s.pins, 0)
+		return
+	}
+	i.delete(s)
+}
+
+// removeUnused removes series that inUse reports as not held by any in-memory partition.
+// Series got in the generation it ends, or pinned, are kept regardless.
+func (i *seriesIndex) removeUnused(inUse func(name string) bool) {
+	// Series got since the previous call are kept until the next call.
+	i.mu.Lock()
+	gen := atomic.AddUint64(&i.gen, 1) - 1
+	i.mu.Unlock()
+
+	i.series.Range(func(key, value interface{}) bool {
+		name := key.(string)
+		s := value.(*indexedSeries)
+		if atomic.LoadUint64(&s.gen) >= gen || inUse(name) {
+			return true
+		}
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		if !atomic.CompareAndSwapInt32(&s.pins, 0, -1) {
+			return true
+		}
+		// It may have been got and inserted since checked above.
+		if atomic.LoadUint64(&s.gen) >= gen || inUse(name) {
+			atomic.StoreInt32(&s.pins, 0)
+			return true
+		}
+		i.delete(s)
+		return true
+	})
+}
+
+// delete forgets the given series. mu must be held.
+func (i *seriesIndex) delete(s *indexedSeries) {
+	i.series.Delete(s.name)
+	i.refs.Delete(s.ref)
+	i.perMetric[s.metric]--
+	if i.perMetric[s.metric] <= 0 {
+		delete(i.perMetric, s.metric)
+	}
+	atomic.AddInt64(&i.total, -1)
+}
+
+// size gives back the number of all series without holding mu.
+func (i *seriesIndex) size() int {
+	return int(atomic.LoadInt64(&i.total))
+}
+
+// cardinality gives back the number of series for each metric.
+func (i *seriesIndex) cardinality() map[string]int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	res := make(map[string]int, len(i.perMetric))
+	for metric, n := range i.perMetric {
+		res[metric] = n
+	}
+	return res
+}
@@ -0,0 +1,144 @@
+package tstorage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_seriesIndex_register(t *testing.T) {
+	tests := []struct {
+		name          string
+		index         *seriesIndex
+		series        [][2]string
+		wantErrs      []bool
+		wantPerMetric bool
+	}{
+		{
+			name:     "unlimited",
+			index:    newSeriesIndex(),
+			series:   [][2]string{{"a1", "a"}, {"a2", "a"}, {"b1", "b"}},
+			wantErrs: []bool{false, false, false},
+		},
+		{
+			name: "total limit",
+			index: func() *seriesIndex {
+				i := newSeriesIndex()
+				i.maxSeries = 2
+				return i
+			}(),
+			series:   [][2]string{{"a1", "a"}, {"a1", "a"}, {"b1", "b"}, {"b2", "b"}},
+			wantErrs: []bool{false, false, false, true},
+		},
+		{
+			name: "per-metric limit",
+			index: func() *seriesIndex {
+				i := newSeriesIndex()
+				i.maxSeriesPerMetric = 1
+				i.metricLimits["b"] = 2
+				return i
+			}(),
+			series:        [][2]string{{"a1", "a"}, {"a2", "a"}, {"b1", "b"}, {"b2", "b"}},
+			wantErrs:      []bool{false, true, false, false},
+			wantPerMetric: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, s := range tt.series {
+				_, err := tt.index.register(s[0], s[1], nil)
+				if !tt.wantErrs[i] {
+					assert.NoError(t, err)
+					continue
+				}
+				assert.ErrorIs(t, err, ErrLimitExceeded)
+				var limitErr *SeriesLimitError
+				require.ErrorAs(t, err, &limitErr)
+				assert.Equal(t, tt.wantPerMetric, limitErr.PerMetric)
+			}
+		})
+	}
+}
+
+func Test_seriesIndex_removeUnused(t *testing.T) {
+	index := newSeriesIndex()
+	index.maxSeries = 2
+	a1, err := index.register("a1", "a", nil)
+	require.NoError(t, err)
+	_, err = index.register("b1", "b", nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, index.cardinality())
+
+	inUse := func(name string) bool {
+		return name == "b1"
+	}
+	// Series just registered survive the first removal.
+	index.removeUnused(inUse)
+	assert.Equal(t, map[string]int{"a": 1, "b": 1}, index.cardinality())
+	index.removeUnused(inUse)
+	assert.Equal(t, map[string]int{"b": 1}, index.cardinality())
+	_, ok := index.lookup(a1.ref)
+	assert.False(t, ok)
+	_, err = index.register("a2", "a", nil)
+	assert.NoError(t, err)
+}
+
+func Test_seriesIndex_acquire(t *testing.T) {
+	index := newSeriesIndex()
+	notInUse := func(string) bool { return false }
+	s, err := index.acquire("a1", "a", nil)
+	require.NoError(t, err)
+	index.removeUnused(notInUse)
+	index.removeUnused(notInUse)
+	got, ok := index.lookup(s.ref)
+	require.True(t, ok)
+	assert.Equal(t, s, got)
+
+	index.release(s)
+	index.removeUnused(notInUse)
+	index.removeUnused(notInUse)
+	_, ok = index.lookup(s.ref)
+	assert.False(t, ok)
+	// The removed series is never pinned again.
+	assert.False(t, index.pin(s))
+	s2, err := index.acquire("a1", "a", nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, s.ref, s2.ref)
+}
+
+func Test_seriesIndex_removeUnused_concurrentInsertion(t *testing.T) {
+	index := newSeriesIndex()
+	m := newMemoryPartition[float64](0, "").(*memoryPartition[float64])
+	m.seriesIndex = index
+	// Series held by older partitions, which are about to be flushed.
+	for i := 0; i < 1000; i++ {
+		_, err := index.register(fmt.Sprintf("metric%d", i), fmt.Sprintf("metric%d", i), nil)
+		require.NoError(t, err)
+	}
+	index.removeUnused(func(string) bool { return true })
+	index.removeUnused(func(string) bool { return true })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			index.removeUnused(m.hasMetric)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		_, err := m.insertRows([]Row[float64]{
+			{Metric: fmt.Sprintf("metric%d", i), DataPoint: DataPoint[float64]{Timestamp: 1}},
+		})
+		require.NoError(t, err)
+	}
+	<-done
+	// Series held by the partition are never removed.
+	index.removeUnused(m.hasMetric)
+	total := 0
+	for _, n := range index.cardinality() {
+		total += n
+	}
+	assert.Equal(t, 1000, total)
+}
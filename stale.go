@@ -0,0 +1,16 @@
+package tstorage
+
+import "math"
+
+// The bits of the NaN Prometheus uses as the stale marker.
+const staleNaNBits = 0x7ff0000000000002
+
+// StaleNaN is the stale marker, a special NaN value written when a series stops being reported,
+// such as when a scrape target disappears. It is compatible with the one of Prometheus.
+var StaleNaN = math.Float64frombits(staleNaNBits)
+
+// IsStaleNaN reports whether the given value is the stale marker.
+// Note that StaleNaN can't be compared with == as it is a NaN.
+func IsStaleNaN(v float64) bool {
+	return math.Float64bits(v) == staleNaNBits
+}
@@ -0,0 +1,14 @@
+package tstorage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsStaleNaN(t *testing.T) {
+	assert.True(t, IsStaleNaN(StaleNaN))
+	assert.False(t, IsStaleNaN(math.NaN()))
+	assert.False(t, IsStaleNaN(0))
+}
@@ -10,7 +10,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nakabonne/tstorage/internal/cgroup"
@@ -19,6 +21,18 @@ import (
 
 var (
 	ErrNoDataPoints = errors.New("no data points found")
+	// ErrOutOfBounds means rows are older than the out-of-order window. See WithOutOfOrderWindow.
+	// For BlockWriter, it means rows overlap existing partitions instead.
+	ErrOutOfBounds = errors.New("out of bounds")
+	// ErrInvalidRow means rows lack required fields.
+	ErrInvalidRow = errors.New("invalid row")
+	// ErrDuplicatePoint means a data point with the same timestamp already exists in the series.
+	// It's only given with RejectDuplicates.
+	ErrDuplicatePoint = errors.New("duplicate data point")
+	// ErrLimitExceeded means rows would exceed the configured limits. See WithMaxSeries.
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// ErrUnknownSeriesRef means the series a SeriesRef points to is no longer held in memory.
+	ErrUnknownSeriesRef = errors.New("unknown series reference")
 
 	// Limit the concurrency for data ingestion to GOMAXPROCS, since this operation
 	// is CPU bound, so there is no sense in running more than GOMAXPROCS concurrent
@@ -42,9 +56,9 @@ const (
 	defaultTimestampPrecision = Nanoseconds
 	defaultWriteTimeout       = 30 * time.Second
 	defaultWALBufferedSize    = 4096
+	defaultWALSegmentSize     = 128 << 20
 
-	writablePartitionsNum = 2
-	checkExpiredInterval  = time.Hour
+	checkExpiredInterval = time.Hour
 
 	walDirName = "wal"
 )
@@ -55,7 +69,45 @@ type Storage[T any] interface {
 	// InsertRows ingests the given rows to the time-series storage.
 	// If the timestamp is empty, it uses the machine's local timestamp in UTC.
 	// The precision of timestamps is nanoseconds by default. It can be changed using WithTimestampPrecision.
+	//
+	// Rows that can't be ingested don't prevent the others from being ingested.
+	// If rows are rejected only because they are older than the out-of-order window,
+	// an *OutOfBoundsError is given back.
+	// Use InsertRowsDetailed to find out which rows were rejected for which reason.
 	InsertRows(rows []Row[T]) error
+	// InsertRowsDetailed is the same as InsertRows, except it gives back the outcome for each row.
+	// An error is given back only if the whole rows failed, e.g. writing to WAL failed.
+	InsertRowsDetailed(rows []Row[T]) (InsertResult, error)
+	// Appender gives back a new Appender, which buffers rows to ingest them at once.
+	Appender() Appender[T]
+	// BlockWriter gives back a new BlockWriter, which builds disk partitions directly out of rows for backfilling.
+	BlockWriter() BlockWriter[T]
+	// GetRef gives back the reference to the series identified by the given metric and labels,
+	// which lets InsertRefs skip identifying the series again.
+	// The reference stays valid across partitions as long as the series is held in memory,
+	// and at least until the head partition at the time gets flushed even if nothing is inserted.
+	// The series gets registered if not yet, so it is subject to the series limits.
+	GetRef(metric string, labels []Label) (SeriesRef, error)
+	// InsertRefs is the same as InsertRows, except it takes references to series instead of
+	// metric names and labels. Rows with unknown references are rejected with ErrUnknownSeriesRef.
+	InsertRefs(rows []RefRow[T]) error
+	// Subscribe gives back the channel that receives rows matching all of the given matchers once they get inserted,
+	// along with the function to cancel it. The channel gets closed when cancelled or the storage gets closed,
+	// and right away if any of the matchers has an invalid regular expression.
+	// Rows received must not be modified.
+	Subscribe(matchers []*Matcher, opts ...SubscribeOption) (<-chan Row[T], func())
+	// Cardinality gives back the number of series held in memory for each metric.
+	Cardinality() map[string]int
+	// TimestampPrecision gives back the precision of timestamps, which is set with WithTimestampPrecision.
+	TimestampPrecision() TimestampPrecision
+	// WriterStats gives back how busy the writers are.
+	WriterStats() WriterStats
+	// Counters gives back the part of Stats kept up to date as the storage runs,
+	// which is cheap enough to be called on every scrape.
+	Counters() Counters
+	// Stats gives back the overview of partitions, WAL and flushes.
+	// It walks through all partitions, so it's not meant to be called at a high rate. Use Counters instead for that.
+	Stats() Stats
 	// Close gracefully shutdowns by flushing any unwritten data to the underlying disk partition.
 	Close() error
 }
@@ -66,6 +118,18 @@ type Reader[T any] interface {
 	// labels within the given start-end range. Keep in mind that start is inclusive, end is exclusive,
 	// and both must be Unix timestamp. ErrNoDataPoints will be returned if no data points found.
 	Select(metric string, labels []Label, start, end int64) (points []*DataPoint[T], err error)
+	// SelectSeries gives back all series matching all of the given matchers, along with their data points
+	// within the given start-end range, in the same manner as Select. Series without data points
+	// in the range are left out. An empty slice will be returned if no series found.
+	// An error is given back if any of the matchers has an invalid regular expression.
+	SelectSeries(matchers []*Matcher, start, end int64) ([]Series[T], error)
+}
+
+// Series is a kind of metrics along with its data points.
+type Series[T any] struct {
+	Metric string
+	Labels []Label
+	Points []*DataPoint[T]
 }
 
 // Row includes a data point along with properties to identify a kind of metrics.
@@ -79,6 +143,12 @@ type Row[T any] struct {
 	DataPoint[T]
 }
 
+// RefRow is a Row whose series is identified by a SeriesRef. See GetRef.
+type RefRow[T any] struct {
+	Ref SeriesRef
+	DataPoint[T]
+}
+
 // DataPoint represents a data point, the smallest unit of time series data.
 type DataPoint[T any] struct {
 	// The actual value. This field must be set.
@@ -87,6 +157,65 @@ type DataPoint[T any] struct {
 	Timestamp int64
 }
 
+// WriterStats describes how busy the writers are. See WithMaxConcurrentWriters.
+type WriterStats struct {
+	// The maximum number of concurrent writers.
+	MaxConcurrentWriters int
+	// The number of writers currently ingesting.
+	ActiveWriters int
+	// The number of writers waiting for others to finish.
+	QueueDepth int
+	// The number of writes that gave up waiting after the write timeout.
+	TimedOutWrites uint64
+	// How long writers waited before starting to ingest.
+	WaitDuration Histogram
+}
+
+// Counters describes the state of the storage that is kept track of without walking through partitions.
+type Counters struct {
+	MemoryPartitions int
+	DiskPartitions   int
+	// The range of timestamps held by the head partition, which takes the newest data points.
+	// Both are zero if it holds none.
+	HeadMinTimestamp int64
+	HeadMaxTimestamp int64
+	// The number of data points across all partitions.
+	NumDataPoints int64
+	// The number of series held in memory, which WithMaxSeries limits.
+	MemorySeries int
+	// Total size in bytes of WAL segments on disk, along with the number of them.
+	WALSize     int64
+	WALSegments int
+	// Total size in bytes of data files of disk partitions mapped into memory.
+	MappedBytes int64
+	// The number of rows ingested so far.
+	InsertedRows uint64
+	// How long each insertion took, including waiting for other writers.
+	InsertDuration Histogram
+	// The number of rows dropped for being older than the out-of-order window.
+	OutOfBoundsRows uint64
+	// The number of rows ingested with a timestamp older than the newest one of their series in the partition.
+	OutOfOrderRows uint64
+	// Total bytes of records written to WAL since opening, and how long each fsync of WAL took.
+	WALBytesWritten uint64
+	WALSyncDuration Histogram
+	// How long selecting data points from each in-memory and disk partition took.
+	MemorySelectDuration Histogram
+	DiskSelectDuration   Histogram
+	// How long flushing each in-memory partition to disk took, which includes encoding it into a disk partition.
+	FlushDuration Histogram
+	// The last error that flushing partitions gave back, and when it happened. Nil if it has never failed.
+	LastFlushError     error
+	LastFlushErrorTime time.Time
+}
+
+// Stats describes the state of the storage.
+type Stats struct {
+	Counters
+	// The number of distinct series across all partitions.
+	NumSeries int
+}
+
 // Option is an optional setting for NewStorage.
 type Option[T any] func(*storage[T])
 
@@ -124,6 +253,107 @@ func WithRetention[T any](retention time.Duration) Option[T] {
 	}
 }
 
+// WithOutOfOrderWindow specifies how far back from the newest data point writes are accepted.
+// Rows older than that are rejected with an *OutOfBoundsError, which wraps ErrOutOfBounds.
+//
+// As many in-memory partitions as needed to cover the window are kept writable,
+// so the wider the window, the more heap is used.
+//
+// Defaults to the partition duration.
+func WithOutOfOrderWindow[T any](window time.Duration) Option[T] {
+	return func(s *storage[T]) {
+		s.outOfOrderWindow = window
+	}
+}
+
+// WithStrictValidation makes InsertRows reject rows with invalid metric names or labels,
+// instead of silently truncating or skipping them, which may merge distinct series into one.
+// A row is rejected with a *ValidationError if any of:
+//   - the metric name doesn't match metricNamePattern
+//   - a label name doesn't match labelNamePattern
+//   - a label name or value is empty
+//   - label names are duplicated
+//   - a label name is longer than 256 bytes, or a label value is longer than 16KiB
+//
+// Giving nil patterns means the Prometheus-compatible charset, that is [a-zA-Z_:][a-zA-Z0-9_:]*
+// for metric names and [a-zA-Z_][a-zA-Z0-9_]* for label names.
+//
+// Defaults to disabled.
+func WithStrictValidation[T any](metricNamePattern, labelNamePattern *regexp.Regexp) Option[T] {
+	return func(s *storage[T]) {
+		s.validation.strict = true
+		s.validation.metricNamePattern = metricNamePattern
+		s.validation.labelNamePattern = labelNamePattern
+	}
+}
+
+// WithNonFiniteValuePolicy specifies how to treat NaN and ±Inf values.
+// Rejected rows come with a *ValidationError.
+//
+// Defaults to AcceptNonFinite.
+func WithNonFiniteValuePolicy[T any](policy NonFiniteValuePolicy) Option[T] {
+	return func(s *storage[T]) {
+		s.validation.nonFinitePolicy = policy
+	}
+}
+
+// WithDuplicatePointPolicy specifies how to treat a data point whose timestamp is already taken in the series.
+// It is only checked against data points held in memory, or buffered in the same BlockWriter.
+//
+// Defaults to KeepDuplicates.
+func WithDuplicatePointPolicy[T any](policy DuplicatePointPolicy) Option[T] {
+	return func(s *storage[T]) {
+		s.duplicatePolicy = policy
+	}
+}
+
+// WithMaxSeries specifies the maximum number of series held in memory.
+// Rows that would create a new series beyond the limit are rejected with a *SeriesLimitError.
+// A series is held in memory from when it is first inserted until all in-memory partitions
+// containing it are flushed.
+//
+// Defaults to 0, which means unlimited.
+func WithMaxSeries[T any](limit int) Option[T] {
+	return func(s *storage[T]) {
+		s.seriesIndex.maxSeries = limit
+	}
+}
+
+// WithMaxSeriesPerMetric specifies the maximum number of series held in memory for each metric.
+// Rows that would create a new series beyond the limit are rejected with a *SeriesLimitError.
+// It can be overridden for specific metrics with WithMetricSeriesLimit.
+//
+// Defaults to 0, which means unlimited.
+func WithMaxSeriesPerMetric[T any](limit int) Option[T] {
+	return func(s *storage[T]) {
+		s.seriesIndex.maxSeriesPerMetric = limit
+	}
+}
+
+// WithMetricSeriesLimit specifies the maximum number of series held in memory for the given metric,
+// taking precedence over WithMaxSeriesPerMetric. Give it multiple times to limit multiple metrics.
+// Giving 0 means unlimited for the metric.
+func WithMetricSeriesLimit[T any](metric string, limit int) Option[T] {
+	return func(s *storage[T]) {
+		s.seriesIndex.metricLimits[metric] = limit
+	}
+}
+
+// WithMemoryBudget specifies the approximate amount of heap in-memory partitions are allowed to use,
+// either in bytes with MemoryBudgetBytes or as a fraction of the cgroup memory limit with MemoryBudgetFraction.
+//
+// Once in-memory partitions get close to the budget, the oldest one gets flushed to disk early,
+// which makes rows for it out of bounds. If they still use up the budget, InsertRows fails
+// with an error wrapping ErrLimitExceeded until enough heap is released.
+// In the in-memory mode, writes are just refused since there is nowhere to flush to.
+//
+// Defaults to unlimited.
+func WithMemoryBudget[T any](budget MemoryBudget) Option[T] {
+	return func(s *storage[T]) {
+		s.memoryBudget = budget
+	}
+}
+
 // WithTimestampPrecision specifies the precision of timestamps to be used by all operations.
 //
 // Defaults to Nanoseconds
@@ -145,6 +375,16 @@ func WithWriteTimeout[T any](timeout time.Duration) Option[T] {
 	}
 }
 
+// WithMaxConcurrentWriters specifies the maximum number of goroutines ingesting at the same time.
+// Extra writers wait for up to the write timeout. See WithWriteTimeout.
+//
+// Defaults to the number of available CPU cores.
+func WithMaxConcurrentWriters[T any](n int) Option[T] {
+	return func(s *storage[T]) {
+		s.maxConcurrentWriters = n
+	}
+}
+
 // WithLogger specifies the logger to emit verbose output.
 //
 // Defaults to a logger implementation that does nothing.
@@ -166,26 +406,68 @@ func WithWALBufferedSize[T any](size int) Option[T] {
 	}
 }
 
+// WithWALSegmentSize specifies the maximum byte size of a WAL segment file, beyond which a new segment gets created.
+// Giving 0 means segments only get rotated when a new partition is created.
+//
+// Defaults to 128MiB.
+func WithWALSegmentSize[T any](size int64) Option[T] {
+	return func(s *storage[T]) {
+		s.walSegmentSize = size
+	}
+}
+
+// WithWALSyncPolicy specifies when WAL gets fsynced, which determines what can be lost on power loss.
+// With WALSyncAlways, concurrent writers are grouped into a single fsync to mitigate its cost.
+// Giving WALSyncInterval with a non-positive interval is the same as WALSyncNever.
+//
+// Defaults to WALSyncNever.
+func WithWALSyncPolicy[T any](policy WALSyncPolicy) Option[T] {
+	return func(s *storage[T]) {
+		s.walSyncPolicy = policy
+	}
+}
+
 // NewStorage gives back a new storage, which stores time-series data in the process memory by default.
 //
 // Give the WithDataPath option for running as a on-disk storage. Specify a directory with data already exists,
 // then it will be read as the initial data.
 func NewStorage[T any](opts ...Option[T]) (Storage[T], error) {
 	s := &storage[T]{
-		partitionList:      newPartitionList[T](),
-		workersLimitCh:     make(chan struct{}, defaultWorkersLimit),
-		partitionDuration:  defaultPartitionDuration,
-		retention:          defaultRetention,
-		timestampPrecision: defaultTimestampPrecision,
-		writeTimeout:       defaultWriteTimeout,
-		walBufferedSize:    defaultWALBufferedSize,
-		wal:                &nopWAL[T]{},
-		logger:             &nopLogger{},
-		doneCh:             make(chan struct{}),
+		partitionList:        newPartitionList[T](),
+		seriesIndex:          newSeriesIndex(),
+		maxConcurrentWriters: defaultWorkersLimit,
+		writeWaitDuration:    newHistogram(defaultDurationBuckets),
+		insertDuration:       newHistogram(defaultDurationBuckets),
+		memorySelectDuration: newHistogram(defaultDurationBuckets),
+		diskSelectDuration:   newHistogram(defaultDurationBuckets),
+		flushDuration:        newHistogram(defaultDurationBuckets),
+		partitionDuration:    defaultPartitionDuration,
+		retention:            defaultRetention,
+		outOfOrderWindow:     -1,
+		timestampPrecision:   defaultTimestampPrecision,
+		writeTimeout:         defaultWriteTimeout,
+		walBufferedSize:      defaultWALBufferedSize,
+		walSegmentSize:       defaultWALSegmentSize,
+		wal:                  &nopWAL[T]{},
+		logger:               &nopLogger{},
+		replication:          newReplicationHub(),
+		subscriptions:        newSubscriptions[T](),
+		doneCh:               make(chan struct{}),
 	}
 	for _, opt := range opts {
 		opt(s)
 	}
+	if s.outOfOrderWindow < 0 {
+		s.outOfOrderWindow = s.partitionDuration
+	}
+	s.memoryBudgetBytes = s.memoryBudget.resolve()
+	if s.maxConcurrentWriters <= 0 {
+		s.maxConcurrentWriters = defaultWorkersLimit
+	}
+	s.workersLimitCh = make(chan struct{}, s.maxConcurrentWriters)
+	if s.walSyncPolicy.mode == walSyncInterval && s.walSyncPolicy.interval <= 0 {
+		s.walSyncPolicy = WALSyncNever
+	}
 
 	if s.inMemoryMode() {
 		s.newPartition(nil, false)
@@ -198,7 +480,7 @@ func NewStorage[T any](opts ...Option[T]) (Storage[T], error) {
 
 	walDir := filepath.Join(s.dataPath, walDirName)
 	if s.walBufferedSize >= 0 {
-		wal, err := newDiskWAL[T](walDir, s.walBufferedSize)
+		wal, err := newDiskWAL[T](walDir, s.walBufferedSize, s.walSegmentSize, s.walSyncPolicy)
 		if err != nil {
 			return nil, err
 		}
@@ -219,6 +501,13 @@ func NewStorage[T any](opts ...Option[T]) (Storage[T], error) {
 	}
 	partitions := make([]partition[T], 0, len(dirs))
 	for _, e := range dirs {
+		if e.IsDir() && strings.HasPrefix(e.Name(), blockTmpDirPrefix) {
+			// A block left by a crash while BlockWriter was building it.
+			if err := os.RemoveAll(filepath.Join(s.dataPath, e.Name())); err != nil {
+				return nil, fmt.Errorf("failed to remove %s: %w", e.Name(), err)
+			}
+			continue
+		}
 		if !isPartitionDir(e) {
 			continue
 		}
@@ -248,6 +537,10 @@ func NewStorage[T any](opts ...Option[T]) (Storage[T], error) {
 	}
 	s.newPartition(nil, false)
 
+	if s.walSyncPolicy.mode == walSyncInterval {
+		go s.syncWALPeriodically(s.walSyncPolicy.interval)
+	}
+
 	// periodically check and permanently remove expired partitions.
 	go func() {
 		ticker := time.NewTicker(checkExpiredInterval)
@@ -267,76 +560,437 @@ func NewStorage[T any](opts ...Option[T]) (Storage[T], error) {
 	return s, nil
 }
 
+// syncWALPeriodically fsyncs WAL at the given interval until the storage gets closed.
+func (s *storage[T]) syncWALPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.doneCh:
+			return
+		case <-ticker.C:
+			if err := s.wal.sync(); err != nil {
+				s.logger.Printf("failed to sync WAL: %v\n", err)
+			}
+		}
+	}
+}
+
 type storage[T any] struct {
 	partitionList partitionList[T]
+	seriesIndex   *seriesIndex
 
 	walBufferedSize    int
+	walSegmentSize     int64
+	walSyncPolicy      WALSyncPolicy
 	wal                wal[T]
 	partitionDuration  time.Duration
 	retention          time.Duration
+	outOfOrderWindow   time.Duration
+	validation         validation
+	duplicatePolicy    DuplicatePointPolicy
+	memoryBudget       MemoryBudget
+	memoryBudgetBytes  int64
 	timestampPrecision TimestampPrecision
 	dataPath           string
 	writeTimeout       time.Duration
 
-	logger         Logger
-	workersLimitCh chan struct{}
+	logger               Logger
+	maxConcurrentWriters int
+	workersLimitCh       chan struct{}
+	// The number of writers waiting for workersLimitCh.
+	writeQueueDepth   int64
+	timedOutWrites    uint64
+	writeWaitDuration *histogram
+	insertedRows      uint64
+	insertDuration    *histogram
+	// The number of rows dropped for being older than the out-of-order window.
+	outOfBoundsRows uint64
+	// The number of rows inserted out of order, which memory partitions count up.
+	outOfOrderRows       uint64
+	memorySelectDuration *histogram
+	diskSelectDuration   *histogram
+	flushDuration        *histogram
+	// Holds the last flushFailure.
+	lastFlushFailure atomic.Value
+	// 1 while flushing early due to the memory budget.
+	flushingEarly int32
+	// flushMu prevents flushing the same partition concurrently.
+	flushMu sync.Mutex
+	// insertMu is read-locked while rows are inserted, so that BlockWriter can exclude inserts.
+	insertMu sync.RWMutex
 	// wg must be incremented to guarantee all writes are done gracefully.
 	wg sync.WaitGroup
+	// Followers streamed from ReplicationSources.
+	replication   *replicationHub
+	subscriptions *subscriptions[T]
 
 	doneCh chan struct{}
 }
 
 func (s *storage[T]) InsertRows(rows []Row[T]) error {
+	res, err := s.InsertRowsDetailed(rows)
+	if err != nil {
+		return err
+	}
+	return res.err()
+}
+
+func (s *storage[T]) InsertRowsDetailed(rows []Row[T]) (InsertResult, error) {
+	return s.insertRows(rows, nil)
+}
+
+// insertRows ingests the given rows. It optionally takes the already identified series for each row,
+// so that partitions can skip identifying them again.
+func (s *storage[T]) insertRows(rows []Row[T], series []*indexedSeries) (InsertResult, error) {
 	s.wg.Add(1)
 	defer s.wg.Done()
+	if s.insertDuration != nil {
+		defer s.insertDuration.since(time.Now())
+	}
 
-	insert := func() error {
+	insert := func() (InsertResult, error) {
 		defer func() { <-s.workersLimitCh }()
+		if err := s.checkMemoryBudget(); err != nil {
+			return InsertResult{}, err
+		}
 		if err := s.ensureActiveHead(); err != nil {
-			return err
+			return InsertResult{}, err
 		}
+		s.insertMu.RLock()
+		defer s.insertMu.RUnlock()
+		// indexes holds the index within the given rows for each of rowsToInsert.
+		// It is nil as long as they are identical.
+		filledRows := fillTimestamps(rows, s.timestampPrecision)
+		rowsToInsert, indexes, identified, rejected := s.filterRows(filledRows, series)
+		seriesToInsert := pickSeries(identified, indexes)
+		// Rows are written to WAL before getting visible, so that failing to write leaves nothing ingested.
+		if len(rowsToInsert) > 0 {
+			if err := s.wal.append(operationInsert, rowsToInsert); err != nil {
+				return InsertResult{}, fmt.Errorf("failed to write to WAL: %w", err)
+			}
+		}
+
 		iterator := s.partitionList.newIterator()
 		n := s.partitionList.size()
-		rowsToInsert := rows
 		// Starting at the head partition, try to insert rows, and loop to insert outdated rows
-		// into older partitions. Any rows more than `writablePartitionsNum` partitions out
-		// of date are dropped.
-		for i := 0; i < n && i < writablePartitionsNum; i++ {
+		// into older partitions. Any rows more than writablePartitionsNum partitions out
+		// of date are rejected.
+		for i := 0; i < n && i < s.writablePartitionsNum(); i++ {
 			if len(rowsToInsert) == 0 {
 				break
 			}
 			if !iterator.next() {
 				break
 			}
-			outdatedRows, err := iterator.value().insertRows(rowsToInsert)
+			if _, ok := iterator.value().(*diskPartition[T]); ok {
+				// Disk partitions are immutable.
+				break
+			}
+			var outdatedRows []Row[T]
+			var err error
+			if memPart, ok := iterator.value().(*memoryPartition[T]); ok {
+				outdatedRows, err = memPart.insertSeriesRows(rowsToInsert, seriesToInsert)
+			} else {
+				outdatedRows, err = iterator.value().insertRows(rowsToInsert)
+			}
 			if err != nil {
-				return fmt.Errorf("failed to insert rows: %w", err)
+				var rejectedErr *rejectedRowsError
+				if !errors.As(err, &rejectedErr) {
+					return InsertResult{}, fmt.Errorf("failed to insert rows: %w", err)
+				}
+				for j, e := range rejectedErr.errs {
+					rejected = rejectRow(rejected, indexes, j, e)
+				}
+			}
+			if len(outdatedRows) > 0 {
+				indexes = outdatedIndexes(rowsToInsert, outdatedRows, indexes)
+				seriesToInsert = pickSeries(identified, indexes)
 			}
 			rowsToInsert = outdatedRows
 		}
-		return nil
+		for i := range rowsToInsert {
+			rejected = rejectRow(rejected, indexes, i, ErrOutOfBounds)
+		}
+		atomic.AddUint64(&s.outOfBoundsRows, uint64(len(rowsToInsert)))
+		s.subscriptions.publish(filledRows, rejected)
+		accepted := len(rows) - len(rejected)
+		atomic.AddUint64(&s.insertedRows, uint64(accepted))
+		return InsertResult{
+			Accepted: accepted,
+			Errors:   rejected,
+		}, nil
 	}
 
 	// Limit the number of concurrent goroutines to prevent from out of memory
 	// errors and CPU trashing even if too many goroutines attempt to write.
 	select {
 	case s.workersLimitCh <- struct{}{}:
+		s.observeWriteWait(0)
 		return insert()
 	default:
 	}
 
 	// Seems like all workers are busy; wait for up to writeTimeout
 
+	atomic.AddInt64(&s.writeQueueDepth, 1)
+	defer atomic.AddInt64(&s.writeQueueDepth, -1)
+	start := time.Now()
 	t := timerpool.Get(s.writeTimeout)
 	select {
 	case s.workersLimitCh <- struct{}{}:
 		timerpool.Put(t)
+		s.observeWriteWait(time.Since(start))
 		return insert()
 	case <-t.C:
 		timerpool.Put(t)
-		return fmt.Errorf("failed to write a data point in %s, since it is overloaded with %d concurrent writers",
-			s.writeTimeout, defaultWorkersLimit)
+		atomic.AddUint64(&s.timedOutWrites, 1)
+		return InsertResult{}, fmt.Errorf("failed to write a data point in %s, since it is overloaded with %d concurrent writers",
+			s.writeTimeout, cap(s.workersLimitCh))
+	}
+}
+
+func (s *storage[T]) observeWriteWait(d time.Duration) {
+	if s.writeWaitDuration != nil {
+		s.writeWaitDuration.observe(d)
+	}
+}
+
+// observeSelect observes how long selecting from the given partition took, by the type of it.
+func (s *storage[T]) observeSelect(part partition[T], d time.Duration) {
+	h := s.memorySelectDuration
+	if _, ok := part.(*diskPartition[T]); ok {
+		h = s.diskSelectDuration
+	}
+	if h != nil {
+		h.observe(d)
+	}
+}
+
+func (s *storage[T]) WriterStats() WriterStats {
+	stats := WriterStats{
+		MaxConcurrentWriters: cap(s.workersLimitCh),
+		ActiveWriters:        len(s.workersLimitCh),
+		QueueDepth:           int(atomic.LoadInt64(&s.writeQueueDepth)),
+		TimedOutWrites:       atomic.LoadUint64(&s.timedOutWrites),
+	}
+	if s.writeWaitDuration != nil {
+		stats.WaitDuration = s.writeWaitDuration.snapshot()
+	}
+	return stats
+}
+
+// flushFailure is an error that flushing partitions gave back.
+type flushFailure struct {
+	err error
+	at  time.Time
+}
+
+func (s *storage[T]) Stats() Stats {
+	stats := Stats{Counters: s.Counters()}
+	names := make(map[string]struct{})
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		for _, name := range iterator.value().metricNames() {
+			names[name] = struct{}{}
+		}
+	}
+	stats.NumSeries = len(names)
+	return stats
+}
+
+func (s *storage[T]) Counters() Counters {
+	disk := s.partitionList.diskStats()
+	stats := Counters{
+		MemoryPartitions: s.partitionList.size() - disk.partitions,
+		DiskPartitions:   disk.partitions,
+		NumDataPoints:    disk.dataPoints,
+		MappedBytes:      disk.mappedBytes,
+		MemorySeries:     s.seriesIndex.size(),
+		InsertedRows:     atomic.LoadUint64(&s.insertedRows),
+		OutOfBoundsRows:  atomic.LoadUint64(&s.outOfBoundsRows),
+		OutOfOrderRows:   atomic.LoadUint64(&s.outOfOrderRows),
+	}
+	if head := s.partitionList.getHead(); head != nil && head.size() > 0 {
+		stats.HeadMinTimestamp = head.minTimestamp()
+		stats.HeadMaxTimestamp = head.maxTimestamp()
+	}
+	// In-memory partitions are the newest few, which come before disk partitions.
+	// Data points in the ones left behind a disk partition as flushing them failed aren't counted.
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		memPart, ok := iterator.value().(*memoryPartition[T])
+		if !ok {
+			break
+		}
+		stats.NumDataPoints += int64(memPart.size())
+	}
+	wal := s.wal.stats()
+	stats.WALSize = wal.size
+	stats.WALSegments = wal.segments
+	stats.WALBytesWritten = wal.bytesWritten
+	stats.WALSyncDuration = wal.syncDuration
+	if s.insertDuration != nil {
+		stats.InsertDuration = s.insertDuration.snapshot()
+	}
+	if s.memorySelectDuration != nil {
+		stats.MemorySelectDuration = s.memorySelectDuration.snapshot()
+		stats.DiskSelectDuration = s.diskSelectDuration.snapshot()
+	}
+	if s.flushDuration != nil {
+		stats.FlushDuration = s.flushDuration.snapshot()
+	}
+	if f, ok := s.lastFlushFailure.Load().(flushFailure); ok {
+		stats.LastFlushError = f.err
+		stats.LastFlushErrorTime = f.at
+	}
+	return stats
+}
+
+// fillTimestamps gives back rows whose empty timestamps are filled with the current time.
+// The given rows are copied only if any of them has to be filled.
+func fillTimestamps[T any](rows []Row[T], precision TimestampPrecision) []Row[T] {
+	filled := rows
+	copied := false
+	for i := range rows {
+		if rows[i].Timestamp != 0 {
+			continue
+		}
+		if !copied {
+			filled = make([]Row[T], len(rows))
+			copy(filled, rows)
+			copied = true
+		}
+		filled[i].Timestamp = toUnix(time.Now(), precision)
+	}
+	return filled
+}
+
+// filterRows separates rows that can't be ingested from the given ones.
+// It gives back the accepted rows along with their indexes within the given rows,
+// the series of each of the given rows, and the reasons why the others were rejected keyed by their indexes.
+// The indexes of accepted rows are nil if nothing is rejected.
+//
+// Rows with identified series are supposed to have the valid metric name and labels.
+// The others get their series identified here, so that rows exceeding the series limits are
+// rejected before being written to WAL.
+func (s *storage[T]) filterRows(rows []Row[T], series []*indexedSeries) (accepted []Row[T], acceptedIndexes []int, identified []*indexedSeries, rejected map[int]error) {
+	newest, bounded := s.newestTimestamp()
+	bound := newest - durationIn(s.outOfOrderWindow, s.timestampPrecision)
+	var outOfBounds uint64
+	defer func() {
+		if outOfBounds > 0 {
+			atomic.AddUint64(&s.outOfBoundsRows, outOfBounds)
+		}
+	}()
+	identified = make([]*indexedSeries, len(rows))
+	copy(identified, series)
+	for i := range rows {
+		var err error
+		if series != nil && series[i] != nil {
+			err = s.validation.validateValue(rows[i].Value)
+		} else {
+			err = s.validation.validate(rows[i].Metric, rows[i].Labels, rows[i].Value)
+		}
+		if err == nil && bounded && rows[i].Timestamp < bound {
+			err = ErrOutOfBounds
+			outOfBounds++
+		}
+		if err == nil && identified[i] == nil {
+			identified[i], err = s.seriesIndex.register(marshalMetricName(rows[i].Metric, rows[i].Labels), rows[i].Metric, rows[i].Labels)
+		}
+		if err == nil {
+			continue
+		}
+		if rejected == nil {
+			rejected = make(map[int]error)
+		}
+		rejected[i] = err
+	}
+	if len(rejected) == 0 {
+		return rows, nil, identified, nil
+	}
+	accepted = make([]Row[T], 0, len(rows)-len(rejected))
+	acceptedIndexes = make([]int, 0, len(rows)-len(rejected))
+	for i := range rows {
+		if _, ok := rejected[i]; ok {
+			continue
+		}
+		accepted = append(accepted, rows[i])
+		acceptedIndexes = append(acceptedIndexes, i)
+	}
+	return accepted, acceptedIndexes, identified, rejected
+}
+
+// rejectRow records the given reason for the i-th row, which is at indexes[i] within the original rows.
+// nil indexes mean they are identical.
+func rejectRow(rejected map[int]error, indexes []int, i int, err error) map[int]error {
+	if indexes != nil {
+		i = indexes[i]
+	}
+	if rejected == nil {
+		rejected = make(map[int]error)
+	}
+	rejected[i] = err
+	return rejected
+}
+
+// pickSeries gives back the series at the given indexes. nil indexes mean all of them.
+func pickSeries(series []*indexedSeries, indexes []int) []*indexedSeries {
+	if series == nil || indexes == nil {
+		return series
+	}
+	res := make([]*indexedSeries, len(indexes))
+	for i, idx := range indexes {
+		res[i] = series[idx]
+	}
+	return res
+}
+
+// newestTimestamp gives back the max timestamp of the newest partition that has data points.
+func (s *storage[T]) newestTimestamp() (int64, bool) {
+	if head := s.partitionList.getHead(); head != nil && head.size() > 0 {
+		return head.maxTimestamp(), true
+	}
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		part := iterator.value()
+		if part == nil || part.size() == 0 {
+			continue
+		}
+		return part.maxTimestamp(), true
+	}
+	return 0, false
+}
+
+// outdatedIndexes gives back the index within the original rows for each of the outdated rows.
+// indexes holds the original index for each of rows, nil means they are identical.
+//
+// Partitions give back outdated rows in the given order, and decide on whether a row is outdated
+// only by its timestamp, so matching timestamps from the beginning is enough to identify them.
+func outdatedIndexes[T any](rows, outdatedRows []Row[T], indexes []int) []int {
+	res := make([]int, 0, len(outdatedRows))
+	for i, j := 0, 0; i < len(rows) && j < len(outdatedRows); i++ {
+		if rows[i].Timestamp != outdatedRows[j].Timestamp {
+			continue
+		}
+		if indexes == nil {
+			res = append(res, i)
+		} else {
+			res = append(res, indexes[i])
+		}
+		j++
+	}
+	return res
+}
+
+// writablePartitionsNum gives back the number of partitions from the head to be kept writable,
+// in order to cover the out-of-order window.
+func (s *storage[T]) writablePartitionsNum() int {
+	if s.partitionDuration <= 0 {
+		return 1
 	}
+	return 1 + int((s.outOfOrderWindow+s.partitionDuration-1)/s.partitionDuration)
 }
 
 // ensureActiveHead ensures the head of partitionList is an active partition.
@@ -386,7 +1040,9 @@ func (s *storage[T]) Select(metric string, labels []Label, start, end int64) ([]
 		if part.minTimestamp() > end {
 			continue
 		}
+		selectStart := time.Now()
 		ps, err := part.selectDataPoints(metric, labels, start, end)
+		s.observeSelect(part, time.Since(selectStart))
 		if errors.Is(err, ErrNoDataPoints) {
 			continue
 		}
@@ -402,9 +1058,145 @@ func (s *storage[T]) Select(metric string, labels []Label, start, end int64) ([]
 	return points, nil
 }
 
+func (s *storage[T]) SelectSeries(matchers []*Matcher, start, end int64) ([]Series[T], error) {
+	if start >= end {
+		return nil, fmt.Errorf("the given start is greater than end")
+	}
+	matchers, err := compileMatchers(matchers)
+	if err != nil {
+		return nil, err
+	}
+	// Collect the names of metrics held by partitions overlapping the range.
+	names := make(map[string]struct{})
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		part := iterator.value()
+		if part == nil {
+			return nil, fmt.Errorf("unexpected empty partition found")
+		}
+		if part.minTimestamp() == 0 {
+			continue
+		}
+		if part.maxTimestamp() < start {
+			break
+		}
+		if part.minTimestamp() > end {
+			continue
+		}
+		for _, name := range part.metricNames() {
+			names[name] = struct{}{}
+		}
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	series := make([]Series[T], 0)
+	for _, name := range sortedNames {
+		metric, labels := unmarshalMetricName(name)
+		if !matchSeries(matchers, metric, labels) {
+			continue
+		}
+		points, err := s.Select(metric, labels, start, end)
+		if errors.Is(err, ErrNoDataPoints) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, Series[T]{
+			Metric: metric,
+			Labels: labels,
+			Points: points,
+		})
+	}
+	return series, nil
+}
+
+func (s *storage[T]) GetRef(metric string, labels []Label) (SeriesRef, error) {
+	if err := s.validation.validateSeries(metric, labels); err != nil {
+		return 0, err
+	}
+	series, err := s.seriesIndex.register(marshalMetricName(metric, labels), metric, labels)
+	if err != nil {
+		return 0, err
+	}
+	return series.ref, nil
+}
+
+func (s *storage[T]) InsertRefs(refRows []RefRow[T]) error {
+	rows := make([]Row[T], 0, len(refRows))
+	series := make([]*indexedSeries, 0, len(refRows))
+	// The index within refRows for each of rows, nil means they are identical.
+	var indexes []int
+	var unknown []int
+	for i := range refRows {
+		ss, ok := s.seriesIndex.lookup(refRows[i].Ref)
+		if !ok {
+			if indexes == nil {
+				indexes = make([]int, 0, len(refRows))
+				for j := 0; j < i; j++ {
+					indexes = append(indexes, j)
+				}
+			}
+			unknown = append(unknown, i)
+			continue
+		}
+		if indexes != nil {
+			indexes = append(indexes, i)
+		}
+		rows = append(rows, Row[T]{Metric: ss.metric, Labels: ss.labels, DataPoint: refRows[i].DataPoint})
+		series = append(series, ss)
+	}
+	res, err := s.insertRows(rows, series)
+	if err != nil {
+		return err
+	}
+	if len(unknown) == 0 {
+		return res.err()
+	}
+	// Make the outcome refer to refRows.
+	errs := make(map[int]error, len(res.Errors)+len(unknown))
+	for i, e := range res.Errors {
+		errs[indexes[i]] = e
+	}
+	for _, i := range unknown {
+		errs[i] = fmt.Errorf("series %d: %w", refRows[i].Ref, ErrUnknownSeriesRef)
+	}
+	res.Errors = errs
+	return res.err()
+}
+
+func (s *storage[T]) Appender() Appender[T] {
+	return &appender[T]{
+		storage: s,
+	}
+}
+
+func (s *storage[T]) Subscribe(matchers []*Matcher, opts ...SubscribeOption) (<-chan Row[T], func()) {
+	return s.subscriptions.subscribe(matchers, opts...)
+}
+
+func (s *storage[T]) Cardinality() map[string]int {
+	if s.seriesIndex == nil {
+		return map[string]int{}
+	}
+	return s.seriesIndex.cardinality()
+}
+
+func (s *storage[T]) TimestampPrecision() TimestampPrecision {
+	return s.timestampPrecision
+}
+
 func (s *storage[T]) Close() error {
+	// Writers may be blocked by subscribers that no longer receive.
+	s.subscriptions.unblock()
 	s.wg.Wait()
 	close(s.doneCh)
+	s.replication.close()
+	s.subscriptions.close()
 	if err := s.wal.flush(); err != nil {
 		return fmt.Errorf("failed to flush buffered WAL: %w", err)
 	}
@@ -412,7 +1204,7 @@ func (s *storage[T]) Close() error {
 	// TODO: Prevent from new goroutines calling InsertRows(), for graceful shutdown.
 
 	// Make all writable partitions read-only by inserting as same number of those.
-	for i := 0; i < writablePartitionsNum; i++ {
+	for i := 0; i < s.writablePartitionsNum(); i++ {
 		if err := s.newPartition(nil, true); err != nil {
 			return err
 		}
@@ -432,7 +1224,7 @@ func (s *storage[T]) Close() error {
 
 func (s *storage[T]) newPartition(p partition[T], punctuateWal bool) error {
 	if p == nil {
-		p = newMemoryPartition(s.wal, s.partitionDuration, s.timestampPrecision)
+		p = s.createMemoryPartition()
 	}
 	s.partitionList.insert(p)
 	if punctuateWal {
@@ -441,15 +1233,58 @@ func (s *storage[T]) newPartition(p partition[T], punctuateWal bool) error {
 	return nil
 }
 
+// createMemoryPartition gives back a new in-memory partition sharing the storage-wide state.
+func (s *storage[T]) createMemoryPartition() *memoryPartition[T] {
+	memPart := newMemoryPartition[T](s.partitionDuration, s.timestampPrecision).(*memoryPartition[T])
+	memPart.seriesIndex = s.seriesIndex
+	memPart.rejectDuplicates = s.duplicatePolicy == RejectDuplicates
+	memPart.outOfOrderRows = &s.outOfOrderRows
+	return memPart
+}
+
 // flushPartitions persists all in-memory partitions ready to persisted.
 // For the in-memory mode, just removes it from the partition list.
 func (s *storage[T]) flushPartitions() error {
-	// Keep the first two partitions as is even if they are inactive,
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	if err := s.flushMemoryPartitions(); err != nil {
+		s.lastFlushFailure.Store(flushFailure{err: err, at: time.Now()})
+		return err
+	}
+	s.removeUnusedSeries()
+	return nil
+}
+
+// removeUnusedSeries forgets series that no in-memory partition holds anymore.
+func (s *storage[T]) removeUnusedSeries() {
+	if s.seriesIndex == nil {
+		return
+	}
+	memParts := make([]*memoryPartition[T], 0, s.writablePartitionsNum())
+	iterator := s.partitionList.newIterator()
+	for iterator.next() {
+		if memPart, ok := iterator.value().(*memoryPartition[T]); ok {
+			memParts = append(memParts, memPart)
+		}
+	}
+	s.seriesIndex.removeUnused(func(name string) bool {
+		for _, p := range memParts {
+			if p.hasMetric(name) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// flushMemoryPartitions swaps in-memory partitions that are no longer writable for disk ones.
+func (s *storage[T]) flushMemoryPartitions() error {
+	// Keep the writable partitions as is even if they are inactive,
 	// to accept out-of-order data points.
 	i := 0
 	iterator := s.partitionList.newIterator()
 	for iterator.next() {
-		if i < writablePartitionsNum {
+		if i < s.writablePartitionsNum() {
 			i++
 			continue
 		}
@@ -462,10 +1297,14 @@ func (s *storage[T]) flushPartitions() error {
 			continue
 		}
 
-		if s.inMemoryMode() {
+		// Empty partitions, e.g. the ones created by Close, are not worth a directory.
+		if s.inMemoryMode() || memPart.size() == 0 {
 			if err := s.partitionList.remove(part); err != nil {
 				return fmt.Errorf("failed to remove partition: %w", err)
 			}
+			if err := s.removeOldestWAL(memPart); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -473,6 +1312,7 @@ func (s *storage[T]) flushPartitions() error {
 		// The disk partition will place at where in-memory one existed.
 
 		dir := filepath.Join(s.dataPath, fmt.Sprintf("p-%d-%d", memPart.minTimestamp(), memPart.maxTimestamp()))
+		start := time.Now()
 		if err := s.flush(dir, memPart); err != nil {
 			return fmt.Errorf("failed to compact memory partition into %s: %w", dir, err)
 		}
@@ -481,6 +1321,9 @@ func (s *storage[T]) flushPartitions() error {
 			if err := s.partitionList.remove(part); err != nil {
 				return fmt.Errorf("failed to remove partition: %w", err)
 			}
+			if err := s.removeOldestWAL(memPart); err != nil {
+				return err
+			}
 			continue
 		}
 		if err != nil {
@@ -489,14 +1332,30 @@ func (s *storage[T]) flushPartitions() error {
 		if err := s.partitionList.swap(part, newPart); err != nil {
 			return fmt.Errorf("failed to swap partitions: %w", err)
 		}
+		if s.flushDuration != nil {
+			s.flushDuration.since(start)
+		}
+		s.replication.partitionFlushed(memPart.minTimestamp(), memPart.maxTimestamp())
 
-		if err := s.wal.removeOldest(); err != nil {
-			return fmt.Errorf("failed to remove oldest WAL segment: %w", err)
+		if err := s.removeOldestWAL(memPart); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// removeOldestWAL removes the oldest WAL segment group as the given partition is gone,
+// unless no group was started for it.
+func (s *storage[T]) removeOldestWAL(memPart *memoryPartition[T]) error {
+	if memPart.noWALGroup {
+		return nil
+	}
+	if err := s.wal.removeOldest(); err != nil {
+		return fmt.Errorf("failed to remove oldest WAL segment: %w", err)
+	}
+	return nil
+}
+
 // flush compacts the data points in the given partition and flushes them to the given directory.
 func (s *storage[T]) flush(dirPath string, m *memoryPartition[T]) error {
 	if dirPath == "" {
@@ -588,7 +1447,7 @@ func (s *storage[T]) removeExpiredPartitions() error {
 	return nil
 }
 
-// recoverWAL inserts all records within the given wal, and then removes all WAL segment files.
+// recoverWAL inserts all records within the given wal, and then removes the WAL segment files it read.
 func (s *storage[T]) recoverWAL(walDir string) error {
 	reader, err := newDiskWALReader[T](walDir)
 	if errors.Is(err, os.ErrNotExist) {
@@ -605,10 +1464,15 @@ func (s *storage[T]) recoverWAL(walDir string) error {
 	if len(reader.rowsToInsert) == 0 {
 		return nil
 	}
-	if err := s.InsertRows(reader.rowsToInsert); err != nil {
+	res, err := s.InsertRowsDetailed(reader.rowsToInsert)
+	if err != nil {
 		return fmt.Errorf("failed to insert rows recovered from WAL: %w", err)
 	}
-	return s.wal.refresh()
+	if len(res.Errors) > 0 {
+		s.logger.Printf("dropped rows recovered from WAL: %v\n", res.err())
+	}
+	// Recovered rows have been written into the current segments again.
+	return s.wal.removeRecovered()
 }
 
 func (s *storage[T]) inMemoryMode() bool {
@@ -46,3 +46,31 @@ func BenchmarkStorage_SelectAmongMillionPoints(b *testing.B) {
 		_, _ = storage.Select("metric1", nil, 10, 100)
 	}
 }
+
+func BenchmarkAppender_AppendRef(b *testing.B) {
+	storage, err := NewStorage[float64]()
+	require.NoError(b, err)
+	app := storage.Appender()
+	ref, err := app.Append("metric1", []Label{{Name: "host", Value: "host-1"}}, 0, 0.1)
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 1; i < b.N; i++ {
+		app.AppendRef(ref, int64(i), 0.1)
+		if i%1000 == 0 {
+			app.Commit()
+		}
+	}
+}
+
+func BenchmarkStorage_InsertRefs(b *testing.B) {
+	storage, err := NewStorage[float64]()
+	require.NoError(b, err)
+	ref, err := storage.GetRef("metric1", []Label{{Name: "host", Value: "host-1"}})
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 1; i < b.N; i++ {
+		storage.InsertRefs([]RefRow[float64]{
+			{Ref: ref, DataPoint: DataPoint[float64]{Timestamp: int64(i), Value: 0.1}},
+		})
+	}
+}
@@ -61,8 +61,72 @@ func ExampleStorage_InsertRows() {
 	// timestamp: 1600000000, value: 0.1
 }
 
+func ExampleStorage_Appender() {
+	storage, err := tstorage.NewStorage[float64](
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer storage.Close()
+
+	app := storage.Appender()
+	labels := []tstorage.Label{{Name: "host", Value: "host-1"}}
+	ref, err := app.Append("metric1", labels, 1600000000, 0.1)
+	if err != nil {
+		panic(err)
+	}
+	// Skip identifying the series from the second time.
+	if err := app.AppendRef(ref, 1600000001, 0.2); err != nil {
+		panic(err)
+	}
+	if _, err := app.Commit(); err != nil {
+		panic(err)
+	}
+
+	points, err := storage.Select("metric1", labels, 1600000000, 1600000002)
+	if err != nil {
+		panic(err)
+	}
+	for _, p := range points {
+		fmt.Printf("timestamp: %v, value: %v\n", p.Timestamp, p.Value)
+	}
+	// Output:
+	// timestamp: 1600000000, value: 0.1
+	// timestamp: 1600000001, value: 0.2
+}
+
+func ExampleStorage_Subscribe() {
+	storage, err := tstorage.NewStorage[float64](
+		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer storage.Close()
+
+	matcher, err := tstorage.NewMatcher(tstorage.MatchEqual, "host", "host-1")
+	if err != nil {
+		panic(err)
+	}
+	ch, cancel := storage.Subscribe([]*tstorage.Matcher{matcher})
+	defer cancel()
+
+	err = storage.InsertRows([]tstorage.Row[float64]{
+		{Metric: "metric1", Labels: []tstorage.Label{{Name: "host", Value: "host-1"}}, DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000000, Value: 0.1}},
+		{Metric: "metric1", Labels: []tstorage.Label{{Name: "host", Value: "host-2"}}, DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000000, Value: 0.2}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	row := <-ch
+	fmt.Printf("metric: %v, timestamp: %v, value: %v\n", row.Metric, row.Timestamp, row.Value)
+	// Output:
+	// metric: metric1, timestamp: 1600000000, value: 0.1
+}
+
 // simulates writing and reading in concurrent.
-func ExampleStorage_InsertRows_Select_concurrent() {
+func ExampleStorage_InsertRows_concurrentWithSelect() {
 	storage, err := tstorage.NewStorage[float64](
 		tstorage.WithPartitionDuration[float64](5*time.Hour),
 		tstorage.WithTimestampPrecision[float64](tstorage.Seconds),
@@ -694,9 +758,11 @@ func ExampleStorage_InsertRows_expired() {
 	err = storage.InsertRows([]tstorage.Row[float64]{
 		{DataPoint: tstorage.DataPoint[float64]{Timestamp: 1600000002, Value: 0.1}, Metric: "metric1"},
 	})
-	if err != nil {
+	var outOfBounds *tstorage.OutOfBoundsError
+	if !errors.As(err, &outOfBounds) {
 		panic(err)
 	}
+	fmt.Printf("Rejected rows: %v\n", outOfBounds.Indexes)
 
 	// Flush all data points
 	if err := storage.Close(); err != nil {
@@ -726,9 +792,10 @@ func ExampleStorage_InsertRows_expired() {
 		fmt.Printf("Timestamp: %v, Value: %v\n", p.Timestamp, p.Value)
 	}
 
-	// Missing data point at 1600000002 because it was dropped.
+	// Missing data point at 1600000002 because it was rejected.
 
 	// Output:
+	// Rejected rows: [0]
 	// Timestamp: 1600000001, Value: 0.1
 	// Timestamp: 1600000003, Value: 0.1
 	// Timestamp: 1600000004, Value: 0.1
@@ -1,16 +1,22 @@
 package tstorage
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_storage_Select(t *testing.T) {
 	tests := []struct {
 		name    string
-		storage storage[float64]
+		storage *storage[float64]
 		metric  string
 		labels  []Label
 		start   int64
@@ -23,8 +29,8 @@ func Test_storage_Select(t *testing.T) {
 			metric: "metric1",
 			start:  1,
 			end:    4,
-			storage: func() storage[float64] {
-				part1 := newMemoryPartition[float64](nil, 1*time.Hour, Seconds)
+			storage: func() *storage[float64] {
+				part1 := newMemoryPartition[float64](1*time.Hour, Seconds)
 				_, err := part1.insertRows([]Row[float64]{
 					{DataPoint: DataPoint[float64]{Timestamp: 1}, Metric: "metric1"},
 					{DataPoint: DataPoint[float64]{Timestamp: 2}, Metric: "metric1"},
@@ -35,7 +41,7 @@ func Test_storage_Select(t *testing.T) {
 				}
 				list := newPartitionList[float64]()
 				list.insert(part1)
-				return storage[float64]{
+				return &storage[float64]{
 					partitionList:  list,
 					workersLimitCh: make(chan struct{}, defaultWorkersLimit),
 				}
@@ -51,8 +57,8 @@ func Test_storage_Select(t *testing.T) {
 			metric: "metric1",
 			start:  1,
 			end:    10,
-			storage: func() storage[float64] {
-				part1 := newMemoryPartition[float64](nil, 1*time.Hour, Seconds)
+			storage: func() *storage[float64] {
+				part1 := newMemoryPartition[float64](1*time.Hour, Seconds)
 				_, err := part1.insertRows([]Row[float64]{
 					{DataPoint: DataPoint[float64]{Timestamp: 1}, Metric: "metric1"},
 					{DataPoint: DataPoint[float64]{Timestamp: 2}, Metric: "metric1"},
@@ -61,7 +67,7 @@ func Test_storage_Select(t *testing.T) {
 				if err != nil {
 					panic(err)
 				}
-				part2 := newMemoryPartition[float64](nil, 1*time.Hour, Seconds)
+				part2 := newMemoryPartition[float64](1*time.Hour, Seconds)
 				_, err = part2.insertRows([]Row[float64]{
 					{DataPoint: DataPoint[float64]{Timestamp: 4}, Metric: "metric1"},
 					{DataPoint: DataPoint[float64]{Timestamp: 5}, Metric: "metric1"},
@@ -70,7 +76,7 @@ func Test_storage_Select(t *testing.T) {
 				if err != nil {
 					panic(err)
 				}
-				part3 := newMemoryPartition[float64](nil, 1*time.Hour, Seconds)
+				part3 := newMemoryPartition[float64](1*time.Hour, Seconds)
 				_, err = part3.insertRows([]Row[float64]{
 					{DataPoint: DataPoint[float64]{Timestamp: 7}, Metric: "metric1"},
 					{DataPoint: DataPoint[float64]{Timestamp: 8}, Metric: "metric1"},
@@ -84,7 +90,7 @@ func Test_storage_Select(t *testing.T) {
 				list.insert(part2)
 				list.insert(part3)
 
-				return storage[float64]{
+				return &storage[float64]{
 					partitionList:  list,
 					workersLimitCh: make(chan struct{}, defaultWorkersLimit),
 				}
@@ -111,3 +117,508 @@ func Test_storage_Select(t *testing.T) {
 		})
 	}
 }
+
+func Test_storage_InsertRows_outOfOrderWindow(t *testing.T) {
+	tests := []struct {
+		name        string
+		window      time.Duration
+		rows        []Row[float64]
+		wantIndexes []int
+	}{
+		{
+			name:   "within the window",
+			window: 10 * time.Second,
+			rows: []Row[float64]{
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000095}},
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000090}},
+			},
+		},
+		{
+			name:   "some rows are older than the window",
+			window: 10 * time.Second,
+			rows: []Row[float64]{
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000089}},
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000101}},
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000010}},
+			},
+			wantIndexes: []int{0, 2},
+		},
+		{
+			name:   "zero window",
+			window: 0,
+			rows: []Row[float64]{
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000101}},
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000099}},
+			},
+			wantIndexes: []int{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStorage(
+				WithTimestampPrecision[float64](Seconds),
+				WithPartitionDuration[float64](time.Hour),
+				WithOutOfOrderWindow[float64](tt.window),
+			)
+			require.NoError(t, err)
+			defer s.Close()
+			err = s.InsertRows([]Row[float64]{
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+				{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000100}},
+			})
+			require.NoError(t, err)
+
+			err = s.InsertRows(tt.rows)
+			if tt.wantIndexes == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrOutOfBounds)
+			var outOfBounds *OutOfBoundsError
+			require.ErrorAs(t, err, &outOfBounds)
+			assert.Equal(t, tt.wantIndexes, outOfBounds.Indexes)
+		})
+	}
+}
+
+func Test_storage_writablePartitionsNum(t *testing.T) {
+	tests := []struct {
+		name              string
+		partitionDuration time.Duration
+		outOfOrderWindow  time.Duration
+		want              int
+	}{
+		{name: "same as partition duration", partitionDuration: time.Hour, outOfOrderWindow: time.Hour, want: 2},
+		{name: "zero window", partitionDuration: time.Hour, outOfOrderWindow: 0, want: 1},
+		{name: "not divisible", partitionDuration: time.Hour, outOfOrderWindow: 90 * time.Minute, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &storage[float64]{
+				partitionDuration: tt.partitionDuration,
+				outOfOrderWindow:  tt.outOfOrderWindow,
+			}
+			assert.Equal(t, tt.want, s.writablePartitionsNum())
+		})
+	}
+}
+
+func Test_storage_InsertRowsDetailed(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithOutOfOrderWindow[float64](10*time.Second),
+		WithDuplicatePointPolicy[float64](RejectDuplicates),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	err = s.InsertRows([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000100}},
+	})
+	require.NoError(t, err)
+
+	got, err := s.InsertRowsDetailed([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000101}},
+		{Metric: "", DataPoint: DataPoint[float64]{Timestamp: 1600000102}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000010}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000100}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000103}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Accepted)
+	assert.Equal(t, []int{1, 2, 3}, got.Rejected())
+	assert.ErrorIs(t, got.Errors[1], ErrInvalidRow)
+	assert.ErrorIs(t, got.Errors[2], ErrOutOfBounds)
+	assert.ErrorIs(t, got.Errors[3], ErrDuplicatePoint)
+}
+
+// failingWAL fails to write any records.
+type failingWAL struct {
+	nopWAL[float64]
+}
+
+func (f *failingWAL) append(_ walOperation, _ []Row[float64]) error {
+	return errors.New("failed")
+}
+
+func Test_storage_InsertRows_walFailure(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	s.(*storage[float64]).wal = &failingWAL{}
+
+	err = s.InsertRows([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.1}},
+	})
+	require.Error(t, err)
+	// Nothing is visible, so that retrying doesn't make duplicates.
+	_, err = s.Select("metric1", nil, 1600000000, 1600000001)
+	assert.ErrorIs(t, err, ErrNoDataPoints)
+}
+
+func Test_storage_InsertRows_seriesLimit(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithMaxSeriesPerMetric[float64](2),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+
+	got, err := s.InsertRowsDetailed([]Row[float64]{
+		{Metric: "metric1", Labels: []Label{{Name: "id", Value: "1"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+		{Metric: "metric1", Labels: []Label{{Name: "id", Value: "2"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+		{Metric: "metric1", Labels: []Label{{Name: "id", Value: "3"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+		{Metric: "metric1", Labels: []Label{{Name: "id", Value: "1"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000001}},
+		{Metric: "metric2", DataPoint: DataPoint[float64]{Timestamp: 1600000001}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, got.Rejected())
+	assert.ErrorIs(t, got.Errors[2], ErrLimitExceeded)
+	assert.Equal(t, map[string]int{"metric1": 2, "metric2": 1}, s.Cardinality())
+}
+
+func Test_storage_WriterStats(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithMaxConcurrentWriters[float64](1),
+		WithWriteTimeout[float64](10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	rows := []Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+	}
+	require.NoError(t, s.InsertRows(rows))
+
+	// Occupy the only worker.
+	s.(*storage[float64]).workersLimitCh <- struct{}{}
+	assert.Error(t, s.InsertRows(rows))
+	<-s.(*storage[float64]).workersLimitCh
+
+	got := s.WriterStats()
+	assert.Equal(t, 1, got.MaxConcurrentWriters)
+	assert.Equal(t, 0, got.ActiveWriters)
+	assert.Equal(t, 0, got.QueueDepth)
+	assert.Equal(t, uint64(1), got.TimedOutWrites)
+	assert.Equal(t, uint64(1), got.WaitDuration.Count)
+}
+
+func Test_storage_Stats(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	s, err := NewStorage(
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+		WithWALSyncPolicy[float64](WALSyncAlways),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	st := s.(*storage[float64])
+
+	require.NoError(t, s.InsertRows([]Row[float64]{
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "a"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+		{Metric: "metric2", DataPoint: DataPoint[float64]{Timestamp: 1600000001}},
+	}))
+	// The head takes a row beyond its range before rotating, so they end up in
+	// [1600000000, 1600000001, 1600100000], [1600200000, 1600300000] and [1600400000].
+	for _, ts := range []int64{1600100000, 1600200000, 1600300000, 1600400000} {
+		require.NoError(t, s.InsertRows([]Row[float64]{
+			{Metric: "metric1", Labels: []Label{{Name: "host", Value: "a"}}, DataPoint: DataPoint[float64]{Timestamp: ts}},
+		}))
+	}
+	require.Equal(t, 2, st.writablePartitionsNum())
+	require.NoError(t, st.flushPartitions())
+	require.NoError(t, s.InsertRows([]Row[float64]{
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "a"}}, DataPoint: DataPoint[float64]{Timestamp: 1600400002}},
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "a"}}, DataPoint: DataPoint[float64]{Timestamp: 1600400001}},
+	}))
+	assert.Error(t, s.InsertRows([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1500000000}},
+	}))
+	_, err = s.Select("metric1", []Label{{Name: "host", Value: "a"}}, 1600000000, 1600500000)
+	require.NoError(t, err)
+
+	got := s.Stats()
+	assert.Equal(t, 2, got.MemoryPartitions)
+	assert.Equal(t, 1, got.DiskPartitions)
+	assert.Equal(t, int64(1600400000), got.HeadMinTimestamp)
+	assert.Equal(t, int64(1600400002), got.HeadMaxTimestamp)
+	assert.Equal(t, int64(8), got.NumDataPoints)
+	assert.Equal(t, 2, got.NumSeries)
+	assert.Positive(t, got.WALSize)
+	assert.Positive(t, got.WALSegments)
+	assert.Positive(t, got.WALBytesWritten)
+	assert.Positive(t, got.WALSyncDuration.Count)
+	assert.Positive(t, got.MappedBytes)
+	assert.Equal(t, uint64(8), got.InsertedRows)
+	assert.Equal(t, uint64(7), got.InsertDuration.Count)
+	assert.Equal(t, uint64(1), got.OutOfBoundsRows)
+	assert.Equal(t, uint64(1), got.OutOfOrderRows)
+	assert.Equal(t, uint64(2), got.MemorySelectDuration.Count)
+	assert.Equal(t, uint64(1), got.DiskSelectDuration.Count)
+	assert.Equal(t, uint64(1), got.FlushDuration.Count)
+	assert.NoError(t, got.LastFlushError)
+	assert.Positive(t, got.MemorySeries)
+
+	// WAL segments are kept track of without touching them.
+	entries, err := os.ReadDir(filepath.Join(tmpDir, walDirName))
+	require.NoError(t, err)
+	var walSize int64
+	for _, e := range entries {
+		info, err := e.Info()
+		require.NoError(t, err)
+		walSize += info.Size()
+	}
+	assert.Equal(t, walSize, got.WALSize)
+	assert.Equal(t, len(entries), got.WALSegments)
+
+	// Flushing fails once the data directory becomes unwritable.
+	file := filepath.Join(tmpDir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	st.flushMu.Lock()
+	st.dataPath = filepath.Join(file, "data")
+	st.flushMu.Unlock()
+	for _, ts := range []int64{1600500000, 1600600000} {
+		require.NoError(t, s.InsertRows([]Row[float64]{
+			{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: ts}},
+		}))
+	}
+	assert.Error(t, st.flushPartitions())
+	got = s.Stats()
+	assert.Error(t, got.LastFlushError)
+	assert.False(t, got.LastFlushErrorTime.IsZero())
+	st.flushMu.Lock()
+	st.dataPath = tmpDir
+	st.flushMu.Unlock()
+}
+
+func Test_storage_InsertRefs(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithPartitionDuration[float64](2*time.Second),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+
+	labels := []Label{{Name: "host", Value: "host-1"}}
+	ref, err := s.GetRef("metric1", labels)
+	require.NoError(t, err)
+	sameRef, err := s.GetRef("metric1", []Label{{Name: "host", Value: "host-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, ref, sameRef)
+
+	// Insert across multiple partitions with the same reference.
+	for ts := int64(1600000000); ts < 1600000006; ts++ {
+		err := s.InsertRefs([]RefRow[float64]{
+			{Ref: ref, DataPoint: DataPoint[float64]{Timestamp: ts, Value: 0.1}},
+		})
+		require.NoError(t, err)
+	}
+	err = s.InsertRefs([]RefRow[float64]{
+		{Ref: SeriesRef(100), DataPoint: DataPoint[float64]{Timestamp: 1600000006}},
+		{Ref: ref, DataPoint: DataPoint[float64]{Timestamp: 1600000006, Value: 0.1}},
+	})
+	assert.ErrorIs(t, err, ErrUnknownSeriesRef)
+
+	points, err := s.Select("metric1", labels, 1600000000, 1600000007)
+	require.NoError(t, err)
+	assert.Len(t, points, 7)
+}
+
+func Test_storage_GetRef_flush(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	st := s.(*storage[float64])
+
+	ref, err := s.GetRef("metric1", nil)
+	require.NoError(t, err)
+	// The reference stays valid across a flush even before anything is inserted.
+	require.NoError(t, st.flushPartitions())
+	require.NoError(t, s.InsertRefs([]RefRow[float64]{
+		{Ref: ref, DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+	}))
+	assert.Equal(t, map[string]int{"metric1": 1}, s.Cardinality())
+
+	ref, err = s.GetRef("metric2", nil)
+	require.NoError(t, err)
+	require.NoError(t, st.flushPartitions())
+	require.NoError(t, st.flushPartitions())
+	err = s.InsertRefs([]RefRow[float64]{
+		{Ref: ref, DataPoint: DataPoint[float64]{Timestamp: 1600000001}},
+	})
+	assert.ErrorIs(t, err, ErrUnknownSeriesRef)
+}
+
+func Test_storage_WALSyncInterval(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewStorage(
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+		WithWALSyncPolicy[float64](WALSyncInterval(10*time.Millisecond)),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.InsertRows([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+	}))
+
+	w := s.(*storage[float64]).wal.(*diskWAL[float64])
+	assert.Eventually(t, func() bool {
+		return atomic.LoadUint64(&w.synced) == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_storage_recoverWAL(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	opts := []Option[float64]{
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+		WithWALBufferedSize[float64](0),
+	}
+
+	// Emulate a crash by leaving the storage without closing.
+	s, err := NewStorage(opts...)
+	require.NoError(t, err)
+	require.NoError(t, s.InsertRows([]Row[float64]{
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "host-1"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.1}},
+	}))
+
+	s, err = NewStorage(opts...)
+	require.NoError(t, err)
+	defer s.Close()
+	got, err := s.Select("metric1", []Label{{Name: "host", Value: "host-1"}}, 1600000000, 1600000001)
+	require.NoError(t, err)
+	assert.Equal(t, []*DataPoint[float64]{{Timestamp: 1600000000, Value: 0.1}}, got)
+
+	// Recovered rows are kept in a new segment in case of another crash.
+	segments, err := listSegments(filepath.Join(tmpDir, walDirName))
+	require.NoError(t, err)
+	require.NotEmpty(t, segments)
+	assert.Equal(t, uint32(1), segments[0].index)
+	reader, err := newDiskWALReader[float64](filepath.Join(tmpDir, walDirName))
+	require.NoError(t, err)
+	require.NoError(t, reader.readAll())
+	assert.Len(t, reader.rowsToInsert, 1)
+}
+
+func Test_storage_recoverWAL_longNames(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	opts := []Option[float64]{
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+		WithWALBufferedSize[float64](0),
+	}
+	metric := strings.Repeat("m", 70000)
+	labels := []Label{{Name: "host", Value: strings.Repeat("h", 70000)}}
+
+	// Emulate a crash by leaving the storage without closing.
+	s, err := NewStorage(opts...)
+	require.NoError(t, err)
+	require.NoError(t, s.InsertRows([]Row[float64]{
+		{Metric: metric, Labels: labels, DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.1}},
+	}))
+	// Followed by a corrupted record, which should only stop the replay.
+	segments, err := listSegments(filepath.Join(tmpDir, walDirName))
+	require.NoError(t, err)
+	require.NotEmpty(t, segments)
+	f, err := os.OpenFile(filepath.Join(tmpDir, walDirName, segments[len(segments)-1].name), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0xff})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = NewStorage(opts...)
+	require.NoError(t, err)
+	defer s.Close()
+	got, err := s.Select(metric, labels, 1600000000, 1600000001)
+	require.NoError(t, err)
+	assert.Equal(t, []*DataPoint[float64]{{Timestamp: 1600000000, Value: 0.1}}, got)
+}
+
+func Test_storage_Close_emptyPartitions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tstorage-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	opts := []Option[float64]{
+		WithDataPath[float64](tmpDir),
+		WithTimestampPrecision[float64](Seconds),
+	}
+
+	s, err := NewStorage(opts...)
+	require.NoError(t, err)
+	require.NoError(t, s.InsertRows([]Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.1}},
+	}))
+	require.NoError(t, s.Close())
+	// Reopening without inserting leaves only empty partitions to flush.
+	s, err = NewStorage(opts...)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	var partitions []string
+	for _, e := range entries {
+		if partitionDirRegex.MatchString(e.Name()) {
+			partitions = append(partitions, e.Name())
+		}
+	}
+	assert.Equal(t, []string{"p-1600000000-1600000000"}, partitions)
+}
+
+func Test_storage_SelectSeries(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.InsertRows([]Row[float64]{
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "host-2"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.1}},
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "host-1"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.2}},
+		{Metric: "metric1", Labels: []Label{{Name: "host", Value: "host-1"}}, DataPoint: DataPoint[float64]{Timestamp: 1600000001, Value: 0.3}},
+		{Metric: "metric2", DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.4}},
+	}))
+
+	nameMatcher, err := NewMatcher(MatchEqual, MetricNameLabel, "metric1")
+	require.NoError(t, err)
+	got, err := s.SelectSeries([]*Matcher{nameMatcher}, 1600000000, 1600000002)
+	require.NoError(t, err)
+	assert.Equal(t, []Series[float64]{
+		{
+			Metric: "metric1",
+			Labels: []Label{{Name: "host", Value: "host-1"}},
+			Points: []*DataPoint[float64]{{Timestamp: 1600000000, Value: 0.2}, {Timestamp: 1600000001, Value: 0.3}},
+		},
+		{
+			Metric: "metric1",
+			Labels: []Label{{Name: "host", Value: "host-2"}},
+			Points: []*DataPoint[float64]{{Timestamp: 1600000000, Value: 0.1}},
+		},
+	}, got)
+
+	// Series without data points in the range are left out.
+	got, err = s.SelectSeries(nil, 1600000001, 1600000002)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, []Label{{Name: "host", Value: "host-1"}}, got[0].Labels)
+
+	// Matchers built without NewMatcher get compiled, and an invalid one is refused.
+	got, err = s.SelectSeries([]*Matcher{{Type: MatchRegexp, Name: "host", Value: "host-2|host-3"}}, 1600000000, 1600000002)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, []Label{{Name: "host", Value: "host-2"}}, got[0].Labels)
+	_, err = s.SelectSeries([]*Matcher{{Type: MatchRegexp, Name: "host", Value: "("}}, 1600000000, 1600000002)
+	assert.Error(t, err)
+}
@@ -0,0 +1,179 @@
+package tstorage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const defaultSubscriptionBufferSize = 1024
+
+// SubscriptionPolicy specifies what happens when the buffer of a subscriber is full.
+type SubscriptionPolicy int
+
+const (
+	// SubscriptionDrop drops rows that don't fit in the buffer, so that writers never wait for subscribers.
+	SubscriptionDrop SubscriptionPolicy = iota
+	// SubscriptionBlock makes writers wait until the subscriber receives rows.
+	// Once the storage starts closing, rows that don't fit in the buffer are dropped instead.
+	SubscriptionBlock
+)
+
+// SubscribeOption is an optional setting for Subscribe.
+type SubscribeOption func(*subscriptionConfig)
+
+type subscriptionConfig struct {
+	bufferSize int
+	policy     SubscriptionPolicy
+}
+
+// WithSubscriptionBufferSize specifies the number of rows buffered for the subscriber.
+//
+// Defaults to 1024.
+func WithSubscriptionBufferSize(size int) SubscribeOption {
+	return func(c *subscriptionConfig) {
+		c.bufferSize = size
+	}
+}
+
+// WithSubscriptionPolicy specifies what happens when the buffer of the subscriber is full.
+//
+// Defaults to SubscriptionDrop.
+func WithSubscriptionPolicy(policy SubscriptionPolicy) SubscribeOption {
+	return func(c *subscriptionConfig) {
+		c.policy = policy
+	}
+}
+
+// subscriptions holds the subscribers of a storage.
+type subscriptions[T any] struct {
+	// The number of subscribers, to skip taking the lock when there is none.
+	num    int32
+	mu     sync.RWMutex
+	subs   map[*subscription[T]]struct{}
+	closed bool
+	// unblockCh gets closed when closing starts, in order to release writers blocked by any subscriber.
+	unblockCh   chan struct{}
+	unblockOnce sync.Once
+}
+
+type subscription[T any] struct {
+	matchers []*Matcher
+	policy   SubscriptionPolicy
+	ch       chan Row[T]
+	// doneCh gets closed when cancelled, in order to release writers blocked by it.
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+func newSubscriptions[T any]() *subscriptions[T] {
+	return &subscriptions[T]{
+		subs:      make(map[*subscription[T]]struct{}),
+		unblockCh: make(chan struct{}),
+	}
+}
+
+// subscribe registers a new subscriber, and gives back the channel and the function to cancel it.
+func (s *subscriptions[T]) subscribe(matchers []*Matcher, opts ...SubscribeOption) (<-chan Row[T], func()) {
+	cfg := &subscriptionConfig{
+		bufferSize: defaultSubscriptionBufferSize,
+		policy:     SubscriptionDrop,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.bufferSize < 0 {
+		cfg.bufferSize = 0
+	}
+	matchers, err := compileMatchers(matchers)
+	sub := &subscription[T]{
+		matchers: matchers,
+		policy:   cfg.policy,
+		ch:       make(chan Row[T], cfg.bufferSize),
+		doneCh:   make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || err != nil {
+		close(sub.doneCh)
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	s.subs[sub] = struct{}{}
+	atomic.AddInt32(&s.num, 1)
+	return sub.ch, func() { s.cancel(sub) }
+}
+
+func (s *subscriptions[T]) cancel(sub *subscription[T]) {
+	// Release writers first, since they hold the read lock while blocked.
+	sub.once.Do(func() { close(sub.doneCh) })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[sub]; !ok {
+		return
+	}
+	delete(s.subs, sub)
+	atomic.AddInt32(&s.num, -1)
+	close(sub.ch)
+}
+
+// publish pushes the given rows to the subscribers they match, except for the rejected ones.
+func (s *subscriptions[T]) publish(rows []Row[T], rejected map[int]error) {
+	if atomic.LoadInt32(&s.num) == 0 {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for sub := range s.subs {
+		for i := range rows {
+			if _, ok := rejected[i]; ok {
+				continue
+			}
+			if !matchSeries(sub.matchers, rows[i].Metric, rows[i].Labels) {
+				continue
+			}
+			if !sub.send(rows[i], s.unblockCh) {
+				break
+			}
+		}
+	}
+}
+
+// send gives back false if it's cancelled. It stops blocking once unblockCh gets closed.
+func (s *subscription[T]) send(row Row[T], unblockCh <-chan struct{}) bool {
+	if s.policy == SubscriptionBlock {
+		select {
+		case s.ch <- row:
+			return true
+		case <-s.doneCh:
+			return false
+		case <-unblockCh:
+		}
+	}
+	select {
+	case s.ch <- row:
+	default:
+	}
+	return true
+}
+
+// unblock makes writers no longer wait for subscribers, so that they can finish even if
+// subscribers stop receiving.
+func (s *subscriptions[T]) unblock() {
+	s.unblockOnce.Do(func() { close(s.unblockCh) })
+}
+
+// close closes the channels of all subscribers.
+func (s *subscriptions[T]) close() {
+	s.unblock()
+	s.mu.Lock()
+	subs := make([]*subscription[T], 0, len(s.subs))
+	for sub := range s.subs {
+		subs = append(subs, sub)
+	}
+	s.closed = true
+	s.mu.Unlock()
+	for _, sub := range subs {
+		s.cancel(sub)
+	}
+}
@@ -0,0 +1,153 @@
+package tstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_storage_Subscribe(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+		WithDuplicatePointPolicy[float64](RejectDuplicates),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	m, err := NewMatcher(MatchEqual, MetricNameLabel, "metric1")
+	require.NoError(t, err)
+	ch, cancel := s.Subscribe([]*Matcher{m})
+
+	rows := []Row[float64]{
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.1}},
+		{Metric: "metric2", DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.2}},
+		// Rejected as a duplicate
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000, Value: 0.3}},
+		{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000001, Value: 0.4}},
+	}
+	_, err = s.InsertRowsDetailed(rows)
+	require.NoError(t, err)
+	assert.Equal(t, rows[0], <-ch)
+	assert.Equal(t, rows[3], <-ch)
+
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+	// Cancelling twice is fine.
+	cancel()
+}
+
+func Test_storage_Subscribe_invalidMatcher(t *testing.T) {
+	s, err := NewStorage[float64]()
+	require.NoError(t, err)
+	defer s.Close()
+	ch, cancel := s.Subscribe([]*Matcher{{Type: MatchRegexp, Name: "host", Value: "("}})
+	defer cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func Test_storage_Subscribe_policy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  SubscriptionPolicy
+		wantLen int
+	}{
+		// The second row gets dropped while nobody receives.
+		{name: "drop", policy: SubscriptionDrop, wantLen: 1},
+		{name: "block", policy: SubscriptionBlock, wantLen: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStorage(
+				WithTimestampPrecision[float64](Seconds),
+			)
+			require.NoError(t, err)
+			defer s.Close()
+			ch, cancel := s.Subscribe(nil, WithSubscriptionBufferSize(1), WithSubscriptionPolicy(tt.policy))
+			defer cancel()
+
+			doneCh := make(chan struct{})
+			go func() {
+				defer close(doneCh)
+				s.InsertRows([]Row[float64]{
+					{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+					{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000001}},
+				})
+			}()
+			if tt.policy == SubscriptionDrop {
+				<-doneCh
+			} else {
+				select {
+				case <-doneCh:
+					t.Fatal("writer wasn't blocked")
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+			var got []Row[float64]
+			for len(got) < tt.wantLen {
+				got = append(got, <-ch)
+			}
+			<-doneCh
+			assert.Len(t, got, tt.wantLen)
+			assert.Len(t, ch, 0)
+		})
+	}
+}
+
+func Test_storage_Subscribe_cancelUnblocksWriters(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+	_, cancel := s.Subscribe(nil, WithSubscriptionBufferSize(0), WithSubscriptionPolicy(SubscriptionBlock))
+
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- s.InsertRows([]Row[float64]{
+			{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.NoError(t, <-doneCh)
+}
+
+func Test_storage_Subscribe_closeUnblocksWriters(t *testing.T) {
+	s, err := NewStorage(
+		WithTimestampPrecision[float64](Seconds),
+	)
+	require.NoError(t, err)
+	ch, _ := s.Subscribe(nil, WithSubscriptionBufferSize(0), WithSubscriptionPolicy(SubscriptionBlock))
+
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- s.InsertRows([]Row[float64]{
+			{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000000}},
+			{Metric: "metric1", DataPoint: DataPoint[float64]{Timestamp: 1600000001}},
+		})
+	}()
+	// Receive only the first row, so that the writer gets blocked by the second one.
+	<-ch
+	require.NoError(t, s.Close())
+	assert.NoError(t, <-doneCh)
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func Test_storage_Subscribe_close(t *testing.T) {
+	s, err := NewStorage[float64]()
+	require.NoError(t, err)
+	ch, _ := s.Subscribe(nil)
+	require.NoError(t, s.Close())
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	// Subscribing after closing gives back a closed channel.
+	ch, cancel := s.Subscribe(nil)
+	defer cancel()
+	_, ok = <-ch
+	assert.False(t, ok)
+}
@@ -0,0 +1,132 @@
+package tstorage
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+)
+
+// The maximum length of metric name. It is limited by the encoding of metric names.
+const maxMetricNameLen = math.MaxUint16
+
+// NonFiniteValuePolicy specifies how to treat NaN and ±Inf values. See WithNonFiniteValuePolicy.
+type NonFiniteValuePolicy int
+
+const (
+	// AcceptNonFinite ingests NaN and ±Inf values as is.
+	AcceptNonFinite NonFiniteValuePolicy = iota
+	// RejectInf rejects ±Inf values while accepting NaN.
+	RejectInf
+	// RejectNonFinite rejects both NaN and ±Inf values.
+	RejectNonFinite
+)
+
+// ValidationError describes why a row was rejected by validation. It wraps ErrInvalidRow.
+type ValidationError struct {
+	// What is invalid, such as "metric", "label name", "label value" and "value".
+	Field string
+	// The invalid content.
+	Value string
+	// Why it is invalid.
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	v := e.Value
+	if len(v) > 64 {
+		v = v[:64] + "..."
+	}
+	return fmt.Sprintf("%v: %s %q %s", ErrInvalidRow, e.Field, v, e.Reason)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidRow
+}
+
+// validation holds the rules rows must follow to be ingested.
+type validation struct {
+	// strict rejects rows that would otherwise be silently truncated or merged into another series.
+	strict bool
+	// nil means the Prometheus-compatible charset.
+	metricNamePattern *regexp.Regexp
+	// nil means the Prometheus-compatible charset.
+	labelNamePattern *regexp.Regexp
+	nonFinitePolicy  NonFiniteValuePolicy
+}
+
+// validate gives back a *ValidationError if the given row breaks the rules.
+func (v *validation) validate(metric string, labels []Label, value float64) error {
+	if err := v.validateValue(value); err != nil {
+		return err
+	}
+	return v.validateSeries(metric, labels)
+}
+
+// validateValue gives back a *ValidationError if the given value breaks the non-finite value policy.
+func (v *validation) validateValue(value float64) error {
+	switch {
+	case v.nonFinitePolicy == RejectNonFinite && math.IsNaN(value):
+		return &ValidationError{Field: "value", Value: "NaN", Reason: "is not allowed"}
+	case v.nonFinitePolicy >= RejectInf && math.IsInf(value, 0):
+		return &ValidationError{Field: "value", Value: fmt.Sprint(value), Reason: "is not allowed"}
+	}
+	return nil
+}
+
+// validateSeries gives back a *ValidationError if the given metric name or labels break the rules.
+func (v *validation) validateSeries(metric string, labels []Label) error {
+	if metric == "" {
+		return &ValidationError{Field: "metric", Reason: "must be set"}
+	}
+	if !v.strict {
+		return nil
+	}
+
+	if len(metric) > maxMetricNameLen {
+		return &ValidationError{Field: "metric", Value: metric, Reason: fmt.Sprintf("is longer than %d", maxMetricNameLen)}
+	}
+	if !matchName(v.metricNamePattern, metric, true) {
+		return &ValidationError{Field: "metric", Value: metric, Reason: "contains invalid characters"}
+	}
+	for i := range labels {
+		label := &labels[i]
+		switch {
+		case label.Name == "":
+			return &ValidationError{Field: "label name", Reason: "must be set"}
+		case label.Value == "":
+			return &ValidationError{Field: "label value", Value: label.Name, Reason: "must be set"}
+		case len(label.Name) > maxLabelNameLen:
+			return &ValidationError{Field: "label name", Value: label.Name, Reason: fmt.Sprintf("is longer than %d", maxLabelNameLen)}
+		case len(label.Value) > maxLabelValueLen:
+			return &ValidationError{Field: "label value", Value: label.Value, Reason: fmt.Sprintf("is longer than %d", maxLabelValueLen)}
+		case !matchName(v.labelNamePattern, label.Name, false):
+			return &ValidationError{Field: "label name", Value: label.Name, Reason: "contains invalid characters"}
+		}
+		for j := 0; j < i; j++ {
+			if labels[j].Name == label.Name {
+				return &ValidationError{Field: "label name", Value: label.Name, Reason: "is duplicated"}
+			}
+		}
+	}
+	return nil
+}
+
+// matchName reports whether the given name matches the pattern.
+// If the pattern is nil, it checks the name consists of the Prometheus-compatible charset,
+// that is [a-zA-Z_:][a-zA-Z0-9_:]* for metric names and [a-zA-Z_][a-zA-Z0-9_]* for label names.
+func matchName(pattern *regexp.Regexp, name string, isMetric bool) bool {
+	if pattern != nil {
+		return pattern.MatchString(name)
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		case c == ':' && isMetric:
+		default:
+			return false
+		}
+	}
+	return len(name) > 0
+}
@@ -0,0 +1,117 @@
+package tstorage
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_validation_validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		validation validation
+		metric     string
+		labels     []Label
+		value      float64
+		wantField  string
+	}{
+		{
+			name:      "empty metric",
+			wantField: "metric",
+		},
+		{
+			name:       "lenient about invalid charset",
+			validation: validation{},
+			metric:     "metric-1",
+			labels:     []Label{{Name: "host.name"}},
+		},
+		{
+			name:       "valid",
+			validation: validation{strict: true},
+			metric:     "http:requests_total",
+			labels:     []Label{{Name: "host", Value: "host-1"}, {Name: "_path", Value: "/"}},
+		},
+		{
+			name:       "invalid metric charset",
+			validation: validation{strict: true},
+			metric:     "1metric",
+			wantField:  "metric",
+		},
+		{
+			name:       "invalid label charset",
+			validation: validation{strict: true},
+			metric:     "metric1",
+			labels:     []Label{{Name: "host:name", Value: "host-1"}},
+			wantField:  "label name",
+		},
+		{
+			name:       "custom charset",
+			validation: validation{strict: true, metricNamePattern: regexp.MustCompile(`^[a-z.-]+$`)},
+			metric:     "metric-a.b",
+		},
+		{
+			name:       "empty label value",
+			validation: validation{strict: true},
+			metric:     "metric1",
+			labels:     []Label{{Name: "host"}},
+			wantField:  "label value",
+		},
+		{
+			name:       "duplicate label names",
+			validation: validation{strict: true},
+			metric:     "metric1",
+			labels:     []Label{{Name: "host", Value: "a"}, {Name: "host", Value: "b"}},
+			wantField:  "label name",
+		},
+		{
+			name:       "too long label name",
+			validation: validation{strict: true},
+			metric:     "metric1",
+			labels:     []Label{{Name: strings.Repeat("a", maxLabelNameLen+1), Value: "a"}},
+			wantField:  "label name",
+		},
+		{
+			name:       "NaN accepted by default",
+			validation: validation{},
+			metric:     "metric1",
+			value:      math.NaN(),
+		},
+		{
+			name:       "NaN accepted when rejecting Inf",
+			validation: validation{nonFinitePolicy: RejectInf},
+			metric:     "metric1",
+			value:      math.NaN(),
+		},
+		{
+			name:       "Inf rejected",
+			validation: validation{nonFinitePolicy: RejectInf},
+			metric:     "metric1",
+			value:      math.Inf(-1),
+			wantField:  "value",
+		},
+		{
+			name:       "NaN rejected",
+			validation: validation{nonFinitePolicy: RejectNonFinite},
+			metric:     "metric1",
+			value:      math.NaN(),
+			wantField:  "value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.validation.validate(tt.metric, tt.labels, tt.value)
+			if tt.wantField == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidRow)
+			var validationErr *ValidationError
+			if assert.ErrorAs(t, err, &validationErr) {
+				assert.Equal(t, tt.wantField, validationErr.Field)
+			}
+		})
+	}
+}
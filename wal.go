@@ -3,17 +3,71 @@ package tstorage
 import (
 	"os"
 	"sync"
+	"time"
 )
 
+// WALSyncPolicy specifies when WAL gets fsynced to survive power loss. See WithWALSyncPolicy.
+type WALSyncPolicy struct {
+	mode     walSyncMode
+	interval time.Duration
+}
+
+type walSyncMode int
+
+const (
+	walSyncNever walSyncMode = iota
+	walSyncAlways
+	walSyncInterval
+)
+
+var (
+	// WALSyncNever leaves fsync to the OS. Written records survive process crashes but may be lost on power loss.
+	WALSyncNever = WALSyncPolicy{mode: walSyncNever}
+	// WALSyncAlways fsyncs before InsertRows returns. Concurrent writers share a single fsync.
+	WALSyncAlways = WALSyncPolicy{mode: walSyncAlways}
+)
+
+// WALSyncInterval fsyncs periodically at the given interval.
+// Records written within the last interval may be lost on power loss.
+func WALSyncInterval(interval time.Duration) WALSyncPolicy {
+	return WALSyncPolicy{mode: walSyncInterval, interval: interval}
+}
+
+// The version of the WAL format, which is held in the header of each segment.
+// Segments written in walFormatV2 start with the header as shown below:
+/*
+   +--------------------------+-------------+
+   | magic(4b: 0xff, "WAL")   | version(1b) |
+   +--------------------------+-------------+
+*/
+// Segments in walFormatV1 have no header, which can be told apart since no record starts with 0xff.
+const (
+	// Records hold the marshaled metric name.
+	walFormatV1 byte = iota + 1
+	// Records hold the metric and labels explicitly.
+	walFormatV2
+
+	walFormatLatest = walFormatV2
+)
+
+var walSegmentMagic = []byte{0xff, 'W', 'A', 'L'}
+
 type walOperation byte
 
 const (
-	// The record format for operateInsert is as shown below:
+	// The record format for operateInsert in walFormatV2 is as shown below:
+	/*
+	   +--------+---------------------+--------+----------------------+--------+--------------------+----------------+
+	   | op(1b) | len metric(varints) | metric | num labels(varints)  | labels | timestamp(varints) | value(varints) |
+	   +--------+---------------------+--------+----------------------+--------+--------------------+----------------+
+	*/
+	// where each label is as shown below:
 	/*
-	   +--------+---------------------+--------+--------------------+----------------+
-	   | op(1b) | len metric(varints) | metric | timestamp(varints) | value(varints) |
-	   +--------+---------------------+--------+--------------------+----------------+
+	   +-------------------+------+--------------------+-------+
+	   | len name(varints) | name | len value(varints) | value |
+	   +-------------------+------+--------------------+-------+
 	*/
+	// In walFormatV1, the metric is the one marshaled with labels, and there are no labels following it.
 	operationInsert walOperation = iota
 )
 
@@ -21,10 +75,32 @@ const (
 type wal[T any] interface {
 	append(op walOperation, rows []Row[T]) error
 	flush() error
+	// sync flushes and then fsyncs the active segment.
+	sync() error
 	punctuate() error
 	removeOldest() error
 	removeAll() error
-	refresh() error
+	// removeRecovered removes segments that existed before opening.
+	removeRecovered() error
+	// stats describes the segments on disk.
+	stats() walStats
+}
+
+type walStats struct {
+	// Total size in bytes of segments, excluding the buffered records.
+	size     int64
+	segments int
+	// Total bytes of records written since opening, including the buffered ones.
+	bytesWritten uint64
+	// How long each fsync took.
+	syncDuration Histogram
+}
+
+// walObserver observes records written to WAL.
+type walObserver interface {
+	// observeRecords is called with records encoded as in segments, in the order they're written.
+	// The observer takes ownership of the given bytes.
+	observeRecords(records []byte)
 }
 
 type nopWAL[T any] struct {
@@ -41,6 +117,10 @@ func (f *nopWAL[T]) flush() error {
 	return nil
 }
 
+func (f *nopWAL[T]) sync() error {
+	return nil
+}
+
 func (f *nopWAL[T]) punctuate() error {
 	return nil
 }
@@ -53,6 +133,10 @@ func (f *nopWAL[T]) removeAll() error {
 	return nil
 }
 
-func (f *nopWAL[T]) refresh() error {
+func (f *nopWAL[T]) removeRecovered() error {
 	return nil
 }
+
+func (f *nopWAL[T]) stats() walStats {
+	return walStats{}
+}